@@ -0,0 +1,254 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "strings"
+import "sync"
+
+// The errors collected by ApplyParallel with OptApplyCollectErrors.
+type MultiError []error
+
+func (errs MultiError) Error() string {
+
+	strs := make([]string, len(errs))
+	for i, err := range errs {
+		strs[i] = err.Error()
+	}
+
+	return strings.Join(strs, "; ")
+}
+
+type ApplyOpt func(*applyConfig)
+
+type applyConfig struct {
+	ctx     context.Context
+	ordered bool
+	collect bool
+}
+
+// Call the handler one item at a time in the order of List.
+//
+// Items are still fetched concurrently, at most twice the number of
+// workers ahead of the handler.
+//
+func OptApplyOrdered() ApplyOpt {
+	return func(c *applyConfig) {
+		c.ordered = true
+	}
+}
+
+// Keep going after errors, returning all of them as a MultiError.
+//
+// By default the first error cancels the remaining items and is
+// returned.
+//
+func OptApplyCollectErrors() ApplyOpt {
+	return func(c *applyConfig) {
+		c.collect = true
+	}
+}
+
+// Stop applying, returning the context error, when ctx is done.  The
+// context is also passed to context aware stores.
+func OptApplyContext(ctx context.Context) ApplyOpt {
+	return func(c *applyConfig) {
+		c.ctx = ctx
+	}
+}
+
+// Stores able to apply a handler using concurrent workers.
+//
+// Unless ordered, the handler is called from several goroutines at
+// once and must be safe for concurrent use.  As with Apply, the
+// handler should not modify the store.
+//
+type ParallelApplier interface {
+	ApplyParallel(f ItemHandler, workers int, opts ...ApplyOpt) error
+}
+
+// Apply f to every item of s using workers goroutines.
+//
+// Stores implementing ParallelApplier are used directly, otherwise the
+// items are listed and retrieved concurrently.  Items deleted while
+// applying are skipped.
+//
+func ApplyParallel(s Store, f ItemHandler, workers int, opts ...ApplyOpt) error {
+
+	if pa, ok := s.(ParallelApplier); ok {
+		return pa.ApplyParallel(f, workers, opts...)
+	}
+
+	list := func(ctx context.Context) ([]ID, error) {
+		return ListContext(ctx, s)
+	}
+
+	return applyFetched(list, func(ctx context.Context, id ID) (Storable, error) {
+		return RetrieveContext(ctx, s, id)
+	}, f, workers, opts...)
+}
+
+func (s MapStore) ApplyParallel(f ItemHandler, workers int, opts ...ApplyOpt) error {
+
+	list := func(context.Context) ([]ID, error) {
+		return s.List()
+	}
+
+	return applyFetched(list, func(ctx context.Context, id ID) (Storable, error) {
+		return s.Retrieve(id)
+	}, f, workers, opts...)
+}
+
+type applyResult struct {
+	idx int
+	id  ID
+	obj Storable
+	err error
+}
+
+// List the IDs and apply f to the items as fetched by workers.
+func applyFetched(list func(context.Context) ([]ID, error),
+	fetch func(context.Context, ID) (Storable, error),
+	f ItemHandler, workers int, opts ...ApplyOpt) error {
+
+	cfg := applyConfig{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	ids, err := list(cfg.ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(cfg.ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var first error
+	errs := MultiError{}
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if cfg.ctx.Err() != nil {
+			return
+		}
+
+		if cfg.collect {
+			errs = append(errs, err)
+			return
+		}
+
+		if first == nil {
+			first = err
+			cancel()
+		}
+	}
+
+	// Handle a fetched item, unless applying has stopped.
+	handle := func(r applyResult) {
+		if r.err == ErrNotFound {
+			return
+		}
+
+		if r.err != nil {
+			fail(r.err)
+			return
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := f(r.id, r.obj)
+		if err != nil {
+			fail(err)
+		}
+	}
+
+	// Bounds the items fetched ahead of an ordered handler.
+	window := make(chan struct{}, 2*workers)
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+
+		for i := range ids {
+			if cfg.ordered {
+				select {
+				case window <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan applyResult, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				obj, err := fetch(ctx, ids[i])
+				r := applyResult{i, ids[i], obj, err}
+
+				if cfg.ordered {
+					results <- r
+				} else {
+					handle(r)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Hand fetched items to the handler in order.
+	pending := map[int]applyResult{}
+	next := 0
+	for r := range results {
+		pending[r.idx] = r
+
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+
+			handle(p)
+			<-window
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if first != nil {
+		return first
+	}
+
+	return cfg.ctx.Err()
+}
@@ -0,0 +1,175 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "errors"
+import "fmt"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "time"
+
+func TestApplyTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func newApplyStore(n int) MapStore {
+	ms := NewMapStore()
+	for i := 0; i < n; i++ {
+		ms.StoreItem(ID(fmt.Sprintf("%03d", i)), i)
+	}
+
+	return ms
+}
+
+// A store without ApplyParallel, holding retrieves until n of them
+// are in flight and recording the most seen at once.
+type gatedStore struct {
+	Store
+	n       int32
+	mu      sync.Mutex
+	active  int32
+	max     int32
+	release chan struct{}
+}
+
+func newGatedStore(s Store, n int32) *gatedStore {
+	return &gatedStore{Store: s, n: n, release: make(chan struct{})}
+}
+
+func (s *gatedStore) Retrieve(id ID) (Storable, error) {
+
+	s.mu.Lock()
+	s.active++
+	if s.active > s.max {
+		s.max = s.active
+	}
+	if s.max == s.n {
+		select {
+		case <-s.release:
+		default:
+			close(s.release)
+		}
+	}
+	s.mu.Unlock()
+
+	// Only guards against hanging when retrieves are sequential.
+	select {
+	case <-s.release:
+	case <-time.After(5 * time.Second):
+	}
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+
+	return s.Store.Retrieve(id)
+}
+
+func TestApplyParallel(t *testing.T) {
+
+	ms := newApplyStore(100)
+
+	var mu sync.Mutex
+	sum := 0
+	err := ms.ApplyParallel(func(id ID, obj Storable) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sum += obj.(int)
+		return nil
+	}, 8)
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 4950, sum)
+}
+
+func TestApplyParallelConcurrent(t *testing.T) {
+
+	s := newGatedStore(NewSyncStore(newApplyStore(20)), 10)
+
+	var n int32
+	err := ApplyParallel(s, func(id ID, obj Storable) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	}, 10)
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int32(20), n)
+
+	// Every worker retrieved at once, and no more.
+	assert.Expect(t, int32(10), s.max)
+}
+
+func TestApplyParallelOrdered(t *testing.T) {
+
+	s := &listedStore{newApplyStore(30), nil}
+	s.ids, _ = s.Store.List()
+
+	seen := []ID{}
+	err := ApplyParallel(s, func(id ID, obj Storable) error {
+		seen = append(seen, id)
+		return nil
+	}, 5, OptApplyOrdered())
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, s.ids, seen)
+}
+
+// A store with a fixed listing.
+type listedStore struct {
+	Store
+	ids []ID
+}
+
+func (s *listedStore) List() ([]ID, error) {
+	return s.ids, nil
+}
+
+func TestApplyParallelFirstError(t *testing.T) {
+
+	ms := newApplyStore(1000)
+	fail := errors.New("Failed")
+
+	var n int32
+	err := ms.ApplyParallel(func(id ID, obj Storable) error {
+		atomic.AddInt32(&n, 1)
+		return fail
+	}, 4)
+
+	assert.Expect(t, fail, err)
+
+	if atomic.LoadInt32(&n) == 1000 {
+		t.Error("Expected the first error to stop the remaining items.")
+	}
+}
+
+func TestApplyParallelCollectErrors(t *testing.T) {
+
+	ms := newApplyStore(10)
+
+	err := ms.ApplyParallel(func(id ID, obj Storable) error {
+		if obj.(int)%2 == 0 {
+			return fmt.Errorf("Item %v failed", id)
+		}
+		return nil
+	}, 3, OptApplyCollectErrors())
+
+	errs, ok := err.(MultiError)
+	assert.Expect(t, true, ok)
+	assert.Expect(t, 5, len(errs))
+}
+
+func TestApplyParallelCancel(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ms := newApplyStore(100)
+	err := ms.ApplyParallel(func(id ID, obj Storable) error {
+		cancel()
+		return nil
+	}, 2, OptApplyContext(ctx))
+
+	assert.Expect(t, context.Canceled, err)
+}
@@ -0,0 +1,472 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bufio"
+import "context"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "fmt"
+import "io"
+import "sort"
+import "strconv"
+import "sync"
+import "time"
+
+type AuditError string
+
+func (err AuditError) Error() string {
+	return (string)(err)
+}
+
+const (
+	AuditStore  = "store"
+	AuditDelete = "delete"
+)
+
+// A record of a single store mutation.
+//
+// Before and After are hex encoded SHA-256 digests of the encoded
+// item, empty when the item did not exist.  Each record includes the
+// Hash of the previous record so that any modification of the log
+// breaks the chain.
+//
+type AuditRecord struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Op        string    `json:"op"`
+	ID        ID        `json:"id"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	Prev      string    `json:"prev"`
+	Hash      string    `json:"hash"`
+}
+
+// Calculate the chained hash of the record.
+func (r AuditRecord) Digest() string {
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%q\n%s\n%q\n%s\n%s\n%s\n",
+		r.Seq, r.Time.UTC().Format(time.RFC3339Nano), r.Principal,
+		r.Op, r.ID, r.Before, r.After, r.Prev)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A destination for audit records.
+type AuditSink interface {
+	Append(AuditRecord) error
+}
+
+type AuditSinkFunc func(AuditRecord) error
+
+func (f AuditSinkFunc) Append(r AuditRecord) error {
+	return f(r)
+}
+
+// Audit sinks able to return the last record appended, so that a new
+// AuditedStore continues their chain.
+type AuditTail interface {
+	AuditSink
+	Last() (AuditRecord, bool, error)
+}
+
+type storeAuditSink struct {
+	store Store
+}
+
+// Keep audit records in a store, keyed by their zero padded sequence
+// number.
+//
+// The sink is an AuditTail.
+//
+func StoreAuditSink(store Store) AuditSink {
+	return storeAuditSink{store}
+}
+
+func (s storeAuditSink) Append(r AuditRecord) error {
+	return s.store.StoreItem(auditID(r.Seq), r)
+}
+
+func (s storeAuditSink) Last() (AuditRecord, bool, error) {
+
+	ids, err := s.store.List()
+	if err != nil {
+		return AuditRecord{}, false, err
+	}
+
+	if len(ids) == 0 {
+		return AuditRecord{}, false, nil
+	}
+
+	// Zero padding sorts the IDs in sequence order.
+	last := ids[0]
+	for _, id := range ids[1:] {
+		if id > last {
+			last = id
+		}
+	}
+
+	obj, err := s.store.Retrieve(last)
+	if err != nil {
+		return AuditRecord{}, false, err
+	}
+
+	r, ok := obj.(AuditRecord)
+	if !ok {
+		return AuditRecord{}, false, AuditError("Item " + (string)(last) + " is not an audit record.")
+	}
+
+	return r, true, nil
+}
+
+func auditID(seq int64) ID {
+	return (ID)(fmt.Sprintf("%020d", seq))
+}
+
+// Write audit records as lines of JSON, for example to a file opened
+// with os.O_APPEND.
+//
+// Stores appending to an existing log must be given its last record
+// with OptAuditResume, see ReadAuditLog.
+//
+func WriterAuditSink(w io.Writer) AuditSink {
+	mu := sync.Mutex{}
+	return AuditSinkFunc(func(r AuditRecord) error {
+		bs, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		_, err = w.Write(append(bs, '\n'))
+		return err
+	})
+}
+
+// Read audit records written by a WriterAuditSink.
+func ReadAuditLog(r io.Reader) ([]AuditRecord, error) {
+
+	records := []AuditRecord{}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		rec := AuditRecord{}
+		err := json.Unmarshal(s.Bytes(), &rec)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, s.Err()
+}
+
+// Read audit records kept by a StoreAuditSink, in sequence order.
+func ReadAuditStore(store Store) ([]AuditRecord, error) {
+
+	records := []AuditRecord{}
+
+	err := store.Apply(func(id ID, obj Storable) error {
+		rec, ok := obj.(AuditRecord)
+		if !ok {
+			return AuditError("Item " + (string)(id) + " is not an audit record.")
+		}
+
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+
+	return records, nil
+}
+
+// Check that records form an unbroken hash chain.
+//
+// Records must be in sequence order, starting from the beginning of
+// the log.
+//
+func VerifyAuditChain(records []AuditRecord) error {
+
+	prev := ""
+	for i, r := range records {
+
+		if r.Seq != int64(i+1) {
+			return AuditError("Audit record " + strconv.Itoa(i+1) + " is missing.")
+		}
+
+		if r.Prev != prev {
+			return AuditError("Audit record " + strconv.FormatInt(r.Seq, 10) +
+				" does not follow the previous record.")
+		}
+
+		if r.Hash != r.Digest() {
+			return AuditError("Audit record " + strconv.FormatInt(r.Seq, 10) +
+				" has been modified.")
+		}
+
+		prev = r.Hash
+	}
+
+	return nil
+}
+
+type AuditedStoreOpt func(*AuditedStore)
+
+// Continue the chain of an existing audit log from its last record.
+//
+// Sinks implementing AuditTail are resumed without this option.
+//
+func OptAuditResume(last AuditRecord) AuditedStoreOpt {
+	return func(s *AuditedStore) {
+		s.seq = last.Seq
+		s.prev = last.Hash
+		s.resumed = true
+	}
+}
+
+// A store wrapper which records every mutation to an AuditSink.
+//
+// The principal is taken from the context passed to
+// StoreItemContext, UpdateContext or DeleteContext, see WithPrincipal,
+// and the context is passed on to the underlying store, including the
+// read of the item before it changes.  Records are
+// written once the mutation has succeeded, if the record can not be
+// written the mutation is undone and the error returned.
+//
+type AuditedStore struct {
+	store   Store
+	sink    AuditSink
+	enc     func(Storable) ([]byte, error)
+	seq     int64
+	prev    string
+	resumed bool
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// Create an AuditedStore, continuing the chain of sinks implementing
+// AuditTail from their last record.
+func NewAuditedStore(store Store, sink AuditSink, enc func(Storable) ([]byte, error), opts ...AuditedStoreOpt) (*AuditedStore, error) {
+
+	s := &AuditedStore{
+		store: store,
+		sink:  sink,
+		enc:   enc,
+		now:   time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if tail, ok := sink.(AuditTail); ok && !s.resumed {
+		last, found, err := tail.Last()
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			OptAuditResume(last)(s)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *AuditedStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *AuditedStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+	return s.UpdateContext(ctx, id, func(Storable, bool) (Storable, error) {
+		return obj, nil
+	})
+}
+
+func (s *AuditedStore) Update(id ID, f UpdateFunc) error {
+	return s.UpdateContext(context.Background(), id, f)
+}
+
+// Apply f and record the change.
+//
+// Mutations through the AuditedStore are serialized, so the update is
+// atomic as long as the underlying store is not written around it.
+//
+func (s *AuditedStore) UpdateContext(ctx context.Context, id ID, f UpdateFunc) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, before, err := s.current(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	obj, err := f(old, old != nil)
+	if err != nil {
+		return err
+	}
+
+	after, err := s.digest(obj)
+	if err != nil {
+		return err
+	}
+
+	err = StoreItemContext(ctx, s.store, id, obj)
+	if err != nil {
+		return err
+	}
+
+	err = s.record(ctx, AuditStore, id, before, after)
+	if err != nil {
+		s.restore(ctx, id, old)
+		return err
+	}
+
+	return nil
+}
+
+func (s *AuditedStore) Retrieve(id ID) (Storable, error) {
+	return s.store.Retrieve(id)
+}
+
+func (s *AuditedStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+	return RetrieveContext(ctx, s.store, id)
+}
+
+func (s *AuditedStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *AuditedStore) ListContext(ctx context.Context) ([]ID, error) {
+	return ListContext(ctx, s.store)
+}
+
+func (s *AuditedStore) Search(query string, limit int) ([]SearchResult, error) {
+	return Search(s.store, query, limit)
+}
+
+func (s *AuditedStore) Apply(f ItemHandler) error {
+	return s.store.Apply(f)
+}
+
+func (s *AuditedStore) ApplyContext(ctx context.Context, f ItemHandler) error {
+	return ApplyContext(ctx, s.store, f)
+}
+
+func (s *AuditedStore) Delete(id ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *AuditedStore) DeleteContext(ctx context.Context, id ID) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, before, err := s.current(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteContext(ctx, s.store, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.record(ctx, AuditDelete, id, before, "")
+	if err != nil {
+		s.restore(ctx, id, old)
+		return err
+	}
+
+	return nil
+}
+
+// Undo a mutation which could not be recorded, old is nil when the
+// item did not exist.
+//
+// Errors are ignored as the record error is more useful to the
+// caller.
+//
+func (s *AuditedStore) restore(ctx context.Context, id ID, old Storable) {
+
+	if old == nil {
+		DeleteContext(ctx, s.store, id)
+		return
+	}
+
+	StoreItemContext(ctx, s.store, id, old)
+}
+
+func (s *AuditedStore) digest(obj Storable) (string, error) {
+
+	bs, err := s.enc(obj)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(bs)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// The current item and its digest, or nil and an empty digest when it
+// does not exist.
+func (s *AuditedStore) current(ctx context.Context, id ID) (Storable, string, error) {
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+	if err == ErrNotFound {
+		return nil, "", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	d, err := s.digest(obj)
+
+	return obj, d, err
+}
+
+func (s *AuditedStore) record(ctx context.Context, op string, id ID, before, after string) error {
+
+	r := AuditRecord{
+		Seq:       s.seq + 1,
+		Time:      s.now(),
+		Principal: Principal(ctx),
+		Op:        op,
+		ID:        id,
+		Before:    before,
+		After:     after,
+		Prev:      s.prev,
+	}
+
+	r.Hash = r.Digest()
+
+	err := s.sink.Append(r)
+	if err != nil {
+		return err
+	}
+
+	s.seq = r.Seq
+	s.prev = r.Hash
+
+	return nil
+}
@@ -0,0 +1,165 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "bytes"
+import "context"
+
+func TestAuditTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestAuditedStore(t *testing.T) {
+
+	log := NewMapStore()
+	as, _ := NewAuditedStore(NewMapStore(), StoreAuditSink(log), stringBytes)
+
+	ctx := WithPrincipal(context.Background(), "alice")
+
+	err := as.StoreItemContext(ctx, "1", "Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	as.StoreItem("1", "Hello!")
+	as.DeleteContext(ctx, "1")
+
+	records, err := ReadAuditStore(log)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 3, len(records))
+
+	assert.Expect(t, "alice", records[0].Principal)
+	assert.Expect(t, AuditStore, records[0].Op)
+	assert.Expect(t, "", records[0].Before)
+	assert.Expect(t, string(helloSHA256), records[0].After)
+
+	assert.Expect(t, "", records[1].Principal)
+	assert.Expect(t, records[0].After, records[1].Before)
+
+	assert.Expect(t, AuditDelete, records[2].Op)
+	assert.Expect(t, "", records[2].After)
+
+	assert.Expect(t, nil, VerifyAuditChain(records))
+}
+
+func TestAuditedAuthorizedStore(t *testing.T) {
+
+	log := NewMapStore()
+	as, _ := NewAuditedStore(
+		NewAuthorizedStore(NewMapStore(), NewRulePolicy(AllowAuthenticated())),
+		StoreAuditSink(log), stringBytes)
+
+	ctx := WithPrincipal(context.Background(), "alice")
+
+	assert.Expect(t, nil, as.StoreItemContext(ctx, "1", "Hello World!"))
+	assert.Expect(t, nil, as.StoreItemContext(ctx, "1", "Hello!"))
+
+	obj, err := as.RetrieveContext(ctx, "1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello!", obj)
+
+	ids, err := as.ListContext(ctx)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"1"}, ids)
+
+	assert.Expect(t, nil, as.DeleteContext(ctx, "1"))
+	assert.Expect(t, ErrUnauthenticated, as.StoreItem("1", "Hello!"))
+
+	records, err := ReadAuditStore(log)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 3, len(records))
+	assert.Expect(t, records[0].After, records[1].Before)
+}
+
+func TestAuditTamper(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	as, _ := NewAuditedStore(NewMapStore(), WriterAuditSink(buf), stringBytes)
+
+	as.StoreItem("1", "one")
+	as.StoreItem("2", "two")
+	as.StoreItem("3", "three")
+
+	records, err := ReadAuditLog(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, nil, VerifyAuditChain(records))
+
+	modified := append([]AuditRecord{}, records...)
+	modified[1].ID = "4"
+	_, ok := VerifyAuditChain(modified).(AuditError)
+	assert.Expect(t, true, ok)
+
+	removed := []AuditRecord{records[0], records[2]}
+	_, ok = VerifyAuditChain(removed).(AuditError)
+	assert.Expect(t, true, ok)
+}
+
+func TestAuditResume(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	store := NewMapStore()
+
+	as, _ := NewAuditedStore(store, WriterAuditSink(buf), stringBytes)
+	as.StoreItem("1", "one")
+
+	records, _ := ReadAuditLog(bytes.NewReader(buf.Bytes()))
+
+	as, _ = NewAuditedStore(store, WriterAuditSink(buf), stringBytes,
+		OptAuditResume(records[len(records)-1]))
+	as.Delete("1")
+
+	records, _ = ReadAuditLog(bytes.NewReader(buf.Bytes()))
+	assert.Expect(t, 2, len(records))
+	assert.Expect(t, nil, VerifyAuditChain(records))
+}
+
+func TestAuditStoreSinkResume(t *testing.T) {
+
+	log := NewMapStore()
+	store := NewMapStore()
+
+	as, _ := NewAuditedStore(store, StoreAuditSink(log), stringBytes)
+	as.StoreItem("1", "one")
+	as.StoreItem("2", "two")
+
+	// A new store continues the log rather than overwriting it.
+	as, err := NewAuditedStore(store, StoreAuditSink(log), stringBytes)
+	assert.Expect(t, nil, err)
+
+	as.Delete("1")
+
+	records, _ := ReadAuditStore(log)
+	assert.Expect(t, 3, len(records))
+	assert.Expect(t, nil, VerifyAuditChain(records))
+}
+
+func TestAuditSinkFailure(t *testing.T) {
+
+	fail := AuditError("The sink failed.")
+	sink := AuditSinkFunc(func(r AuditRecord) error {
+		return fail
+	})
+
+	store := NewMapStore()
+	store.StoreItem("1", "one")
+
+	as, _ := NewAuditedStore(store, sink, stringBytes)
+
+	assert.Expect(t, fail, as.StoreItem("1", "changed"))
+	assert.Expect(t, "one", store["1"])
+
+	assert.Expect(t, fail, as.StoreItem("2", "two"))
+	_, ok := store["2"]
+	assert.Expect(t, false, ok)
+
+	assert.Expect(t, fail, as.Delete("1"))
+	assert.Expect(t, "one", store["1"])
+}
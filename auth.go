@@ -0,0 +1,701 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "strings"
+
+type AuthError string
+
+func (err AuthError) Error() string {
+	return (string)(err)
+}
+
+// Returned when an anonymous caller is denied.
+const ErrUnauthenticated = AuthError("Authentication is required.")
+
+// Returned when an authenticated caller is denied.
+const ErrForbidden = AuthError("The operation is not permitted.")
+
+// An operation subject to authorization.
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionWrite  Action = "write"
+	ActionDelete Action = "delete"
+	ActionList   Action = "list"
+)
+
+// Decides whether principal may perform action on an item.
+//
+// The principal is empty for anonymous callers.  For reads and
+// deletes obj is the stored item, for writes it is the new item.  For
+// ActionList both id and obj are empty.
+//
+type Policy interface {
+	Authorize(principal string, action Action, id ID, obj Storable) error
+}
+
+type PolicyFunc func(principal string, action Action, id ID, obj Storable) error
+
+func (f PolicyFunc) Authorize(principal string, action Action, id ID, obj Storable) error {
+	return f(principal, action, id, obj)
+}
+
+type Decision int
+
+const (
+	Abstain Decision = iota
+	Allow
+	Deny
+)
+
+// A single authorization rule, returning Abstain when it does not
+// apply.
+type Rule func(principal string, action Action, id ID, obj Storable) Decision
+
+// A Policy evaluating rules in order.
+//
+// The first rule to Allow or Deny decides, when every rule abstains
+// the request is denied.
+//
+type RulePolicy struct {
+	rules []Rule
+}
+
+func NewRulePolicy(rules ...Rule) *RulePolicy {
+	return &RulePolicy{rules}
+}
+
+func (p *RulePolicy) Authorize(principal string, action Action, id ID, obj Storable) error {
+
+	if decide(p.rules, principal, action, id, obj) == Allow {
+		return nil
+	}
+
+	if principal == "" {
+		return ErrUnauthenticated
+	}
+
+	return ErrForbidden
+}
+
+func decide(rules []Rule, principal string, action Action, id ID, obj Storable) Decision {
+	for _, rule := range rules {
+		d := rule(principal, action, id, obj)
+		if d != Abstain {
+			return d
+		}
+	}
+
+	return Abstain
+}
+
+// Whether action is one of actions, an empty list matches every
+// action.
+func matchAction(action Action, actions []Action) bool {
+
+	if len(actions) == 0 {
+		return true
+	}
+
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allow actions to everyone, including anonymous callers.
+func AllowAnyone(actions ...Action) Rule {
+	return func(principal string, action Action, id ID, obj Storable) Decision {
+		if matchAction(action, actions) {
+			return Allow
+		}
+
+		return Abstain
+	}
+}
+
+// Allow actions to any authenticated caller.
+func AllowAuthenticated(actions ...Action) Rule {
+	return func(principal string, action Action, id ID, obj Storable) Decision {
+		if principal != "" && matchAction(action, actions) {
+			return Allow
+		}
+
+		return Abstain
+	}
+}
+
+// Deny actions to everyone.
+func DenyAll(actions ...Action) Rule {
+	return func(principal string, action Action, id ID, obj Storable) Decision {
+		if matchAction(action, actions) {
+			return Deny
+		}
+
+		return Abstain
+	}
+}
+
+// Lists the roles held by a principal.
+type RoleFunc func(principal string) []string
+
+// Allow actions to principals holding role.
+func AllowRole(roles RoleFunc, role string, actions ...Action) Rule {
+	return func(principal string, action Action, id ID, obj Storable) Decision {
+		if principal == "" || !matchAction(action, actions) {
+			return Abstain
+		}
+
+		for _, r := range roles(principal) {
+			if r == role {
+				return Allow
+			}
+		}
+
+		return Abstain
+	}
+}
+
+// Determines the owning principal of an item.
+type OwnerFunc func(id ID, obj Storable) string
+
+// Allow actions to the owner of an item.
+//
+// The rule abstains when there is no item, such as for List requests
+// or deletes of missing items.
+//
+// Note: Writes are checked against both the new item and any existing
+// item, see AuthorizedStore.
+//
+func AllowOwner(owner OwnerFunc, actions ...Action) Rule {
+	return func(principal string, action Action, id ID, obj Storable) Decision {
+		if principal == "" || obj == nil || !matchAction(action, actions) {
+			return Abstain
+		}
+
+		if owner(id, obj) == principal {
+			return Allow
+		}
+
+		return Abstain
+	}
+}
+
+// Apply rules only to items with IDs starting with prefix.
+//
+// The nested rules are evaluated in order, if they all abstain so
+// does the prefix rule.  List requests are not matched.
+//
+func ForPrefix(prefix string, rules ...Rule) Rule {
+	return func(principal string, action Action, id ID, obj Storable) Decision {
+		if action == ActionList || !strings.HasPrefix((string)(id), prefix) {
+			return Abstain
+		}
+
+		return decide(rules, principal, action, id, obj)
+	}
+}
+
+// A store wrapper enforcing a Policy.
+//
+// The principal is taken from the context passed to the context
+// aware methods, see WithPrincipal.  The plain Store methods act as
+// an anonymous caller.  Apply, ApplyContext and ApplyParallel skip
+// items the principal may not read.
+//
+// Missing items are authorized as nil, so callers denied an action
+// can not tell whether the item exists.
+//
+// Updates, search, revisions, the trash and metadata of the
+// underlying store are subject to the same policy.  When the
+// underlying store lacks them, they return ErrUpdateUnsupported,
+// ErrSearchUnsupported, ErrRevisionsUnsupported, ErrTrashUnsupported
+// or ErrMetaUnsupported, while metadata reads
+// return empty metadata.
+//
+type AuthorizedStore struct {
+	store  Store
+	policy Policy
+}
+
+func NewAuthorizedStore(store Store, policy Policy) *AuthorizedStore {
+	return &AuthorizedStore{store, policy}
+}
+
+func (s *AuthorizedStore) Policy() Policy {
+	return s.policy
+}
+
+// Authorize action on an item retrieved with err, returning
+// ErrNotFound for missing items only when the action is allowed.
+func (s *AuthorizedStore) authorizeFound(ctx context.Context, action Action, id ID, obj Storable, err error) error {
+
+	if err == ErrNotFound {
+		obj = nil
+	} else if err != nil {
+		return err
+	}
+
+	aerr := s.policy.Authorize(Principal(ctx), action, id, obj)
+	if aerr != nil {
+		return aerr
+	}
+
+	return err
+}
+
+// Authorize writing obj over the current item, if any.
+func (s *AuthorizedStore) authorizeWrite(ctx context.Context, id ID, obj Storable) error {
+
+	p := Principal(ctx)
+
+	old, err := RetrieveContext(ctx, s.store, id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if err == nil {
+		err = s.policy.Authorize(p, ActionWrite, id, old)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.policy.Authorize(p, ActionWrite, id, obj)
+}
+
+func (s *AuthorizedStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *AuthorizedStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+
+	err := s.authorizeWrite(ctx, id, obj)
+	if err != nil {
+		return err
+	}
+
+	return StoreItemContext(ctx, s.store, id, obj)
+}
+
+func (s *AuthorizedStore) Retrieve(id ID) (Storable, error) {
+	return s.RetrieveContext(context.Background(), id)
+}
+
+func (s *AuthorizedStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+
+	err = s.authorizeFound(ctx, ActionRead, id, obj, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (s *AuthorizedStore) List() ([]ID, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *AuthorizedStore) ListContext(ctx context.Context) ([]ID, error) {
+
+	err := s.policy.Authorize(Principal(ctx), ActionList, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ListContext(ctx, s.store)
+}
+
+func (s *AuthorizedStore) Search(query string, limit int) ([]SearchResult, error) {
+	return s.SearchContext(context.Background(), query, limit)
+}
+
+// Search for items the principal may read, given it may list them.
+//
+// The underlying store is searched without a limit, as denied items
+// are dropped before limit is applied.
+//
+func (s *AuthorizedStore) SearchContext(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+
+	p := Principal(ctx)
+
+	err := s.policy.Authorize(p, ActionList, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := SearchContext(ctx, s.store, query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := []SearchResult{}
+	for _, r := range results {
+		if limit > 0 && len(allowed) >= limit {
+			break
+		}
+
+		obj, err := RetrieveContext(ctx, s.store, r.ID)
+		if err != nil {
+			continue
+		}
+
+		if s.policy.Authorize(p, ActionRead, r.ID, obj) == nil {
+			allowed = append(allowed, r)
+		}
+	}
+
+	return allowed, nil
+}
+
+func (s *AuthorizedStore) Apply(f ItemHandler) error {
+	return s.ApplyContext(context.Background(), f)
+}
+
+func (s *AuthorizedStore) ApplyContext(ctx context.Context, f ItemHandler) error {
+
+	p := Principal(ctx)
+
+	return s.store.Apply(func(id ID, obj Storable) error {
+		if s.policy.Authorize(p, ActionRead, id, obj) != nil {
+			return nil
+		}
+
+		return f(id, obj)
+	})
+}
+
+// Apply f concurrently to the items the principal may read, skipping
+// the others as ApplyContext does.  The principal is taken from the
+// context of OptApplyContext.
+func (s *AuthorizedStore) ApplyParallel(f ItemHandler, workers int, opts ...ApplyOpt) error {
+
+	cfg := applyConfig{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	p := Principal(cfg.ctx)
+
+	return ApplyParallel(s.store, func(id ID, obj Storable) error {
+		if s.policy.Authorize(p, ActionRead, id, obj) != nil {
+			return nil
+		}
+
+		return f(id, obj)
+	}, workers, opts...)
+}
+
+func (s *AuthorizedStore) Delete(id ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *AuthorizedStore) DeleteContext(ctx context.Context, id ID) error {
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	err = s.policy.Authorize(Principal(ctx), ActionDelete, id, obj)
+	if err != nil {
+		return err
+	}
+
+	return DeleteContext(ctx, s.store, id)
+}
+
+func (s *AuthorizedStore) Update(id ID, f UpdateFunc) error {
+	return s.UpdateContext(context.Background(), id, f)
+}
+
+// Update an item the principal may both read and write, with a new
+// value it may write.
+func (s *AuthorizedStore) UpdateContext(ctx context.Context, id ID, f UpdateFunc) error {
+
+	p := Principal(ctx)
+
+	return UpdateContext(ctx, s.store, id, func(old Storable, exists bool) (Storable, error) {
+
+		if exists {
+			for _, action := range []Action{ActionRead, ActionWrite} {
+				err := s.policy.Authorize(p, action, id, old)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		obj, err := f(old, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.policy.Authorize(p, ActionWrite, id, obj)
+		if err != nil {
+			return nil, err
+		}
+
+		return obj, nil
+	})
+}
+
+func (s *AuthorizedStore) revisions() (RevisionStore, error) {
+
+	rs, ok := s.store.(RevisionStore)
+	if !ok {
+		return nil, ErrRevisionsUnsupported
+	}
+
+	return rs, nil
+}
+
+func (s *AuthorizedStore) History(id ID) ([]Revision, error) {
+	return s.HistoryContext(context.Background(), id)
+}
+
+// List the revisions of an item the principal may read, deleted items
+// are authorized as nil.
+func (s *AuthorizedStore) HistoryContext(ctx context.Context, id ID) ([]Revision, error) {
+
+	rs, err := s.revisions()
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	err = s.policy.Authorize(Principal(ctx), ActionRead, id, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return HistoryContext(ctx, rs, id)
+}
+
+func (s *AuthorizedStore) RetrieveRevision(id ID, rev int) (Storable, error) {
+	return s.RetrieveRevisionContext(context.Background(), id, rev)
+}
+
+// Retrieve a revision the principal may read.
+func (s *AuthorizedStore) RetrieveRevisionContext(ctx context.Context, id ID, rev int) (Storable, error) {
+
+	rs, err := s.revisions()
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := RetrieveRevisionContext(ctx, rs, id, rev)
+
+	err = s.authorizeFound(ctx, ActionRead, id, obj, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (s *AuthorizedStore) Revert(id ID, rev int) error {
+	return s.RevertContext(context.Background(), id, rev)
+}
+
+// Revert an item to a revision, which the principal must be able to
+// write over the current item.
+func (s *AuthorizedStore) RevertContext(ctx context.Context, id ID, rev int) error {
+
+	rs, err := s.revisions()
+	if err != nil {
+		return err
+	}
+
+	obj, err := RetrieveRevisionContext(ctx, rs, id, rev)
+
+	err = s.authorizeFound(ctx, ActionRead, id, obj, err)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorizeWrite(ctx, id, obj)
+	if err != nil {
+		return err
+	}
+
+	return RevertContext(ctx, rs, id, rev)
+}
+
+func (s *AuthorizedStore) trash() (TrashStore, error) {
+
+	ts, ok := s.store.(TrashStore)
+	if !ok {
+		return nil, ErrTrashUnsupported
+	}
+
+	return ts, nil
+}
+
+func (s *AuthorizedStore) Restore(id ID) error {
+	return s.RestoreContext(context.Background(), id)
+}
+
+// Restore a trashed item the principal may write.
+func (s *AuthorizedStore) RestoreContext(ctx context.Context, id ID) error {
+
+	ts, err := s.trash()
+	if err != nil {
+		return err
+	}
+
+	tr, err := RetrieveTrashContext(ctx, ts, id)
+
+	err = s.authorizeFound(ctx, ActionWrite, id, tr.Value, err)
+	if err != nil {
+		return err
+	}
+
+	return RestoreContext(ctx, ts, id)
+}
+
+func (s *AuthorizedStore) ListTrash() ([]ID, error) {
+	return s.ListTrashContext(context.Background())
+}
+
+// List the trashed items the principal may read.
+func (s *AuthorizedStore) ListTrashContext(ctx context.Context) ([]ID, error) {
+
+	ts, err := s.trash()
+	if err != nil {
+		return nil, err
+	}
+
+	p := Principal(ctx)
+
+	err = s.policy.Authorize(p, ActionList, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := ListTrashContext(ctx, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := []ID{}
+	for _, id := range ids {
+		tr, err := RetrieveTrashContext(ctx, ts, id)
+		if err != nil {
+			continue
+		}
+
+		if s.policy.Authorize(p, ActionRead, id, tr.Value) == nil {
+			allowed = append(allowed, id)
+		}
+	}
+
+	return allowed, nil
+}
+
+func (s *AuthorizedStore) RetrieveTrash(id ID) (Trashed, error) {
+	return s.RetrieveTrashContext(context.Background(), id)
+}
+
+// Retrieve a trashed item the principal may read.
+func (s *AuthorizedStore) RetrieveTrashContext(ctx context.Context, id ID) (Trashed, error) {
+
+	ts, err := s.trash()
+	if err != nil {
+		return Trashed{}, err
+	}
+
+	tr, err := RetrieveTrashContext(ctx, ts, id)
+
+	err = s.authorizeFound(ctx, ActionRead, id, tr.Value, err)
+	if err != nil {
+		return Trashed{}, err
+	}
+
+	return tr, nil
+}
+
+func (s *AuthorizedStore) Purge(id ID) error {
+	return s.PurgeContext(context.Background(), id)
+}
+
+// Purge a trashed item the principal may delete.
+func (s *AuthorizedStore) PurgeContext(ctx context.Context, id ID) error {
+
+	ts, err := s.trash()
+	if err != nil {
+		return err
+	}
+
+	tr, err := RetrieveTrashContext(ctx, ts, id)
+
+	err = s.authorizeFound(ctx, ActionDelete, id, tr.Value, err)
+	if err != nil {
+		return err
+	}
+
+	return PurgeContext(ctx, ts, id)
+}
+
+func (s *AuthorizedStore) StoreItemWithMeta(id ID, obj Storable, meta Meta) error {
+	return s.StoreItemWithMetaContext(context.Background(), id, obj, meta)
+}
+
+func (s *AuthorizedStore) StoreItemWithMetaContext(ctx context.Context, id ID, obj Storable, meta Meta) error {
+
+	ms, ok := s.store.(MetaStore)
+	if !ok {
+		return ErrMetaUnsupported
+	}
+
+	err := s.authorizeWrite(ctx, id, obj)
+	if err != nil {
+		return err
+	}
+
+	return StoreItemWithMetaContext(ctx, ms, id, obj, meta)
+}
+
+func (s *AuthorizedStore) RetrieveMeta(id ID) (Storable, Meta, error) {
+	return s.RetrieveMetaContext(context.Background(), id)
+}
+
+func (s *AuthorizedStore) RetrieveMetaContext(ctx context.Context, id ID) (Storable, Meta, error) {
+
+	var obj Storable
+	var meta Meta
+	var err error
+
+	if ms, ok := s.store.(MetaStore); ok {
+		obj, meta, err = RetrieveMetaContext(ctx, ms, id)
+	} else {
+		obj, err = RetrieveContext(ctx, s.store, id)
+	}
+
+	err = s.authorizeFound(ctx, ActionRead, id, obj, err)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+
+	return obj, meta, nil
+}
+
+// The metadata of an item the principal may read, anonymously.
+func (s *AuthorizedStore) Stat(id ID) (Meta, error) {
+
+	_, meta, err := s.RetrieveMeta(id)
+
+	return meta, err
+}
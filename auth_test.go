@@ -0,0 +1,263 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "context"
+import "strings"
+import "sync"
+
+func TestAuthTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type doc struct {
+	Owner string
+	Text  string
+}
+
+func docOwner(id ID, obj Storable) string {
+	d, ok := obj.(doc)
+	if !ok {
+		return ""
+	}
+
+	return d.Owner
+}
+
+func testRoles(principal string) []string {
+	if principal == "root" {
+		return []string{"admin"}
+	}
+
+	return nil
+}
+
+func newTestPolicy() *RulePolicy {
+	return NewRulePolicy(
+		AllowRole(testRoles, "admin"),
+		ForPrefix("public/", AllowAnyone(ActionRead)),
+		ForPrefix("locked/", DenyAll(ActionWrite, ActionDelete)),
+		AllowOwner(docOwner),
+		AllowAuthenticated(ActionList),
+	)
+}
+
+func TestRulePolicy(t *testing.T) {
+
+	p := newTestPolicy()
+	d := doc{Owner: "alice"}
+
+	assert.Expect(t, nil, p.Authorize("alice", ActionWrite, "1", d))
+	assert.Expect(t, ErrForbidden, p.Authorize("bob", ActionRead, "1", d))
+	assert.Expect(t, ErrUnauthenticated, p.Authorize("", ActionRead, "1", d))
+	assert.Expect(t, nil, p.Authorize("root", ActionDelete, "1", d))
+
+	assert.Expect(t, nil, p.Authorize("", ActionRead, "public/1", d))
+	assert.Expect(t, ErrForbidden, p.Authorize("alice", ActionWrite, "locked/1", d))
+
+	assert.Expect(t, nil, p.Authorize("bob", ActionList, "", nil))
+	assert.Expect(t, ErrUnauthenticated, p.Authorize("", ActionList, "", nil))
+}
+
+func TestAuthorizedStore(t *testing.T) {
+
+	as := NewAuthorizedStore(NewMapStore(), newTestPolicy())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	err := as.StoreItemContext(alice, "1", doc{"alice", "Hello"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Bob may not take over Alice's item.
+	err = as.StoreItemContext(bob, "1", doc{"bob", "Mine now"})
+	assert.Expect(t, ErrForbidden, err)
+
+	_, err = as.RetrieveContext(bob, "1")
+	assert.Expect(t, ErrForbidden, err)
+
+	_, err = as.Retrieve("1")
+	assert.Expect(t, ErrUnauthenticated, err)
+
+	obj, err := as.RetrieveContext(alice, "1")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, doc{"alice", "Hello"}, obj)
+
+	as.StoreItemContext(bob, "2", doc{"bob", "Hi"})
+
+	texts := []string{}
+	as.ApplyContext(bob, func(id ID, obj Storable) error {
+		texts = append(texts, obj.(doc).Text)
+		return nil
+	})
+	assert.Expect(t, "Hi", strings.Join(texts, ","))
+
+	assert.Expect(t, ErrForbidden, as.DeleteContext(bob, "1"))
+	assert.Expect(t, nil, as.DeleteContext(alice, "1"))
+}
+
+func TestAuthorizedStoreApplyParallel(t *testing.T) {
+
+	as := NewAuthorizedStore(NewSyncStore(NewMapStore()), newTestPolicy())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	as.StoreItemContext(alice, "1", doc{"alice", "Hello"})
+	as.StoreItemContext(bob, "2", doc{"bob", "Hi"})
+	as.StoreItemContext(alice, "3", doc{"alice", "Bye"})
+
+	var mu sync.Mutex
+	texts := []string{}
+	err := ApplyParallel(as, func(id ID, obj Storable) error {
+		mu.Lock()
+		defer mu.Unlock()
+		texts = append(texts, obj.(doc).Text)
+		return nil
+	}, 2, OptApplyContext(bob))
+
+	// Alice's items are skipped rather than failing the apply.
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []string{"Hi"}, texts)
+}
+
+func TestAuthorizedStoreMissing(t *testing.T) {
+
+	as := NewAuthorizedStore(NewMapStore(), newTestPolicy())
+
+	bob := WithPrincipal(context.Background(), "bob")
+	root := WithPrincipal(context.Background(), "root")
+
+	as.StoreItemContext(root, "1", doc{"alice", "Hello"})
+
+	// Missing and forbidden items are indistinguishable.
+	_, err := as.RetrieveContext(bob, "1")
+	assert.Expect(t, ErrForbidden, err)
+
+	_, err = as.RetrieveContext(bob, "2")
+	assert.Expect(t, ErrForbidden, err)
+
+	_, err = as.RetrieveContext(root, "2")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestAuthorizedStoreUpdate(t *testing.T) {
+
+	as := NewAuthorizedStore(NewSyncStore(NewMapStore()), newTestPolicy())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	edit := func(owner, text string) UpdateFunc {
+		return func(old Storable, exists bool) (Storable, error) {
+			return doc{owner, text}, nil
+		}
+	}
+
+	assert.Expect(t, nil, as.UpdateContext(alice, "1", edit("alice", "Hello")))
+	assert.Expect(t, ErrForbidden, as.UpdateContext(bob, "1", edit("bob", "Mine now")))
+	assert.Expect(t, ErrForbidden, as.UpdateContext(alice, "2", edit("bob", "For Bob")))
+	assert.Expect(t, ErrUnauthenticated, Update(as, "1", edit("alice", "Anonymous")))
+
+	obj, _ := as.RetrieveContext(alice, "1")
+	assert.Expect(t, doc{"alice", "Hello"}, obj)
+
+	// Stores unable to update atomically still can not.
+	plain := NewAuthorizedStore(struct{ Store }{NewMapStore()}, newTestPolicy())
+	assert.Expect(t, ErrUpdateUnsupported, plain.UpdateContext(alice, "1", edit("alice", "Hello")))
+}
+
+func TestAuthorizedStoreRevisions(t *testing.T) {
+
+	as := NewAuthorizedStore(NewVersionedStore(NewMapStore(), NewMapStore()), newTestPolicy())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	as.StoreItemContext(alice, "1", doc{"alice", "Hello"})
+	as.StoreItemContext(alice, "1", doc{"alice", "Hello World!"})
+
+	revs, err := as.HistoryContext(alice, "1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 2, len(revs))
+
+	_, err = as.HistoryContext(bob, "1")
+	assert.Expect(t, ErrForbidden, err)
+
+	_, err = as.RetrieveRevisionContext(bob, "1", revs[0].Rev)
+	assert.Expect(t, ErrForbidden, err)
+
+	obj, err := as.RetrieveRevisionContext(alice, "1", revs[0].Rev)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, doc{"alice", "Hello"}, obj)
+
+	assert.Expect(t, ErrForbidden, as.RevertContext(bob, "1", revs[0].Rev))
+	assert.Expect(t, nil, as.RevertContext(alice, "1", revs[0].Rev))
+
+	_, err = NewAuthorizedStore(NewMapStore(), newTestPolicy()).HistoryContext(alice, "1")
+	assert.Expect(t, ErrRevisionsUnsupported, err)
+}
+
+func TestAuthorizedStoreTrash(t *testing.T) {
+
+	as := NewAuthorizedStore(NewSoftDeleteStore(NewMapStore(), NewMapStore()), newTestPolicy())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	as.StoreItemContext(alice, "1", doc{"alice", "Hello"})
+	as.StoreItemContext(bob, "2", doc{"bob", "Hi"})
+	as.DeleteContext(alice, "1")
+	as.DeleteContext(bob, "2")
+
+	ids, err := as.ListTrashContext(bob)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"2"}, ids)
+
+	_, err = as.RetrieveTrashContext(bob, "1")
+	assert.Expect(t, ErrForbidden, err)
+
+	assert.Expect(t, ErrForbidden, as.RestoreContext(bob, "1"))
+	assert.Expect(t, ErrForbidden, as.PurgeContext(bob, "1"))
+	assert.Expect(t, ErrForbidden, as.PurgeContext(bob, "3"))
+
+	assert.Expect(t, nil, as.RestoreContext(alice, "1"))
+	assert.Expect(t, nil, as.PurgeContext(bob, "2"))
+
+	_, err = NewAuthorizedStore(NewMapStore(), newTestPolicy()).ListTrashContext(alice)
+	assert.Expect(t, ErrTrashUnsupported, err)
+}
+
+func TestAuthorizedStoreMeta(t *testing.T) {
+
+	as := NewAuthorizedStore(NewMapStore(), newTestPolicy())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	err := as.StoreItemWithMetaContext(alice, "1", doc{"alice", "Hello"}, Meta{ContentType: "text/plain"})
+	assert.Expect(t, nil, err)
+
+	err = as.StoreItemWithMetaContext(bob, "1", doc{"bob", "Mine now"}, Meta{})
+	assert.Expect(t, ErrForbidden, err)
+
+	_, meta, err := as.RetrieveMetaContext(alice, "1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "text/plain", meta.ContentType)
+
+	_, _, err = as.RetrieveMetaContext(bob, "1")
+	assert.Expect(t, ErrForbidden, err)
+
+	_, err = as.Stat("1")
+	assert.Expect(t, ErrUnauthenticated, err)
+
+	plain := NewAuthorizedStore(struct{ Store }{NewMapStore()}, newTestPolicy())
+	err = plain.StoreItemWithMetaContext(alice, "1", doc{"alice", "Hello"}, Meta{})
+	assert.Expect(t, ErrMetaUnsupported, err)
+}
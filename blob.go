@@ -0,0 +1,204 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "io"
+import "io/ioutil"
+import "sort"
+import "sync"
+import "time"
+
+type BlobError string
+
+func (err BlobError) Error() string {
+	return (string)(err)
+}
+
+// Returned when a range starts beyond the end of a blob.
+const ErrInvalidRange = BlobError("The range is not satisfiable.")
+
+// Describes a blob.
+//
+// Size is ignored by PutStream and set by the store from the bytes
+// written.  A ModTime of zero is set to the time of the write.
+// Created is set by the store and kept when a blob is replaced.
+//
+type BlobMeta struct {
+	ContentType string            `json:"contentType,omitempty"`
+	Size        int64             `json:"size"`
+	ModTime     time.Time         `json:"modTime"`
+	Created     time.Time         `json:"created"`
+	Tags        []string          `json:"tags,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Set the times of meta for a blob replacing one with old metadata.
+func touchBlobMeta(meta, old BlobMeta, now time.Time) BlobMeta {
+
+	if meta.ModTime.IsZero() {
+		meta.ModTime = now
+	}
+
+	meta.Created = old.Created
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+
+	return meta
+}
+
+// Stores for objects too large to be held in memory.
+//
+// Blobs are written and read as streams.  The reader returned by
+// GetStream must be closed, it implements io.Seeker when the store is
+// able to seek within a blob.
+//
+type BlobStore interface {
+	PutStream(id ID, r io.Reader, meta BlobMeta) error
+	GetStream(id ID) (io.ReadCloser, BlobMeta, error)
+	StatBlob(id ID) (BlobMeta, error)
+	DeleteBlob(id ID) error
+	ListBlobs() ([]ID, error)
+}
+
+// Blob stores able to read part of a blob.
+//
+// A negative length reads to the end of the blob.
+//
+type BlobRanger interface {
+	GetRange(id ID, offset, length int64) (io.ReadCloser, BlobMeta, error)
+}
+
+// Read part of a blob, using GetRange when the store provides it.
+func GetRange(bs BlobStore, id ID, offset, length int64) (io.ReadCloser, BlobMeta, error) {
+
+	if br, ok := bs.(BlobRanger); ok {
+		return br.GetRange(id, offset, length)
+	}
+
+	rc, meta, err := bs.GetStream(id)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	if offset > meta.Size {
+		rc.Close()
+		return nil, meta, ErrInvalidRange
+	}
+
+	if s, ok := rc.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, rc, offset)
+	}
+
+	if err != nil {
+		rc.Close()
+		return nil, meta, err
+	}
+
+	if length < 0 {
+		return rc, meta, nil
+	}
+
+	return limitedReadCloser{io.LimitReader(rc, length), rc}, meta, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type bytesReadCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReadCloser) Close() error {
+	return nil
+}
+
+type memBlob struct {
+	data []byte
+	meta BlobMeta
+}
+
+// An in-memory BlobStore, mostly useful for testing.
+//
+// MemBlobStore is safe for concurrent use.
+//
+type MemBlobStore struct {
+	blobs map[ID]memBlob
+	mu    sync.RWMutex
+}
+
+func NewMemBlobStore() *MemBlobStore {
+	return &MemBlobStore{blobs: map[ID]memBlob{}}
+}
+
+func (s *MemBlobStore) PutStream(id ID, r io.Reader, meta BlobMeta) error {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta = touchBlobMeta(meta, s.blobs[id].meta, time.Now())
+	meta.Size = int64(len(data))
+
+	s.blobs[id] = memBlob{data, meta}
+
+	return nil
+}
+
+func (s *MemBlobStore) GetStream(id ID) (io.ReadCloser, BlobMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.blobs[id]
+	if !ok {
+		return nil, BlobMeta{}, ErrNotFound
+	}
+
+	// Blobs are replaced rather than modified, so readers may share
+	// the data.
+	return bytesReadCloser{bytes.NewReader(b.data)}, b.meta, nil
+}
+
+func (s *MemBlobStore) StatBlob(id ID) (BlobMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.blobs[id]
+	if !ok {
+		return BlobMeta{}, ErrNotFound
+	}
+
+	return b.meta, nil
+}
+
+func (s *MemBlobStore) DeleteBlob(id ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blobs, id)
+
+	return nil
+}
+
+func (s *MemBlobStore) ListBlobs() ([]ID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]ID, 0, len(s.blobs))
+	for id := range s.blobs {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
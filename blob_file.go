@@ -0,0 +1,225 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "encoding/json"
+import "io"
+import "io/ioutil"
+import "net/url"
+import "os"
+import "path/filepath"
+import "sort"
+import "time"
+
+// Returned when writing a blob with an empty ID.
+const ErrInvalidBlobID = BlobError("Invalid blob ID.")
+
+// A BlobStore keeping each blob in a file.
+//
+// Blobs are kept in the data directory under dir, named by their
+// escaped IDs, with their metadata in the meta directory.  Writes go
+// to a temporary file which replaces the blob once complete, so
+// readers never see a partial blob.  The data and then the metadata
+// are synced and renamed into place, so the metadata never describes
+// data that did not reach the disk.
+//
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+
+	for _, sub := range []string{"data", "meta", "tmp"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileBlobStore{dir}, nil
+}
+
+func (s *FileBlobStore) path(sub string, id ID) string {
+	return filepath.Join(s.dir, sub, EscapeID(id))
+}
+
+func (s *FileBlobStore) PutStream(id ID, r io.Reader, meta BlobMeta) error {
+
+	if id == "" {
+		return ErrInvalidBlobID
+	}
+
+	f, err := ioutil.TempFile(filepath.Join(s.dir, "tmp"), "blob-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	old, _ := s.StatBlob(id)
+
+	meta = touchBlobMeta(meta, old, time.Now())
+	meta.Size = n
+
+	bs, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// The data goes first so a crash never leaves metadata describing a
+	// blob that was not written.
+	err = os.Rename(f.Name(), s.path("data", id))
+	if err != nil {
+		return err
+	}
+
+	syncDir(filepath.Join(s.dir, "data"))
+
+	return s.writeMeta(id, bs)
+}
+
+// Replace the metadata of id through a temporary file.
+func (s *FileBlobStore) writeMeta(id ID, bs []byte) error {
+
+	f, err := ioutil.TempFile(filepath.Join(s.dir, "tmp"), "meta-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(bs)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), s.path("meta", id))
+	if err != nil {
+		return err
+	}
+
+	syncDir(filepath.Join(s.dir, "meta"))
+
+	return nil
+}
+
+// Make renames into dir durable.  Not every platform can sync a
+// directory, so this is best effort.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func (s *FileBlobStore) GetStream(id ID) (io.ReadCloser, BlobMeta, error) {
+
+	meta, err := s.StatBlob(id)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	f, err := os.Open(s.path("data", id))
+	if os.IsNotExist(err) {
+		return nil, BlobMeta{}, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, BlobMeta{}, err
+	}
+
+	// The file may have been replaced since the metadata was read.
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, BlobMeta{}, err
+	}
+
+	meta.Size = fi.Size()
+
+	return f, meta, nil
+}
+
+func (s *FileBlobStore) StatBlob(id ID) (BlobMeta, error) {
+
+	meta := BlobMeta{}
+
+	if id == "" {
+		return meta, ErrNotFound
+	}
+
+	bs, err := ioutil.ReadFile(s.path("meta", id))
+	if os.IsNotExist(err) {
+		return meta, ErrNotFound
+	}
+
+	if err != nil {
+		return meta, err
+	}
+
+	err = json.Unmarshal(bs, &meta)
+
+	return meta, err
+}
+
+func (s *FileBlobStore) DeleteBlob(id ID) error {
+
+	if id == "" {
+		return nil
+	}
+
+	err := os.Remove(s.path("data", id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(s.path("meta", id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileBlobStore) ListBlobs() ([]ID, error) {
+
+	names, err := ioutil.ReadDir(filepath.Join(s.dir, "data"))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]ID, 0, len(names))
+	for _, fi := range names {
+		id, err := url.PathUnescape(fi.Name())
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, (ID)(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
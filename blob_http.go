@@ -0,0 +1,219 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "encoding/json"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "net/http"
+import "strconv"
+import "strings"
+import "time"
+
+type HttpBlobOpt func(*HttpBlobStore)
+
+func OptBlobClient(c *http.Client) HttpBlobOpt {
+	return func(s *HttpBlobStore) {
+		s.client = c
+	}
+}
+
+// A BlobStore client for blobs served over HTTP, such as by the blob
+// endpoints of a www.DataServer.
+//
+// Blobs are streamed to and from {base}/{id}, GET {base}/ lists the
+// blobs as a JSON array.  Ranges are read with HTTP Range requests.
+//
+type HttpBlobStore struct {
+	base   string
+	client *http.Client
+}
+
+func NewHttpBlobStore(base string, opts ...HttpBlobOpt) *HttpBlobStore {
+
+	s := &HttpBlobStore{
+		strings.TrimSuffix(base, "/"),
+		http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *HttpBlobStore) url(id ID) string {
+	return s.base + "/" + EscapeID(id)
+}
+
+// The error for an unexpected response, closing its body.
+func blobResponseError(res *http.Response) error {
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return ErrInvalidRange
+	}
+
+	return NewStoreError("Unexpected response, " + res.Status)
+}
+
+// Read blob metadata from response headers.
+func blobMetaFromHeader(h http.Header) BlobMeta {
+
+	meta := BlobMeta{ContentType: h.Get("Content-Type")}
+
+	meta.ModTime, _ = time.Parse(http.TimeFormat, h.Get("Last-Modified"))
+	meta.Created, _ = time.Parse(time.RFC3339Nano, h.Get(CreatedHeader))
+	meta.Tags, meta.Labels = readTagsHeader(h)
+
+	// Content-Range gives the size of the whole blob for partial
+	// responses.
+	cr := h.Get("Content-Range")
+	if i := strings.LastIndex(cr, "/"); i >= 0 {
+		meta.Size, _ = strconv.ParseInt(cr[i+1:], 10, 64)
+		return meta
+	}
+
+	meta.Size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+
+	return meta
+}
+
+func (s *HttpBlobStore) PutStream(id ID, r io.Reader, meta BlobMeta) error {
+
+	req, err := http.NewRequest("PUT", s.url(id), r)
+	if err != nil {
+		return err
+	}
+
+	if meta.ContentType != "" {
+		req.Header.Set("Content-Type", meta.ContentType)
+	}
+
+	writeTagsHeader(req.Header, meta.Tags, meta.Labels)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return blobResponseError(res)
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+
+	return res.Body.Close()
+}
+
+func (s *HttpBlobStore) GetStream(id ID) (io.ReadCloser, BlobMeta, error) {
+	return s.GetRange(id, 0, -1)
+}
+
+func (s *HttpBlobStore) GetRange(id ID, offset, length int64) (io.ReadCloser, BlobMeta, error) {
+
+	req, err := http.NewRequest("GET", s.url(id), nil)
+	if err != nil {
+		return nil, BlobMeta{}, err
+	}
+
+	if length == 0 {
+		// An empty range can not be requested, check the blob instead.
+		meta, err := s.StatBlob(id)
+		if err == nil && offset > meta.Size {
+			err = ErrInvalidRange
+		}
+
+		return ioutil.NopCloser(strings.NewReader("")), meta, err
+	}
+
+	if offset > 0 || length > 0 {
+		rng := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			rng += strconv.FormatInt(offset+length-1, 10)
+		}
+
+		req.Header.Set("Range", rng)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, BlobMeta{}, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if offset > 0 || length > 0 {
+			// The server ignored the range.
+			res.Body.Close()
+			return nil, BlobMeta{}, NewStoreError("The server does not support ranges.")
+		}
+
+	case http.StatusPartialContent:
+
+	default:
+		return nil, BlobMeta{}, blobResponseError(res)
+	}
+
+	return res.Body, blobMetaFromHeader(res.Header), nil
+}
+
+func (s *HttpBlobStore) StatBlob(id ID) (BlobMeta, error) {
+
+	res, err := s.client.Head(s.url(id))
+	if err != nil {
+		return BlobMeta{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return BlobMeta{}, blobResponseError(res)
+	}
+
+	res.Body.Close()
+
+	return blobMetaFromHeader(res.Header), nil
+}
+
+func (s *HttpBlobStore) DeleteBlob(id ID) error {
+
+	req, err := http.NewRequest("DELETE", s.url(id), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusNotFound {
+		return blobResponseError(res)
+	}
+
+	return res.Body.Close()
+}
+
+func (s *HttpBlobStore) ListBlobs() ([]ID, error) {
+
+	res, err := s.client.Get(s.base + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, blobResponseError(res)
+	}
+
+	defer res.Body.Close()
+
+	ids := []ID{}
+	err = json.NewDecoder(res.Body).Decode(&ids)
+
+	return ids, err
+}
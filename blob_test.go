@@ -0,0 +1,248 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+func TestBlobTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func readBlob(t *testing.T, bs BlobStore, id ID) (string, BlobMeta) {
+
+	rc, meta, err := bs.GetStream(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return (string)(data), meta
+}
+
+func readRange(t *testing.T, bs BlobStore, id ID, offset, length int64) string {
+
+	rc, _, err := GetRange(bs, id, offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rc.Close()
+
+	data, _ := ioutil.ReadAll(rc)
+
+	return (string)(data)
+}
+
+func testBlobStore(t *testing.T, bs BlobStore) {
+
+	err := bs.PutStream("a/b", strings.NewReader("Hello"),
+		BlobMeta{ContentType: "text/plain", Tags: []string{"greeting"}})
+	assert.Expect(t, nil, err)
+
+	first, _ := bs.StatBlob("a/b")
+	assert.Expect(t, []string{"greeting"}, first.Tags)
+
+	err = bs.PutStream("a/b", strings.NewReader("Hello World!"),
+		BlobMeta{ContentType: "text/plain", Labels: map[string]string{"lang": "en"}})
+	assert.Expect(t, nil, err)
+
+	data, meta := readBlob(t, bs, "a/b")
+	assert.Expect(t, "Hello World!", data)
+	assert.Expect(t, "text/plain", meta.ContentType)
+	assert.Expect(t, int64(12), meta.Size)
+
+	assert.Expect(t, map[string]string{"lang": "en"}, meta.Labels)
+	assert.Expect(t, true, first.Created.Equal(meta.Created))
+
+	if meta.ModTime.IsZero() {
+		t.Error("Expected the modification time to be set.")
+	}
+
+	meta, err = bs.StatBlob("a/b")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int64(12), meta.Size)
+
+	assert.Expect(t, "World", readRange(t, bs, "a/b", 6, 5))
+	assert.Expect(t, "World!", readRange(t, bs, "a/b", 6, -1))
+
+	_, _, err = GetRange(bs, "a/b", 13, -1)
+	assert.Expect(t, ErrInvalidRange, err)
+
+	bs.PutStream("..", bytes.NewReader(make([]byte, 1<<20)), BlobMeta{})
+
+	ids, err := bs.ListBlobs()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"..", "a/b"}, ids)
+
+	_, meta = readBlob(t, bs, "..")
+	assert.Expect(t, int64(1<<20), meta.Size)
+
+	assert.Expect(t, nil, bs.DeleteBlob("a/b"))
+
+	_, _, err = bs.GetStream("a/b")
+	assert.Expect(t, ErrNotFound, err)
+
+	_, err = bs.StatBlob("a/b")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestMemBlobStore(t *testing.T) {
+	testBlobStore(t, NewMemBlobStore())
+}
+
+func TestFileBlobStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	bs, err := NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBlobStore(t, bs)
+
+	// Temporary data and metadata files are cleaned up.
+	tmp, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 0, len(tmp))
+}
+
+// A reader failing after n bytes, like a dropped connection.
+type brokenReader struct {
+	r io.Reader
+	n int
+}
+
+func (br *brokenReader) Read(bs []byte) (int, error) {
+	if br.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	if len(bs) > br.n {
+		bs = bs[:br.n]
+	}
+
+	n, err := br.r.Read(bs)
+	br.n -= n
+
+	return n, err
+}
+
+func TestUploads(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	bs := NewMemBlobStore()
+	u, err := NewUploads(bs, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := u.Begin("big", BlobMeta{ContentType: "text/plain", Size: 10})
+	assert.Expect(t, nil, err)
+
+	offset, err := u.Write(token, 0, &brokenReader{strings.NewReader("01234"), 3})
+	assert.Expect(t, io.ErrUnexpectedEOF, err)
+	assert.Expect(t, int64(3), offset)
+
+	// Resume from a fresh Uploads, as after a restart.
+	u, _ = NewUploads(bs, dir)
+
+	offset, err = u.Offset(token)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int64(3), offset)
+
+	_, err = u.Write(token, 0, strings.NewReader("0123"))
+	assert.Expect(t, ErrUploadOffset, err)
+
+	_, err = u.Complete(token)
+	assert.Expect(t, ErrUploadIncomplete, err)
+
+	// Writes stop at the declared size.
+	offset, err = u.Write(token, 3, strings.NewReader("3456789abc"))
+	assert.Expect(t, ErrUploadOffset, err)
+	assert.Expect(t, int64(10), offset)
+
+	_, err = u.Write(token, 10, strings.NewReader("d"))
+	assert.Expect(t, ErrUploadOffset, err)
+
+	offset, err = u.Write(token, 10, strings.NewReader(""))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int64(10), offset)
+
+	id, err := u.Complete(token)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, ID("big"), id)
+
+	data, meta := readBlob(t, bs, "big")
+	assert.Expect(t, "0123456789", data)
+	assert.Expect(t, "text/plain", meta.ContentType)
+
+	_, err = u.Offset(token)
+	assert.Expect(t, ErrNotFound, err)
+
+	_, err = u.Offset("../../etc/passwd")
+	assert.Expect(t, ErrNotFound, err)
+
+	token, _ = u.Begin("aborted", BlobMeta{})
+	assert.Expect(t, nil, u.Abort(token))
+	assert.Expect(t, ErrNotFound, u.Abort(token))
+}
+
+func TestUploadsExpiry(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	u, _ := NewUploads(NewMemBlobStore(), dir, OptUploadExpiry(time.Hour))
+
+	stale, _ := u.Begin("stale", BlobMeta{})
+	fresh, _ := u.Begin("fresh", BlobMeta{})
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, stale+".part"), old, old)
+
+	_, err = u.Offset(stale)
+	assert.Expect(t, ErrNotFound, err)
+
+	_, err = u.Write(stale, 0, strings.NewReader("late"))
+	assert.Expect(t, ErrNotFound, err)
+
+	n, err := u.Expire()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 1, n)
+
+	files, _ := filepath.Glob(filepath.Join(dir, stale+".*"))
+	assert.Expect(t, 0, len(files))
+
+	_, err = u.Offset(fresh)
+	assert.Expect(t, nil, err)
+}
@@ -0,0 +1,381 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "encoding/hex"
+import "encoding/json"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+import "time"
+
+// Returned when a chunk does not start at the current upload offset.
+const ErrUploadOffset = BlobError("The chunk does not start at the upload offset.")
+
+// Returned when a chunk is written to an upload already being written.
+const ErrUploadBusy = BlobError("The upload is being written.")
+
+// Returned when completing an upload with fewer bytes than declared.
+const ErrUploadIncomplete = BlobError("The upload is incomplete.")
+
+// How long an upload is kept without being written to, by default.
+const DefaultUploadExpiry = 24 * time.Hour
+
+type UploadsOpt func(*Uploads)
+
+// Discard uploads which have not been written to for d, zero keeps
+// them until they are completed or aborted.
+func OptUploadExpiry(d time.Duration) UploadsOpt {
+	return func(u *Uploads) {
+		u.expiry = d
+	}
+}
+
+type upload struct {
+	ID   ID       `json:"id"`
+	Meta BlobMeta `json:"meta"`
+}
+
+// Resumable chunked uploads into a BlobStore.
+//
+// An upload is started with Begin and written in chunks, each
+// starting at the offset reached so far.  A chunk cut short, for
+// example by a dropped connection, keeps the bytes received and the
+// upload resumes from Offset.  Complete streams the assembled blob into
+// the store.
+//
+// Uploads are staged in dir so they survive restarts.  When the size
+// in the metadata given to Begin is not zero, Complete requires
+// exactly that many bytes.
+//
+// Uploads left idle for longer than DefaultUploadExpiry, see
+// OptUploadExpiry, are no longer found and their staging files are
+// removed by Expire, which is run by each Begin.
+//
+type Uploads struct {
+	store  BlobStore
+	dir    string
+	expiry time.Duration
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func NewUploads(store BlobStore, dir string, opts ...UploadsOpt) (*Uploads, error) {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Uploads{
+		store:  store,
+		dir:    dir,
+		expiry: DefaultUploadExpiry,
+		busy:   map[string]bool{},
+	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	return u, nil
+}
+
+// Whether a staged upload was last written before the expiry.
+func (u *Uploads) expired(part string) bool {
+
+	if u.expiry <= 0 {
+		return false
+	}
+
+	fi, err := os.Stat(part)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(fi.ModTime()) > u.expiry
+}
+
+// Remove the staging files of expired uploads, returning the number
+// removed.
+func (u *Uploads) Expire() (int, error) {
+
+	if u.expiry <= 0 {
+		return 0, nil
+	}
+
+	names, err := filepath.Glob(filepath.Join(u.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, name := range names {
+		token := strings.TrimSuffix(filepath.Base(name), ".json")
+
+		_, part, err := u.paths(token)
+		if err != nil || !u.expired(part) || u.acquire(token) != nil {
+			continue
+		}
+
+		err = u.remove(token)
+		u.release(token)
+
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// The staging files of an upload, tokens are validated to keep them
+// within the staging directory.
+func (u *Uploads) paths(token string) (string, string, error) {
+
+	bs, err := hex.DecodeString(token)
+	if err != nil || len(bs) != 16 {
+		return "", "", ErrNotFound
+	}
+
+	base := filepath.Join(u.dir, token)
+
+	return base + ".json", base + ".part", nil
+}
+
+func (u *Uploads) load(token string) (upload, string, error) {
+
+	info, part, err := u.paths(token)
+	if err != nil {
+		return upload{}, "", err
+	}
+
+	if u.expired(part) {
+		return upload{}, "", ErrNotFound
+	}
+
+	bs, err := ioutil.ReadFile(info)
+	if os.IsNotExist(err) {
+		return upload{}, "", ErrNotFound
+	}
+
+	if err != nil {
+		return upload{}, "", err
+	}
+
+	up := upload{}
+	err = json.Unmarshal(bs, &up)
+
+	return up, part, err
+}
+
+// Start an upload of a blob, returning the token identifying it.
+func (u *Uploads) Begin(id ID, meta BlobMeta) (string, error) {
+
+	if id == "" {
+		return "", ErrInvalidBlobID
+	}
+
+	_, err := u.Expire()
+	if err != nil {
+		return "", err
+	}
+
+	bs := make([]byte, 16)
+	err = randomBytes(bs)
+	if err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(bs)
+	info, part, _ := u.paths(token)
+
+	err = ioutil.WriteFile(part, nil, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(upload{id, meta})
+	if err != nil {
+		return "", err
+	}
+
+	return token, ioutil.WriteFile(info, data, 0644)
+}
+
+// The ID and metadata an upload was started with.
+func (u *Uploads) Info(token string) (ID, BlobMeta, error) {
+	up, _, err := u.load(token)
+	return up.ID, up.Meta, err
+}
+
+// The number of bytes received so far.
+func (u *Uploads) Offset(token string) (int64, error) {
+
+	_, part, err := u.load(token)
+	if err != nil {
+		return 0, err
+	}
+
+	fi, err := os.Stat(part)
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+func (u *Uploads) acquire(token string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.busy[token] {
+		return ErrUploadBusy
+	}
+
+	u.busy[token] = true
+
+	return nil
+}
+
+func (u *Uploads) release(token string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.busy, token)
+}
+
+// Append a chunk starting at offset, returning the new offset.
+//
+// The new offset is returned along with any error reading the chunk,
+// the bytes read before the error are kept. When the upload declared a
+// size, a chunk running past it is cut at the size and ErrUploadOffset
+// is returned.
+//
+func (u *Uploads) Write(token string, offset int64, r io.Reader) (int64, error) {
+
+	err := u.acquire(token)
+	if err != nil {
+		return 0, err
+	}
+
+	defer u.release(token)
+
+	up, part, err := u.load(token)
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := u.Offset(token)
+	if err != nil {
+		return 0, err
+	}
+
+	if offset != current {
+		return current, ErrUploadOffset
+	}
+
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return current, err
+	}
+
+	size := up.Meta.Size
+
+	src := r
+	if size > 0 {
+		src = io.LimitReader(r, size-current)
+	}
+
+	n, err := io.Copy(f, src)
+
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	// Anything left in the chunk runs past the declared size.
+	if err == nil && size > 0 && current+n == size {
+		var b [1]byte
+		if m, _ := io.ReadFull(r, b[:]); m > 0 {
+			err = ErrUploadOffset
+		}
+	}
+
+	return current + n, err
+}
+
+// Store the uploaded blob and remove the staged upload.
+func (u *Uploads) Complete(token string) (ID, error) {
+
+	err := u.acquire(token)
+	if err != nil {
+		return "", err
+	}
+
+	defer u.release(token)
+
+	up, part, err := u.load(token)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(part)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if up.Meta.Size != 0 && fi.Size() != up.Meta.Size {
+		return "", ErrUploadIncomplete
+	}
+
+	err = u.store.PutStream(up.ID, f, up.Meta)
+	if err != nil {
+		return "", err
+	}
+
+	return up.ID, u.remove(token)
+}
+
+// Discard an upload.
+func (u *Uploads) Abort(token string) error {
+
+	err := u.acquire(token)
+	if err != nil {
+		return err
+	}
+
+	defer u.release(token)
+
+	_, _, err = u.load(token)
+	if err != nil {
+		return err
+	}
+
+	return u.remove(token)
+}
+
+func (u *Uploads) remove(token string) error {
+
+	info, part, _ := u.paths(token)
+
+	err := os.Remove(part)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(info)
+}
@@ -0,0 +1,453 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "io"
+import "io/ioutil"
+import "mime"
+import "strconv"
+import "strings"
+import "sync"
+
+type CodecError string
+
+func (err CodecError) Error() string {
+	return (string)(err)
+}
+
+// Returned when no codec is registered for a media type.
+const ErrUnsupportedMediaType = CodecError("The media type is not supported.")
+
+// Returned by codecs unable to encode or decode in one direction.
+const ErrCodecUnsupported = CodecError("The codec does not support the operation.")
+
+// Returned by list codecs given something other than a []ID.
+const ErrNotIDList = CodecError("The object is not a list of IDs.")
+
+// Encodes and decodes items as a media type.
+//
+// Encode and Decode work on whole values while EncodeTo and DecodeFrom
+// stream them.  List codecs encode and decode []ID values.
+//
+type Codec interface {
+	MediaType() string
+	Encode(Storable) ([]byte, error)
+	Decode([]byte) (Storable, error)
+	EncodeTo(io.Writer, Storable) error
+	DecodeFrom(io.Reader) (Storable, error)
+}
+
+type funcCodec struct {
+	t   string
+	enc func(Storable) ([]byte, error)
+	dec func([]byte) (Storable, error)
+}
+
+// A Codec built from functions encoding to and decoding from bytes,
+// such as a www.Encoder and www.Decoder.
+//
+// Either function may be nil, the codec then returns
+// ErrCodecUnsupported.  Streams are buffered in memory.
+//
+func NewFuncCodec(t string, enc func(Storable) ([]byte, error), dec func([]byte) (Storable, error)) Codec {
+	return funcCodec{t, enc, dec}
+}
+
+func (c funcCodec) MediaType() string {
+	return c.t
+}
+
+func (c funcCodec) Encode(obj Storable) ([]byte, error) {
+	if c.enc == nil {
+		return nil, ErrCodecUnsupported
+	}
+
+	return c.enc(obj)
+}
+
+func (c funcCodec) Decode(bs []byte) (Storable, error) {
+	if c.dec == nil {
+		return nil, ErrCodecUnsupported
+	}
+
+	return c.dec(bs)
+}
+
+func (c funcCodec) EncodeTo(w io.Writer, obj Storable) error {
+
+	bs, err := c.Encode(obj)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(bs)
+
+	return err
+}
+
+func (c funcCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	if c.dec == nil {
+		return nil, ErrCodecUnsupported
+	}
+
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.dec(bs)
+}
+
+// A Codec using the HttpStore marshaling functions, either of which
+// may be nil.
+func NewHttpStoreCodec(t string, m HttpStoreMarshaler, u HttpStoreUnmarshaler) Codec {
+
+	c := funcCodec{t: t}
+
+	if m != nil {
+		c.enc = func(obj Storable) ([]byte, error) {
+			rc, _, err := m(obj)
+			if err != nil {
+				return nil, err
+			}
+
+			defer rc.Close()
+
+			return ioutil.ReadAll(rc)
+		}
+	}
+
+	if u != nil {
+		c.dec = func(bs []byte) (Storable, error) {
+			return u(bytes.NewReader(bs))
+		}
+	}
+
+	return c
+}
+
+// A list Codec decoding with an HttpStoreIDUnmarshaler, either
+// function may be nil.
+func NewIDListCodec(t string, enc func(Storable) ([]byte, error), u HttpStoreIDUnmarshaler) Codec {
+
+	c := funcCodec{t: t, enc: enc}
+
+	if u != nil {
+		c.dec = func(bs []byte) (Storable, error) {
+			return u(bytes.NewReader(bs))
+		}
+	}
+
+	return c
+}
+
+// Adapt a Codec to the HttpStore marshaling functions.
+func CodecMarshaler(c Codec) HttpStoreMarshaler {
+	return func(obj Storable) (io.ReadCloser, int64, error) {
+
+		bs, err := c.Encode(obj)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return ioutil.NopCloser(bytes.NewReader(bs)), int64(len(bs)), nil
+	}
+}
+
+func CodecUnmarshaler(c Codec) HttpStoreUnmarshaler {
+	return c.DecodeFrom
+}
+
+func CodecIDUnmarshaler(c Codec) HttpStoreIDUnmarshaler {
+	return func(r io.Reader) ([]ID, error) {
+
+		obj, err := c.DecodeFrom(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ids, ok := obj.([]ID)
+		if !ok {
+			return nil, ErrNotIDList
+		}
+
+		return ids, nil
+	}
+}
+
+// Plain strings as text/plain.
+var StringCodec = NewHttpStoreCodec("text/plain", StringMarshaler,
+	func(r io.Reader) (Storable, error) {
+		bs, err := ioutil.ReadAll(r)
+		return (string)(bs), err
+	})
+
+// Lists of IDs as text/plain, joined by sep.
+func StringListCodec(sep string) Codec {
+	return NewIDListCodec("text/plain",
+		func(obj Storable) ([]byte, error) {
+			ids, ok := obj.([]ID)
+			if !ok {
+				return nil, ErrNotIDList
+			}
+
+			strs := make([]string, len(ids))
+			for i := range ids {
+				strs[i] = (string)(ids[i])
+			}
+
+			return ([]byte)(strings.Join(strs, sep)), nil
+		},
+		StringIDUnmarshaler(sep))
+}
+
+// Codecs for items and for lists of IDs, keyed by media type.
+//
+// Media types are matched without their parameters.  The first codec
+// registered is preferred when a client accepts any type.
+//
+// CodecRegistry is safe for concurrent use.
+//
+type CodecRegistry struct {
+	items codecSet
+	lists codecSet
+	mu    sync.RWMutex
+}
+
+type codecSet struct {
+	codecs map[string]Codec
+	order  []string
+}
+
+func (cs *codecSet) add(c Codec) {
+
+	t := baseMediaType(c.MediaType())
+
+	if cs.codecs == nil {
+		cs.codecs = map[string]Codec{}
+	}
+
+	if _, ok := cs.codecs[t]; !ok {
+		cs.order = append(cs.order, t)
+	}
+
+	cs.codecs[t] = c
+}
+
+// Create a registry of item codecs.
+func NewCodecRegistry(codecs ...Codec) *CodecRegistry {
+
+	r := &CodecRegistry{}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+
+	return r
+}
+
+// The media type without parameters, in lower case.
+func baseMediaType(t string) string {
+
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(t))
+	}
+
+	return mt
+}
+
+// Add an item codec, replacing any for the same media type.
+func (r *CodecRegistry) Register(c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.items.add(c)
+}
+
+// Add a codec for lists of IDs.
+func (r *CodecRegistry) RegisterList(c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lists.add(c)
+}
+
+// The item codec for a media type.
+func (r *CodecRegistry) Codec(t string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.items.codecs[baseMediaType(t)]
+	return c, ok
+}
+
+// The list codec for a media type.
+func (r *CodecRegistry) ListCodec(t string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.lists.codecs[baseMediaType(t)]
+	return c, ok
+}
+
+// The media types of the item codecs, in order of registration.
+func (r *CodecRegistry) MediaTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string{}, r.items.order...)
+}
+
+// The media types of the list codecs, in order of registration.
+func (r *CodecRegistry) ListMediaTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string{}, r.lists.order...)
+}
+
+// Choose the item codec best matching an Accept header.
+func (r *CodecRegistry) Negotiate(accept string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return negotiate(accept, &r.items)
+}
+
+// Choose the list codec best matching an Accept header.
+func (r *CodecRegistry) NegotiateList(accept string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return negotiate(accept, &r.lists)
+}
+
+func negotiate(accept string, cs *codecSet) (Codec, bool) {
+
+	t, ok := NegotiateMediaType(accept, cs.order)
+	if !ok {
+		return nil, false
+	}
+
+	return cs.codecs[t], true
+}
+
+// Choose the media type of types best matching an Accept header.
+//
+// Each type takes the quality of the most specific range matching it,
+// an exact type before type/* and */*, and types with a quality of
+// zero are excluded.  Ties go to the type matched by the more
+// specific range, then to the earlier type.  An empty header accepts
+// anything.
+//
+func NegotiateMediaType(accept string, types []string) (string, bool) {
+
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+
+	type mediaRange struct {
+		t string
+		q float64
+	}
+
+	ranges := []mediaRange{}
+
+	for _, entry := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(entry)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{mt, q})
+	}
+
+	best, bestQ, bestSpecific := "", 0.0, -1
+
+	for _, t := range types {
+		t = baseMediaType(t)
+
+		q, specific := 0.0, -1
+		for _, r := range ranges {
+			s := matchMediaRange(r.t, t)
+			if s > specific {
+				q, specific = r.q, s
+			}
+		}
+
+		if specific < 0 || q <= 0 {
+			continue
+		}
+
+		if q > bestQ || (q == bestQ && specific > bestSpecific) {
+			best, bestQ, bestSpecific = t, q, specific
+		}
+	}
+
+	return best, bestSpecific >= 0
+}
+
+// How specifically a media range matches media type t, 2 for the type
+// itself, 1 for type/* and 0 for */*, or -1 when it does not match.
+func matchMediaRange(r, t string) int {
+
+	switch {
+	case r == t:
+		return 2
+	case r == "*/*":
+		return 0
+	case strings.HasSuffix(r, "/*") && strings.HasPrefix(t, strings.TrimSuffix(r, "*")):
+		return 1
+	}
+
+	return -1
+}
+
+// An Accept header preferring t and accepting every other type in
+// types at a lower quality.
+func acceptHeader(t string, types []string) string {
+
+	entries := []string{t}
+	for _, other := range types {
+		if other != baseMediaType(t) {
+			entries = append(entries, other+";q=0.9")
+		}
+	}
+
+	return strings.Join(entries, ", ")
+}
+
+// A registry of the JSON, CBOR, MessagePack, gob and XML codecs
+// decoding items to the type of proto, with JSON preferred.
+//
+// Lists of IDs are sent as JSON, CBOR, MessagePack, gob or text/plain,
+// one per line.
+//
+func StandardCodecs(proto Storable) *CodecRegistry {
+
+	r := NewCodecRegistry(
+		NewJSONCodec(OptJSONPrototype(proto)),
+		NewCBORCodec(proto),
+		NewMsgPackCodec(proto),
+		NewGobCodec(proto),
+		NewXMLCodec(proto),
+	)
+
+	r.RegisterList(JSONListCodec)
+	r.RegisterList(NewCBORCodec([]ID{}))
+	r.RegisterList(NewMsgPackCodec([]ID{}))
+	r.RegisterList(NewGobCodec([]ID{}))
+	r.RegisterList(StringListCodec("\n"))
+
+	return r
+}
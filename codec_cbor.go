@@ -0,0 +1,394 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "encoding/binary"
+import "io"
+import "math"
+import "reflect"
+
+// The media type of the CBOR codec, RFC 8949.
+const CBORMediaType = "application/cbor"
+
+// A Codec for CBOR items, decoding to the type of proto.
+//
+// Structs are encoded as maps keyed by field name, or by the cbor
+// struct tag, falling back to the json tag.  Without a prototype items
+// decode to nil, bool, int64, uint64, float64, string, []byte,
+// []interface{} and map[string]interface{}, or
+// map[interface{}]interface{} for maps with other keys.
+//
+// Tags are skipped when decoding, leaving the tagged item.
+//
+func NewCBORCodec(proto Storable) Codec {
+	return valueCodec{
+		t:     CBORMediaType,
+		key:   "cbor",
+		proto: reflect.TypeOf(proto),
+		write: writeCBOR,
+		read:  readCBOR,
+	}
+}
+
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+)
+
+type cborWriter struct {
+	buf *bytes.Buffer
+}
+
+func writeCBOR(w io.Writer, key string, obj Storable) error {
+
+	cw := cborWriter{&bytes.Buffer{}}
+
+	err := writeValue(cw, key, reflect.ValueOf(obj))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(cw.buf.Bytes())
+
+	return err
+}
+
+// Write the head of an item, the major type and its argument.
+func (cw cborWriter) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		cw.buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		cw.buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		cw.buf.WriteByte(major | 25)
+		binary.Write(cw.buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		cw.buf.WriteByte(major | 26)
+		binary.Write(cw.buf, binary.BigEndian, uint32(n))
+	default:
+		cw.buf.WriteByte(major | 27)
+		binary.Write(cw.buf, binary.BigEndian, n)
+	}
+}
+
+func (cw cborWriter) writeNil() {
+	cw.buf.WriteByte(cborSimple | 22)
+}
+
+func (cw cborWriter) writeBool(b bool) {
+	if b {
+		cw.buf.WriteByte(cborSimple | 21)
+		return
+	}
+
+	cw.buf.WriteByte(cborSimple | 20)
+}
+
+func (cw cborWriter) writeInt(i int64) {
+	if i < 0 {
+		cw.head(cborNegInt, uint64(-1-i))
+		return
+	}
+
+	cw.head(cborUint, uint64(i))
+}
+
+func (cw cborWriter) writeUint(u uint64) {
+	cw.head(cborUint, u)
+}
+
+// Floats are written as single precision when no precision is lost.
+func (cw cborWriter) writeFloat(f float64) {
+	if f32 := float32(f); float64(f32) == f {
+		cw.buf.WriteByte(cborSimple | 26)
+		binary.Write(cw.buf, binary.BigEndian, math.Float32bits(f32))
+		return
+	}
+
+	cw.buf.WriteByte(cborSimple | 27)
+	binary.Write(cw.buf, binary.BigEndian, math.Float64bits(f))
+}
+
+func (cw cborWriter) writeString(s string) {
+	cw.head(cborText, uint64(len(s)))
+	cw.buf.WriteString(s)
+}
+
+func (cw cborWriter) writeBytes(bs []byte) {
+	cw.head(cborBytes, uint64(len(bs)))
+	cw.buf.Write(bs)
+}
+
+func (cw cborWriter) writeArray(n int) {
+	cw.head(cborArray, uint64(n))
+}
+
+func (cw cborWriter) writeMap(n int) {
+	cw.head(cborMap, uint64(n))
+}
+
+// The break stop code ending indefinite length items.
+type cborBreak struct{}
+
+func readCBOR(vr *valueReader) (interface{}, error) {
+
+	v, err := readCBORItem(vr)
+	if _, ok := v.(cborBreak); ok {
+		return nil, ErrMalformed
+	}
+
+	return v, err
+}
+
+// Read the argument of an item head, and whether the item is of
+// indefinite length.
+func readCBORArg(vr *valueReader, info byte) (uint64, bool, error) {
+
+	switch {
+	case info < 24:
+		return uint64(info), false, nil
+	case info <= 27:
+		n, err := vr.readUint(1 << (info - 24))
+		return n, false, err
+	case info == 31:
+		return 0, true, nil
+	}
+
+	return 0, false, ErrMalformed
+}
+
+func readCBORItem(vr *valueReader) (interface{}, error) {
+
+	b, err := vr.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tags are skipped in favour of the tagged item, in a loop so
+	// nested tags do not grow the stack.
+	tagged := false
+	for b&0xe0 == cborTag {
+		_, indefinite, err := readCBORArg(vr, b&0x1f)
+		if err != nil || indefinite {
+			return nil, ErrMalformed
+		}
+
+		b, err = vr.readByte()
+		if err != nil {
+			return nil, ErrMalformed
+		}
+
+		tagged = true
+	}
+
+	major, info := b&0xe0, b&0x1f
+
+	if major == cborSimple {
+		v, err := readCBORSimple(vr, info)
+		if _, ok := v.(cborBreak); ok && tagged {
+			return nil, ErrMalformed
+		}
+
+		return v, err
+	}
+
+	n, indefinite, err := readCBORArg(vr, info)
+	if err != nil {
+		return nil, err
+	}
+
+	if indefinite && (major == cborUint || major == cborNegInt) {
+		return nil, ErrMalformed
+	}
+
+	switch major {
+	case cborUint:
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+
+		return n, nil
+
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, ErrTypeMismatch
+		}
+
+		return -1 - int64(n), nil
+
+	case cborBytes, cborText:
+		var bs []byte
+		if indefinite {
+			bs, err = readCBORChunks(vr, major)
+		} else {
+			bs, err = vr.readN(n)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if major == cborText {
+			return (string)(bs), nil
+		}
+
+		return bs, nil
+
+	case cborArray:
+		err := vr.enter()
+		if err != nil {
+			return nil, err
+		}
+
+		defer vr.leave()
+
+		items := []interface{}{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			item, err := readCBORItem(vr)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := item.(cborBreak); ok {
+				if !indefinite {
+					return nil, ErrMalformed
+				}
+				break
+			}
+
+			items = append(items, item)
+		}
+
+		return items, nil
+
+	case cborMap:
+		err := vr.enter()
+		if err != nil {
+			return nil, err
+		}
+
+		defer vr.leave()
+
+		mb := &mapBuilder{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			k, err := readCBORItem(vr)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := k.(cborBreak); ok {
+				if !indefinite {
+					return nil, ErrMalformed
+				}
+				break
+			}
+
+			v, err := readCBOR(vr)
+			if err != nil {
+				return nil, err
+			}
+
+			err = mb.add(k, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return mb.build(), nil
+	}
+
+	return nil, ErrMalformed
+}
+
+// Read the chunks of an indefinite length byte or text string.
+func readCBORChunks(vr *valueReader, major byte) ([]byte, error) {
+
+	bs := []byte{}
+
+	for {
+		b, err := vr.readByte()
+		if err != nil {
+			return nil, ErrMalformed
+		}
+
+		if b == cborSimple|31 {
+			return bs, nil
+		}
+
+		if b&0xe0 != major {
+			return nil, ErrMalformed
+		}
+
+		n, indefinite, err := readCBORArg(vr, b&0x1f)
+		if err != nil || indefinite {
+			return nil, ErrMalformed
+		}
+
+		chunk, err := vr.readN(n)
+		if err != nil {
+			return nil, err
+		}
+
+		bs = append(bs, chunk...)
+	}
+}
+
+func readCBORSimple(vr *valueReader, info byte) (interface{}, error) {
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		h, err := vr.readUint(2)
+		return halfToFloat(uint16(h)), err
+	case 26:
+		f, err := vr.readUint(4)
+		return float64(math.Float32frombits(uint32(f))), err
+	case 27:
+		f, err := vr.readUint(8)
+		return math.Float64frombits(f), err
+	case 31:
+		return cborBreak{}, nil
+	}
+
+	return nil, ErrMalformed
+}
+
+// Convert an IEEE 754 half precision float.
+func halfToFloat(h uint16) float64 {
+
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+
+	return f
+}
@@ -0,0 +1,246 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "encoding/hex"
+import "io"
+import "math"
+import "strings"
+import "testing"
+import "time"
+
+func TestCBORCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func unhex(t *testing.T, s string) []byte {
+	bs, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bs
+}
+
+// Examples from RFC 8949, appendix A.
+func TestCBORVectors(t *testing.T) {
+
+	c := NewCBORCodec(nil)
+	assert.Expect(t, CBORMediaType, c.MediaType())
+
+	tests := []struct {
+		hex     string
+		obj     Storable
+		encodes bool
+	}{
+		{"00", int64(0), true},
+		{"17", int64(23), true},
+		{"1818", int64(24), true},
+		{"1903e8", int64(1000), true},
+		{"1a000f4240", int64(1000000), true},
+		{"1b000000e8d4a51000", int64(1000000000000), true},
+		{"1bffffffffffffffff", uint64(math.MaxUint64), true},
+		{"20", int64(-1), true},
+		{"3863", int64(-100), true},
+		{"3903e7", int64(-1000), true},
+		{"f93e00", 1.5, false},
+		{"f97c00", math.Inf(1), false},
+		{"fa47c35000", 100000.0, true},
+		{"fb3ff199999999999a", 1.1, true},
+		{"f4", false, true},
+		{"f5", true, true},
+		{"f6", nil, true},
+		{"60", "", true},
+		{"6449455446", "IETF", true},
+		{"4401020304", []byte{1, 2, 3, 4}, true},
+		{"80", []interface{}{}, true},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}, true},
+		{"a0", map[string]interface{}{}, true},
+		{"a26161016162820203", map[string]interface{}{
+			"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)},
+		}, true},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}, true},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}, false},
+		{"7f657374726561646d696e67ff", "streaming", false},
+		{"9fff", []interface{}{}, false},
+		{"9f018202039f0405ffff", []interface{}{
+			int64(1),
+			[]interface{}{int64(2), int64(3)},
+			[]interface{}{int64(4), int64(5)},
+		}, false},
+		{"bf61610161629f0203ffff", map[string]interface{}{
+			"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)},
+		}, false},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z", false},
+	}
+
+	for _, test := range tests {
+		obj, err := c.Decode(unhex(t, test.hex))
+		assert.Expect(t, nil, err)
+		assert.Expect(t, test.obj, obj)
+
+		if test.encodes {
+			bs, err := c.Encode(test.obj)
+			assert.Expect(t, nil, err)
+			assert.Expect(t, test.hex, hex.EncodeToString(bs))
+		}
+	}
+}
+
+type record struct {
+	Name    string            `json:"name"`
+	Count   int               `cbor:"n" msgpack:"n"`
+	Ratio   float32           `json:"ratio,omitempty"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]uint16 `json:"attrs"`
+	Data    []byte            `json:"data"`
+	Next    *record           `json:"next"`
+	When    time.Time         `json:"when"`
+	Skipped string            `json:"-"`
+	hidden  string
+	embedded
+}
+
+type embedded struct {
+	Level int8 `json:"level"`
+}
+
+func testRecord() *record {
+	return &record{
+		Name:  "first",
+		Count: 3,
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]uint16{"x": 1, "y": 65535},
+		Data:  []byte{0, 1, 2},
+		Next:  &record{Name: "second", Count: -1},
+		When:  time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC),
+		embedded: embedded{
+			Level: -5,
+		},
+	}
+}
+
+func TestCBORCodecPrototype(t *testing.T) {
+
+	c := NewCBORCodec(&record{})
+
+	r := testRecord()
+	r.Skipped, r.hidden = "skipped", "hidden"
+
+	bs, err := c.Encode(r)
+	assert.Expect(t, nil, err)
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+
+	r.Skipped, r.hidden = "", ""
+	assert.Expect(t, r, obj)
+
+	// Field names and tags, as seen by a generic decoder.
+	obj, err = NewCBORCodec(nil).Decode(bs)
+	assert.Expect(t, nil, err)
+
+	m := obj.(map[string]interface{})
+	assert.Expect(t, int64(3), m["n"])
+	assert.Expect(t, int64(-5), m["level"])
+	assert.Expect(t, "2019-05-01T12:00:00Z", m["when"])
+	assert.Expect(t, false, m["ratio"] != nil)
+
+	obj, err = NewCBORCodec(record{}).Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, *r, obj)
+
+	obj, err = NewCBORCodec(ID("")).Decode([]byte("\x63abc"))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, ID("abc"), obj)
+}
+
+func TestCBORCodecStream(t *testing.T) {
+
+	c := NewCBORCodec([]ID{})
+
+	buf := &bytes.Buffer{}
+	assert.Expect(t, nil, c.EncodeTo(buf, []ID{"1", "2"}))
+	assert.Expect(t, nil, c.EncodeTo(buf, []ID{}))
+
+	obj, err := c.DecodeFrom(buf)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"1", "2"}, obj)
+
+	obj, err = c.DecodeFrom(buf)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{}, obj)
+
+	_, err = c.DecodeFrom(buf)
+	assert.Expect(t, io.EOF, err)
+}
+
+func TestCBORCodecErrors(t *testing.T) {
+
+	c := NewCBORCodec(nil)
+
+	tests := []struct {
+		hex string
+		err error
+	}{
+		{"", io.EOF},
+		{"19", ErrMalformed},
+		{"6449", ErrMalformed},
+		{"8301", io.ErrUnexpectedEOF},
+		{"0000", ErrMalformed},
+		{"ff", ErrMalformed},
+		{"1c", ErrMalformed},
+		{"f8", ErrMalformed},
+		{"1f", ErrMalformed},
+		{"8201ff", ErrMalformed},
+		{"5f6161ff", ErrMalformed},
+		{"a18001", ErrMalformed},
+		{"3bffffffffffffffff", ErrTypeMismatch},
+	}
+
+	for _, test := range tests {
+		_, err := c.Decode(unhex(t, test.hex))
+		assert.Expect(t, test.err, err)
+	}
+
+	_, err := c.Decode(bytes.Repeat([]byte{0x81}, maxDecodeDepth+1))
+	assert.Expect(t, ErrMalformed, err)
+
+	// Deeply nested tags are skipped without recursing.
+	obj, err := c.Decode(append(bytes.Repeat([]byte{0xc0}, 1<<22), 0x00))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int64(0), obj)
+
+	for _, h := range []string{"c0", "9fc0ff", "dfff", "d8"} {
+		_, err = c.Decode(unhex(t, h))
+		assert.Expect(t, ErrMalformed, err)
+	}
+
+	// A length longer than the data is not allocated.
+	_, err = c.Decode(unhex(t, "5bffffffffffffff00"))
+	assert.Expect(t, ErrMalformed, err)
+
+	_, err = c.Encode(make(chan int))
+	assert.Expect(t, ErrUnsupportedType, err)
+
+	_, err = NewCBORCodec(int8(0)).Decode(unhex(t, "1903e8"))
+	assert.Expect(t, ErrTypeMismatch, err)
+
+	_, err = NewCBORCodec(uint(0)).Decode(unhex(t, "20"))
+	assert.Expect(t, ErrTypeMismatch, err)
+
+	_, err = NewCBORCodec(&record{}).Decode(unhex(t, "83010203"))
+	assert.Expect(t, ErrTypeMismatch, err)
+
+	_, err = NewCBORCodec(&record{}).Decode(([]byte)("\xa1\x64name\x01"))
+	assert.Expect(t, ErrTypeMismatch, err)
+
+	_, err = NewCBORCodec(time.Time{}).Decode(([]byte)("\x63bad"))
+	if err == nil || !strings.Contains(err.Error(), "parsing time") {
+		t.Errorf("Expected a time parsing error, got %v.", err)
+	}
+}
@@ -0,0 +1,272 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "encoding"
+import "encoding/csv"
+import "io"
+import "reflect"
+import "strconv"
+
+// The media type of the CSV codec, RFC 4180.
+const CSVMediaType = "text/csv"
+
+type csvCodec struct {
+	proto reflect.Type
+}
+
+// A Codec for slices of records as CSV, decoding to the slice type of
+// proto, such as []Order or []*Order.
+//
+// The first row holds the names of the struct fields, from the csv
+// struct tag, falling back to the json tag.  Columns are matched to
+// fields by name when decoding and unknown columns are ignored.
+// Fields must be strings, booleans, numbers or implement
+// encoding.TextMarshaler and encoding.TextUnmarshaler, nil pointers
+// are empty.
+//
+// Without a prototype items are [][]string rows, with no header.
+//
+func NewCSVCodec(proto Storable) Codec {
+	return csvCodec{reflect.TypeOf(proto)}
+}
+
+func (c csvCodec) MediaType() string {
+	return CSVMediaType
+}
+
+func (c csvCodec) Encode(obj Storable) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	err := c.EncodeTo(buf, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// The record type of a slice type, and whether records are pointers.
+func csvRecordType(t reflect.Type) (reflect.Type, bool, error) {
+
+	if t == nil || t.Kind() != reflect.Slice {
+		return nil, false, ErrUnsupportedType
+	}
+
+	rt := t.Elem()
+	if rt.Kind() == reflect.Ptr {
+		if rt.Elem().Kind() != reflect.Struct {
+			return nil, false, ErrUnsupportedType
+		}
+
+		return rt.Elem(), true, nil
+	}
+
+	if rt.Kind() != reflect.Struct {
+		return nil, false, ErrUnsupportedType
+	}
+
+	return rt, false, nil
+}
+
+func (c csvCodec) EncodeTo(w io.Writer, obj Storable) error {
+
+	cw := csv.NewWriter(w)
+
+	if rows, ok := obj.([][]string); ok {
+		return cw.WriteAll(rows)
+	}
+
+	v := reflect.ValueOf(obj)
+
+	rt, _, err := csvRecordType(reflect.TypeOf(obj))
+	if err != nil {
+		return err
+	}
+
+	fields := structFields(rt, "csv")
+
+	row := make([]string, len(fields))
+	for i, f := range fields {
+		row[i] = f.name
+	}
+
+	err = cw.Write(row)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		rv := reflect.Indirect(v.Index(i))
+		if !rv.IsValid() {
+			return ErrUnsupportedType
+		}
+
+		for j, f := range fields {
+			row[j], err = formatCSVField(rv.FieldByIndex(f.index))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func formatCSVField(v reflect.Value) (string, error) {
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+
+		v = v.Elem()
+	}
+
+	if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+		bs, err := tm.MarshalText()
+		return (string)(bs), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+
+	return "", ErrUnsupportedType
+}
+
+func (c csvCodec) Decode(bs []byte) (Storable, error) {
+	return c.DecodeFrom(bytes.NewReader(bs))
+}
+
+func (c csvCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.proto == nil {
+		return rows, nil
+	}
+
+	rt, ptrs, err := csvRecordType(c.proto)
+	if err != nil {
+		return nil, err
+	}
+
+	records := reflect.MakeSlice(c.proto, 0, len(rows))
+	if len(rows) == 0 {
+		return records.Interface(), nil
+	}
+
+	fields := structFields(rt, "csv")
+
+	columns := make([]*structField, len(rows[0]))
+	for i, name := range rows[0] {
+		if f, ok := fieldByName(fields, name); ok {
+			columns[i] = &f
+		}
+	}
+
+	for _, row := range rows[1:] {
+		rv := reflect.New(rt)
+
+		for i, s := range row {
+			if i >= len(columns) || columns[i] == nil {
+				continue
+			}
+
+			err := parseCSVField(rv.Elem().FieldByIndex(columns[i].index), s)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !ptrs {
+			rv = rv.Elem()
+		}
+
+		records = reflect.Append(records, rv)
+	}
+
+	return records.Interface(), nil
+}
+
+func parseCSVField(v reflect.Value, s string) error {
+
+	if v.Kind() == reflect.Ptr {
+		if s == "" {
+			return nil
+		}
+
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+
+	if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText(([]byte)(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return ErrTypeMismatch
+		}
+
+		v.SetBool(b)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return ErrTypeMismatch
+		}
+
+		v.SetInt(i)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return ErrTypeMismatch
+		}
+
+		v.SetUint(u)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return ErrTypeMismatch
+		}
+
+		v.SetFloat(f)
+		return nil
+	}
+
+	return ErrUnsupportedType
+}
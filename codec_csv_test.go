@@ -0,0 +1,79 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "testing"
+import "time"
+
+func TestCSVCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type row struct {
+	Name  string    `csv:"name"`
+	Count uint      `csv:"count"`
+	Delta int16     `json:"delta"`
+	Ratio float64   `csv:"ratio"`
+	OK    bool      `csv:"ok"`
+	Note  *string   `csv:"note"`
+	When  time.Time `csv:"when"`
+	Skip  string    `csv:"-"`
+}
+
+func TestCSVCodec(t *testing.T) {
+
+	c := NewCSVCodec([]row{})
+	assert.Expect(t, CSVMediaType, c.MediaType())
+
+	note := "with, comma"
+	when := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	rows := []row{
+		{"a", 1, -2, 0.5, true, &note, when, ""},
+		{"b", 0, 0, 0, false, nil, time.Time{}, ""},
+	}
+
+	bs, err := c.Encode(rows)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "name,count,delta,ratio,ok,note,when\n"+
+		"a,1,-2,0.5,true,\"with, comma\",2019-05-01T00:00:00Z\n"+
+		"b,0,0,0,false,,0001-01-01T00:00:00Z\n", (string)(bs))
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, rows, obj)
+
+	// Pointer records, reordered and unknown columns.
+	obj, err = NewCSVCodec([]*row{}).Decode([]byte("extra,Name,COUNT\nx,c,7\n"))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []*row{{Name: "c", Count: 7}}, obj)
+
+	obj, err = c.Decode([]byte{})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []row{}, obj)
+
+	_, err = c.Decode([]byte("count\n-1\n"))
+	assert.Expect(t, ErrTypeMismatch, err)
+
+	_, err = c.Encode([]string{"not", "records"})
+	assert.Expect(t, ErrUnsupportedType, err)
+
+	_, err = NewCSVCodec(row{}).Decode(bs)
+	assert.Expect(t, ErrUnsupportedType, err)
+}
+
+func TestCSVCodecRows(t *testing.T) {
+
+	c := NewCSVCodec(nil)
+
+	rows := [][]string{{"a", "b"}, {"1", "2\n3"}}
+
+	buf := &bytes.Buffer{}
+	assert.Expect(t, nil, c.EncodeTo(buf, rows))
+
+	obj, err := c.DecodeFrom(buf)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, rows, obj)
+}
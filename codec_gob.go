@@ -0,0 +1,92 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "encoding/gob"
+import "io"
+import "reflect"
+
+// The media type of the gob codec.
+const GobMediaType = "application/x-gob"
+
+type gobCodec struct {
+	proto reflect.Type
+}
+
+// A Codec for encoding/gob items, decoding to the type of proto.
+//
+// Without a prototype items are sent as interface values, so their
+// types must be registered with gob.Register on both sides.  Each item
+// carries its own type information.
+//
+func NewGobCodec(proto Storable) Codec {
+	return gobCodec{reflect.TypeOf(proto)}
+}
+
+func (c gobCodec) MediaType() string {
+	return GobMediaType
+}
+
+func (c gobCodec) Encode(obj Storable) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	err := c.EncodeTo(buf, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c gobCodec) EncodeTo(w io.Writer, obj Storable) error {
+
+	enc := gob.NewEncoder(w)
+
+	if c.proto == nil {
+		return enc.Encode(&obj)
+	}
+
+	return enc.Encode(obj)
+}
+
+func (c gobCodec) Decode(bs []byte) (Storable, error) {
+	return c.DecodeFrom(bytes.NewReader(bs))
+}
+
+func (c gobCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	dec := gob.NewDecoder(r)
+
+	if c.proto == nil {
+		var obj Storable
+		err := dec.Decode(&obj)
+		return obj, err
+	}
+
+	return decodeInto(c.proto, dec.Decode)
+}
+
+// Decode into a new value of type t with a function decoding into a
+// pointer, as in encoding/gob and encoding/xml.
+func decodeInto(t reflect.Type, decode func(interface{}) error) (Storable, error) {
+
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		err := decode(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		return v.Interface(), nil
+	}
+
+	v := reflect.New(t)
+	err := decode(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Elem().Interface(), nil
+}
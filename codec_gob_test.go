@@ -0,0 +1,67 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "encoding/gob"
+import "io"
+import "testing"
+
+func TestGobCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestGobCodecPrototype(t *testing.T) {
+
+	c := NewGobCodec(order{})
+	assert.Expect(t, GobMediaType, c.MediaType())
+
+	bs, err := c.Encode(order{"o-1", 9.5})
+	assert.Expect(t, nil, err)
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 9.5}, obj)
+
+	// Gob does not distinguish pointers from values.
+	obj, err = NewGobCodec(&order{}).Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &order{"o-1", 9.5}, obj)
+
+	buf := &bytes.Buffer{}
+	assert.Expect(t, nil, c.EncodeTo(buf, order{"o-1", 1}))
+	assert.Expect(t, nil, c.EncodeTo(buf, order{"o-2", 2}))
+
+	for _, exp := range []order{{"o-1", 1}, {"o-2", 2}} {
+		obj, err := c.DecodeFrom(buf)
+		assert.Expect(t, nil, err)
+		assert.Expect(t, exp, obj)
+	}
+
+	_, err = c.DecodeFrom(buf)
+	assert.Expect(t, io.EOF, err)
+}
+
+type gobOnly struct {
+	Name string
+}
+
+func TestGobCodecRegistered(t *testing.T) {
+
+	gob.Register(gobOnly{})
+
+	c := NewGobCodec(nil)
+
+	bs, err := c.Encode(gobOnly{"registered"})
+	assert.Expect(t, nil, err)
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, gobOnly{"registered"}, obj)
+
+	_, err = c.Encode(order{})
+	if err == nil {
+		t.Error("Expected an error encoding an unregistered type.")
+	}
+}
@@ -0,0 +1,316 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "encoding/json"
+import "io"
+import "reflect"
+
+// The media type of the JSON codecs.
+const JSONMediaType = "application/json"
+
+type JSONOpt func(*jsonCodec)
+
+// Decode into a new value of the same type as proto.
+//
+// A pointer prototype decodes to a new pointer, any other prototype
+// to a value of its type.
+//
+func OptJSONPrototype(proto Storable) JSONOpt {
+	return func(c *jsonCodec) {
+		t := reflect.TypeOf(proto)
+		if t == nil {
+			c.newValue = nil
+			return
+		}
+
+		c.newValue = func() Storable {
+			if t.Kind() == reflect.Ptr {
+				return reflect.New(t.Elem()).Interface()
+			}
+
+			return reflect.New(t).Interface()
+		}
+
+		c.deref = t.Kind() != reflect.Ptr
+	}
+}
+
+// Decode into the pointer returned by newValue, returning the pointer.
+func OptJSONConstructor(newValue func() Storable) JSONOpt {
+	return func(c *jsonCodec) {
+		c.newValue = newValue
+		c.deref = false
+	}
+}
+
+// Fail to decode objects with fields not found in the target struct.
+func OptJSONDisallowUnknownFields() JSONOpt {
+	return func(c *jsonCodec) {
+		c.disallowUnknown = true
+	}
+}
+
+// Decode numbers into json.Number rather than float64 when decoding
+// into interface values.
+func OptJSONUseNumber() JSONOpt {
+	return func(c *jsonCodec) {
+		c.useNumber = true
+	}
+}
+
+// Indent encoded values with the given string.
+func OptJSONIndent(indent string) JSONOpt {
+	return func(c *jsonCodec) {
+		c.indent = indent
+	}
+}
+
+type jsonCodec struct {
+	newValue        func() Storable
+	deref           bool
+	disallowUnknown bool
+	useNumber       bool
+	indent          string
+}
+
+// A Codec for JSON items.
+//
+// Without a prototype or constructor, items decode to the generic
+// types of encoding/json, such as map[string]interface{}.
+//
+func NewJSONCodec(opts ...JSONOpt) Codec {
+
+	c := &jsonCodec{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *jsonCodec) MediaType() string {
+	return JSONMediaType
+}
+
+func (c *jsonCodec) Encode(obj Storable) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	err := c.EncodeTo(buf, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (c *jsonCodec) EncodeTo(w io.Writer, obj Storable) error {
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", c.indent)
+
+	return enc.Encode(obj)
+}
+
+func (c *jsonCodec) Decode(bs []byte) (Storable, error) {
+	return c.DecodeFrom(bytes.NewReader(bs))
+}
+
+func (c *jsonCodec) decoder(r io.Reader) *json.Decoder {
+
+	dec := json.NewDecoder(r)
+
+	if c.disallowUnknown {
+		dec.DisallowUnknownFields()
+	}
+
+	if c.useNumber {
+		dec.UseNumber()
+	}
+
+	return dec
+}
+
+func (c *jsonCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	dec := c.decoder(r)
+
+	if c.newValue == nil {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+
+		return v, jsonEnd(dec)
+	}
+
+	v := c.newValue()
+
+	err := dec.Decode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jsonEnd(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.deref {
+		return reflect.ValueOf(v).Elem().Interface(), nil
+	}
+
+	return v, nil
+}
+
+// Check that nothing but whitespace follows the decoded value.
+func jsonEnd(dec *json.Decoder) error {
+
+	_, err := dec.Token()
+	if err != io.EOF {
+		return ErrMalformed
+	}
+
+	return nil
+}
+
+func (c *jsonCodec) DecodeEnvelope(bs []byte) (string, []byte, error) {
+
+	env := map[string]json.RawMessage{}
+
+	err := json.Unmarshal(bs, &env)
+	if _, ok := err.(*json.UnmarshalTypeError); ok {
+		return "", nil, ErrNoEnvelope
+	}
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	var name string
+	if json.Unmarshal(env["type"], &name) != nil || name == "" {
+		return "", nil, ErrNoEnvelope
+	}
+
+	value := env["value"]
+	if value == nil {
+		value = json.RawMessage("null")
+	}
+
+	return name, value, nil
+}
+
+type jsonListCodec struct{}
+
+// A list Codec for JSON arrays of IDs.
+//
+// Arrays are written and read one ID at a time, so long lists are
+// not buffered when streamed.
+//
+var JSONListCodec Codec = jsonListCodec{}
+
+func (jsonListCodec) MediaType() string {
+	return JSONMediaType
+}
+
+func (c jsonListCodec) Encode(obj Storable) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	err := c.EncodeTo(buf, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (jsonListCodec) EncodeTo(w io.Writer, obj Storable) error {
+
+	ids, ok := obj.([]ID)
+	if !ok {
+		return ErrNotIDList
+	}
+
+	sep := []byte("[")
+	for _, id := range ids {
+		bs, err := json.Marshal(id)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(append(sep, bs...))
+		if err != nil {
+			return err
+		}
+
+		sep = []byte(",")
+	}
+
+	if len(ids) == 0 {
+		_, err := w.Write([]byte("[]"))
+		return err
+	}
+
+	_, err := w.Write([]byte("]"))
+
+	return err
+}
+
+func (c jsonListCodec) Decode(bs []byte) (Storable, error) {
+	return c.DecodeFrom(bytes.NewReader(bs))
+}
+
+func (jsonListCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok != json.Delim('[') {
+		return nil, ErrNotIDList
+	}
+
+	ids := []ID{}
+	for dec.More() {
+		var id ID
+		err = dec.Decode(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	_, err = dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	err = jsonEnd(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// A registry with the JSON codecs, preferred, and the text/plain
+// string codecs.
+//
+// Lists of IDs are sent as text/plain one per line.
+//
+func DefaultCodecs() *CodecRegistry {
+
+	r := NewCodecRegistry(NewJSONCodec(), StringCodec)
+	r.RegisterList(JSONListCodec)
+	r.RegisterList(StringListCodec("\n"))
+
+	return r
+}
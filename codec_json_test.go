@@ -0,0 +1,161 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "encoding/json"
+import "io/ioutil"
+import "strings"
+import "testing"
+
+func TestJSONCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type order struct {
+	ID    string  `json:"id"`
+	Total float64 `json:"total"`
+}
+
+func TestJSONCodecGeneric(t *testing.T) {
+
+	c := NewJSONCodec()
+	assert.Expect(t, JSONMediaType, c.MediaType())
+
+	bs, err := c.Encode(map[string]interface{}{"a": 1})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, `{"a":1}`, (string)(bs))
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, map[string]interface{}{"a": 1.0}, obj)
+
+	obj, err = NewJSONCodec(OptJSONUseNumber()).Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, map[string]interface{}{"a": json.Number("1")}, obj)
+
+	_, err = c.Decode([]byte("{"))
+	if err == nil {
+		t.Error("Expected a syntax error.")
+	}
+}
+
+func TestJSONCodecTyped(t *testing.T) {
+
+	data := `{"id":"o-1","total":9.5}`
+
+	obj, err := NewJSONCodec(OptJSONPrototype(order{})).Decode([]byte(data))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 9.5}, obj)
+
+	obj, err = NewJSONCodec(OptJSONPrototype(&order{})).DecodeFrom(strings.NewReader(data))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &order{"o-1", 9.5}, obj)
+
+	obj, err = NewJSONCodec(OptJSONConstructor(func() Storable {
+		return &order{Total: -1}
+	})).Decode([]byte(`{"id":"o-2"}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &order{"o-2", -1}, obj)
+
+	strict := NewJSONCodec(OptJSONPrototype(order{}), OptJSONDisallowUnknownFields())
+
+	_, err = strict.Decode([]byte(`{"id":"o-3","extra":true}`))
+	if err == nil {
+		t.Error("Expected unknown fields to be rejected.")
+	}
+
+	_, err = strict.Decode([]byte(data))
+	assert.Expect(t, nil, err)
+
+	buf := &bytes.Buffer{}
+	err = NewJSONCodec(OptJSONIndent("  ")).EncodeTo(buf, order{"o-1", 9.5})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "{\n  \"id\": \"o-1\",\n  \"total\": 9.5\n}\n", buf.String())
+}
+
+func TestJSONMarshalers(t *testing.T) {
+
+	rc, n, err := JSONMarshaler(order{"o-1", 9.5})
+	assert.Expect(t, nil, err)
+
+	bs, _ := ioutil.ReadAll(rc)
+	assert.Expect(t, int64(len(bs)), n)
+
+	obj, err := JSONUnmarshaler(order{})(bytes.NewReader(bs))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 9.5}, obj)
+
+	obj, err = JSONUnmarshaler(nil)(strings.NewReader(`"text"`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "text", obj)
+}
+
+func TestJSONListCodec(t *testing.T) {
+
+	bs, err := JSONListCodec.Encode([]ID{"1", "a \"quoted\" id"})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, `["1","a \"quoted\" id"]`, (string)(bs))
+
+	obj, err := JSONListCodec.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"1", "a \"quoted\" id"}, obj)
+
+	bs, _ = JSONListCodec.Encode([]ID{})
+	assert.Expect(t, "[]", (string)(bs))
+
+	obj, err = JSONListCodec.DecodeFrom(strings.NewReader(" [ ] "))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{}, obj)
+
+	_, err = JSONListCodec.Decode([]byte(`{"a":1}`))
+	assert.Expect(t, ErrNotIDList, err)
+
+	_, err = JSONListCodec.Decode([]byte(`["1",2]`))
+	if err == nil {
+		t.Error("Expected an error for a non-string ID.")
+	}
+
+	_, err = JSONListCodec.Encode("1")
+	assert.Expect(t, ErrNotIDList, err)
+
+	_, err = JSONListCodec.Decode([]byte(`["1"] ["2"]`))
+	assert.Expect(t, ErrMalformed, err)
+}
+
+func TestJSONCodecTrailingData(t *testing.T) {
+
+	type order struct {
+		ID string `json:"id"`
+	}
+
+	codecs := []Codec{
+		NewJSONCodec(),
+		NewJSONCodec(OptJSONPrototype(order{})),
+	}
+
+	for _, c := range codecs {
+		_, err := c.Decode([]byte("{\"id\":\"1\"} \n"))
+		assert.Expect(t, nil, err)
+
+		for _, data := range []string{`{"id":"1"}{"id":"2"}`, `{"id":"1"} garbage`, `{"id":"1"}}`} {
+			_, err = c.Decode(([]byte)(data))
+			assert.Expect(t, ErrMalformed, err)
+		}
+	}
+}
+
+func TestDefaultCodecs(t *testing.T) {
+
+	r := DefaultCodecs()
+
+	c, _ := r.Negotiate("*/*")
+	assert.Expect(t, JSONMediaType, c.MediaType())
+
+	c, _ = r.Negotiate("text/plain")
+	assert.Expect(t, "text/plain", c.MediaType())
+
+	c, _ = r.NegotiateList("")
+	assert.Expect(t, JSONMediaType, c.MediaType())
+}
@@ -0,0 +1,333 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "encoding/binary"
+import "io"
+import "math"
+import "reflect"
+import "time"
+
+// The media type of the MessagePack codec.
+const MsgPackMediaType = "application/msgpack"
+
+// A Codec for MessagePack items, decoding to the type of proto.
+//
+// Structs are encoded as maps keyed by field name, or by the msgpack
+// struct tag, falling back to the json tag.  Without a prototype items
+// decode to the same generic types as the CBOR codec, with timestamp
+// extensions decoding to time.Time.  Other extension types are not
+// supported.
+//
+func NewMsgPackCodec(proto Storable) Codec {
+	return valueCodec{
+		t:     MsgPackMediaType,
+		key:   "msgpack",
+		proto: reflect.TypeOf(proto),
+		write: writeMsgPack,
+		read:  readMsgPack,
+	}
+}
+
+type msgpackWriter struct {
+	buf *bytes.Buffer
+}
+
+func writeMsgPack(w io.Writer, key string, obj Storable) error {
+
+	mw := msgpackWriter{&bytes.Buffer{}}
+
+	err := writeValue(mw, key, reflect.ValueOf(obj))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(mw.buf.Bytes())
+
+	return err
+}
+
+// Write a type byte followed by a big endian value.
+func (mw msgpackWriter) put(b byte, v interface{}) {
+	mw.buf.WriteByte(b)
+	binary.Write(mw.buf, binary.BigEndian, v)
+}
+
+func (mw msgpackWriter) writeNil() {
+	mw.buf.WriteByte(0xc0)
+}
+
+func (mw msgpackWriter) writeBool(b bool) {
+	if b {
+		mw.buf.WriteByte(0xc3)
+		return
+	}
+
+	mw.buf.WriteByte(0xc2)
+}
+
+func (mw msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		mw.writeUint(uint64(i))
+	case i >= -32:
+		mw.buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		mw.put(0xd0, int8(i))
+	case i >= math.MinInt16:
+		mw.put(0xd1, int16(i))
+	case i >= math.MinInt32:
+		mw.put(0xd2, int32(i))
+	default:
+		mw.put(0xd3, i)
+	}
+}
+
+func (mw msgpackWriter) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		mw.buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		mw.put(0xcc, uint8(u))
+	case u <= math.MaxUint16:
+		mw.put(0xcd, uint16(u))
+	case u <= math.MaxUint32:
+		mw.put(0xce, uint32(u))
+	default:
+		mw.put(0xcf, u)
+	}
+}
+
+// Floats are written as single precision when no precision is lost.
+func (mw msgpackWriter) writeFloat(f float64) {
+	if f32 := float32(f); float64(f32) == f {
+		mw.put(0xca, math.Float32bits(f32))
+		return
+	}
+
+	mw.put(0xcb, math.Float64bits(f))
+}
+
+// Write the head of a sized item, using the fixed form below fixed.
+func (mw msgpackWriter) head(n int, fix byte, fixed int, b8, b16, b32 byte) {
+	switch {
+	case n < fixed:
+		mw.buf.WriteByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		mw.put(b8, uint8(n))
+	case n <= math.MaxUint16:
+		mw.put(b16, uint16(n))
+	default:
+		mw.put(b32, uint32(n))
+	}
+}
+
+func (mw msgpackWriter) writeString(s string) {
+	mw.head(len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	mw.buf.WriteString(s)
+}
+
+func (mw msgpackWriter) writeBytes(bs []byte) {
+	mw.head(len(bs), 0, 0, 0xc4, 0xc5, 0xc6)
+	mw.buf.Write(bs)
+}
+
+func (mw msgpackWriter) writeArray(n int) {
+	mw.head(n, 0x90, 16, 0, 0xdc, 0xdd)
+}
+
+func (mw msgpackWriter) writeMap(n int) {
+	mw.head(n, 0x80, 16, 0, 0xde, 0xdf)
+}
+
+// The sizes of the big endian lengths following 8, 16 and 32 bit
+// sized types.
+var msgpackSizes = map[byte]int{
+	0xc4: 1, 0xc5: 2, 0xc6: 4, // bin
+	0xc7: 1, 0xc8: 2, 0xc9: 4, // ext
+	0xd9: 1, 0xda: 2, 0xdb: 4, // str
+	0xdc: 2, 0xdd: 4, // array
+	0xde: 2, 0xdf: 4, // map
+}
+
+func readMsgPack(vr *valueReader) (interface{}, error) {
+
+	b, err := vr.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0xa0 && b <= 0xbf:
+		return readMsgPackString(vr, uint64(b&0x1f))
+	case b >= 0x90 && b <= 0x9f:
+		return readMsgPackArray(vr, uint64(b&0x0f))
+	case b >= 0x80 && b <= 0x8f:
+		return readMsgPackMap(vr, uint64(b&0x0f))
+	}
+
+	if size, ok := msgpackSizes[b]; ok {
+		n, err := vr.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+
+		switch b {
+		case 0xc4, 0xc5, 0xc6:
+			return vr.readN(n)
+		case 0xc7, 0xc8, 0xc9:
+			return readMsgPackExt(vr, n)
+		case 0xd9, 0xda, 0xdb:
+			return readMsgPackString(vr, n)
+		case 0xdc, 0xdd:
+			return readMsgPackArray(vr, n)
+		default:
+			return readMsgPackMap(vr, n)
+		}
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+
+	case 0xca:
+		f, err := vr.readUint(4)
+		return float64(math.Float32frombits(uint32(f))), err
+	case 0xcb:
+		f, err := vr.readUint(8)
+		return math.Float64frombits(f), err
+
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := vr.readUint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+
+		return u, nil
+
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+
+		u, err := vr.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+
+		// Sign extend from the size read.
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, nil
+
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return readMsgPackExt(vr, 1<<(b-0xd4))
+	}
+
+	return nil, ErrMalformed
+}
+
+func readMsgPackString(vr *valueReader, n uint64) (interface{}, error) {
+
+	bs, err := vr.readN(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return (string)(bs), nil
+}
+
+func readMsgPackArray(vr *valueReader, n uint64) (interface{}, error) {
+
+	err := vr.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	defer vr.leave()
+
+	items := []interface{}{}
+	for i := uint64(0); i < n; i++ {
+		item, err := readMsgPack(vr)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func readMsgPackMap(vr *valueReader, n uint64) (interface{}, error) {
+
+	err := vr.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	defer vr.leave()
+
+	mb := &mapBuilder{}
+	for i := uint64(0); i < n; i++ {
+		k, err := readMsgPack(vr)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := readMsgPack(vr)
+		if err != nil {
+			return nil, err
+		}
+
+		err = mb.add(k, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mb.build(), nil
+}
+
+// Read an extension of n bytes, only timestamps, type -1, are
+// supported.
+func readMsgPackExt(vr *valueReader, n uint64) (interface{}, error) {
+
+	t, err := vr.readByte()
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	data, err := vr.readN(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if int8(t) != -1 {
+		return nil, ErrCodecUnsupported
+	}
+
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		u := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(u&0x3ffffffff), int64(u>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	}
+
+	return nil, ErrMalformed
+}
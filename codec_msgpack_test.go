@@ -0,0 +1,158 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "encoding/hex"
+import "io"
+import "math"
+import "strings"
+import "testing"
+import "time"
+
+func TestMsgPackCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestMsgPackVectors(t *testing.T) {
+
+	c := NewMsgPackCodec(nil)
+	assert.Expect(t, MsgPackMediaType, c.MediaType())
+
+	tests := []struct {
+		hex     string
+		obj     Storable
+		encodes bool
+	}{
+		{"c0", nil, true},
+		{"c2", false, true},
+		{"c3", true, true},
+		{"00", int64(0), true},
+		{"7f", int64(127), true},
+		{"ccc8", int64(200), true},
+		{"cd0100", int64(256), true},
+		{"ce00011170", int64(70000), true},
+		{"cf0000000100000000", int64(1 << 32), true},
+		{"cfffffffffffffffff", uint64(math.MaxUint64), true},
+		{"ff", int64(-1), true},
+		{"e0", int64(-32), true},
+		{"d0df", int64(-33), true},
+		{"d1ff00", int64(-256), true},
+		{"d2fffe7960", int64(-100000), true},
+		{"d3fffffffefffffffe", int64(-4294967298), true},
+		{"ca3fc00000", 1.5, true},
+		{"cb3ff199999999999a", 1.1, true},
+		{"a0", "", true},
+		{"a161", "a", true},
+		{"d90161", "a", false},
+		{"da000161", "a", false},
+		{"c4020102", []byte{1, 2}, true},
+		{"90", []interface{}{}, true},
+		{"920102", []interface{}{int64(1), int64(2)}, true},
+		{"dc00020102", []interface{}{int64(1), int64(2)}, false},
+		{"81a16101", map[string]interface{}{"a": int64(1)}, true},
+		{"820102a162c3", map[interface{}]interface{}{int64(1): int64(2), "b": true}, false},
+		{"d6ff00000000", time.Unix(0, 0).UTC(), false},
+		{"d7ff0000000400000001", time.Unix(1, 1).UTC(), false},
+		{"c70cff00000001ffffffffffffffff", time.Unix(-1, 1).UTC(), false},
+	}
+
+	for _, test := range tests {
+		obj, err := c.Decode(unhex(t, test.hex))
+		assert.Expect(t, nil, err)
+		assert.Expect(t, test.obj, obj)
+
+		if test.encodes {
+			bs, err := c.Encode(test.obj)
+			assert.Expect(t, nil, err)
+			assert.Expect(t, test.hex, hex.EncodeToString(bs))
+		}
+	}
+
+	// Lengths past the fixed forms.
+	long := strings.Repeat("x", 300)
+
+	bs, err := c.Encode(long)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "da012c", hex.EncodeToString(bs[:3]))
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, long, obj)
+
+	items := make([]interface{}, 20)
+	for i := range items {
+		items[i] = int64(i)
+	}
+
+	bs, err = c.Encode(items)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "dc0014", hex.EncodeToString(bs[:3]))
+
+	obj, err = c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, items, obj)
+}
+
+func TestMsgPackCodecPrototype(t *testing.T) {
+
+	c := NewMsgPackCodec(&record{})
+
+	r := testRecord()
+
+	buf := &bytes.Buffer{}
+	assert.Expect(t, nil, c.EncodeTo(buf, r))
+	assert.Expect(t, nil, c.EncodeTo(buf, &record{Name: "third"}))
+
+	obj, err := c.DecodeFrom(buf)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, r, obj)
+
+	obj, err = c.DecodeFrom(buf)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &record{Name: "third"}, obj)
+
+	_, err = c.DecodeFrom(buf)
+	assert.Expect(t, io.EOF, err)
+
+	// Timestamps decode to time fields.
+	obj, err = NewMsgPackCodec(record{}).Decode(unhex(t, "81a47768656ed6ff00000000"))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, time.Unix(0, 0).UTC(), obj.(record).When)
+}
+
+func TestMsgPackCodecErrors(t *testing.T) {
+
+	c := NewMsgPackCodec(nil)
+
+	tests := []struct {
+		hex string
+		err error
+	}{
+		{"", io.EOF},
+		{"c1", ErrMalformed},
+		{"cd01", ErrMalformed},
+		{"a261", ErrMalformed},
+		{"9201", io.ErrUnexpectedEOF},
+		{"c0c0", ErrMalformed},
+		{"819001", ErrMalformed},
+		{"d40100", ErrCodecUnsupported},
+		{"d5ff0000", ErrMalformed},
+		{"c6ffffffff", ErrMalformed},
+	}
+
+	for _, test := range tests {
+		_, err := c.Decode(unhex(t, test.hex))
+		assert.Expect(t, test.err, err)
+	}
+
+	_, err := c.Decode(bytes.Repeat([]byte{0x91}, maxDecodeDepth+1))
+	assert.Expect(t, ErrMalformed, err)
+
+	_, err = c.Encode(func() {})
+	assert.Expect(t, ErrUnsupportedType, err)
+
+	_, err = NewMsgPackCodec(uint8(0)).Decode(unhex(t, "cd0100"))
+	assert.Expect(t, ErrTypeMismatch, err)
+}
@@ -0,0 +1,176 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "io/ioutil"
+import "strings"
+import "testing"
+
+func TestCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestFuncCodec(t *testing.T) {
+
+	c := NewFuncCodec("text/x-upper",
+		func(obj Storable) ([]byte, error) {
+			return ([]byte)(strings.ToUpper(obj.(string))), nil
+		}, nil)
+
+	assert.Expect(t, "text/x-upper", c.MediaType())
+
+	bs, err := c.Encode("hello")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "HELLO", (string)(bs))
+
+	buf := &bytes.Buffer{}
+	assert.Expect(t, nil, c.EncodeTo(buf, "world"))
+	assert.Expect(t, "WORLD", buf.String())
+
+	_, err = c.Decode(bs)
+	assert.Expect(t, ErrCodecUnsupported, err)
+
+	_, err = c.DecodeFrom(buf)
+	assert.Expect(t, ErrCodecUnsupported, err)
+}
+
+func TestStringCodecs(t *testing.T) {
+
+	bs, err := StringCodec.Encode("Hello\nWorld!")
+	assert.Expect(t, nil, err)
+
+	obj, err := StringCodec.DecodeFrom(bytes.NewReader(bs))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello\nWorld!", obj)
+
+	_, err = StringCodec.Encode(42)
+	assert.Expect(t, NewHttpStoreError("Expected the Storable to be a string."), err)
+
+	lc := StringListCodec(",")
+
+	bs, err = lc.Encode([]ID{"1", "2", "3"})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "1,2,3", (string)(bs))
+
+	ids, err := CodecIDUnmarshaler(lc)(bytes.NewReader(bs))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"1", "2", "3"}, ids)
+
+	_, err = lc.Encode("1,2")
+	assert.Expect(t, ErrNotIDList, err)
+
+	_, err = CodecIDUnmarshaler(StringCodec)(strings.NewReader("1,2"))
+	assert.Expect(t, ErrNotIDList, err)
+}
+
+func TestCodecMarshaler(t *testing.T) {
+
+	rc, n, err := CodecMarshaler(StringCodec)("Hello")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int64(5), n)
+
+	bs, _ := ioutil.ReadAll(rc)
+	assert.Expect(t, "Hello", (string)(bs))
+
+	obj, err := CodecUnmarshaler(StringCodec)(strings.NewReader("Hello"))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello", obj)
+}
+
+func TestCodecRegistry(t *testing.T) {
+
+	html := NewFuncCodec("text/html", nil, nil)
+	csv := NewFuncCodec("text/csv", nil, nil)
+	other := NewFuncCodec("application/x-other", nil, nil)
+
+	r := NewCodecRegistry(StringCodec, html, other)
+	r.Register(csv)
+	r.RegisterList(StringListCodec(","))
+
+	assert.Expect(t, []string{"text/plain", "text/html", "application/x-other", "text/csv"}, r.MediaTypes())
+	assert.Expect(t, []string{"text/plain"}, r.ListMediaTypes())
+
+	c, ok := r.Codec("Text/Plain; charset=utf-8")
+	assert.Expect(t, true, ok)
+	assert.Expect(t, "text/plain", c.MediaType())
+
+	_, ok = r.Codec("image/png")
+	assert.Expect(t, false, ok)
+
+	_, ok = r.ListCodec("text/html")
+	assert.Expect(t, false, ok)
+
+	tests := map[string]string{
+		"":                                 "text/plain",
+		"*/*":                              "text/plain",
+		"text/html":                        "text/html",
+		"image/png, text/csv":              "text/csv",
+		"text/plain;q=0.5, text/html":      "text/html",
+		"*/*;q=0.1, application/*":         "application/x-other",
+		"text/*, text/csv":                 "text/csv",
+		"text/html;q=0, text/*;q=0.5":      "text/plain",
+		"text/csv;q=0.3, */*;q=0.2, bogus": "text/csv",
+		"text/plain;q=0, */*":              "text/html",
+		"*/*, text/plain;q=0":              "text/html",
+		"text/*;q=0, */*":                  "application/x-other",
+	}
+
+	for accept, exp := range tests {
+		c, ok := r.Negotiate(accept)
+		assert.Expect(t, true, ok)
+		assert.Expect(t, exp, c.MediaType())
+	}
+
+	_, ok = r.Negotiate("image/png")
+	assert.Expect(t, false, ok)
+
+	_, ok = r.Negotiate("*/*;q=0")
+	assert.Expect(t, false, ok)
+
+	c, ok = r.NegotiateList("text/*")
+	assert.Expect(t, true, ok)
+	assert.Expect(t, "text/plain", c.MediaType())
+
+	// Replacing a codec keeps its position.
+	r.Register(NewFuncCodec("text/html", nil, nil))
+	assert.Expect(t, "text/html", r.MediaTypes()[1])
+
+	assert.Expect(t, "text/html, text/plain;q=0.9, application/x-other;q=0.9, text/csv;q=0.9",
+		acceptHeader("text/html", r.MediaTypes()))
+}
+
+func TestStandardCodecs(t *testing.T) {
+
+	r := StandardCodecs(order{})
+
+	assert.Expect(t, []string{JSONMediaType, CBORMediaType, MsgPackMediaType,
+		GobMediaType, XMLMediaType}, r.MediaTypes())
+	assert.Expect(t, []string{JSONMediaType, CBORMediaType, MsgPackMediaType,
+		GobMediaType, "text/plain"}, r.ListMediaTypes())
+
+	for _, mt := range r.MediaTypes() {
+		c, ok := r.Negotiate(mt)
+		assert.Expect(t, true, ok)
+		assert.Expect(t, mt, c.MediaType())
+
+		bs, err := c.Encode(order{"o-1", 9.5})
+		assert.Expect(t, nil, err)
+
+		obj, err := c.Decode(bs)
+		assert.Expect(t, nil, err)
+		assert.Expect(t, order{"o-1", 9.5}, obj)
+	}
+
+	for _, mt := range r.ListMediaTypes() {
+		c, _ := r.ListCodec(mt)
+
+		bs, err := c.Encode([]ID{"1", "2"})
+		assert.Expect(t, nil, err)
+
+		ids, err := CodecIDUnmarshaler(c)(bytes.NewReader(bs))
+		assert.Expect(t, nil, err)
+		assert.Expect(t, []ID{"1", "2"}, ids)
+	}
+}
@@ -0,0 +1,743 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bufio"
+import "bytes"
+import "encoding"
+import "fmt"
+import "io"
+import "reflect"
+import "sort"
+import "strings"
+
+// Returned when encoding values such as channels and functions which
+// have no encoding.
+const ErrUnsupportedType = CodecError("The type can not be encoded.")
+
+// Returned when an encoded value does not fit the type decoded to.
+const ErrTypeMismatch = CodecError("The encoded value does not match the type.")
+
+// Returned when decoding invalid or truncated data.
+const ErrMalformed = CodecError("The encoded data is malformed.")
+
+// The deepest nesting of arrays and maps decoded.
+const maxDecodeDepth = 1024
+
+// Writes the data model shared by the CBOR and MessagePack codecs,
+// nil, booleans, numbers, strings, byte strings, arrays and maps.
+type valueWriter interface {
+	writeNil()
+	writeBool(bool)
+	writeInt(int64)
+	writeUint(uint64)
+	writeFloat(float64)
+	writeString(string)
+	writeBytes([]byte)
+	writeArray(n int)
+	writeMap(n int)
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// An exported struct field and the name it is encoded under.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// The encoded fields of struct type t.
+//
+// Field names come from the tag key, falling back to the json tag,
+// then to the field name.  Embedded structs without a name are
+// flattened and fields tagged "-" are skipped.
+//
+func structFields(t reflect.Type, key string) []structField {
+
+	fields := []structField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, ef := range structFields(f.Type, key) {
+				ef.index = append([]int{i}, ef.index...)
+				fields = append(fields, ef)
+			}
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		sf := structField{name: name, index: []int{i}}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				sf.omitEmpty = true
+			}
+		}
+
+		fields = append(fields, sf)
+	}
+
+	return fields
+}
+
+// The struct field for an encoded name, matching exactly then
+// ignoring case.
+func fieldByName(fields []structField, name string) (structField, bool) {
+
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+
+	return structField{}, false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// Write a Go value to w.
+//
+// Structs are written as maps of their fields and map keys are sorted
+// so encodings are repeatable.  Values implementing
+// encoding.TextMarshaler, such as time.Time, are written as strings.
+//
+func writeValue(w valueWriter, key string, v reflect.Value) error {
+
+	if !v.IsValid() {
+		w.writeNil()
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+	}
+
+	if v.Type().Implements(textMarshalerType) && v.CanInterface() {
+		bs, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+
+		w.writeString((string)(bs))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return writeValue(w, key, v.Elem())
+
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(v.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		w.writeFloat(v.Float())
+
+	case reflect.String:
+		w.writeString(v.String())
+
+	case reflect.Slice:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			w.writeBytes(v.Bytes())
+			return nil
+		}
+
+		return writeArray(w, key, v)
+
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bs := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bs), v)
+			w.writeBytes(bs)
+			return nil
+		}
+
+		return writeArray(w, key, v)
+
+	case reflect.Map:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		w.writeMap(len(keys))
+		for _, k := range keys {
+			err := writeValue(w, key, k)
+			if err != nil {
+				return err
+			}
+
+			err = writeValue(w, key, v.MapIndex(k))
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		fields := []structField{}
+		for _, f := range structFields(v.Type(), key) {
+			if f.omitEmpty && isEmptyValue(v.FieldByIndex(f.index)) {
+				continue
+			}
+
+			fields = append(fields, f)
+		}
+
+		w.writeMap(len(fields))
+		for _, f := range fields {
+			w.writeString(f.name)
+
+			err := writeValue(w, key, v.FieldByIndex(f.index))
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return ErrUnsupportedType
+	}
+
+	return nil
+}
+
+func writeArray(w valueWriter, key string, v reflect.Value) error {
+
+	w.writeArray(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		err := writeValue(w, key, v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Call f with each entry of a decoded map.
+func eachEntry(src interface{}, f func(k, v interface{}) error) (bool, error) {
+
+	switch m := src.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			err := f(k, v)
+			if err != nil {
+				return true, err
+			}
+		}
+
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			err := f(k, v)
+			if err != nil {
+				return true, err
+			}
+		}
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Set dst from a generically decoded value.
+//
+// Numbers convert between kinds when they fit, maps fill structs by
+// field name and strings fill encoding.TextUnmarshalers.  Unknown
+// struct fields are ignored.
+//
+func assignValue(dst reflect.Value, key string, src interface{}) error {
+
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+
+		return assignValue(dst.Elem(), key, src)
+	}
+
+	if reflect.PtrTo(dst.Type()).Implements(textUnmarshalerType) {
+		var text []byte
+		switch s := src.(type) {
+		case string:
+			text = ([]byte)(s)
+		case []byte:
+			text = s
+		default:
+			return ErrTypeMismatch
+		}
+
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(text)
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return ErrTypeMismatch
+		}
+
+		dst.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch n := src.(type) {
+		case int64:
+			i = n
+		case uint64:
+			i = int64(n)
+			if i < 0 {
+				return ErrTypeMismatch
+			}
+		case float64:
+			i = int64(n)
+			if float64(i) != n {
+				return ErrTypeMismatch
+			}
+		default:
+			return ErrTypeMismatch
+		}
+
+		if dst.OverflowInt(i) {
+			return ErrTypeMismatch
+		}
+
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch n := src.(type) {
+		case int64:
+			if n < 0 {
+				return ErrTypeMismatch
+			}
+			u = uint64(n)
+		case uint64:
+			u = n
+		case float64:
+			u = uint64(n)
+			if n < 0 || float64(u) != n {
+				return ErrTypeMismatch
+			}
+		default:
+			return ErrTypeMismatch
+		}
+
+		if dst.OverflowUint(u) {
+			return ErrTypeMismatch
+		}
+
+		dst.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		switch n := src.(type) {
+		case float64:
+			dst.SetFloat(n)
+		case int64:
+			dst.SetFloat(float64(n))
+		case uint64:
+			dst.SetFloat(float64(n))
+		default:
+			return ErrTypeMismatch
+		}
+
+	case reflect.String:
+		switch s := src.(type) {
+		case string:
+			dst.SetString(s)
+		case []byte:
+			dst.SetString((string)(s))
+		default:
+			return ErrTypeMismatch
+		}
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch s := src.(type) {
+			case []byte:
+				dst.SetBytes(append([]byte{}, s...))
+				return nil
+			case string:
+				dst.SetBytes(([]byte)(s))
+				return nil
+			}
+		}
+
+		items, ok := src.([]interface{})
+		if !ok {
+			return ErrTypeMismatch
+		}
+
+		sl := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			err := assignValue(sl.Index(i), key, item)
+			if err != nil {
+				return err
+			}
+		}
+
+		dst.Set(sl)
+
+	case reflect.Array:
+		if bs, ok := src.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			if len(bs) != dst.Len() {
+				return ErrTypeMismatch
+			}
+
+			reflect.Copy(dst, reflect.ValueOf(bs))
+			return nil
+		}
+
+		items, ok := src.([]interface{})
+		if !ok || len(items) != dst.Len() {
+			return ErrTypeMismatch
+		}
+
+		for i, item := range items {
+			err := assignValue(dst.Index(i), key, item)
+			if err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		m := reflect.MakeMap(dst.Type())
+
+		isMap, err := eachEntry(src, func(k, v interface{}) error {
+			kv := reflect.New(dst.Type().Key()).Elem()
+			err := assignValue(kv, key, k)
+			if err != nil {
+				return err
+			}
+
+			vv := reflect.New(dst.Type().Elem()).Elem()
+			err = assignValue(vv, key, v)
+			if err != nil {
+				return err
+			}
+
+			m.SetMapIndex(kv, vv)
+			return nil
+		})
+
+		if !isMap {
+			return ErrTypeMismatch
+		}
+
+		if err != nil {
+			return err
+		}
+
+		dst.Set(m)
+
+	case reflect.Struct:
+		fields := structFields(dst.Type(), key)
+
+		isMap, err := eachEntry(src, func(k, v interface{}) error {
+			name, ok := k.(string)
+			if !ok {
+				return nil
+			}
+
+			f, ok := fieldByName(fields, name)
+			if !ok {
+				return nil
+			}
+
+			return assignValue(dst.FieldByIndex(f.index), key, v)
+		})
+
+		if !isMap {
+			return ErrTypeMismatch
+		}
+
+		return err
+
+	case reflect.Interface:
+		if !sv.Type().Implements(dst.Type()) {
+			return ErrTypeMismatch
+		}
+
+		dst.Set(sv)
+
+	default:
+		return ErrTypeMismatch
+	}
+
+	return nil
+}
+
+// Decode a generic value to a new value of type t, a pointer to a new
+// value for pointer types, or return it as is when t is nil.
+func decodeAs(t reflect.Type, key string, src interface{}) (Storable, error) {
+
+	if t == nil {
+		return src, nil
+	}
+
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		err := assignValue(v.Elem(), key, src)
+		if err != nil {
+			return nil, err
+		}
+
+		return v.Interface(), nil
+	}
+
+	v := reflect.New(t).Elem()
+	err := assignValue(v, key, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Interface(), nil
+}
+
+// Reads the bytes of one encoded value.
+type valueReader struct {
+	r     io.ByteReader
+	src   io.Reader
+	depth int
+}
+
+func newValueReader(r io.Reader) *valueReader {
+
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(r)
+		br, r = b, b
+	}
+
+	return &valueReader{r: br, src: r}
+}
+
+func (vr *valueReader) readByte() (byte, error) {
+
+	b, err := vr.r.ReadByte()
+	if err == io.EOF && vr.depth > 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return b, err
+}
+
+// Read n bytes, without trusting n when allocating.
+func (vr *valueReader) readN(n uint64) ([]byte, error) {
+
+	if int64(n) < 0 {
+		return nil, ErrMalformed
+	}
+
+	buf := &bytes.Buffer{}
+
+	_, err := io.CopyN(buf, vr.src, int64(n))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Read an unsigned big endian integer of size bytes.
+func (vr *valueReader) readUint(size int) (uint64, error) {
+
+	var u uint64
+
+	for i := 0; i < size; i++ {
+		b, err := vr.r.ReadByte()
+		if err != nil {
+			return 0, ErrMalformed
+		}
+
+		u = u<<8 | uint64(b)
+	}
+
+	return u, nil
+}
+
+// Enter a nested array or map.
+func (vr *valueReader) enter() error {
+
+	vr.depth++
+	if vr.depth > maxDecodeDepth {
+		return ErrMalformed
+	}
+
+	return nil
+}
+
+func (vr *valueReader) leave() {
+	vr.depth--
+}
+
+// A map of decoded entries, with string keys when every key is a
+// string.
+type mapBuilder struct {
+	keys   []interface{}
+	values []interface{}
+}
+
+func (mb *mapBuilder) add(k, v interface{}) error {
+
+	switch k.(type) {
+	case []interface{}, map[string]interface{}, map[interface{}]interface{}, []byte:
+		return ErrMalformed
+	}
+
+	mb.keys = append(mb.keys, k)
+	mb.values = append(mb.values, v)
+
+	return nil
+}
+
+func (mb *mapBuilder) build() interface{} {
+
+	strs := map[string]interface{}{}
+	for i, k := range mb.keys {
+		s, ok := k.(string)
+		if !ok {
+			m := map[interface{}]interface{}{}
+			for i, k := range mb.keys {
+				m[k] = mb.values[i]
+			}
+
+			return m
+		}
+
+		strs[s] = mb.values[i]
+	}
+
+	return strs
+}
+
+// Values encoded to and decoded from the CBOR and MessagePack data
+// model.
+type valueCodec struct {
+	t     string
+	key   string
+	proto reflect.Type
+	write func(io.Writer, string, Storable) error
+	read  func(*valueReader) (interface{}, error)
+}
+
+func (c valueCodec) MediaType() string {
+	return c.t
+}
+
+func (c valueCodec) Encode(obj Storable) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	err := c.EncodeTo(buf, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c valueCodec) EncodeTo(w io.Writer, obj Storable) error {
+	return c.write(w, c.key, obj)
+}
+
+func (c valueCodec) Decode(bs []byte) (Storable, error) {
+
+	r := bytes.NewReader(bs)
+
+	obj, err := c.DecodeFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() > 0 {
+		return nil, ErrMalformed
+	}
+
+	return obj, nil
+}
+
+func (c valueCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	v, err := c.read(newValueReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAs(c.proto, c.key, v)
+}
@@ -0,0 +1,49 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "encoding/xml"
+import "io"
+import "reflect"
+
+// The media type of the XML codec.
+const XMLMediaType = "application/xml"
+
+type xmlCodec struct {
+	proto reflect.Type
+}
+
+// A Codec for encoding/xml items, decoding to the type of proto.
+//
+// XML is not self-describing, without a prototype the codec only
+// encodes and decoding returns ErrCodecUnsupported.
+//
+func NewXMLCodec(proto Storable) Codec {
+	return xmlCodec{reflect.TypeOf(proto)}
+}
+
+func (c xmlCodec) MediaType() string {
+	return XMLMediaType
+}
+
+func (c xmlCodec) Encode(obj Storable) ([]byte, error) {
+	return xml.Marshal(obj)
+}
+
+func (c xmlCodec) EncodeTo(w io.Writer, obj Storable) error {
+	return xml.NewEncoder(w).Encode(obj)
+}
+
+func (c xmlCodec) Decode(bs []byte) (Storable, error) {
+	return c.DecodeFrom(bytes.NewReader(bs))
+}
+
+func (c xmlCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	if c.proto == nil {
+		return nil, ErrCodecUnsupported
+	}
+
+	return decodeInto(c.proto, xml.NewDecoder(r).Decode)
+}
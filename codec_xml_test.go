@@ -0,0 +1,51 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "testing"
+
+func TestXMLCodecTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type invoice struct {
+	Number string   `xml:"number,attr"`
+	Lines  []string `xml:"line"`
+}
+
+func TestXMLCodec(t *testing.T) {
+
+	c := NewXMLCodec(&invoice{})
+	assert.Expect(t, XMLMediaType, c.MediaType())
+
+	inv := &invoice{"7", []string{"one", "two"}}
+
+	bs, err := c.Encode(inv)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, `<invoice number="7"><line>one</line><line>two</line></invoice>`, (string)(bs))
+
+	obj, err := c.Decode(bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, inv, obj)
+
+	buf := &bytes.Buffer{}
+	assert.Expect(t, nil, c.EncodeTo(buf, inv))
+
+	obj, err = NewXMLCodec(invoice{}).DecodeFrom(buf)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, *inv, obj)
+
+	obj, err = NewXMLCodec("").Decode([]byte("<string>value</string>"))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "value", obj)
+
+	_, err = NewXMLCodec(nil).Decode(bs)
+	assert.Expect(t, ErrCodecUnsupported, err)
+
+	_, err = c.Decode([]byte("<invoice>"))
+	if err == nil {
+		t.Error("Expected a syntax error.")
+	}
+}
@@ -0,0 +1,151 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored_test // import "kilobit.ca/go/stored_test"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/stored/storetest"
+import "io/ioutil"
+import "os"
+import "testing"
+
+func encodeString(obj stored.Storable) ([]byte, error) {
+	return ([]byte)(obj.(string)), nil
+}
+
+func TestConformanceMapStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewMapStore(), nil
+	}, storetest.OptSkipConcurrency())
+}
+
+func TestConformanceSyncStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewSyncStore(stored.NewMapStore()), nil
+	})
+}
+
+func TestConformanceIDGenStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewIDGenStore(stored.NewSyncStore(stored.NewMapStore()), stored.IncrIDGen()), nil
+	})
+}
+
+func TestConformanceSchemaStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewSchemaStore(
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.NewSchemaRegistry(1),
+		), nil
+	})
+}
+
+func TestConformanceVersionedStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewVersionedStore(
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.NewSyncStore(stored.NewMapStore()),
+		), nil
+	})
+}
+
+func TestConformanceSoftDeleteStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewSoftDeleteStore(
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.NewSyncStore(stored.NewMapStore()),
+		), nil
+	})
+}
+
+func TestConformanceAuditedStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		as, _ := stored.NewAuditedStore(
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.StoreAuditSink(stored.NewSyncStore(stored.NewMapStore())),
+			encodeString,
+		)
+
+		return as, nil
+	})
+}
+
+func TestConformanceNamespacedStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		ms := stored.NewSyncStore(stored.NewMapStore())
+		ms.StoreItem("other/1", "Not in the namespace")
+
+		ns, _ := stored.NewNamespacedStore(ms, "tenant",
+			stored.OptQuota(stored.Quota{MaxItems: 1000}))
+		return ns, nil
+	})
+}
+
+func TestConformanceOrderedStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewOrderedStore(), nil
+	})
+}
+
+func TestConformanceSearchableStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		ss, _ := stored.NewSearchableStore(stored.NewSyncStore(stored.NewMapStore()))
+		return ss, nil
+	})
+}
+
+func TestConformancePersistentMapStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		dir, err := ioutil.TempDir("", "stored-conformance-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := stored.NewPersistentMapStore(dir, encodeString,
+			func(bs []byte) (stored.Storable, error) {
+				return (string)(bs), nil
+			}, stored.OptSync(stored.SyncNever), stored.OptSnapshotEvery(7))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s, func() {
+			s.Close()
+			os.RemoveAll(dir)
+		}
+	})
+}
+
+func TestConformanceIntegrityStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewIntegrityStore(stored.NewSyncStore(stored.NewMapStore()),
+			encodeString,
+			func(bs []byte) (stored.Storable, error) {
+				return (string)(bs), nil
+			}), nil
+	})
+}
+
+func TestConformanceTracedStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewTracedStore(stored.NewSyncStore(stored.NewMapStore()),
+			&stored.HookTracer{}, "mem"), nil
+	})
+}
+
+func TestConformanceAuthorizedStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return stored.NewAuthorizedStore(stored.NewSyncStore(stored.NewMapStore()),
+			stored.NewRulePolicy(stored.AllowAnyone())), nil
+	})
+}
+
+func TestConformanceContentStore(t *testing.T) {
+
+	cs := func() *stored.ContentStore {
+		return stored.NewContentStore(stored.NewSyncStore(stored.NewMapStore()), encodeString)
+	}
+
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return cs(), nil
+	}, storetest.OptContentAddressed(cs().Hash))
+}
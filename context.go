@@ -0,0 +1,100 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+
+// Stores accepting a context with each mutation.
+//
+// The context carries request scoped values such as the principal
+// making the change.  Callers holding a context, such as the
+// DataServer, should prefer these methods when a store provides them.
+//
+type ContextStore interface {
+	Store
+	StoreItemContext(context.Context, ID, Storable) error
+	DeleteContext(context.Context, ID) error
+}
+
+type principalKey struct{}
+
+// Attach the identity of the caller to a context.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// The principal attached to a context, or an empty string.
+func Principal(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
+
+// Store an item using the context aware method when available.
+func StoreItemContext(ctx context.Context, s Store, id ID, obj Storable) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.StoreItemContext(ctx, id, obj)
+	}
+
+	return s.StoreItem(id, obj)
+}
+
+// Delete an item using the context aware method when available.
+func DeleteContext(ctx context.Context, s Store, id ID) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.DeleteContext(ctx, id)
+	}
+
+	return s.Delete(id)
+}
+
+// Stores accepting a context with reads.
+type ContextReader interface {
+	RetrieveContext(context.Context, ID) (Storable, error)
+	ListContext(context.Context) ([]ID, error)
+}
+
+// Retrieve an item using the context aware method when available.
+func RetrieveContext(ctx context.Context, s Store, id ID) (Storable, error) {
+	if cr, ok := s.(ContextReader); ok {
+		return cr.RetrieveContext(ctx, id)
+	}
+
+	return s.Retrieve(id)
+}
+
+// List items using the context aware method when available.
+func ListContext(ctx context.Context, s Store) ([]ID, error) {
+	if cr, ok := s.(ContextReader); ok {
+		return cr.ListContext(ctx)
+	}
+
+	return s.List()
+}
+
+// Stores accepting a context with Apply.
+type ContextApplier interface {
+	ApplyContext(context.Context, ItemHandler) error
+}
+
+// Apply f using the context aware method when available.
+func ApplyContext(ctx context.Context, s Store, f ItemHandler) error {
+	if ca, ok := s.(ContextApplier); ok {
+		return ca.ApplyContext(ctx, f)
+	}
+
+	return s.Apply(f)
+}
+
+// Stores accepting a context when creating items.
+type ContextCreator interface {
+	CreateContext(context.Context, Storable) (ID, error)
+}
+
+// Create an item using the context aware method when available.
+func CreateContext(ctx context.Context, c Creator, obj Storable) (ID, error) {
+	if cc, ok := c.(ContextCreator); ok {
+		return cc.CreateContext(ctx, obj)
+	}
+
+	return c.Create(obj)
+}
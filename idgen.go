@@ -0,0 +1,323 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/binary"
+import "encoding/hex"
+import "hash"
+import "io"
+import "math/big"
+import "strconv"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// Generates IDs for new items.
+//
+// Implementations must be safe for concurrent use.
+//
+type IDGenerator interface {
+	NextID(Storable) (ID, error)
+}
+
+type IDGeneratorFunc func(Storable) (ID, error)
+
+func (f IDGeneratorFunc) NextID(obj Storable) (ID, error) {
+	return f(obj)
+}
+
+// Stores that assign the ID of new items themselves.
+type Creator interface {
+	Create(Storable) (ID, error)
+}
+
+// A store wrapper implementing Creator with an IDGenerator.
+type IDGenStore struct {
+	Store
+	gen IDGenerator
+}
+
+func NewIDGenStore(store Store, gen IDGenerator) *IDGenStore {
+	return &IDGenStore{store, gen}
+}
+
+func (s *IDGenStore) Create(obj Storable) (ID, error) {
+	return s.CreateContext(context.Background(), obj)
+}
+
+func (s *IDGenStore) CreateContext(ctx context.Context, obj Storable) (ID, error) {
+
+	id, err := s.gen.NextID(obj)
+	if err != nil {
+		return "", err
+	}
+
+	err = StoreItemContext(ctx, s.Store, id, obj)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func randomBytes(bs []byte) error {
+	_, err := io.ReadFull(rand.Reader, bs)
+	return err
+}
+
+// Sequential decimal IDs starting at 0.
+//
+// Note: The counter is not persisted, see CounterIDGen.
+//
+func IncrIDGen() IDGenerator {
+	i := int64(-1)
+	return IDGeneratorFunc(func(Storable) (ID, error) {
+		return (ID)(strconv.FormatInt(atomic.AddInt64(&i, 1), 10)), nil
+	})
+}
+
+func formatUUID(bs []byte) ID {
+	s := hex.EncodeToString(bs)
+	return (ID)(s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32])
+}
+
+// Random RFC 4122 version 4 UUIDs.
+func UUIDv4Gen() IDGenerator {
+	return IDGeneratorFunc(func(Storable) (ID, error) {
+		bs := make([]byte, 16)
+		err := randomBytes(bs)
+		if err != nil {
+			return "", err
+		}
+
+		bs[6] = (bs[6] & 0x0f) | 0x40
+		bs[8] = (bs[8] & 0x3f) | 0x80
+
+		return formatUUID(bs), nil
+	})
+}
+
+// Time ordered RFC 9562 version 7 UUIDs.
+func UUIDv7Gen() IDGenerator {
+	return IDGeneratorFunc(func(Storable) (ID, error) {
+		bs := make([]byte, 16)
+		err := randomBytes(bs[6:])
+		if err != nil {
+			return "", err
+		}
+
+		putMillis48(bs, time.Now())
+		bs[6] = (bs[6] & 0x0f) | 0x70
+		bs[8] = (bs[8] & 0x3f) | 0x80
+
+		return formatUUID(bs), nil
+	})
+}
+
+func putMillis48(bs []byte, t time.Time) {
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		bs[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Lexicographically sortable ULIDs.
+//
+// See https://github.com/ulid/spec
+//
+func ULIDGen() IDGenerator {
+	return IDGeneratorFunc(func(Storable) (ID, error) {
+		bs := make([]byte, 16)
+		err := randomBytes(bs[6:])
+		if err != nil {
+			return "", err
+		}
+
+		putMillis48(bs, time.Now())
+
+		// 128 bits encoded 5 bits at a time, most significant first
+		// with 2 bits of leading padding.
+		n := new(big.Int).SetBytes(bs)
+		out := make([]byte, 26)
+		mask := big.NewInt(31)
+		for i := 25; i >= 0; i-- {
+			out[i] = crockford[new(big.Int).And(n, mask).Int64()]
+			n.Rsh(n, 5)
+		}
+
+		return (ID)(out), nil
+	})
+}
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// The KSUID epoch, 2014-05-13T16:53:20Z.
+const ksuidEpoch = 1400000000
+
+// KSUID style IDs, a 32 bit timestamp in seconds followed by 128
+// random bits encoded as 27 base62 characters.
+//
+// See https://github.com/segmentio/ksuid
+//
+func KSUIDGen() IDGenerator {
+	return IDGeneratorFunc(func(Storable) (ID, error) {
+		bs := make([]byte, 20)
+		err := randomBytes(bs[4:])
+		if err != nil {
+			return "", err
+		}
+
+		binary.BigEndian.PutUint32(bs, uint32(time.Now().Unix()-ksuidEpoch))
+
+		n := new(big.Int).SetBytes(bs)
+		out := make([]byte, 27)
+		base := big.NewInt(62)
+		rem := new(big.Int)
+		for i := 26; i >= 0; i-- {
+			n.QuoRem(n, base, rem)
+			out[i] = base62[rem.Int64()]
+		}
+
+		return (ID)(out), nil
+	})
+}
+
+// IDs derived from the hex encoded hash of the encoded item.
+//
+// A nil hash function defaults to SHA-256.
+//
+func ContentHashIDGen(newHash func() hash.Hash, enc func(Storable) ([]byte, error)) IDGenerator {
+
+	if newHash == nil {
+		newHash = sha256.New
+	}
+
+	return IDGeneratorFunc(func(obj Storable) (ID, error) {
+		bs, err := enc(obj)
+		if err != nil {
+			return "", err
+		}
+
+		h := newHash()
+		h.Write(bs)
+
+		return (ID)(hex.EncodeToString(h.Sum(nil))), nil
+	})
+}
+
+// The default snowflake epoch, 2019-11-01T00:00:00Z.
+var SnowflakeEpoch = time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+
+type snowflake struct {
+	node int64
+	last int64
+	seq  int64
+	mu   sync.Mutex
+}
+
+// Twitter snowflake style IDs, 41 bits of milliseconds since
+// SnowflakeEpoch, a 10 bit node ID and a 12 bit sequence.
+//
+// Each process generating IDs for the same store needs a distinct
+// node ID.
+//
+func SnowflakeGen(node int) (IDGenerator, error) {
+
+	if node < 0 || node > 1023 {
+		return nil, NewStoreError("Snowflake node ID must be between 0 and 1023.")
+	}
+
+	return &snowflake{node: int64(node)}, nil
+}
+
+func (sf *snowflake) NextID(Storable) (ID, error) {
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	now := time.Since(SnowflakeEpoch).Nanoseconds() / int64(time.Millisecond)
+	if now < sf.last {
+		now = sf.last
+	}
+
+	if now == sf.last {
+		sf.seq = (sf.seq + 1) & 0xfff
+		if sf.seq == 0 {
+			// Sequence exhausted, wait for the next millisecond.
+			for now <= sf.last {
+				time.Sleep(time.Millisecond / 10)
+				now = time.Since(SnowflakeEpoch).Nanoseconds() / int64(time.Millisecond)
+			}
+		}
+	} else {
+		sf.seq = 0
+	}
+
+	sf.last = now
+
+	return (ID)(strconv.FormatInt(now<<22|sf.node<<12|sf.seq, 10)), nil
+}
+
+type counter struct {
+	store Store
+	key   ID
+	mu    sync.Mutex
+}
+
+// Sequential decimal IDs starting at 0, with the last issued value
+// persisted in store under key.
+//
+// The store must not be the store of the items, or the counter would
+// be listed, searched and migrated with them.  Use a store of its
+// own, or a NamespacedStore over a backend where the items are kept
+// in a different namespace.
+//
+// The counter is kept as a decimal string so that it can be encoded
+// by any codec supporting strings.  Only one generator may use a
+// given counter at a time.
+//
+func CounterIDGen(store Store, key ID) IDGenerator {
+	return &counter{store: store, key: key}
+}
+
+func (c *counter) NextID(Storable) (ID, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := int64(0)
+
+	obj, err := c.store.Retrieve(c.key)
+	if err != nil && err != ErrNotFound {
+		return "", err
+	}
+
+	if err == nil {
+		s, ok := obj.(string)
+		if !ok {
+			return "", NewStoreError("Counter " + (string)(c.key) + " is not a string.")
+		}
+
+		last, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return "", err
+		}
+
+		next = last + 1
+	}
+
+	id := strconv.FormatInt(next, 10)
+
+	err = c.store.StoreItem(c.key, id)
+	if err != nil {
+		return "", err
+	}
+
+	return (ID)(id), nil
+}
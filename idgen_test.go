@@ -0,0 +1,141 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "regexp"
+import "sort"
+import "sync"
+
+func TestIDGenTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func expectIDs(t *testing.T, gen IDGenerator, pattern string) []ID {
+	t.Helper()
+
+	re := regexp.MustCompile(pattern)
+	ids := []ID{}
+	seen := map[ID]bool{}
+
+	for i := 0; i < 100; i++ {
+		id, err := gen.NextID(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !re.MatchString((string)(id)) {
+			t.Errorf("ID %s does not match %s.", id, pattern)
+		}
+
+		if seen[id] {
+			t.Errorf("Duplicate ID %s.", id)
+		}
+
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func TestIDGenFormats(t *testing.T) {
+
+	expectIDs(t, UUIDv4Gen(), `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	expectIDs(t, UUIDv7Gen(), `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	expectIDs(t, ULIDGen(), `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	expectIDs(t, KSUIDGen(), `^[0-9A-Za-z]{27}$`)
+
+	sf, err := SnowflakeGen(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, sf, `^[0-9]+$`)
+
+	_, err = SnowflakeGen(1024)
+	if err == nil {
+		t.Error("Expected an invalid node error.")
+	}
+}
+
+func TestIncrIDGen(t *testing.T) {
+
+	gen := IncrIDGen()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gen.NextID(nil)
+		}()
+	}
+	wg.Wait()
+
+	id, _ := gen.NextID(nil)
+	assert.Expect(t, ID("10"), id)
+}
+
+func TestContentHashIDGen(t *testing.T) {
+
+	gen := ContentHashIDGen(nil, func(obj Storable) ([]byte, error) {
+		return ([]byte)(obj.(string)), nil
+	})
+
+	id, err := gen.NextID("Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, ID("7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069"), id)
+}
+
+func TestCounterIDGen(t *testing.T) {
+
+	ms := NewMapStore()
+
+	gen := CounterIDGen(ms, "_counter")
+	gen.NextID(nil)
+	gen.NextID(nil)
+
+	// A new generator picks up where the last one stopped.
+	gen = CounterIDGen(ms, "_counter")
+	id, err := gen.NextID(nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, ID("2"), id)
+
+	// Items and the counter share a backend in separate namespaces.
+	backend := NewMapStore()
+	items, _ := NewNamespacedStore(backend, "items")
+	counters, _ := NewNamespacedStore(backend, "counters")
+
+	s := NewIDGenStore(items, CounterIDGen(counters, "orders"))
+	s.Create("Hello")
+	s.Create("World")
+
+	ids, _ := items.List()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	assert.Expect(t, []ID{"0", "1"}, ids)
+
+	obj, _ := counters.Retrieve("orders")
+	assert.Expect(t, "1", obj)
+}
+
+func TestIDGenStore(t *testing.T) {
+
+	ms := NewMapStore()
+	s := NewIDGenStore(ms, IncrIDGen())
+
+	var c Creator = s
+	id, err := c.Create("Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, ID("0"), id)
+	assert.Expect(t, "Hello World!", ms["0"])
+}
@@ -0,0 +1,170 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "crypto/md5"
+import "crypto/sha256"
+import "encoding/base64"
+import "hash"
+import "hash/crc32"
+import "io/ioutil"
+import "net/http"
+import "strings"
+
+type IntegrityError string
+
+func (err IntegrityError) Error() string {
+	return (string)(err)
+}
+
+// Returned when data does not match its checksum.
+const ErrCorrupt = IntegrityError("The data does not match its checksum.")
+
+// Returned for items stored without a checksum, such as those written
+// before an IntegrityStore was introduced.
+const ErrNotChecksummed = IntegrityError("The data has no checksum.")
+
+// Returned for checksums made with an unknown algorithm.
+const ErrUnknownChecksum = IntegrityError("The checksum algorithm is not known.")
+
+// A checksum algorithm, named as in the HTTP Digest Algorithm
+// registry.
+type Checksum struct {
+	Name string
+	New  func() hash.Hash
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32C, fast and enough to detect accidental corruption.
+var CRC32C = Checksum{"crc32c", func() hash.Hash { return crc32.New(castagnoli) }}
+
+// SHA-256, for detecting tampering as well as corruption.
+var SHA256 = Checksum{"sha-256", sha256.New}
+
+// MD5, for Content-MD5 headers.
+var MD5 = Checksum{"md5", md5.New}
+
+// The checksum of bs.
+func (c Checksum) Sum(bs []byte) []byte {
+	h := c.New()
+	h.Write(bs)
+	return h.Sum(nil)
+}
+
+// The built in checksum named name, ignoring case.
+func ChecksumByName(name string) (Checksum, bool) {
+
+	for _, c := range []Checksum{CRC32C, SHA256, MD5} {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+
+	return Checksum{}, false
+}
+
+// Headers carrying the digest of an HTTP message body.
+const (
+	ContentDigestHeader = "Content-Digest" // RFC 9530
+	DigestHeader        = "Digest"         // RFC 3230
+	ContentMD5Header    = "Content-MD5"    // RFC 1864
+)
+
+// Set the Content-Digest header to the digests of bs.
+//
+// An MD5 digest is sent in the Content-MD5 header instead, as MD5 is
+// deprecated for Content-Digest.
+//
+func SetContentDigest(h http.Header, bs []byte, sums ...Checksum) {
+
+	entries := []string{}
+	for _, c := range sums {
+		sum := base64.StdEncoding.EncodeToString(c.Sum(bs))
+
+		if c.Name == MD5.Name {
+			h.Set(ContentMD5Header, sum)
+			continue
+		}
+
+		entries = append(entries, c.Name+"=:"+sum+":")
+	}
+
+	if len(entries) > 0 {
+		h.Set(ContentDigestHeader, strings.Join(entries, ", "))
+	}
+}
+
+// The digests of a Content-Digest or Digest header, by algorithm.
+func parseDigests(value string) map[string]string {
+
+	digests := map[string]string{}
+
+	for _, entry := range strings.Split(value, ",") {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(entry[:i]))
+		digests[name] = strings.Trim(strings.TrimSpace(entry[i+1:]), ":")
+	}
+
+	return digests
+}
+
+// Whether h carries a digest checked by VerifyDigest.
+func HasDigest(h http.Header) bool {
+	return h.Get(ContentDigestHeader) != "" || h.Get(DigestHeader) != "" || h.Get(ContentMD5Header) != ""
+}
+
+// Check bs against the Content-Digest, Digest and Content-MD5 headers
+// of h, returning ErrCorrupt for any mismatch.
+//
+// Digests made with algorithms other than those of ChecksumByName are
+// ignored.
+//
+func VerifyDigest(h http.Header, bs []byte) error {
+
+	digests := parseDigests(h.Get(DigestHeader))
+	for name, d := range parseDigests(h.Get(ContentDigestHeader)) {
+		digests[name] = d
+	}
+
+	if md := h.Get(ContentMD5Header); md != "" {
+		digests[MD5.Name] = md
+	}
+
+	for name, d := range digests {
+		c, ok := ChecksumByName(name)
+		if !ok {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(d)
+		if err != nil || !bytes.Equal(sum, c.Sum(bs)) {
+			return ErrCorrupt
+		}
+	}
+
+	return nil
+}
+
+// Read and check the body of a response carrying digests, leaving the
+// body to be read again.
+func verifyBody(res *http.Response) error {
+
+	if !HasDigest(res.Header) {
+		return nil
+	}
+
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(bs))
+
+	return VerifyDigest(res.Header, bs)
+}
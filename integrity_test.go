@@ -0,0 +1,81 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "encoding/hex"
+import "net/http"
+import "testing"
+
+func TestIntegrityTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestChecksums(t *testing.T) {
+
+	tests := []struct {
+		c   Checksum
+		sum string
+	}{
+		{CRC32C, "e3069283"},
+		{SHA256, "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225"},
+		{MD5, "25f9e794323b453885f5181f1b624d0b"},
+	}
+
+	for _, test := range tests {
+		assert.Expect(t, test.sum, hex.EncodeToString(test.c.Sum([]byte("123456789"))))
+
+		c, ok := ChecksumByName(test.c.Name)
+		assert.Expect(t, true, ok)
+		assert.Expect(t, test.c.Name, c.Name)
+	}
+
+	c, ok := ChecksumByName("SHA-256")
+	assert.Expect(t, true, ok)
+	assert.Expect(t, SHA256.Name, c.Name)
+
+	_, ok = ChecksumByName("sha-512")
+	assert.Expect(t, false, ok)
+}
+
+func TestDigestHeaders(t *testing.T) {
+
+	body := []byte("hello world")
+
+	h := http.Header{}
+	assert.Expect(t, false, HasDigest(h))
+	assert.Expect(t, nil, VerifyDigest(h, body))
+
+	SetContentDigest(h, body, SHA256, CRC32C)
+	assert.Expect(t, "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:, crc32c=:yZRlqg==:",
+		h.Get(ContentDigestHeader))
+
+	assert.Expect(t, true, HasDigest(h))
+	assert.Expect(t, nil, VerifyDigest(h, body))
+	assert.Expect(t, ErrCorrupt, VerifyDigest(h, []byte("hello world!")))
+
+	h = http.Header{}
+	SetContentDigest(h, body, MD5)
+	assert.Expect(t, "", h.Get(ContentDigestHeader))
+	assert.Expect(t, "XrY7u+Ae7tCTyyK7j1rNww==", h.Get(ContentMD5Header))
+	assert.Expect(t, nil, VerifyDigest(h, body))
+
+	tests := []struct {
+		name, value string
+		err         error
+	}{
+		{DigestHeader, "SHA-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", nil},
+		{DigestHeader, "SHA-256=AAAA", ErrCorrupt},
+		{ContentMD5Header, "XrY7u+Ae7tCTyyK7j1rNww==", nil},
+		{ContentMD5Header, "AAAAAAAAAAAAAAAAAAAAAA==", ErrCorrupt},
+		{ContentDigestHeader, "sha-512=:unchecked:, md5=:XrY7u+Ae7tCTyyK7j1rNww==:", nil},
+		{ContentDigestHeader, "sha-256=:not base64:", ErrCorrupt},
+		{ContentDigestHeader, "malformed", nil},
+	}
+
+	for _, test := range tests {
+		h := http.Header{}
+		h.Set(test.name, test.value)
+		assert.Expect(t, test.err, VerifyDigest(h, body))
+	}
+}
@@ -0,0 +1,201 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "net/http"
+import "net/url"
+import "strconv"
+import "strings"
+import "time"
+
+// Metadata kept alongside a stored item.
+//
+// Created and Updated are maintained by the store, Created is kept
+// when an item is replaced.  Size is the encoded size of the item
+// when known.
+//
+type Meta struct {
+	ContentType string            `json:"contentType,omitempty"`
+	Created     time.Time         `json:"created"`
+	Updated     time.Time         `json:"updated"`
+	Size        int64             `json:"size,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Stores keeping metadata with their items.
+//
+// Items stored with StoreItem have no metadata, RetrieveMeta and Stat
+// return a zero Meta for them.
+//
+type MetaStore interface {
+	Store
+	StoreItemWithMeta(ID, Storable, Meta) error
+	RetrieveMeta(ID) (Storable, Meta, error)
+	Stat(ID) (Meta, error)
+}
+
+// Returned when metadata is given to a store which can not keep it.
+const ErrMetaUnsupported = StoreError("The store does not keep metadata.")
+
+// MetaStores accepting a context, see ContextStore.
+type MetaContextStore interface {
+	MetaStore
+	StoreItemWithMetaContext(context.Context, ID, Storable, Meta) error
+	RetrieveMetaContext(context.Context, ID) (Storable, Meta, error)
+}
+
+// Store an item with its metadata using the context aware method when
+// available.
+func StoreItemWithMetaContext(ctx context.Context, s MetaStore, id ID, obj Storable, meta Meta) error {
+	if mcs, ok := s.(MetaContextStore); ok {
+		return mcs.StoreItemWithMetaContext(ctx, id, obj, meta)
+	}
+
+	return s.StoreItemWithMeta(id, obj, meta)
+}
+
+// Retrieve an item with its metadata using the context aware method
+// when available.
+func RetrieveMetaContext(ctx context.Context, s MetaStore, id ID) (Storable, Meta, error) {
+	if mcs, ok := s.(MetaContextStore); ok {
+		return mcs.RetrieveMetaContext(ctx, id)
+	}
+
+	return s.RetrieveMeta(id)
+}
+
+// Set the timestamps of meta for an item replacing one with old
+// metadata.
+func touchMeta(meta, old Meta, now time.Time) Meta {
+
+	meta.Created = old.Created
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+
+	meta.Updated = now
+
+	return meta
+}
+
+// An item stored in a MapStore with its metadata.
+type metaItem struct {
+	value Storable
+	meta  Meta
+}
+
+func (s MapStore) StoreItemWithMeta(id ID, obj Storable, meta Meta) error {
+
+	_, old, _ := s.RetrieveMeta(id)
+
+	s[id] = metaItem{obj, touchMeta(meta, old, time.Now())}
+
+	return nil
+}
+
+func (s MapStore) RetrieveMeta(id ID) (Storable, Meta, error) {
+
+	obj, ok := s[id]
+	if !ok {
+		return nil, Meta{}, ErrNotFound
+	}
+
+	if mi, ok := obj.(metaItem); ok {
+		return mi.value, mi.meta, nil
+	}
+
+	return obj, Meta{}, nil
+}
+
+func (s MapStore) Stat(id ID) (Meta, error) {
+	_, meta, err := s.RetrieveMeta(id)
+	return meta, err
+}
+
+// Headers carrying item metadata over HTTP, along with Content-Type,
+// Content-Length and Last-Modified.
+const (
+	CreatedHeader = "X-Stored-Created"
+	UpdatedHeader = "X-Stored-Updated"
+	TagsHeader    = "X-Stored-Tags"
+	LabelsHeader  = "X-Stored-Labels"
+)
+
+// Add the headers describing meta to h.
+//
+// Timestamps are written in RFC 3339 format, tags as a comma separated
+// list and labels as a URL encoded query.  Content-Type and
+// Content-Length are left to the caller.
+//
+func WriteMetaHeader(h http.Header, meta Meta) {
+
+	if !meta.Created.IsZero() {
+		h.Set(CreatedHeader, meta.Created.Format(time.RFC3339Nano))
+	}
+
+	if !meta.Updated.IsZero() {
+		h.Set(UpdatedHeader, meta.Updated.Format(time.RFC3339Nano))
+		h.Set("Last-Modified", meta.Updated.UTC().Format(http.TimeFormat))
+	}
+
+	writeTagsHeader(h, meta.Tags, meta.Labels)
+}
+
+func writeTagsHeader(h http.Header, tags []string, labels map[string]string) {
+
+	if len(tags) > 0 {
+		escaped := make([]string, len(tags))
+		for i, tag := range tags {
+			escaped[i] = url.QueryEscape(tag)
+		}
+
+		h.Set(TagsHeader, strings.Join(escaped, ","))
+	}
+
+	if len(labels) > 0 {
+		vals := url.Values{}
+		for k, v := range labels {
+			vals.Set(k, v)
+		}
+
+		h.Set(LabelsHeader, vals.Encode())
+	}
+}
+
+// Read the metadata described by the headers in h.
+func ReadMetaHeader(h http.Header) Meta {
+
+	meta := Meta{ContentType: h.Get("Content-Type")}
+
+	meta.Created, _ = time.Parse(time.RFC3339Nano, h.Get(CreatedHeader))
+	meta.Updated, _ = time.Parse(time.RFC3339Nano, h.Get(UpdatedHeader))
+	meta.Size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	meta.Tags, meta.Labels = readTagsHeader(h)
+
+	return meta
+}
+
+func readTagsHeader(h http.Header) ([]string, map[string]string) {
+
+	var tags []string
+	if v := h.Get(TagsHeader); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			tag, err := url.QueryUnescape(strings.TrimSpace(tag))
+			if err == nil && tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	var labels map[string]string
+	if vals, err := url.ParseQuery(h.Get(LabelsHeader)); err == nil && len(vals) > 0 {
+		labels = map[string]string{}
+		for k := range vals {
+			labels[k] = vals.Get(k)
+		}
+	}
+
+	return tags, labels
+}
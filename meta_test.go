@@ -0,0 +1,147 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "net/http"
+import "testing"
+import "time"
+
+func TestMetaTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func testMetaStore(t *testing.T, ms MetaStore) {
+
+	err := ms.StoreItemWithMeta("1", "one", Meta{
+		ContentType: "text/plain",
+		Size:        3,
+		Tags:        []string{"a", "b"},
+		Labels:      map[string]string{"owner": "alice"},
+	})
+	assert.Expect(t, nil, err)
+
+	obj, meta, err := ms.RetrieveMeta("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "one", obj)
+	assert.Expect(t, "text/plain", meta.ContentType)
+	assert.Expect(t, int64(3), meta.Size)
+	assert.Expect(t, []string{"a", "b"}, meta.Tags)
+	assert.Expect(t, "alice", meta.Labels["owner"])
+
+	if meta.Created.IsZero() || meta.Updated.IsZero() {
+		t.Error("Expected the timestamps to be set.")
+	}
+
+	created := meta.Created
+
+	// Plain reads see only the value.
+	obj, _ = ms.Retrieve("1")
+	assert.Expect(t, "one", obj)
+
+	ms.Apply(func(id ID, obj Storable) error {
+		assert.Expect(t, "one", obj)
+		return nil
+	})
+
+	time.Sleep(time.Millisecond)
+	ms.StoreItemWithMeta("1", "uno", Meta{ContentType: "text/plain"})
+
+	meta, err = ms.Stat("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, created, meta.Created)
+	assert.Expect(t, true, meta.Updated.After(created))
+	assert.Expect(t, 0, len(meta.Tags))
+
+	// Items stored without metadata have none.
+	ms.StoreItem("2", "two")
+	meta, err = ms.Stat("2")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, Meta{}, meta)
+
+	_, err = ms.Stat("3")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestMapStoreMeta(t *testing.T) {
+	testMetaStore(t, NewMapStore())
+}
+
+func TestSyncStoreMeta(t *testing.T) {
+	testMetaStore(t, NewSyncStore(NewMapStore()))
+}
+
+// Records the principal of the last context aware meta call.
+type principalMetaStore struct {
+	MapStore
+	principal string
+}
+
+func (s *principalMetaStore) StoreItemWithMetaContext(ctx context.Context, id ID, obj Storable, meta Meta) error {
+	s.principal = Principal(ctx)
+	return s.StoreItemWithMeta(id, obj, meta)
+}
+
+func (s *principalMetaStore) RetrieveMetaContext(ctx context.Context, id ID) (Storable, Meta, error) {
+	s.principal = Principal(ctx)
+	return s.RetrieveMeta(id)
+}
+
+func TestSyncStoreMetaContext(t *testing.T) {
+
+	ps := &principalMetaStore{MapStore: NewMapStore()}
+	ss := NewSyncStore(ps)
+
+	ctx := WithPrincipal(context.Background(), "alice")
+	assert.Expect(t, nil, StoreItemWithMetaContext(ctx, ss, "1", "one", Meta{}))
+	assert.Expect(t, "alice", ps.principal)
+
+	ctx = WithPrincipal(context.Background(), "bob")
+	obj, _, err := RetrieveMetaContext(ctx, ss, "1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "one", obj)
+	assert.Expect(t, "bob", ps.principal)
+
+	// Metadata is not silently dropped.
+	plain := NewSyncStore(struct{ Store }{NewMapStore()})
+	assert.Expect(t, ErrMetaUnsupported, plain.StoreItemWithMeta("1", "one", Meta{}))
+}
+
+func TestMapStoreUpdateKeepsMeta(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItemWithMeta("1", 1, Meta{Tags: []string{"counter"}})
+
+	assert.Expect(t, nil, Update(NewSyncStore(ms), "1", increment))
+
+	obj, meta, _ := ms.RetrieveMeta("1")
+	assert.Expect(t, 2, obj)
+	assert.Expect(t, []string{"counter"}, meta.Tags)
+}
+
+func TestMetaHeader(t *testing.T) {
+
+	now := time.Now()
+	meta := Meta{
+		Created: now.Add(-time.Hour),
+		Updated: now,
+		Tags:    []string{"a,b", "c d"},
+		Labels:  map[string]string{"owner": "alice", "env": "a&b=c"},
+	}
+
+	h := http.Header{}
+	WriteMetaHeader(h, meta)
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Length", "42")
+
+	got := ReadMetaHeader(h)
+
+	assert.Expect(t, true, meta.Created.Equal(got.Created))
+	assert.Expect(t, true, meta.Updated.Equal(got.Updated))
+	assert.Expect(t, meta.Tags, got.Tags)
+	assert.Expect(t, meta.Labels, got.Labels)
+	assert.Expect(t, "text/plain", got.ContentType)
+	assert.Expect(t, int64(42), got.Size)
+	assert.Expect(t, now.UTC().Format(http.TimeFormat), h.Get("Last-Modified"))
+}
@@ -0,0 +1,90 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package metrics // import "kilobit.ca/go/stored/metrics"
+
+import "io"
+import "net/http"
+import "strconv"
+import "time"
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+type recorder struct {
+	http.ResponseWriter
+	code int
+	n    int64
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(bs []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(bs)
+	r.n += int64(n)
+	return n, err
+}
+
+// Wrap an HTTP handler, such as a DataServer, recording requests by
+// method and status code, latencies and bytes transferred.
+//
+// Metrics are labelled with the given handler name.
+//
+func Middleware(reg *Registry, name string, h http.Handler) http.Handler {
+
+	reqs := reg.Counter("http_requests_total",
+		"HTTP requests by handler, method and status code.")
+	latency := reg.Histogram("http_request_duration_seconds",
+		"HTTP request latency in seconds.", LatencyBuckets)
+	in := reg.Counter("http_request_bytes_total",
+		"Bytes read from HTTP request bodies.")
+	out := reg.Counter("http_response_bytes_total",
+		"Bytes written to HTTP response bodies.")
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+
+		start := time.Now()
+
+		body := &countingBody{ReadCloser: req.Body}
+		if req.Body != nil {
+			req.Body = body
+		}
+
+		rec := &recorder{ResponseWriter: res}
+
+		h.ServeHTTP(rec, req)
+
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		labels := Labels{"handler": name, "method": req.Method}
+
+		latency.Observe(time.Since(start).Seconds(), labels)
+		in.Add(float64(body.n), labels)
+		out.Add(float64(rec.n), labels)
+
+		reqs.Inc(Labels{
+			"handler": name,
+			"method":  req.Method,
+			"code":    strconv.Itoa(rec.code),
+		})
+	})
+}
@@ -0,0 +1,44 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package metrics // import "kilobit.ca/go/stored/metrics"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "io/ioutil"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+
+func TestMiddleware(t *testing.T) {
+
+	reg := NewRegistry()
+
+	h := Middleware(reg, "test", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		bs, _ := ioutil.ReadAll(req.Body)
+		if len(bs) == 0 {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		res.Write(bs)
+	}))
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader("Hello World!")))
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("POST", "/", nil))
+
+	reqs := reg.Counter("http_requests_total", "")
+	assert.Expect(t, 1.0, reqs.Value(Labels{"handler": "test", "method": "POST", "code": "200"}))
+	assert.Expect(t, 1.0, reqs.Value(Labels{"handler": "test", "method": "POST", "code": "400"}))
+
+	labels := Labels{"handler": "test", "method": "POST"}
+	assert.Expect(t, 12.0, reg.Counter("http_request_bytes_total", "").Value(labels))
+	assert.Expect(t, 12.0, reg.Counter("http_response_bytes_total", "").Value(labels))
+
+	res = httptest.NewRecorder()
+	reg.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Expect(t, true, strings.Contains(res.Body.String(),
+		`http_requests_total{code="200",handler="test",method="POST"} 1`))
+}
@@ -0,0 +1,359 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+// Lightweight metrics for stores and HTTP services.
+//
+// Metrics are kept in a Registry and can be served in the Prometheus
+// text exposition format or published with expvar.
+//
+package metrics // import "kilobit.ca/go/stored/metrics"
+
+import "bufio"
+import "expvar"
+import "fmt"
+import "io"
+import "math"
+import "net/http"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+
+// Metric label names and values.
+type Labels map[string]string
+
+// Default latency buckets in seconds.
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default size buckets in bytes.
+var SizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+const (
+	kindCounter   = "counter"
+	kindHistogram = "histogram"
+)
+
+type series struct {
+	labels  Labels
+	value   float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	series  map[string]*series
+}
+
+// A set of named metrics.
+//
+// A Registry is safe for concurrent use.
+//
+type Registry struct {
+	families map[string]*family
+	mu       sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+func (r *Registry) family(name, help, kind string, buckets []float64) *family {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if ok {
+		if f.kind != kind {
+			panic("metrics: " + name + " is already registered as a " + f.kind)
+		}
+
+		return f
+	}
+
+	f = &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		buckets: append([]float64{}, buckets...),
+		series:  map[string]*series{},
+	}
+	sort.Float64s(f.buckets)
+
+	r.families[name] = f
+
+	return f
+}
+
+// Must be called with the registry lock held.
+func (f *family) get(labels Labels) *series {
+
+	key := labelString(labels)
+
+	s, ok := f.series[key]
+	if !ok {
+		copied := Labels{}
+		for k, v := range labels {
+			copied[k] = v
+		}
+
+		s = &series{labels: copied, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// A monotonically increasing value.
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// Get or create the counter named name.
+func (r *Registry) Counter(name, help string) *Counter {
+	return &Counter{r, r.family(name, help, kindCounter, nil)}
+}
+
+func (c *Counter) Add(delta float64, labels Labels) {
+
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+
+	c.f.get(labels).value += delta
+}
+
+func (c *Counter) Inc(labels Labels) {
+	c.Add(1, labels)
+}
+
+// The current value of the counter with the given labels.
+func (c *Counter) Value(labels Labels) float64 {
+
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+
+	s, ok := c.f.series[labelString(labels)]
+	if !ok {
+		return 0
+	}
+
+	return s.value
+}
+
+// A distribution of observed values.
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// Get or create the histogram named name.
+//
+// The buckets of an existing histogram are not changed.
+//
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	return &Histogram{r, r.family(name, help, kindHistogram, buckets)}
+}
+
+func (h *Histogram) Observe(v float64, labels Labels) {
+
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+
+	s := h.f.get(labels)
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+
+	s.sum += v
+	s.samples++
+}
+
+// The number and sum of observations with the given labels.
+func (h *Histogram) Count(labels Labels) (uint64, float64) {
+
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+
+	s, ok := h.f.series[labelString(labels)]
+	if !ok {
+		return 0, 0
+	}
+
+	return s.samples, s.sum
+}
+
+func sortedKeys(labels Labels) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelString(labels Labels) string {
+
+	parts := []string{}
+	for _, k := range sortedKeys(labels) {
+		parts = append(parts, k+`="`+labelEscaper.Replace(labels[k])+`"`)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeSample(w io.Writer, name, labels, extra string, v string) {
+
+	switch {
+	case labels == "" && extra == "":
+		fmt.Fprintf(w, "%s %s\n", name, v)
+	case labels == "":
+		fmt.Fprintf(w, "%s{%s} %s\n", name, extra, v)
+	case extra == "":
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, v)
+	default:
+		fmt.Fprintf(w, "%s{%s,%s} %s\n", name, labels, extra, v)
+	}
+}
+
+func (r *Registry) sortedFamilies() []*family {
+
+	names := []string{}
+	for name := range r.families {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fams := []*family{}
+	for _, name := range names {
+		fams = append(fams, r.families[name])
+	}
+
+	return fams
+}
+
+func sortedSeries(f *family) []string {
+
+	keys := []string{}
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Write every metric in the Prometheus text exposition format.
+func (r *Registry) WritePrometheus(out io.Writer) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+
+	for _, f := range r.sortedFamilies() {
+
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.Replace(f.help, "\n", `\n`, -1))
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+		for _, key := range sortedSeries(f) {
+			s := f.series[key]
+
+			if f.kind == kindCounter {
+				writeSample(w, f.name, key, "", formatFloat(s.value))
+				continue
+			}
+
+			for i, b := range f.buckets {
+				writeSample(w, f.name+"_bucket", key, `le="`+formatFloat(b)+`"`,
+					strconv.FormatUint(s.counts[i], 10))
+			}
+
+			writeSample(w, f.name+"_bucket", key, `le="+Inf"`, strconv.FormatUint(s.samples, 10))
+			writeSample(w, f.name+"_sum", key, "", formatFloat(s.sum))
+			writeSample(w, f.name+"_count", key, "", strconv.FormatUint(s.samples, 10))
+		}
+	}
+
+	return w.Flush()
+}
+
+// Serve the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := r.WritePrometheus(res)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// A JSON friendly copy of every metric, keyed by metric name and
+// then by label string.
+func (r *Registry) Snapshot() map[string]interface{} {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := map[string]interface{}{}
+
+	for name, f := range r.families {
+		values := map[string]interface{}{}
+
+		for key, s := range f.series {
+			if f.kind == kindCounter {
+				values[key] = s.value
+				continue
+			}
+
+			buckets := map[string]uint64{}
+			for i, b := range f.buckets {
+				buckets[formatFloat(b)] = s.counts[i]
+			}
+
+			values[key] = map[string]interface{}{
+				"count":   s.samples,
+				"sum":     s.sum,
+				"buckets": buckets,
+			}
+		}
+
+		snap[name] = values
+	}
+
+	return snap
+}
+
+// Publish the registry snapshot as an expvar variable.
+//
+// Like expvar.Publish, this panics if the name is already in use.
+//
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Snapshot()
+	}))
+}
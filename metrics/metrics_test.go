@@ -0,0 +1,95 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package metrics // import "kilobit.ca/go/stored/metrics"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "bytes"
+import "encoding/json"
+import "expvar"
+import "fmt"
+import "strings"
+
+func TestMetricsTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestCounter(t *testing.T) {
+
+	reg := NewRegistry()
+
+	c := reg.Counter("requests_total", "Requests.")
+	c.Inc(Labels{"op": "a"})
+	c.Add(2, Labels{"op": "a"})
+	reg.Counter("requests_total", "Requests.").Inc(Labels{"op": "b"})
+
+	assert.Expect(t, 3.0, c.Value(Labels{"op": "a"}))
+	assert.Expect(t, 1.0, c.Value(Labels{"op": "b"}))
+	assert.Expect(t, 0.0, c.Value(Labels{"op": "c"}))
+}
+
+func TestWritePrometheus(t *testing.T) {
+
+	reg := NewRegistry()
+
+	reg.Counter("hits_total", "Hits.").Inc(Labels{"path": `a"b`})
+
+	h := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05, nil)
+	h.Observe(0.5, nil)
+	h.Observe(5, nil)
+
+	buf := &bytes.Buffer{}
+	err := reg.WritePrometheus(buf)
+	if err != nil {
+		t.Error(err)
+	}
+
+	exp := `# HELP hits_total Hits.
+# TYPE hits_total counter
+hits_total{path="a\"b"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+`
+	assert.Expect(t, exp, buf.String())
+}
+
+// Counts published expvars, as names can only be published once per
+// process and tests may run more than once.
+var expvarRuns int
+
+func TestPublishExpvar(t *testing.T) {
+
+	expvarRuns++
+	name := fmt.Sprintf("%s_%d", t.Name(), expvarRuns)
+
+	reg := NewRegistry()
+	reg.Counter("hits_total", "Hits.").Inc(Labels{"path": "/"})
+	reg.PublishExpvar(name)
+
+	snap := map[string]map[string]float64{}
+	err := json.Unmarshal(([]byte)(expvar.Get(name).String()), &snap)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 1.0, snap["hits_total"][`path="/"`])
+}
+
+func TestRegistryKindConflict(t *testing.T) {
+
+	reg := NewRegistry()
+	reg.Counter("x", "")
+
+	defer func() {
+		r := recover()
+		assert.Expect(t, true, r != nil && strings.Contains(r.(string), "counter"))
+	}()
+
+	reg.Histogram("x", "", nil)
+}
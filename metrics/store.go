@@ -0,0 +1,194 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package metrics // import "kilobit.ca/go/stored/metrics"
+
+import "kilobit.ca/go/stored"
+import "context"
+import "fmt"
+import "time"
+
+type StoreOpt func(*Store)
+
+// Record the size of stored and retrieved items as measured by size.
+//
+// For example, the length of the item as encoded by the store.
+//
+func OptPayloadSize(size func(stored.Storable) int) StoreOpt {
+	return func(s *Store) {
+		s.size = size
+	}
+}
+
+// A store wrapper recording operation counts, errors, latencies and
+// payload sizes.
+//
+// Metrics are labelled with the name of the store and the operation.
+//
+type Store struct {
+	store stored.Store
+	name  string
+	size  func(stored.Storable) int
+
+	ops      *Counter
+	errs     *Counter
+	latency  *Histogram
+	payloads *Histogram
+}
+
+func NewStore(store stored.Store, reg *Registry, name string, opts ...StoreOpt) *Store {
+
+	s := &Store{
+		store: store,
+		name:  name,
+		ops: reg.Counter("stored_operations_total",
+			"Store operations by store and operation."),
+		errs: reg.Counter("stored_errors_total",
+			"Failed store operations by store, operation and error kind."),
+		latency: reg.Histogram("stored_operation_duration_seconds",
+			"Store operation latency in seconds.", LatencyBuckets),
+		payloads: reg.Histogram("stored_payload_bytes",
+			"Size of stored and retrieved items in bytes.", SizeBuckets),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Classify an error for the error kind label.
+func ErrorKind(err error) string {
+	if err == stored.ErrNotFound {
+		return "not_found"
+	}
+
+	return fmt.Sprintf("%T", err)
+}
+
+func (s *Store) observe(op string, start time.Time, err error) {
+
+	labels := Labels{"store": s.name, "op": op}
+
+	s.ops.Inc(labels)
+	s.latency.Observe(time.Since(start).Seconds(), labels)
+
+	if err != nil {
+		s.errs.Inc(Labels{"store": s.name, "op": op, "kind": ErrorKind(err)})
+	}
+}
+
+func (s *Store) observeSize(op string, obj stored.Storable) {
+	if s.size != nil {
+		s.payloads.Observe(float64(s.size(obj)), Labels{"store": s.name, "op": op})
+	}
+}
+
+func (s *Store) StoreItem(id stored.ID, obj stored.Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *Store) StoreItemContext(ctx context.Context, id stored.ID, obj stored.Storable) error {
+
+	start := time.Now()
+	err := stored.StoreItemContext(ctx, s.store, id, obj)
+	s.observe("store", start, err)
+
+	if err == nil {
+		s.observeSize("store", obj)
+	}
+
+	return err
+}
+
+func (s *Store) Retrieve(id stored.ID) (stored.Storable, error) {
+	return s.RetrieveContext(context.Background(), id)
+}
+
+func (s *Store) RetrieveContext(ctx context.Context, id stored.ID) (stored.Storable, error) {
+
+	start := time.Now()
+	obj, err := stored.RetrieveContext(ctx, s.store, id)
+	s.observe("retrieve", start, err)
+
+	if err == nil {
+		s.observeSize("retrieve", obj)
+	}
+
+	return obj, err
+}
+
+func (s *Store) List() ([]stored.ID, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *Store) ListContext(ctx context.Context) ([]stored.ID, error) {
+
+	start := time.Now()
+	ids, err := stored.ListContext(ctx, s.store)
+	s.observe("list", start, err)
+
+	return ids, err
+}
+
+func (s *Store) Search(query string, limit int) ([]stored.SearchResult, error) {
+	return s.SearchContext(context.Background(), query, limit)
+}
+
+func (s *Store) SearchContext(ctx context.Context, query string, limit int) ([]stored.SearchResult, error) {
+
+	start := time.Now()
+	results, err := stored.SearchContext(ctx, s.store, query, limit)
+	s.observe("search", start, err)
+
+	return results, err
+}
+
+func (s *Store) Apply(f stored.ItemHandler) error {
+	return s.ApplyContext(context.Background(), f)
+}
+
+func (s *Store) ApplyContext(ctx context.Context, f stored.ItemHandler) error {
+
+	start := time.Now()
+	err := stored.ApplyContext(ctx, s.store, f)
+	s.observe("apply", start, err)
+
+	return err
+}
+
+func (s *Store) Update(id stored.ID, f stored.UpdateFunc) error {
+	return s.UpdateContext(context.Background(), id, f)
+}
+
+func (s *Store) UpdateContext(ctx context.Context, id stored.ID, f stored.UpdateFunc) error {
+
+	var obj stored.Storable
+
+	start := time.Now()
+	err := stored.UpdateContext(ctx, s.store, id, func(old stored.Storable, exists bool) (stored.Storable, error) {
+		var err error
+		obj, err = f(old, exists)
+		return obj, err
+	})
+	s.observe("update", start, err)
+
+	if err == nil {
+		s.observeSize("update", obj)
+	}
+
+	return err
+}
+
+func (s *Store) Delete(id stored.ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, id stored.ID) error {
+
+	start := time.Now()
+	err := stored.DeleteContext(ctx, s.store, id)
+	s.observe("delete", start, err)
+
+	return err
+}
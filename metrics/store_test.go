@@ -0,0 +1,81 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package metrics // import "kilobit.ca/go/stored/metrics"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/stored/storetest"
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "testing"
+
+func TestStore(t *testing.T) {
+
+	reg := NewRegistry()
+	s := NewStore(stored.NewSyncStore(stored.NewMapStore()), reg, "mem",
+		OptPayloadSize(func(obj stored.Storable) int {
+			return len(obj.(string))
+		}))
+
+	s.StoreItem("1", "Hello World!")
+	s.Retrieve("1")
+	s.Retrieve("2")
+	s.List()
+	stored.Update(s, "1", func(old stored.Storable, exists bool) (stored.Storable, error) {
+		return old.(string) + "!", nil
+	})
+	s.Delete("1")
+
+	ops := reg.Counter("stored_operations_total", "")
+	assert.Expect(t, 1.0, ops.Value(Labels{"store": "mem", "op": "store"}))
+	assert.Expect(t, 2.0, ops.Value(Labels{"store": "mem", "op": "retrieve"}))
+	assert.Expect(t, 1.0, ops.Value(Labels{"store": "mem", "op": "delete"}))
+	assert.Expect(t, 1.0, ops.Value(Labels{"store": "mem", "op": "update"}))
+
+	errs := reg.Counter("stored_errors_total", "")
+	assert.Expect(t, 1.0, errs.Value(Labels{"store": "mem", "op": "retrieve", "kind": "not_found"}))
+
+	n, _ := reg.Histogram("stored_operation_duration_seconds", "", nil).Count(
+		Labels{"store": "mem", "op": "list"})
+	assert.Expect(t, uint64(1), n)
+
+	n, sum := reg.Histogram("stored_payload_bytes", "", nil).Count(
+		Labels{"store": "mem", "op": "retrieve"})
+	assert.Expect(t, uint64(1), n)
+	assert.Expect(t, 12.0, sum)
+}
+
+func TestStoreConformance(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+		return NewStore(stored.NewSyncStore(stored.NewMapStore()), NewRegistry(), "mem"), nil
+	})
+}
+
+func TestStoreContext(t *testing.T) {
+
+	as := stored.NewAuthorizedStore(stored.NewMapStore(),
+		stored.NewRulePolicy(stored.AllowAuthenticated()))
+	s := NewStore(as, NewRegistry(), "auth")
+
+	ctx := stored.WithPrincipal(context.Background(), "alice")
+
+	assert.Expect(t, nil, s.StoreItemContext(ctx, "1", "Hello World!"))
+
+	obj, err := s.RetrieveContext(ctx, "1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello World!", obj)
+
+	ids, err := s.ListContext(ctx)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []stored.ID{"1"}, ids)
+
+	n := 0
+	err = s.ApplyContext(ctx, func(stored.ID, stored.Storable) error {
+		n++
+		return nil
+	})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 1, n)
+
+	_, err = s.Retrieve("1")
+	assert.Expect(t, stored.ErrUnauthenticated, err)
+}
@@ -0,0 +1,320 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "fmt"
+import "reflect"
+import "sync"
+
+type MigrateError string
+
+func NewMigrateError(msg string) MigrateError {
+	return (MigrateError)(msg)
+}
+
+func (err MigrateError) Error() string {
+	return (string)(err)
+}
+
+// Transform an item on its way from the source to the destination
+// store.
+//
+// The returned ID and Storable are written to the destination.
+// Returning an empty ID skips the item.
+//
+type MigrateTransform func(ID, Storable) (ID, Storable, error)
+
+// Compare a migrated item in the destination with the expected value.
+type MigrateEqual func(expected, actual Storable) bool
+
+// Receives a snapshot of the migration counters after every item.
+type MigrateProgress func(MigrateStats)
+
+// Counters describing the state of a migration.
+type MigrateStats struct {
+	Total    int // Items listed in the source
+	Resumed  int // Items skipped because of the checkpoint
+	Copied   int // Items written to the destination
+	Planned  int // Items a dry run would have written
+	Skipped  int // Items dropped by the transform
+	Verified int // Items confirmed by the verification pass
+}
+
+type MigrateOpt func(*migration)
+
+type migration struct {
+	workers    int
+	transform  MigrateTransform
+	dryrun     bool
+	progress   MigrateProgress
+	checkpoint Store
+	verify     bool
+	equal      MigrateEqual
+}
+
+// Copy items between stores using the given number of concurrent
+// workers.
+//
+// Note: With more than one worker, both stores must be safe for
+// concurrent use.
+//
+func OptMigrateWorkers(n int) MigrateOpt {
+	return func(m *migration) {
+		if n > 0 {
+			m.workers = n
+		}
+	}
+}
+
+func OptMigrateTransform(f MigrateTransform) MigrateOpt {
+	return func(m *migration) {
+		m.transform = f
+	}
+}
+
+// Read and transform every item without writing to the destination
+// or the checkpoint.
+//
+// Items which would have been written are counted as Planned rather
+// than Copied.
+//
+func OptMigrateDryRun() MigrateOpt {
+	return func(m *migration) {
+		m.dryrun = true
+	}
+}
+
+func OptMigrateProgress(f MigrateProgress) MigrateOpt {
+	return func(m *migration) {
+		m.progress = f
+	}
+}
+
+// Record the IDs of migrated items in cp.
+//
+// Items already present in cp are skipped, allowing an interrupted
+// migration to be resumed by running it again with the same
+// checkpoint store.
+//
+func OptMigrateCheckpoint(cp Store) MigrateOpt {
+	return func(m *migration) {
+		m.checkpoint = cp
+	}
+}
+
+// Compare every migrated item in the destination with the value
+// written once the copy is complete.
+//
+// The written values are kept in memory until then.
+//
+// A nil equal function defaults to reflect.DeepEqual.
+//
+func OptMigrateVerify(equal MigrateEqual) MigrateOpt {
+	return func(m *migration) {
+		m.verify = true
+		m.equal = equal
+		if m.equal == nil {
+			m.equal = func(a, b Storable) bool {
+				return reflect.DeepEqual(a, b)
+			}
+		}
+	}
+}
+
+func identityTransform(id ID, obj Storable) (ID, Storable, error) {
+	return id, obj, nil
+}
+
+// Copy every item in src to dst.
+//
+// The migration stops at the first error, returning the counters
+// accumulated so far.  Use a checkpoint to resume from that point.
+//
+func Migrate(src, dst Store, opts ...MigrateOpt) (MigrateStats, error) {
+
+	m := &migration{
+		workers:   1,
+		transform: identityTransform,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m.run(src, dst)
+}
+
+func (m *migration) run(src, dst Store) (MigrateStats, error) {
+
+	stats := MigrateStats{}
+
+	ids, err := src.List()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Total = len(ids)
+
+	var mu sync.Mutex
+	var firstErr error
+	done := map[ID]migrated{}
+
+	report := func(f func(*MigrateStats)) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		f(&stats)
+		if m.progress != nil {
+			m.progress(stats)
+		}
+	}
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	queue := make(chan ID)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				err := m.migrateItem(src, dst, id, report, &mu, done)
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+
+		if failed {
+			break
+		}
+
+		queue <- id
+	}
+
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return stats, firstErr
+	}
+
+	if m.verify && !m.dryrun {
+		err = m.verifyItems(dst, done, &stats)
+	}
+
+	return stats, err
+}
+
+// An item written to the destination, with its value when it is to
+// be verified.
+type migrated struct {
+	id  ID
+	obj Storable
+}
+
+func (m *migration) migrateItem(src, dst Store, id ID,
+	report func(func(*MigrateStats)),
+	mu *sync.Mutex, done map[ID]migrated) error {
+
+	if m.checkpoint != nil {
+		mu.Lock()
+		_, err := m.checkpoint.Retrieve(id)
+		mu.Unlock()
+
+		if err == nil {
+			report(func(s *MigrateStats) { s.Resumed++ })
+			return nil
+		}
+	}
+
+	obj, err := src.Retrieve(id)
+	if err != nil {
+		return err
+	}
+
+	newid, newobj, err := m.transform(id, obj)
+	if err != nil {
+		return err
+	}
+
+	if newid == "" {
+		report(func(s *MigrateStats) { s.Skipped++ })
+		return nil
+	}
+
+	if m.dryrun {
+		report(func(s *MigrateStats) { s.Planned++ })
+		return nil
+	}
+
+	err = dst.StoreItem(newid, newobj)
+	if err != nil {
+		return err
+	}
+
+	if m.checkpoint != nil {
+		mu.Lock()
+		err = m.checkpoint.StoreItem(id, newid)
+		mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the value written is verified, the transform is not run
+	// again.
+	item := migrated{id: newid}
+	if m.verify {
+		item.obj = newobj
+	}
+
+	mu.Lock()
+	done[id] = item
+	mu.Unlock()
+
+	report(func(s *MigrateStats) { s.Copied++ })
+
+	return nil
+}
+
+// Items resumed from a checkpoint are not verified as their transform
+// output is not known.
+func (m *migration) verifyItems(dst Store, done map[ID]migrated, stats *MigrateStats) error {
+
+	for _, item := range done {
+
+		actual, err := dst.Retrieve(item.id)
+		if err != nil {
+			return NewMigrateError(fmt.Sprintf(
+				"Verification failed for %s, %s", item.id, err.Error()))
+		}
+
+		if !m.equal(item.obj, actual) {
+			return NewMigrateError(fmt.Sprintf(
+				"Verification failed for %s, the items differ.", item.id))
+		}
+
+		stats.Verified++
+		if m.progress != nil {
+			m.progress(*stats)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "strings"
+import "sync"
+
+func TestMigrateTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func newTestSrc() MapStore {
+	src := NewMapStore()
+	src.StoreItem("1", "one")
+	src.StoreItem("2", "two")
+	src.StoreItem("3", "three")
+	return src
+}
+
+func TestMigrate(t *testing.T) {
+
+	src := newTestSrc()
+	dst := NewMapStore()
+
+	calls := 0
+	stats, err := Migrate(src, dst,
+		OptMigrateVerify(nil),
+		OptMigrateProgress(func(MigrateStats) { calls++ }),
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, MigrateStats{Total: 3, Copied: 3, Verified: 3}, stats)
+	assert.Expect(t, 6, calls)
+	assert.Expect(t, src, dst)
+}
+
+func TestMigrateTransform(t *testing.T) {
+
+	src := newTestSrc()
+	dst := NewMapStore()
+
+	stats, err := Migrate(src, NewSyncStore(dst),
+		OptMigrateWorkers(2),
+		OptMigrateTransform(func(id ID, obj Storable) (ID, Storable, error) {
+			if id == "2" {
+				return "", nil, nil
+			}
+			return "x" + id, strings.ToUpper(obj.(string)), nil
+		}),
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, MigrateStats{Total: 3, Copied: 2, Skipped: 1}, stats)
+	assert.Expect(t, MapStore{"x1": "ONE", "x3": "THREE"}, dst)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+
+	src := newTestSrc()
+	dst := NewMapStore()
+	cp := NewMapStore()
+
+	stats, err := Migrate(src, dst, OptMigrateDryRun(), OptMigrateCheckpoint(cp))
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 0, stats.Copied)
+	assert.Expect(t, 3, stats.Planned)
+	assert.Expect(t, 0, len(dst))
+	assert.Expect(t, 0, len(cp))
+}
+
+func TestMigrateVerifyTransformed(t *testing.T) {
+
+	src := newTestSrc()
+	dst := NewSyncStore(NewMapStore())
+
+	// A transform giving a different value on every call.
+	var mu sync.Mutex
+	n := 0
+	stats, err := Migrate(src, dst,
+		OptMigrateWorkers(2),
+		OptMigrateTransform(func(id ID, obj Storable) (ID, Storable, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			n++
+			return id, n, nil
+		}),
+		OptMigrateVerify(nil),
+	)
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 3, stats.Verified)
+	assert.Expect(t, 3, n)
+}
+
+func TestMigrateResume(t *testing.T) {
+
+	src := newTestSrc()
+	dst := NewMapStore()
+	cp := NewMapStore()
+
+	_, err := Migrate(src, dst,
+		OptMigrateCheckpoint(cp),
+		OptMigrateTransform(func(id ID, obj Storable) (ID, Storable, error) {
+			if id == "3" {
+				return "", nil, NewMigrateError("Interrupted.")
+			}
+			return id, obj, nil
+		}),
+	)
+	if err == nil {
+		t.Error("Expected the migration to fail.")
+	}
+
+	stats, err := Migrate(src, dst, OptMigrateCheckpoint(cp), OptMigrateVerify(nil))
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 3, stats.Resumed+stats.Copied)
+	assert.Expect(t, stats.Copied, stats.Verified)
+	assert.Expect(t, src, dst)
+	assert.Expect(t, 3, len(cp))
+}
+
+func TestMigrateVerifyMismatch(t *testing.T) {
+
+	src := newTestSrc()
+	dst := NewMapStore()
+
+	_, err := Migrate(src, dst,
+		OptMigrateVerify(func(expected, actual Storable) bool {
+			return false
+		}),
+	)
+
+	_, ok := err.(MigrateError)
+	assert.Expect(t, true, ok)
+}
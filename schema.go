@@ -0,0 +1,285 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "strconv"
+import "sync"
+
+type SchemaError string
+
+func NewSchemaError(msg string) SchemaError {
+	return (SchemaError)(msg)
+}
+
+func (err SchemaError) Error() string {
+	return (string)(err)
+}
+
+// A stored value tagged with the version of its schema.
+//
+// Values found in a store without a Versioned wrapper are treated as
+// version 0.
+//
+type Versioned struct {
+	Version int
+	Value   Storable
+}
+
+// Convert a value from one schema version to the next.
+type Upcaster func(Storable) (Storable, error)
+
+// A set of upcasters leading from older schema versions to the
+// current one.
+type SchemaRegistry struct {
+	current   int
+	upcasters map[int]Upcaster
+	mu        sync.RWMutex
+}
+
+func NewSchemaRegistry(current int) *SchemaRegistry {
+	return &SchemaRegistry{
+		current:   current,
+		upcasters: map[int]Upcaster{},
+	}
+}
+
+func (r *SchemaRegistry) Current() int {
+	return r.current
+}
+
+// Register an upcaster converting values from version from to version
+// from+1.
+func (r *SchemaRegistry) Register(from int, f Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.upcasters[from] = f
+}
+
+// Apply upcasters in sequence until the value reaches the current
+// version.
+func (r *SchemaRegistry) Upcast(v Versioned) (Versioned, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if v.Version > r.current {
+		return v, NewSchemaError("Schema version " + strconv.Itoa(v.Version) +
+			" is newer than the current version " + strconv.Itoa(r.current) + ".")
+	}
+
+	for v.Version < r.current {
+		f, ok := r.upcasters[v.Version]
+		if !ok {
+			return v, NewSchemaError("No upcaster registered for schema version " +
+				strconv.Itoa(v.Version) + ".")
+		}
+
+		obj, err := f(v.Value)
+		if err != nil {
+			return v, err
+		}
+
+		v = Versioned{v.Version + 1, obj}
+	}
+
+	return v, nil
+}
+
+func asVersioned(obj Storable) Versioned {
+	switch v := obj.(type) {
+	case Versioned:
+		return v
+	case *Versioned:
+		return *v
+	}
+
+	return Versioned{0, obj}
+}
+
+type SchemaStoreOpt func(*SchemaStore)
+
+// Write upcasted values back to the underlying store when they are
+// read.
+func OptSchemaRewrite() SchemaStoreOpt {
+	return func(s *SchemaStore) {
+		s.rewrite = true
+	}
+}
+
+// A store wrapper which tags stored values with the current schema
+// version and upcasts older values as they are read.
+//
+// The wrapped store holds Versioned values, its codecs must be able
+// to encode them.
+//
+type SchemaStore struct {
+	store   Store
+	reg     *SchemaRegistry
+	rewrite bool
+}
+
+func NewSchemaStore(store Store, reg *SchemaRegistry, opts ...SchemaStoreOpt) *SchemaStore {
+
+	s := &SchemaStore{store, reg, false}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *SchemaStore) StoreItem(id ID, obj Storable) error {
+	return s.store.StoreItem(id, Versioned{s.reg.Current(), obj})
+}
+
+func (s *SchemaStore) Retrieve(id ID) (Storable, error) {
+
+	obj, err := s.store.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.upcast(id, obj)
+}
+
+func (s *SchemaStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *SchemaStore) Search(query string, limit int) ([]SearchResult, error) {
+	return Search(s.store, query, limit)
+}
+
+// Apply f to the upcast items.
+//
+// With OptSchemaRewrite the upcast items are written back once the
+// underlying Apply returns, as stores may not be written from within
+// their own Apply.
+//
+func (s *SchemaStore) Apply(f ItemHandler) error {
+
+	ids := []ID{}
+	upcast := []Versioned{}
+
+	err := s.store.Apply(func(id ID, obj Storable) error {
+
+		v, changed, err := s.upcastVersioned(obj)
+		if err != nil {
+			return err
+		}
+
+		if s.rewrite && changed {
+			ids = append(ids, id)
+			upcast = append(upcast, v)
+		}
+
+		return f(id, v.Value)
+	})
+
+	for i, id := range ids {
+		werr := s.store.StoreItem(id, upcast[i])
+		if err == nil {
+			err = werr
+		}
+	}
+
+	return err
+}
+
+func (s *SchemaStore) Delete(id ID) error {
+	return s.store.Delete(id)
+}
+
+// Update the upcast item, storing the new value at the current
+// version.
+func (s *SchemaStore) Update(id ID, f UpdateFunc) error {
+	return Update(s.store, id, func(old Storable, exists bool) (Storable, error) {
+
+		if exists {
+			v, _, err := s.upcastVersioned(old)
+			if err != nil {
+				return nil, err
+			}
+
+			old = v.Value
+		}
+
+		obj, err := f(old, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		return Versioned{s.reg.Current(), obj}, nil
+	})
+}
+
+func (s *SchemaStore) upcast(id ID, obj Storable) (Storable, error) {
+
+	v, changed, err := s.upcastVersioned(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.rewrite && changed {
+		err = s.store.StoreItem(id, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v.Value, nil
+}
+
+// Upcast a stored value, reporting whether its version changed.
+func (s *SchemaStore) upcastVersioned(obj Storable) (Versioned, bool, error) {
+
+	v := asVersioned(obj)
+	old := v.Version
+
+	v, err := s.reg.Upcast(v)
+	if err != nil {
+		return Versioned{}, false, err
+	}
+
+	return v, v.Version != old, nil
+}
+
+// Rewrite every outdated item in the store at the current schema
+// version, returning the number of items upgraded.
+func (s *SchemaStore) Upgrade() (int, error) {
+
+	ids, err := s.store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+
+		obj, err := s.store.Retrieve(id)
+		if err != nil {
+			return n, err
+		}
+
+		v := asVersioned(obj)
+		if v.Version == s.reg.Current() {
+			continue
+		}
+
+		v, err = s.reg.Upcast(v)
+		if err != nil {
+			return n, err
+		}
+
+		err = s.store.StoreItem(id, v)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
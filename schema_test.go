@@ -0,0 +1,149 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+
+func TestSchemaTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type personV2 struct {
+	First string
+	Last  string
+}
+
+func newTestRegistry() *SchemaRegistry {
+
+	reg := NewSchemaRegistry(2)
+
+	// v0 -> v1, a bare name becomes a single element slice.
+	reg.Register(0, func(obj Storable) (Storable, error) {
+		return []string{obj.(string)}, nil
+	})
+
+	// v1 -> v2, names are split into first and last.
+	reg.Register(1, func(obj Storable) (Storable, error) {
+		names := obj.([]string)
+		p := personV2{First: names[0]}
+		if len(names) > 1 {
+			p.Last = names[1]
+		}
+		return p, nil
+	})
+
+	return reg
+}
+
+func TestSchemaStoreRetrieve(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("1", "Alice")
+	ms.StoreItem("2", Versioned{1, []string{"Bob", "Smith"}})
+
+	ss := NewSchemaStore(ms, newTestRegistry())
+	ss.StoreItem("3", personV2{"Carol", "Jones"})
+
+	obj, err := ss.Retrieve("1")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, personV2{First: "Alice"}, obj)
+
+	obj, err = ss.Retrieve("2")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, personV2{"Bob", "Smith"}, obj)
+
+	obj, err = ss.Retrieve("3")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, personV2{"Carol", "Jones"}, obj)
+
+	// Without the rewrite option the stored values are untouched.
+	assert.Expect(t, "Alice", ms["1"])
+
+	n := 0
+	err = ss.Apply(func(id ID, obj Storable) error {
+		_, ok := obj.(personV2)
+		assert.Expect(t, true, ok)
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, 3, n)
+}
+
+func TestSchemaStoreRewrite(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("1", "Alice")
+
+	ss := NewSchemaStore(ms, newTestRegistry(), OptSchemaRewrite())
+
+	_, err := ss.Retrieve("1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, Versioned{2, personV2{First: "Alice"}}, ms["1"])
+}
+
+func TestSchemaStoreRewriteApply(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("1", "Alice")
+	ms.StoreItem("2", Versioned{1, []string{"Bob", "Smith"}})
+	ms.StoreItem("3", Versioned{2, personV2{"Carol", "Jones"}})
+
+	// Rewriting from within Apply would deadlock the SyncStore.
+	ss := NewSchemaStore(NewSyncStore(ms), newTestRegistry(), OptSchemaRewrite())
+
+	n := 0
+	err := ss.Apply(func(id ID, obj Storable) error {
+		n++
+		return nil
+	})
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 3, n)
+	assert.Expect(t, Versioned{2, personV2{First: "Alice"}}, ms["1"])
+	assert.Expect(t, Versioned{2, personV2{"Bob", "Smith"}}, ms["2"])
+}
+
+func TestSchemaStoreUpgrade(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("1", "Alice")
+	ms.StoreItem("2", Versioned{2, personV2{"Bob", "Smith"}})
+
+	ss := NewSchemaStore(ms, newTestRegistry())
+
+	n, err := ss.Upgrade()
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 1, n)
+	assert.Expect(t, Versioned{2, personV2{First: "Alice"}}, ms["1"])
+}
+
+func TestSchemaMissingUpcaster(t *testing.T) {
+
+	reg := NewSchemaRegistry(1)
+	ss := NewSchemaStore(MapStore{"1": "Alice"}, reg)
+
+	_, err := ss.Retrieve("1")
+	_, ok := err.(SchemaError)
+	assert.Expect(t, true, ok)
+
+	ss = NewSchemaStore(MapStore{"1": Versioned{3, "Alice"}}, reg)
+	_, err = ss.Retrieve("1")
+	_, ok = err.(SchemaError)
+	assert.Expect(t, true, ok)
+}
@@ -0,0 +1,337 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "math"
+import "sort"
+import "strings"
+import "sync"
+import "unicode"
+
+// Turn text into the terms to be indexed or searched for.
+type Analyzer func(text string) []string
+
+// Split text into lower case words of letters and digits.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Common English words left out of the index.
+var StopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true,
+	"at": true, "be": true, "but": true, "by": true, "for": true,
+	"if": true, "in": true, "into": true, "is": true, "it": true,
+	"no": true, "not": true, "of": true, "on": true, "or": true,
+	"such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+func isVowel(b byte) bool {
+	return strings.IndexByte("aeiouy", b) >= 0
+}
+
+func hasVowel(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if isVowel(s[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reduce an English word to its stem.
+//
+// This is a light stemmer removing plurals and the common -ed, -ing
+// and -ly inflections, so that store, stores, stored and storing
+// share a stem.  Stems are not always words.  Use an Analyzer with a
+// full stemmer, such as Porter or Snowball, for better recall.
+//
+func Stem(word string) string {
+
+	if len(word) <= 3 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") &&
+		!strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+
+	for _, suffix := range []string{"ing", "ed", "ly"} {
+		stem := strings.TrimSuffix(word, suffix)
+		if stem == word || len(stem) < 3 || !hasVowel(stem) {
+			continue
+		}
+
+		// Undo doubled consonants, as in running or stopped.
+		n := len(stem)
+		if suffix != "ly" && stem[n-1] == stem[n-2] &&
+			!isVowel(stem[n-1]) && strings.IndexByte("lsz", stem[n-1]) < 0 {
+			stem = stem[:n-1]
+		}
+
+		word = stem
+		break
+	}
+
+	if len(word) > 3 && strings.HasSuffix(word, "e") {
+		word = word[:len(word)-1]
+	}
+
+	return word
+}
+
+// Tokenize text, dropping stop words and stemming the rest.
+func DefaultAnalyzer(text string) []string {
+
+	terms := []string{}
+	for _, word := range Tokenize(text) {
+		if !StopWords[word] {
+			terms = append(terms, Stem(word))
+		}
+	}
+
+	return terms
+}
+
+// An item matching a search, scored by relevance.
+type SearchResult struct {
+	ID    ID      `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Stores able to search their items.
+//
+// Results are ordered from the most to the least relevant, a limit of
+// zero or less returns every match.
+//
+type Searcher interface {
+	Search(query string, limit int) ([]SearchResult, error)
+}
+
+// Returned by Search for stores unable to search their items.
+const ErrSearchUnsupported = StoreError("The store does not support search.")
+
+// Searchers accepting a context, see ContextStore.
+type ContextSearcher interface {
+	SearchContext(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// Search a store, returning ErrSearchUnsupported when it is not a
+// Searcher.
+func Search(s Store, query string, limit int) ([]SearchResult, error) {
+
+	if sr, ok := s.(Searcher); ok {
+		return sr.Search(query, limit)
+	}
+
+	return nil, ErrSearchUnsupported
+}
+
+// Search a store using the context aware method when available.
+func SearchContext(ctx context.Context, s Store, query string, limit int) ([]SearchResult, error) {
+	if cs, ok := s.(ContextSearcher); ok {
+		return cs.SearchContext(ctx, query, limit)
+	}
+
+	return Search(s, query, limit)
+}
+
+type IndexOpt func(*Index)
+
+// Analyze documents and queries with a instead of DefaultAnalyzer.
+func OptAnalyzer(a Analyzer) IndexOpt {
+	return func(ix *Index) {
+		ix.analyze = a
+	}
+}
+
+// Set the BM25 term frequency saturation, k1, and length
+// normalization, b.  The defaults are 1.2 and 0.75.
+func OptBM25(k1, b float64) IndexOpt {
+	return func(ix *Index) {
+		ix.k1 = k1
+		ix.b = b
+	}
+}
+
+// An in-memory inverted index ranking documents with BM25.
+//
+// Index is safe for concurrent use.
+//
+type Index struct {
+	analyze  Analyzer
+	k1, b    float64
+	postings map[string]map[ID]int
+	terms    map[ID][]string
+	lengths  map[ID]int
+	total    int
+	mu       sync.RWMutex
+}
+
+func NewIndex(opts ...IndexOpt) *Index {
+
+	ix := &Index{
+		analyze:  DefaultAnalyzer,
+		k1:       1.2,
+		b:        0.75,
+		postings: map[string]map[ID]int{},
+		terms:    map[ID][]string{},
+		lengths:  map[ID]int{},
+	}
+
+	for _, opt := range opts {
+		opt(ix)
+	}
+
+	return ix
+}
+
+// Index the text of a document, replacing any previous text.
+func (ix *Index) Add(id ID, text string) {
+
+	terms := ix.analyze(text)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(id)
+
+	freqs := map[string]int{}
+	for _, term := range terms {
+		freqs[term]++
+	}
+
+	unique := make([]string, 0, len(freqs))
+	for term, n := range freqs {
+		p, ok := ix.postings[term]
+		if !ok {
+			p = map[ID]int{}
+			ix.postings[term] = p
+		}
+
+		p[id] = n
+		unique = append(unique, term)
+	}
+
+	ix.terms[id] = unique
+	ix.lengths[id] = len(terms)
+	ix.total += len(terms)
+}
+
+// Remove a document from the index.
+func (ix *Index) Remove(id ID) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id ID) {
+
+	terms, ok := ix.terms[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range terms {
+		delete(ix.postings[term], id)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+
+	ix.total -= ix.lengths[id]
+	delete(ix.terms, id)
+	delete(ix.lengths, id)
+}
+
+func (ix *Index) clear() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.postings = map[string]map[ID]int{}
+	ix.terms = map[ID][]string{}
+	ix.lengths = map[ID]int{}
+	ix.total = 0
+}
+
+// The number of documents in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.terms)
+}
+
+// Find the documents containing any of the terms in query, ranked by
+// their BM25 scores.
+func (ix *Index) Search(query string, limit int) []SearchResult {
+
+	terms := ix.analyze(query)
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.terms))
+	if n == 0 {
+		return []SearchResult{}
+	}
+
+	avg := float64(ix.total) / n
+	if avg == 0 {
+		avg = 1
+	}
+
+	scores := map[ID]float64{}
+	seen := map[string]bool{}
+
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+
+		seen[term] = true
+
+		p := ix.postings[term]
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for id, tf := range p {
+			f := float64(tf)
+			norm := ix.k1 * (1 - ix.b + ix.b*float64(ix.lengths[id])/avg)
+			scores[id] += idf * f * (ix.k1 + 1) / (f + norm)
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, SearchResult{id, score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+
+		return results[i].ID < results[j].ID
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
@@ -0,0 +1,94 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "strings"
+import "testing"
+
+func TestSearchTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Expect(t, []string{"hello", "wörld", "42", "a", "b"},
+		Tokenize("Hello, Wörld! 42 a-b"))
+	assert.Expect(t, []string{}, Tokenize(" ... "))
+}
+
+func TestStem(t *testing.T) {
+
+	tests := map[string]string{
+		"store":   "stor",
+		"stores":  "stor",
+		"stored":  "stor",
+		"storing": "stor",
+		"running": "run",
+		"stopped": "stop",
+		"falling": "fall",
+		"ponies":  "pony",
+		"classes": "class",
+		"quickly": "quick",
+		"status":  "status",
+		"string":  "string",
+		"go":      "go",
+	}
+
+	for word, exp := range tests {
+		assert.Expect(t, exp, Stem(word))
+	}
+}
+
+func TestDefaultAnalyzer(t *testing.T) {
+	assert.Expect(t, []string{"quick", "fox", "jump"},
+		DefaultAnalyzer("The quick fox is jumping"))
+}
+
+func TestIndexSearch(t *testing.T) {
+
+	ix := NewIndex()
+	ix.Add("1", "The quick brown fox jumps over the lazy dog.")
+	ix.Add("2", "A fox, a fox, a fox in the henhouse.")
+	ix.Add("3", "Dogs sleep all day.")
+	ix.Add("4", strings.Repeat("filler words ", 50)+"fox")
+
+	assert.Expect(t, 4, ix.Len())
+
+	results := ix.Search("foxes", 0)
+	assert.Expect(t, 3, len(results))
+
+	// Repeated terms in short documents rank highest.
+	assert.Expect(t, ID("2"), results[0].ID)
+	assert.Expect(t, ID("1"), results[1].ID)
+	assert.Expect(t, ID("4"), results[2].ID)
+
+	results = ix.Search("lazy dog", 1)
+	assert.Expect(t, 1, len(results))
+	assert.Expect(t, ID("1"), results[0].ID)
+
+	assert.Expect(t, 0, len(ix.Search("the", 0)))
+	assert.Expect(t, 0, len(ix.Search("cat", 0)))
+
+	ix.Remove("2")
+	ix.Add("1", "No canines here.")
+
+	results = ix.Search("fox", 0)
+	assert.Expect(t, 1, len(results))
+	assert.Expect(t, ID("4"), results[0].ID)
+
+	ix.Remove("missing")
+	assert.Expect(t, 3, ix.Len())
+}
+
+func TestIndexAnalyzer(t *testing.T) {
+
+	ix := NewIndex(OptAnalyzer(Tokenize), OptBM25(2, 0))
+	ix.Add("1", "The stores")
+	ix.Add("2", "The store")
+
+	results := ix.Search("the", 0)
+	assert.Expect(t, 2, len(results))
+
+	results = ix.Search("store", 0)
+	assert.Expect(t, []SearchResult{{"2", results[0].Score}}, results)
+}
@@ -12,6 +12,9 @@ func (err StoreError) Error() string {
 	return (string)(err)
 }
 
+// Returned by stores when an item does not exist.
+const ErrNotFound = StoreError("Store object not found.")
+
 type ID string
 
 type Storable interface{}
@@ -48,12 +51,9 @@ func (s MapStore) StoreItem(id ID, obj Storable) error {
 
 func (s MapStore) Retrieve(id ID) (Storable, error) {
 
-	dst, ok := s[id]
-	if !ok {
-		return nil, NewStoreError("Store object not found.")
-	}
+	dst, _, err := s.RetrieveMeta(id)
 
-	return dst, nil
+	return dst, err
 }
 
 func (s MapStore) List() ([]ID, error) {
@@ -69,6 +69,10 @@ func (s MapStore) List() ([]ID, error) {
 
 func (s MapStore) Apply(f ItemHandler) error {
 	for id, dst := range s {
+		if mi, ok := dst.(metaItem); ok {
+			dst = mi.value
+		}
+
 		err := f(id, dst)
 		if err != nil {
 			return err
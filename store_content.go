@@ -0,0 +1,162 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "hash"
+
+type ContentError string
+
+func (err ContentError) Error() string {
+	return (string)(err)
+}
+
+// Returned by StoreItem when the ID is not the hash of the item.
+const ErrContentMismatch = ContentError("The ID does not match the content hash.")
+
+// Returned by Retrieve when a stored item no longer matches its ID.
+//
+// The same error as ErrCorrupt, so corruption is detected alike
+// whether items are addressed by their hash or checksummed by an
+// IntegrityStore.
+//
+const ErrContentCorrupt = ErrCorrupt
+
+type ContentStoreOpt func(*ContentStore)
+
+// Hash items with the given function instead of SHA-256.
+//
+// Any hash.Hash implementation may be used, for example BLAKE3 from
+// lukechampine.com/blake3 or github.com/zeebo/blake3.
+//
+func OptContentHash(newHash func() hash.Hash) ContentStoreOpt {
+	return func(s *ContentStore) {
+		s.newHash = newHash
+	}
+}
+
+// A content-addressable store for immutable items.
+//
+// Items are stored under the hex encoded hash of their encoding, so
+// identical items are only stored once.  Use Create to add items,
+// StoreItem only accepts an ID matching the content.
+//
+// Note: The encoding must be deterministic for the same item.
+//
+type ContentStore struct {
+	store   Store
+	enc     func(Storable) ([]byte, error)
+	newHash func() hash.Hash
+	gen     IDGenerator
+}
+
+func NewContentStore(store Store, enc func(Storable) ([]byte, error), opts ...ContentStoreOpt) *ContentStore {
+
+	s := &ContentStore{store: store, enc: enc}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.gen = ContentHashIDGen(s.newHash, s.enc)
+
+	return s
+}
+
+// Calculate the ID of an item.
+func (s *ContentStore) Hash(obj Storable) (ID, error) {
+	return s.gen.NextID(obj)
+}
+
+// Store an item under its hash, returning the hash.
+func (s *ContentStore) Create(obj Storable) (ID, error) {
+	return s.CreateContext(context.Background(), obj)
+}
+
+func (s *ContentStore) CreateContext(ctx context.Context, obj Storable) (ID, error) {
+
+	id, err := s.Hash(obj)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = RetrieveContext(ctx, s.store, id)
+	if err == nil {
+		return id, nil
+	}
+
+	if err != ErrNotFound {
+		return "", err
+	}
+
+	return id, StoreItemContext(ctx, s.store, id, obj)
+}
+
+func (s *ContentStore) StoreItem(id ID, obj Storable) error {
+
+	hid, err := s.Hash(obj)
+	if err != nil {
+		return err
+	}
+
+	if hid != id {
+		return ErrContentMismatch
+	}
+
+	_, err = s.Create(obj)
+
+	return err
+}
+
+func (s *ContentStore) Retrieve(id ID) (Storable, error) {
+
+	obj, err := s.store.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.verify(id, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (s *ContentStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *ContentStore) Search(query string, limit int) ([]SearchResult, error) {
+	return Search(s.store, query, limit)
+}
+
+func (s *ContentStore) Apply(f ItemHandler) error {
+	return s.store.Apply(func(id ID, obj Storable) error {
+
+		err := s.verify(id, obj)
+		if err != nil {
+			return err
+		}
+
+		return f(id, obj)
+	})
+}
+
+func (s *ContentStore) Delete(id ID) error {
+	return s.store.Delete(id)
+}
+
+func (s *ContentStore) verify(id ID, obj Storable) error {
+
+	hid, err := s.Hash(obj)
+	if err != nil {
+		return err
+	}
+
+	if hid != id {
+		return ErrContentCorrupt
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "crypto/sha1"
+
+func TestContentStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func stringBytes(obj Storable) ([]byte, error) {
+	str, ok := obj.(string)
+	if !ok {
+		return nil, NewStoreError("Expected the Storable to be a string.")
+	}
+
+	return ([]byte)(str), nil
+}
+
+const helloSHA256 = ID("7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069")
+
+func TestContentStoreCreate(t *testing.T) {
+
+	ms := NewMapStore()
+	cs := NewContentStore(ms, stringBytes)
+
+	id, err := cs.Create("Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, helloSHA256, id)
+
+	id2, err := cs.Create("Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, id, id2)
+	assert.Expect(t, 1, len(ms))
+
+	obj, err := cs.Retrieve(id)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, "Hello World!", obj)
+}
+
+func TestContentStoreStoreItem(t *testing.T) {
+
+	cs := NewContentStore(NewMapStore(), stringBytes)
+
+	err := cs.StoreItem("1", "Hello World!")
+	assert.Expect(t, ErrContentMismatch, err)
+
+	err = cs.StoreItem(helloSHA256, "Hello World!")
+	assert.Expect(t, nil, err)
+}
+
+func TestContentStoreCorrupt(t *testing.T) {
+
+	ms := NewMapStore()
+	cs := NewContentStore(ms, stringBytes)
+
+	id, _ := cs.Create("Hello World!")
+	ms[id] = "Goodbye World!"
+
+	_, err := cs.Retrieve(id)
+	assert.Expect(t, ErrContentCorrupt, err)
+
+	err = cs.Apply(func(ID, Storable) error { return nil })
+	assert.Expect(t, ErrContentCorrupt, err)
+
+	// Content corruption is reported like any other.
+	assert.Expect(t, ErrCorrupt, err)
+}
+
+func TestContentStoreHash(t *testing.T) {
+
+	cs := NewContentStore(NewMapStore(), stringBytes, OptContentHash(sha1.New))
+
+	id, err := cs.Hash("Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, ID("2ef7bde608ce5404e97d5f042f95f89f1c232871"), id)
+}
@@ -4,7 +4,7 @@ package stored // import "kilobit.ca/go/stored"
 
 import "bufio"
 import "bytes"
-import "encoding/json"
+import "context"
 import "io"
 import "io/ioutil"
 import "net/http"
@@ -36,6 +36,27 @@ func OptUseTransport(t http.RoundTripper) HttpStoreOpt {
 	}
 }
 
+// Start a span for every request and propagate it to the server in
+// the W3C traceparent header.
+func OptUseTracer(t Tracer) HttpStoreOpt {
+	return func(h *HttpStore) {
+		h.tracer = t
+	}
+}
+
+// Encode items as media type t with the codecs in r, decoding
+// responses by their Content-Type.
+//
+// The marshaling functions given to NewHttpStore are not used and may
+// be nil.
+//
+func OptUseCodecs(r *CodecRegistry, t string) HttpStoreOpt {
+	return func(h *HttpStore) {
+		h.codecs = r
+		h.mediaType = t
+	}
+}
+
 type HttpStoreReq func(ID) (*http.Request, error)
 type HttpStoreMarshaler func(Storable) (io.ReadCloser, int64, error)
 type HttpStoreUnmarshaler func(io.Reader) (Storable, error)
@@ -53,6 +74,9 @@ type HttpStore struct {
 	marshal      HttpStoreMarshaler     // Object marshaler
 	unmarshal    HttpStoreUnmarshaler   // Object unmarshaler
 	unmarshalIDs HttpStoreIDUnmarshaler // ID list unmarshaler
+	tracer       Tracer                 // Request tracer
+	codecs       *CodecRegistry         // Codecs replacing the marshalers
+	mediaType    string                 // Media type of stored items
 }
 
 func NewHttpStore(sr, rr, lr, dr HttpStoreReq,
@@ -65,6 +89,8 @@ func NewHttpStore(sr, rr, lr, dr HttpStoreReq,
 			Timeout: time.Second * 10,
 		},
 		sr, rr, lr, dr, m, u, ui,
+		NopTracer,
+		nil, "",
 	}
 
 	s.Options(opts...)
@@ -72,6 +98,34 @@ func NewHttpStore(sr, rr, lr, dr HttpStoreReq,
 	return s
 }
 
+// Create a store for the items under base, such as those served by a
+// www.DataServer, encoded as media type t by the codecs in r.
+//
+// A nil registry uses DefaultCodecs and an empty media type JSON.
+//
+func NewCodecHttpStore(base string, r *CodecRegistry, t string, opts ...HttpStoreOpt) *HttpStore {
+
+	if r == nil {
+		r = DefaultCodecs()
+	}
+
+	if t == "" {
+		t = JSONMediaType
+	}
+
+	base = strings.TrimSuffix(base, "/") + "/"
+	hdrs := &http.Header{}
+
+	return NewHttpStore(
+		SimpleStoreReq("PUT", base, AppendIDURLFunc, hdrs),
+		SimpleStoreReq("GET", base, AppendIDURLFunc, hdrs),
+		SimpleStoreReq("GET", base, AppendIDURLFunc, hdrs),
+		SimpleStoreReq("DELETE", base, AppendIDURLFunc, hdrs),
+		nil, nil, nil,
+		append([]HttpStoreOpt{OptUseCodecs(r, t)}, opts...)...,
+	)
+}
+
 func (s *HttpStore) Options(opts ...HttpStoreOpt) {
 	for _, opt := range opts {
 		opt(s)
@@ -79,18 +133,61 @@ func (s *HttpStore) Options(opts ...HttpStoreOpt) {
 }
 
 func (s *HttpStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *HttpStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+
+	ctx, span := s.tracer.Start(ctx, "HttpStore.StoreItem", Attr{"stored.id", (string)(id)})
+	err := s.storeItem(ctx, span, id, obj, nil)
+	span.End(err)
+
+	return err
+}
+
+// Store an item along with the tags and labels of meta.
+//
+// The Content-Type is that of the store request and the timestamps
+// are set by the server.
+//
+func (s *HttpStore) StoreItemWithMeta(id ID, obj Storable, meta Meta) error {
+	return s.StoreItemWithMetaContext(context.Background(), id, obj, meta)
+}
+
+func (s *HttpStore) StoreItemWithMetaContext(ctx context.Context, id ID, obj Storable, meta Meta) error {
+
+	ctx, span := s.tracer.Start(ctx, "HttpStore.StoreItem", Attr{"stored.id", (string)(id)})
+	err := s.storeItem(ctx, span, id, obj, &meta)
+	span.End(err)
+
+	return err
+}
+
+func (s *HttpStore) storeItem(ctx context.Context, span Span, id ID, obj Storable, meta *Meta) error {
 
 	req, err := s.storeRequest(id)
 	if err != nil {
 		return err
 	}
 
-	req.Body, req.ContentLength, err = s.marshal(obj)
+	if meta != nil {
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
 
-	res, err := s.c.Do(req)
+		writeTagsHeader(req.Header, meta.Tags, meta.Labels)
+	}
+
+	err = s.encode(req, obj)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, span, req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusNoContent {
 		return NewHttpStoreError("Failed response from server: " + http.StatusText(res.StatusCode))
@@ -99,58 +196,264 @@ func (s *HttpStore) StoreItem(id ID, obj Storable) error {
 	return nil
 }
 
-func (s *HttpStore) Retrieve(id ID, dst Storable) (Storable, error) {
+func (s *HttpStore) Retrieve(id ID) (Storable, error) {
+	return s.RetrieveContext(context.Background(), id)
+}
+
+func (s *HttpStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+
+	ctx, span := s.tracer.Start(ctx, "HttpStore.Retrieve", Attr{"stored.id", (string)(id)})
+	obj, _, err := s.retrieve(ctx, span, false, id)
+	span.End(err)
+
+	return obj, err
+}
+
+// Retrieve an item along with the metadata sent by the server.
+func (s *HttpStore) RetrieveMeta(id ID) (Storable, Meta, error) {
+	return s.RetrieveMetaContext(context.Background(), id)
+}
+
+func (s *HttpStore) RetrieveMetaContext(ctx context.Context, id ID) (Storable, Meta, error) {
+
+	ctx, span := s.tracer.Start(ctx, "HttpStore.Retrieve", Attr{"stored.id", (string)(id)})
+	obj, meta, err := s.retrieve(ctx, span, false, id)
+	span.End(err)
+
+	return obj, meta, err
+}
+
+// Retrieve the metadata of an item with a HEAD request.
+func (s *HttpStore) Stat(id ID) (Meta, error) {
+
+	ctx, span := s.tracer.Start(context.Background(), "HttpStore.Stat", Attr{"stored.id", (string)(id)})
+	_, meta, err := s.retrieve(ctx, span, true, id)
+	span.End(err)
+
+	return meta, err
+}
+
+func (s *HttpStore) retrieve(ctx context.Context, span Span, head bool, id ID) (Storable, Meta, error) {
 
 	req, err := s.retrRequest(id)
 	if err != nil {
-		return nil, err
+		return nil, Meta{}, err
 	}
 
-	res, err := s.c.Do(req)
+	if head {
+		req.Method = "HEAD"
+	}
+
+	if s.codecs != nil {
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+
+		req.Header.Set("Accept", acceptHeader(s.mediaType, s.codecs.MediaTypes()))
+	}
+
+	res, err := s.do(ctx, span, req)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, Meta{}, ErrNotFound
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, Meta{}, NewHttpStoreError("Failed response from server: " + http.StatusText(res.StatusCode))
+	}
+
+	meta := ReadMetaHeader(res.Header)
+
+	if head {
+		return nil, meta, nil
+	}
+
+	obj, err := s.decode(res)
+
+	return obj, meta, err
+}
+
+func (s *HttpStore) List() ([]ID, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *HttpStore) ListContext(ctx context.Context) ([]ID, error) {
+
+	ctx, span := s.tracer.Start(ctx, "HttpStore.List")
+	ids, err := s.list(ctx, span)
+	span.End(err)
+
+	return ids, err
+}
+
+func (s *HttpStore) list(ctx context.Context, span Span) ([]ID, error) {
+
+	req, err := s.listRequest("")
 	if err != nil {
 		return nil, err
 	}
 
-	dst, err = s.unmarshal(res.Body)
+	if s.codecs != nil {
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+
+		types := s.codecs.ListMediaTypes()
+		if len(types) > 0 {
+			req.Header.Set("Accept", acceptHeader(types[0], types))
+		}
+	}
+
+	res, err := s.do(ctx, span, req)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, NewHttpStoreError("Failed response from server: " + http.StatusText(res.StatusCode))
+	}
 
-	return dst, nil
+	return s.decodeIDs(res)
 }
 
-// TODO: Handle Errors
-func (s *HttpStore) List() []ID {
+// Set the body of a request to the encoded item.
+func (s *HttpStore) encode(req *http.Request, obj Storable) error {
+
+	if s.codecs == nil {
+		var err error
+		req.Body, req.ContentLength, err = s.marshal(obj)
+		return err
+	}
+
+	c, ok := s.codecs.Codec(s.mediaType)
+	if !ok {
+		return ErrUnsupportedMediaType
+	}
+
+	t := s.mediaType
+
+	var bs []byte
+	var err error
+
+	if mc, ok := c.(MediaTypeCodec); ok {
+		t, bs, err = mc.EncodeMediaType(obj)
+	} else {
+		bs, err = c.Encode(obj)
+	}
 
-	req, err := s.listRequest("")
 	if err != nil {
-		return []ID{}
+		return err
 	}
 
-	res, err := s.c.Do(req)
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	req.Header.Set("Content-Type", t)
+	req.Body = ioutil.NopCloser(bytes.NewReader(bs))
+	req.ContentLength = int64(len(bs))
+
+	return nil
+}
+
+// Decode the item in a response, returning ErrCorrupt when the body
+// does not match any digest headers.
+func (s *HttpStore) decode(res *http.Response) (Storable, error) {
+
+	err := verifyBody(res)
 	if err != nil {
-		return []ID{}
+		return nil, err
+	}
+
+	if s.codecs == nil {
+		return s.unmarshal(res.Body)
+	}
+
+	t := res.Header.Get("Content-Type")
+
+	c, ok := s.codecs.Codec(t)
+	if !ok {
+		return nil, ErrUnsupportedMediaType
 	}
 
-	ids, err := s.unmarshalIDs(res.Body)
+	if mc, ok := c.(MediaTypeCodec); ok {
+		bs, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return mc.DecodeMediaType(t, bs)
+	}
+
+	return c.DecodeFrom(res.Body)
+}
+
+// Decode the list of IDs in a response.
+func (s *HttpStore) decodeIDs(res *http.Response) ([]ID, error) {
+
+	err := verifyBody(res)
 	if err != nil {
-		return []ID{}
+		return nil, err
+	}
+
+	if s.codecs == nil {
+		return s.unmarshalIDs(res.Body)
 	}
 
-	return ids
+	c, ok := s.codecs.ListCodec(res.Header.Get("Content-Type"))
+	if !ok {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	return CodecIDUnmarshaler(c)(res.Body)
+}
+
+func (s *HttpStore) Apply(f ItemHandler) error {
+
+	ctx, span := s.tracer.Start(context.Background(), "HttpStore.Apply")
+	err := s.apply(ctx, f)
+	span.End(err)
+
+	return err
 }
 
-func (s *HttpStore) Apply(f ItemHandler, dst Storable) error {
+// Apply f while retrieving items with concurrent requests, see
+// ParallelApplier.
+func (s *HttpStore) ApplyParallel(f ItemHandler, workers int, opts ...ApplyOpt) error {
+
+	cfg := applyConfig{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ctx, span := s.tracer.Start(cfg.ctx, "HttpStore.ApplyParallel")
+	err := applyFetched(s.ListContext, s.RetrieveContext, f, workers,
+		append(append([]ApplyOpt{}, opts...), OptApplyContext(ctx))...)
+	span.End(err)
+
+	return err
+}
+
+func (s *HttpStore) apply(ctx context.Context, f ItemHandler) error {
+
+	ids, err := s.ListContext(ctx)
+	if err != nil {
+		return err
+	}
 
-	ids := s.List()
 	for _, id := range ids {
 
-		dst, err := s.Retrieve(id, dst)
+		obj, err := s.RetrieveContext(ctx, id)
 		if err != nil {
 			return err
 		}
 
-		err = f(id, dst)
+		err = f(id, obj)
 		if err != nil {
 			return err
 		}
@@ -159,17 +462,88 @@ func (s *HttpStore) Apply(f ItemHandler, dst Storable) error {
 	return nil
 }
 
-// TODO: Handle errors
-func (s *HttpStore) Delete(id ID) {
-	req, _ := s.delRequest(id)
-	s.c.Do(req)
+func (s *HttpStore) Delete(id ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *HttpStore) DeleteContext(ctx context.Context, id ID) error {
+
+	ctx, span := s.tracer.Start(ctx, "HttpStore.Delete", Attr{"stored.id", (string)(id)})
+	err := s.delete(ctx, span, id)
+	span.End(err)
+
+	return err
+}
+
+func (s *HttpStore) delete(ctx context.Context, span Span, id ID) error {
+
+	req, err := s.delRequest(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, span, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	}
+
+	return NewHttpStoreError("Failed response from server: " + http.StatusText(res.StatusCode))
+}
+
+// Send a request within the span, propagating the trace to the
+// server.
+func (s *HttpStore) do(ctx context.Context, span Span, req *http.Request) (*http.Response, error) {
+
+	req = req.WithContext(ctx)
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+
+	InjectTraceparent(span, req.Header)
+
+	span.SetAttributes(
+		Attr{"http.method", req.Method},
+		Attr{"http.url", req.URL.String()},
+	)
+
+	res, err := s.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(Attr{"http.status_code", res.StatusCode})
+
+	return res, nil
 }
 
 type URLFunc func(base string, id ID) (*url.URL, error)
 
 func AppendIDURLFunc(base string, id ID) (*url.URL, error) {
 
-	return url.Parse(base + "/" + (string)(id))
+	return url.Parse(base + "/" + EscapeID(id))
+}
+
+// Escape an ID for use as a single URL path segment.
+//
+// Dot segments are escaped as well so that they are not removed when
+// the path is cleaned.
+//
+func EscapeID(id ID) string {
+
+	switch id {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+
+	return url.PathEscape((string)(id))
 }
 
 func SimpleStoreReq(method, base string, urlf URLFunc, hdrs *http.Header) HttpStoreReq {
@@ -183,7 +557,7 @@ func SimpleStoreReq(method, base string, urlf URLFunc, hdrs *http.Header) HttpSt
 		return &http.Request{
 			Method: method,
 			URL:    url,
-			Header: *hdrs,
+			Header: hdrs.Clone(),
 		}, nil
 	}
 }
@@ -208,12 +582,15 @@ func StringUnmarshaler(r io.Reader) (Storable, error) {
 
 func StringIDUnmarshaler(sep string) HttpStoreIDUnmarshaler {
 	return func(r io.Reader) ([]ID, error) {
-		bs := []byte{}
-		_, err := r.Read(bs)
+		bs, err := ioutil.ReadAll(r)
 		if err != nil {
 			return nil, err
 		}
 
+		if len(bs) == 0 {
+			return []ID{}, nil
+		}
+
 		idstrs := strings.Split((string)(bs), sep)
 		ids := make([]ID, len(idstrs))
 		for i := range idstrs {
@@ -224,15 +601,13 @@ func StringIDUnmarshaler(sep string) HttpStoreIDUnmarshaler {
 	}
 }
 
-func JSONMarshaler(obj Storable) (io.Reader, error) {
-	bs, err := json.Marshal(obj)
-	return bytes.NewReader(bs), err
+// Marshal items as JSON.
+func JSONMarshaler(obj Storable) (io.ReadCloser, int64, error) {
+	return CodecMarshaler(NewJSONCodec())(obj)
 }
 
-func JSONUnmarshaler(obj Storable) HttpStoreUnmarshaler {
-	return func(r io.Reader) (Storable, error) {
-		//		dec := json.NewDecoder(r)
-		return nil, nil
-
-	}
+// Unmarshal JSON items into new values of the same type as proto, or
+// into the generic encoding/json types when proto is nil.
+func JSONUnmarshaler(proto Storable) HttpStoreUnmarshaler {
+	return NewJSONCodec(OptJSONPrototype(proto)).DecodeFrom
 }
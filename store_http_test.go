@@ -44,6 +44,23 @@ func (mt *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}, nil
 }
 
+func TestEscapeID(t *testing.T) {
+
+	tests := map[ID]string{
+		"1":          "1",
+		"with space": "with%20space",
+		"a+b":        "a+b",
+		"a/b":        "a%2Fb",
+		"?#":         "%3F%23",
+		".":          "%2E",
+		"..":         "%2E%2E",
+	}
+
+	for id, exp := range tests {
+		assert.Expect(t, exp, EscapeID(id))
+	}
+}
+
 func TestNewHttpStore(t *testing.T) {
 
 	hdrs := &http.Header{}
@@ -62,8 +79,10 @@ func TestNewHttpStore(t *testing.T) {
 		t.Error(err)
 	}
 
-	var str string
-	obj, err := hs.Retrieve("1", str)
+	obj, err := hs.Retrieve("1")
+	if err != nil {
+		t.Error(err)
+	}
 
 	assert.Expect(t, "Hello World!", obj)
 }
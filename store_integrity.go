@@ -0,0 +1,254 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bytes"
+import "context"
+import "sort"
+
+// An encoded item with its checksum, as kept by an IntegrityStore.
+//
+// Stores under an IntegrityStore hold Checksummed values, so file and
+// remote stores need an encoding for them, such as
+// NewCBORCodec(Checksummed{}).
+//
+type Checksummed struct {
+	Checksum string `json:"checksum"`
+	Sum      []byte `json:"sum"`
+	Data     []byte `json:"data"`
+}
+
+type IntegrityOpt func(*IntegrityStore)
+
+// Checksum new items with c instead of CRC32C.
+//
+// Items are verified with the algorithm they were stored with, so
+// existing items stay readable.
+//
+func OptChecksum(c Checksum) IntegrityOpt {
+	return func(s *IntegrityStore) {
+		s.sum = c
+	}
+}
+
+// Detects silent corruption of items in the underlying store.
+//
+// Items are encoded and stored with a checksum of their encoding,
+// which is verified when they are retrieved.  Retrieve and Apply
+// return ErrCorrupt for items which no longer match, and
+// ErrNotChecksummed for items stored without a checksum, which
+// ChecksumLegacy upgrades.
+//
+type IntegrityStore struct {
+	store Store
+	enc   func(Storable) ([]byte, error)
+	dec   func([]byte) (Storable, error)
+	sum   Checksum
+}
+
+func NewIntegrityStore(store Store, enc func(Storable) ([]byte, error), dec func([]byte) (Storable, error), opts ...IntegrityOpt) *IntegrityStore {
+
+	s := &IntegrityStore{store, enc, dec, CRC32C}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *IntegrityStore) checksum(obj Storable) (Checksummed, error) {
+
+	bs, err := s.enc(obj)
+	if err != nil {
+		return Checksummed{}, err
+	}
+
+	return Checksummed{s.sum.Name, s.sum.Sum(bs), bs}, nil
+}
+
+// The verified encoding of a stored item.
+func (s *IntegrityStore) verify(obj Storable) ([]byte, error) {
+
+	var c Checksummed
+
+	switch v := obj.(type) {
+	case Checksummed:
+		c = v
+	case *Checksummed:
+		c = *v
+	default:
+		return nil, ErrNotChecksummed
+	}
+
+	alg := s.sum
+	if c.Checksum != alg.Name {
+		var ok bool
+		alg, ok = ChecksumByName(c.Checksum)
+		if !ok {
+			return nil, ErrUnknownChecksum
+		}
+	}
+
+	if !bytes.Equal(c.Sum, alg.Sum(c.Data)) {
+		return nil, ErrCorrupt
+	}
+
+	return c.Data, nil
+}
+
+func (s *IntegrityStore) decode(obj Storable) (Storable, error) {
+
+	bs, err := s.verify(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.dec(bs)
+}
+
+func (s *IntegrityStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *IntegrityStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+
+	c, err := s.checksum(obj)
+	if err != nil {
+		return err
+	}
+
+	return StoreItemContext(ctx, s.store, id, c)
+}
+
+func (s *IntegrityStore) Retrieve(id ID) (Storable, error) {
+	return s.RetrieveContext(context.Background(), id)
+}
+
+func (s *IntegrityStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decode(obj)
+}
+
+func (s *IntegrityStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *IntegrityStore) ListContext(ctx context.Context) ([]ID, error) {
+	return ListContext(ctx, s.store)
+}
+
+func (s *IntegrityStore) Apply(f ItemHandler) error {
+	return s.store.Apply(func(id ID, obj Storable) error {
+
+		item, err := s.decode(obj)
+		if err != nil {
+			return err
+		}
+
+		return f(id, item)
+	})
+}
+
+func (s *IntegrityStore) Delete(id ID) error {
+	return s.store.Delete(id)
+}
+
+func (s *IntegrityStore) DeleteContext(ctx context.Context, id ID) error {
+	return DeleteContext(ctx, s.store, id)
+}
+
+// Verify every item, returning the IDs of those which are corrupt or
+// checksummed with an unknown algorithm, in order.
+//
+// Unlike Apply, Scrub carries on past corrupt items and does not
+// decode them.  Items without a checksum are not corrupt and are
+// skipped, see ChecksumLegacy.
+//
+func (s *IntegrityStore) Scrub() ([]ID, error) {
+
+	corrupt := []ID{}
+
+	err := s.store.Apply(func(id ID, obj Storable) error {
+		_, err := s.verify(obj)
+		if err != nil && err != ErrNotChecksummed {
+			corrupt = append(corrupt, id)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sortIDs(corrupt)
+
+	return corrupt, nil
+}
+
+// Store the items written without a checksum, such as those present
+// before the IntegrityStore was introduced, with their checksum,
+// returning their IDs in order.
+//
+// Note: Items written to the underlying store while ChecksumLegacy
+// runs may be lost, run it while the store is not being written.
+//
+func (s *IntegrityStore) ChecksumLegacy() ([]ID, error) {
+
+	legacy := []ID{}
+
+	err := s.store.Apply(func(id ID, obj Storable) error {
+		if _, err := s.verify(obj); err == ErrNotChecksummed {
+			legacy = append(legacy, id)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sortIDs(legacy)
+
+	for _, id := range legacy {
+
+		obj, err := s.store.Retrieve(id)
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip items checksummed since they were listed.
+		if _, err := s.verify(obj); err != ErrNotChecksummed {
+			continue
+		}
+
+		c, err := s.checksum(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.store.StoreItem(id, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return legacy, nil
+}
+
+func sortIDs(ids []ID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+}
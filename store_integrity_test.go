@@ -0,0 +1,124 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+
+func TestIntegrityStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func newTestIntegrityStore(store Store, opts ...IntegrityOpt) *IntegrityStore {
+	c := NewJSONCodec(OptJSONPrototype(order{}))
+	return NewIntegrityStore(store, c.Encode, c.Decode, opts...)
+}
+
+func TestIntegrityStore(t *testing.T) {
+
+	ms := NewMapStore()
+	s := newTestIntegrityStore(ms)
+
+	assert.Expect(t, nil, s.StoreItem("1", order{"o-1", 9.5}))
+	assert.Expect(t, nil, s.StoreItem("2", order{"o-2", 1}))
+
+	obj, err := s.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 9.5}, obj)
+
+	raw, _ := ms.Retrieve("1")
+	assert.Expect(t, Checksummed{"crc32c", CRC32C.Sum(raw.(Checksummed).Data),
+		[]byte(`{"id":"o-1","total":9.5}`)}, raw)
+
+	ids, err := s.Scrub()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{}, ids)
+
+	// Flip a bit behind the store's back.
+	c := raw.(Checksummed)
+	c.Data = append([]byte{}, c.Data...)
+	c.Data[7] ^= 0x01
+	ms.StoreItem("1", c)
+
+	_, err = s.Retrieve("1")
+	assert.Expect(t, ErrCorrupt, err)
+
+	err = s.Apply(func(ID, Storable) error { return nil })
+	assert.Expect(t, ErrCorrupt, err)
+
+	ms.StoreItem("3", "not checksummed")
+	ms.StoreItem("4", &Checksummed{"unknown", nil, nil})
+
+	ids, err = s.Scrub()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"1", "4"}, ids)
+
+	_, err = s.Retrieve("3")
+	assert.Expect(t, ErrNotChecksummed, err)
+
+	_, err = s.Retrieve("4")
+	assert.Expect(t, ErrUnknownChecksum, err)
+
+	ids, _ = s.List()
+	assert.Expect(t, 4, len(ids))
+
+	assert.Expect(t, nil, s.Delete("1"))
+	_, err = s.Retrieve("1")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestIntegrityStoreChecksum(t *testing.T) {
+
+	ms := NewMapStore()
+
+	assert.Expect(t, nil, newTestIntegrityStore(ms).StoreItem("1", order{"o-1", 1}))
+
+	s := newTestIntegrityStore(ms, OptChecksum(SHA256))
+	assert.Expect(t, nil, s.StoreItem("2", order{"o-2", 2}))
+
+	raw, _ := ms.Retrieve("2")
+	assert.Expect(t, "sha-256", raw.(Checksummed).Checksum)
+	assert.Expect(t, 32, len(raw.(Checksummed).Sum))
+
+	// Items stored with CRC32C are still verified.
+	obj, err := s.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 1}, obj)
+
+	seen := map[ID]Storable{}
+	err = s.Apply(func(id ID, obj Storable) error {
+		seen[id] = obj
+		return nil
+	})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, map[ID]Storable{"1": order{"o-1", 1}, "2": order{"o-2", 2}}, seen)
+
+	_, err = NewIntegrityStore(ms, func(Storable) ([]byte, error) {
+		return nil, ErrUnsupportedType
+	}, nil).Retrieve("missing")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestIntegrityStoreLegacy(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("1", order{"o-1", 1})
+
+	s := newTestIntegrityStore(ms)
+	assert.Expect(t, nil, s.StoreItem("2", order{"o-2", 2}))
+
+	_, err := s.Retrieve("1")
+	assert.Expect(t, ErrNotChecksummed, err)
+
+	ids, err := s.ChecksumLegacy()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{"1"}, ids)
+
+	obj, err := s.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 1}, obj)
+
+	ids, err = s.ChecksumLegacy()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []ID{}, ids)
+}
@@ -0,0 +1,632 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "io"
+import "sort"
+import "strings"
+import "sync"
+
+type TenantError string
+
+func (err TenantError) Error() string {
+	return (string)(err)
+}
+
+// Returned when a request can not be attributed to a tenant.
+const ErrNoTenant = TenantError("No tenant was given.")
+
+// Returned for tenant names that are empty or contain NamespaceSep.
+const ErrInvalidTenant = TenantError("Invalid tenant name.")
+
+// Returned for tenants rejected by OptTenantCheck.
+const ErrUnknownTenant = TenantError("The tenant is not known.")
+
+// Returned when a write would take a tenant over its quota.
+const ErrQuotaExceeded = TenantError("The tenant quota has been exceeded.")
+
+// Separates the tenant from the item ID in the underlying store.
+const NamespaceSep = "/"
+
+// Limits on the contents of a namespace, zero values are unlimited.
+type Quota struct {
+	MaxItems int
+	MaxBytes int64
+}
+
+// The contents of a namespace as counted against its Quota.
+type Usage struct {
+	Items int
+	Bytes int64
+}
+
+type NamespaceOpt func(*NamespacedStore)
+
+// Limit the number of items and bytes held by the namespace.
+func OptQuota(q Quota) NamespaceOpt {
+	return func(s *NamespacedStore) {
+		s.quota = q
+	}
+}
+
+// Measure items for byte quotas.
+//
+// By default strings and byte slices count their length and every
+// other item counts as zero bytes.
+//
+func OptNamespaceSize(size func(Storable) int) NamespaceOpt {
+	return func(s *NamespacedStore) {
+		s.size = size
+	}
+}
+
+// A store wrapper scoping every operation to a tenant.
+//
+// Items are kept in the underlying store under the tenant name and
+// NamespaceSep, List and Apply only see the items of the tenant and
+// return them without the prefix.
+//
+// Usage is counted from the underlying store on the first write and
+// tracked from then on, so all writes for a tenant should go through
+// the same NamespacedStore, see Tenants.
+//
+type NamespacedStore struct {
+	store  Store
+	tenant string
+	prefix ID
+	quota  Quota
+	size   func(Storable) int
+
+	mu    sync.Mutex
+	usage *Usage
+}
+
+func NewNamespacedStore(store Store, tenant string, opts ...NamespaceOpt) (*NamespacedStore, error) {
+
+	if tenant == "" || strings.Contains(tenant, NamespaceSep) {
+		return nil, ErrInvalidTenant
+	}
+
+	s := &NamespacedStore{
+		store:  store,
+		tenant: tenant,
+		prefix: (ID)(tenant + NamespaceSep),
+		size:   sizeOf,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+func sizeOf(obj Storable) int {
+	switch v := obj.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+
+	return 0
+}
+
+func (s *NamespacedStore) Tenant() string {
+	return s.tenant
+}
+
+func (s *NamespacedStore) Quota() Quota {
+	return s.quota
+}
+
+// Count the items and bytes held by the tenant.
+func (s *NamespacedStore) Usage() (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Usage is only tracked by writes when there is a quota.
+	if !s.limited() {
+		s.usage = nil
+	}
+
+	err := s.loadUsage()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return *s.usage, nil
+}
+
+func (s *NamespacedStore) loadUsage() error {
+
+	if s.usage != nil {
+		return nil
+	}
+
+	u := Usage{}
+	err := s.Apply(func(id ID, obj Storable) error {
+		u.Items++
+		u.Bytes += int64(s.size(obj))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.usage = &u
+
+	return nil
+}
+
+func (s *NamespacedStore) limited() bool {
+	return s.quota.MaxItems > 0 || s.quota.MaxBytes > 0
+}
+
+func (s *NamespacedStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *NamespacedStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+
+	if !s.limited() {
+		return StoreItemContext(ctx, s.store, s.prefix+id, obj)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.loadUsage()
+	if err != nil {
+		return err
+	}
+
+	u := *s.usage
+
+	old, err := RetrieveContext(ctx, s.store, s.prefix+id)
+	switch err {
+	case nil:
+		u.Bytes -= int64(s.size(old))
+	case ErrNotFound:
+		u.Items++
+	default:
+		return err
+	}
+
+	u.Bytes += int64(s.size(obj))
+
+	if (s.quota.MaxItems > 0 && u.Items > s.quota.MaxItems) ||
+		(s.quota.MaxBytes > 0 && u.Bytes > s.quota.MaxBytes) {
+		return ErrQuotaExceeded
+	}
+
+	err = StoreItemContext(ctx, s.store, s.prefix+id, obj)
+	if err != nil {
+		return err
+	}
+
+	*s.usage = u
+
+	return nil
+}
+
+func (s *NamespacedStore) Update(id ID, f UpdateFunc) error {
+	return s.UpdateContext(context.Background(), id, f)
+}
+
+// Update an item of the tenant, failing with ErrQuotaExceeded when
+// the new value would take the tenant over its quota.
+func (s *NamespacedStore) UpdateContext(ctx context.Context, id ID, f UpdateFunc) error {
+
+	if !s.limited() {
+		return UpdateContext(ctx, s.store, s.prefix+id, f)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.loadUsage()
+	if err != nil {
+		return err
+	}
+
+	var u Usage
+
+	err = UpdateContext(ctx, s.store, s.prefix+id, func(old Storable, exists bool) (Storable, error) {
+
+		u = *s.usage
+		if exists {
+			u.Bytes -= int64(s.size(old))
+		} else {
+			u.Items++
+		}
+
+		obj, err := f(old, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		u.Bytes += int64(s.size(obj))
+
+		if (s.quota.MaxItems > 0 && u.Items > s.quota.MaxItems) ||
+			(s.quota.MaxBytes > 0 && u.Bytes > s.quota.MaxBytes) {
+			return nil, ErrQuotaExceeded
+		}
+
+		return obj, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	*s.usage = u
+
+	return nil
+}
+
+func (s *NamespacedStore) Retrieve(id ID) (Storable, error) {
+	return s.store.Retrieve(s.prefix + id)
+}
+
+func (s *NamespacedStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+	return RetrieveContext(ctx, s.store, s.prefix+id)
+}
+
+func (s *NamespacedStore) List() ([]ID, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *NamespacedStore) ListContext(ctx context.Context) ([]ID, error) {
+
+	ids, err := ListContext(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ID{}
+	for _, id := range ids {
+		if strings.HasPrefix((string)(id), (string)(s.prefix)) {
+			result = append(result, id[len(s.prefix):])
+		}
+	}
+
+	return result, nil
+}
+
+func (s *NamespacedStore) Search(query string, limit int) ([]SearchResult, error) {
+	return s.SearchContext(context.Background(), query, limit)
+}
+
+// Search the items of the tenant.
+//
+// The underlying store is searched without a limit, as matches from
+// other tenants are dropped before limit is applied.
+//
+func (s *NamespacedStore) SearchContext(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+
+	results, err := SearchContext(ctx, s.store, query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []SearchResult{}
+	for _, r := range results {
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+
+		if strings.HasPrefix((string)(r.ID), (string)(s.prefix)) {
+			r.ID = r.ID[len(s.prefix):]
+			matches = append(matches, r)
+		}
+	}
+
+	return matches, nil
+}
+
+func (s *NamespacedStore) Apply(f ItemHandler) error {
+	return s.store.Apply(func(id ID, obj Storable) error {
+		if !strings.HasPrefix((string)(id), (string)(s.prefix)) {
+			return nil
+		}
+
+		return f(id[len(s.prefix):], obj)
+	})
+}
+
+func (s *NamespacedStore) Delete(id ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *NamespacedStore) DeleteContext(ctx context.Context, id ID) error {
+
+	if !s.limited() {
+		return DeleteContext(ctx, s.store, s.prefix+id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := RetrieveContext(ctx, s.store, s.prefix+id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	err = DeleteContext(ctx, s.store, s.prefix+id)
+	if err != nil {
+		return err
+	}
+
+	if s.usage != nil && old != nil {
+		s.usage.Items--
+		s.usage.Bytes -= int64(s.size(old))
+	}
+
+	return nil
+}
+
+// A blob store wrapper scoping every blob to a tenant, as
+// NamespacedStore does for items.
+type NamespacedBlobStore struct {
+	store  BlobStore
+	prefix ID
+}
+
+func NewNamespacedBlobStore(store BlobStore, tenant string) (*NamespacedBlobStore, error) {
+
+	if tenant == "" || strings.Contains(tenant, NamespaceSep) {
+		return nil, ErrInvalidTenant
+	}
+
+	return &NamespacedBlobStore{store, (ID)(tenant + NamespaceSep)}, nil
+}
+
+func (s *NamespacedBlobStore) PutStream(id ID, r io.Reader, meta BlobMeta) error {
+
+	if id == "" {
+		return ErrInvalidBlobID
+	}
+
+	return s.store.PutStream(s.prefix+id, r, meta)
+}
+
+func (s *NamespacedBlobStore) GetStream(id ID) (io.ReadCloser, BlobMeta, error) {
+	return s.store.GetStream(s.prefix + id)
+}
+
+func (s *NamespacedBlobStore) GetRange(id ID, offset, length int64) (io.ReadCloser, BlobMeta, error) {
+	return GetRange(s.store, s.prefix+id, offset, length)
+}
+
+func (s *NamespacedBlobStore) StatBlob(id ID) (BlobMeta, error) {
+	return s.store.StatBlob(s.prefix + id)
+}
+
+func (s *NamespacedBlobStore) DeleteBlob(id ID) error {
+	return s.store.DeleteBlob(s.prefix + id)
+}
+
+func (s *NamespacedBlobStore) ListBlobs() ([]ID, error) {
+
+	ids, err := s.store.ListBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ID{}
+	for _, id := range ids {
+		if strings.HasPrefix((string)(id), (string)(s.prefix)) {
+			result = append(result, id[len(s.prefix):])
+		}
+	}
+
+	return result, nil
+}
+
+type TenantsOpt func(*Tenants)
+
+// Set the quota of each tenant.
+//
+// Usage is counted with an Apply over the underlying store the first
+// time a store of the tenant is written.  Without OptTenantCheck the
+// stores are not kept, so every write through Tenants counts it again,
+// at a cost proportional to the size of the underlying store.
+//
+func OptTenantQuota(quota func(tenant string) Quota) TenantsOpt {
+	return func(t *Tenants) {
+		t.quota = quota
+	}
+}
+
+// Options passed to every NamespacedStore created.
+func OptTenantNamespace(opts ...NamespaceOpt) TenantsOpt {
+	return func(t *Tenants) {
+		t.opts = append(t.opts, opts...)
+	}
+}
+
+// Accept only the tenants for which check returns nil, such as those
+// of an allow-list.  The stores of accepted tenants are kept, see
+// Tenants.
+func OptTenantCheck(check func(tenant string) error) TenantsOpt {
+	return func(t *Tenants) {
+		t.check = check
+	}
+}
+
+// Decorate the store of each tenant, returned by Tenants.Decorated,
+// for example with an AuthorizedStore or AuditedStore.
+func OptTenantWrap(wrap func(tenant string, s Store) Store) TenantsOpt {
+	return func(t *Tenants) {
+		t.wrap = wrap
+	}
+}
+
+// A check for OptTenantCheck accepting only the given tenants.
+func AllowTenants(tenants ...string) func(string) error {
+
+	allowed := map[string]bool{}
+	for _, tenant := range tenants {
+		allowed[tenant] = true
+	}
+
+	return func(tenant string) error {
+		if !allowed[tenant] {
+			return ErrUnknownTenant
+		}
+
+		return nil
+	}
+}
+
+type tenantStores struct {
+	ns    *NamespacedStore
+	store Store
+}
+
+// The tenants sharing an underlying store.
+//
+// Tenant names usually come from clients, so the stores handed out
+// are only kept for tenants accepted by OptTenantCheck.  Kept stores
+// track quota usage consistently, without a check quota usage is
+// counted afresh for each store and concurrent writes may exceed it.
+// Tenants is safe for concurrent use when the underlying store is.
+//
+type Tenants struct {
+	store Store
+	quota func(string) Quota
+	opts  []NamespaceOpt
+	check func(string) error
+	wrap  func(string, Store) Store
+
+	mu     sync.Mutex
+	stores map[string]tenantStores
+}
+
+func NewTenants(store Store, opts ...TenantsOpt) *Tenants {
+
+	t := &Tenants{
+		store:  store,
+		stores: map[string]tenantStores{},
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+func (t *Tenants) tenant(tenant string) (tenantStores, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ts, ok := t.stores[tenant]
+	if ok {
+		return ts, nil
+	}
+
+	if t.check != nil {
+		err := t.check(tenant)
+		if err != nil {
+			return tenantStores{}, err
+		}
+	}
+
+	opts := t.opts
+	if t.quota != nil {
+		opts = append(append([]NamespaceOpt{}, opts...), OptQuota(t.quota(tenant)))
+	}
+
+	ns, err := NewNamespacedStore(t.store, tenant, opts...)
+	if err != nil {
+		return tenantStores{}, err
+	}
+
+	ts = tenantStores{ns, ns}
+	if t.wrap != nil {
+		ts.store = t.wrap(tenant, ns)
+	}
+
+	if t.check != nil {
+		t.stores[tenant] = ts
+	}
+
+	return ts, nil
+}
+
+// The store for a tenant.
+func (t *Tenants) Store(tenant string) (*NamespacedStore, error) {
+	ts, err := t.tenant(tenant)
+	return ts.ns, err
+}
+
+// The store for a tenant as decorated by OptTenantWrap.
+func (t *Tenants) Decorated(tenant string) (Store, error) {
+	ts, err := t.tenant(tenant)
+	return ts.store, err
+}
+
+// The tenants holding items in the underlying store, sorted by name.
+func (t *Tenants) List() ([]string, error) {
+
+	ids, err := t.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		i := strings.Index((string)(id), NamespaceSep)
+		if i > 0 {
+			seen[(string)(id[:i])] = true
+		}
+	}
+
+	tenants := make([]string, 0, len(seen))
+	for tenant := range seen {
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Strings(tenants)
+
+	return tenants, nil
+}
+
+// Copy every item of a tenant, without the prefix, to dst.
+func (t *Tenants) Export(tenant string, dst Store, opts ...MigrateOpt) (MigrateStats, error) {
+
+	s, err := t.Store(tenant)
+	if err != nil {
+		return MigrateStats{}, err
+	}
+
+	return Migrate(s, dst, opts...)
+}
+
+// Delete every item of a tenant, returning the number deleted.
+func (t *Tenants) Delete(tenant string) (int, error) {
+
+	s, err := t.Store(tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		err = s.Delete(id)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
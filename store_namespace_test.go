@@ -0,0 +1,201 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "sort"
+import "strings"
+import "testing"
+
+func TestNamespaceStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestNamespacedStore(t *testing.T) {
+
+	ms := NewMapStore()
+
+	acme, err := NewNamespacedStore(ms, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initech, _ := NewNamespacedStore(ms, "initech")
+
+	acme.StoreItem("1", "Hello")
+	acme.StoreItem("2", "World")
+	initech.StoreItem("1", "Other")
+
+	obj, err := acme.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello", obj)
+
+	obj, _ = initech.Retrieve("1")
+	assert.Expect(t, "Other", obj)
+
+	_, err = initech.Retrieve("2")
+	assert.Expect(t, ErrNotFound, err)
+
+	ids, _ := acme.List()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	assert.Expect(t, []ID{"1", "2"}, ids)
+
+	n := 0
+	initech.Apply(func(id ID, obj Storable) error {
+		n++
+		return nil
+	})
+	assert.Expect(t, 1, n)
+
+	_, err = ms.Retrieve("acme/2")
+	assert.Expect(t, nil, err)
+
+	_, err = NewNamespacedStore(ms, "a/b")
+	assert.Expect(t, ErrInvalidTenant, err)
+
+	_, err = NewNamespacedStore(ms, "")
+	assert.Expect(t, ErrInvalidTenant, err)
+}
+
+func TestNamespacedBlobStore(t *testing.T) {
+
+	bs := NewMemBlobStore()
+
+	acme, err := NewNamespacedBlobStore(bs, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globex, _ := NewNamespacedBlobStore(bs, "globex")
+
+	assert.Expect(t, nil, acme.PutStream("a", strings.NewReader("Acme"), BlobMeta{}))
+	assert.Expect(t, nil, globex.PutStream("b", strings.NewReader("Globex"), BlobMeta{}))
+	assert.Expect(t, ErrInvalidBlobID, acme.PutStream("", strings.NewReader(""), BlobMeta{}))
+
+	ids, _ := bs.ListBlobs()
+	assert.Expect(t, []ID{"acme/a", "globex/b"}, ids)
+
+	ids, _ = acme.ListBlobs()
+	assert.Expect(t, []ID{"a"}, ids)
+
+	data, _ := readBlob(t, acme, "a")
+	assert.Expect(t, "Acme", data)
+
+	_, err = acme.StatBlob("b")
+	assert.Expect(t, ErrNotFound, err)
+
+	rc, _, err := acme.GetRange("a", 1, 2)
+	assert.Expect(t, nil, err)
+	part := make([]byte, 2)
+	rc.Read(part)
+	rc.Close()
+	assert.Expect(t, "cm", (string)(part))
+
+	assert.Expect(t, nil, acme.DeleteBlob("a"))
+	ids, _ = bs.ListBlobs()
+	assert.Expect(t, []ID{"globex/b"}, ids)
+
+	_, err = NewNamespacedBlobStore(bs, "a/b")
+	assert.Expect(t, ErrInvalidTenant, err)
+}
+
+func TestNamespacedStoreQuota(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("acme/0", "pre-existing")
+
+	acme, _ := NewNamespacedStore(ms, "acme", OptQuota(Quota{MaxItems: 3, MaxBytes: 20}))
+
+	assert.Expect(t, nil, acme.StoreItem("1", "12345"))
+	assert.Expect(t, ErrQuotaExceeded, acme.StoreItem("2", "too many bytes"))
+	assert.Expect(t, nil, acme.StoreItem("2", "12"))
+	assert.Expect(t, ErrQuotaExceeded, acme.StoreItem("3", "1"))
+
+	// Overwrites only count the difference.
+	assert.Expect(t, nil, acme.StoreItem("1", "1"))
+
+	u, _ := acme.Usage()
+	assert.Expect(t, Usage{Items: 3, Bytes: 15}, u)
+
+	acme.Delete("0")
+	assert.Expect(t, nil, acme.StoreItem("3", "1"))
+
+	u, _ = acme.Usage()
+	assert.Expect(t, Usage{Items: 3, Bytes: 4}, u)
+}
+
+func TestTenants(t *testing.T) {
+
+	ms := NewMapStore()
+	ts := NewTenants(ms,
+		OptTenantQuota(func(tenant string) Quota {
+			if tenant == "free" {
+				return Quota{MaxItems: 1}
+			}
+			return Quota{}
+		}),
+		OptTenantCheck(func(tenant string) error { return nil }),
+	)
+
+	free, _ := ts.Store("free")
+	same, _ := ts.Store("free")
+	assert.Expect(t, true, free == same)
+
+	assert.Expect(t, nil, free.StoreItem("1", "one"))
+	assert.Expect(t, ErrQuotaExceeded, free.StoreItem("2", "two"))
+
+	paid, _ := ts.Store("paid")
+	paid.StoreItem("1", "one")
+	paid.StoreItem("2", "two")
+
+	tenants, _ := ts.List()
+	assert.Expect(t, []string{"free", "paid"}, tenants)
+
+	dst := NewMapStore()
+	stats, err := ts.Export("paid", dst)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 2, stats.Copied)
+	assert.Expect(t, "two", dst["2"])
+
+	n, err := ts.Delete("paid")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 2, n)
+
+	tenants, _ = ts.List()
+	assert.Expect(t, []string{"free"}, tenants)
+}
+
+func TestTenantsCheck(t *testing.T) {
+
+	ms := NewMapStore()
+
+	ts := NewTenants(ms)
+	a, _ := ts.Store("acme")
+	b, _ := ts.Store("acme")
+	assert.Expect(t, false, a == b)
+
+	ts = NewTenants(ms,
+		OptTenantCheck(AllowTenants("acme")),
+		OptTenantWrap(func(tenant string, s Store) Store {
+			return NewAuthorizedStore(s, NewRulePolicy(AllowAuthenticated()))
+		}),
+	)
+
+	_, err := ts.Store("mallory")
+	assert.Expect(t, ErrUnknownTenant, err)
+
+	_, err = ts.Decorated("mallory")
+	assert.Expect(t, ErrUnknownTenant, err)
+
+	a, _ = ts.Store("acme")
+	b, _ = ts.Store("acme")
+	assert.Expect(t, true, a == b)
+
+	s, _ := ts.Decorated("acme")
+	assert.Expect(t, ErrUnauthenticated, s.StoreItem("1", "Anonymous"))
+
+	ctx := WithPrincipal(context.Background(), "alice")
+	assert.Expect(t, nil, StoreItemContext(ctx, s, "1", "Alice's"))
+	assert.Expect(t, "Alice's", ms["acme/1"])
+}
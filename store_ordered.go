@@ -0,0 +1,369 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "math/rand"
+import "sort"
+import "sync"
+import "time"
+
+type RangeOpt func(*rangeConfig)
+
+type rangeConfig struct {
+	reverse bool
+	limit   int
+}
+
+// Visit the items from the end of the range back to its start.
+func OptRangeReverse() RangeOpt {
+	return func(c *rangeConfig) {
+		c.reverse = true
+	}
+}
+
+// Stop after n items, zero or less visits every item in the range.
+func OptRangeLimit(n int) RangeOpt {
+	return func(c *rangeConfig) {
+		c.limit = n
+	}
+}
+
+func newRangeConfig(opts []RangeOpt) *rangeConfig {
+
+	c := &rangeConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Stores keeping their items ordered by ID.
+//
+// Ranges include start and exclude end, an empty end runs to the last
+// item.  Items are visited in ascending order of their IDs unless
+// OptRangeReverse is given.  An error from the handler stops the scan
+// and is returned.
+//
+type RangeStore interface {
+	Store
+	Range(start, end ID, f ItemHandler, opts ...RangeOpt) error
+	Prefix(p ID, f ItemHandler, opts ...RangeOpt) error
+	DeleteRange(start, end ID) (int, error)
+}
+
+// The smallest ID greater than every ID starting with p, or an empty
+// ID when there is none.
+func PrefixEnd(p ID) ID {
+
+	bs := ([]byte)(p)
+	for i := len(bs) - 1; i >= 0; i-- {
+		if bs[i] < 0xff {
+			bs[i]++
+			return (ID)(bs[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// List the IDs within a range of any store.
+//
+// RangeStores scan the range directly, the IDs of other stores are
+// listed, sorted and filtered.
+//
+func ListRangeContext(ctx context.Context, s Store, start, end ID, opts ...RangeOpt) ([]ID, error) {
+
+	ids := []ID{}
+
+	if rs, ok := s.(RangeStore); ok {
+		err := rs.Range(start, end, func(id ID, obj Storable) error {
+			ids = append(ids, id)
+			return ctx.Err()
+		}, opts...)
+
+		return ids, err
+	}
+
+	all, err := ListContext(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	for _, id := range all {
+		if id >= start && (end == "" || id < end) {
+			ids = append(ids, id)
+		}
+	}
+
+	c := newRangeConfig(opts)
+
+	if c.reverse {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+
+	if c.limit > 0 && len(ids) > c.limit {
+		ids = ids[:c.limit]
+	}
+
+	return ids, nil
+}
+
+// The maximum height of the OrderedStore skip list, enough for
+// billions of items.
+const orderedMaxLevel = 24
+
+type orderedNode struct {
+	id    ID
+	value Storable
+	next  []*orderedNode
+	prev  *orderedNode
+}
+
+// An in-memory store keeping its items ordered by ID.
+//
+// Items are held in a skip list, so hierarchical IDs such as
+// users/42/orders/7 can be scanned by prefix or range without visiting
+// the rest of the store.  List and Apply return items in order.
+//
+// OrderedStore is safe for concurrent use.  Handlers passed to Apply,
+// Range and Prefix must not call back into the store.
+//
+type OrderedStore struct {
+	head  *orderedNode
+	tail  *orderedNode
+	level int
+	size  int
+	rnd   *rand.Rand
+	mu    sync.RWMutex
+}
+
+func NewOrderedStore() *OrderedStore {
+	return &OrderedStore{
+		head:  &orderedNode{next: make([]*orderedNode, orderedMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Find the first node at or after id, filling update with the last
+// node before id at each level when it is given.
+func (s *OrderedStore) find(id ID, update []*orderedNode) *orderedNode {
+
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].id < id {
+			x = x.next[i]
+		}
+
+		if update != nil {
+			update[i] = x
+		}
+	}
+
+	return x.next[0]
+}
+
+// Find the last node before id, or the last node for an empty ID.
+func (s *OrderedStore) findBefore(id ID) *orderedNode {
+
+	if id == "" {
+		return s.tail
+	}
+
+	x := s.find(id, nil)
+	if x == nil {
+		return s.tail
+	}
+
+	return x.prev
+}
+
+func (s *OrderedStore) randomLevel() int {
+
+	level := 1
+	for level < orderedMaxLevel && s.rnd.Intn(4) == 0 {
+		level++
+	}
+
+	return level
+}
+
+// Unlink x, given the nodes before it at each of its levels.
+func (s *OrderedStore) remove(x *orderedNode, update []*orderedNode) {
+
+	for i := range x.next {
+		update[i].next[i] = x.next[i]
+	}
+
+	if x.next[0] != nil {
+		x.next[0].prev = x.prev
+	} else {
+		s.tail = x.prev
+	}
+
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+
+	s.size--
+}
+
+func (s *OrderedStore) StoreItem(id ID, obj Storable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := make([]*orderedNode, orderedMaxLevel)
+
+	x := s.find(id, update)
+	if x != nil && x.id == id {
+		x.value = obj
+		return nil
+	}
+
+	level := s.randomLevel()
+	for i := s.level; i < level; i++ {
+		update[i] = s.head
+	}
+
+	if level > s.level {
+		s.level = level
+	}
+
+	n := &orderedNode{id: id, value: obj, next: make([]*orderedNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+
+	if update[0] != s.head {
+		n.prev = update[0]
+	}
+
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		s.tail = n
+	}
+
+	s.size++
+
+	return nil
+}
+
+func (s *OrderedStore) Retrieve(id ID) (Storable, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	x := s.find(id, nil)
+	if x == nil || x.id != id {
+		return nil, ErrNotFound
+	}
+
+	return x.value, nil
+}
+
+// List the IDs of every item in order.
+func (s *OrderedStore) List() ([]ID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]ID, 0, s.size)
+	for x := s.head.next[0]; x != nil; x = x.next[0] {
+		ids = append(ids, x.id)
+	}
+
+	return ids, nil
+}
+
+// Apply f to every item in order.
+func (s *OrderedStore) Apply(f ItemHandler) error {
+	return s.Range("", "", f)
+}
+
+func (s *OrderedStore) Delete(id ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := make([]*orderedNode, orderedMaxLevel)
+
+	x := s.find(id, update)
+	if x != nil && x.id == id {
+		s.remove(x, update)
+	}
+
+	return nil
+}
+
+// The number of items in the store.
+func (s *OrderedStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.size
+}
+
+func (s *OrderedStore) Range(start, end ID, f ItemHandler, opts ...RangeOpt) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := newRangeConfig(opts)
+
+	n := 0
+	visit := func(x *orderedNode) (bool, error) {
+		if c.limit > 0 && n >= c.limit {
+			return false, nil
+		}
+
+		n++
+
+		return true, f(x.id, x.value)
+	}
+
+	if c.reverse {
+		for x := s.findBefore(end); x != nil && x.id >= start; x = x.prev {
+			ok, err := visit(x)
+			if !ok || err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for x := s.find(start, nil); x != nil && (end == "" || x.id < end); x = x.next[0] {
+		ok, err := visit(x)
+		if !ok || err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Visit the items with IDs starting with p.
+func (s *OrderedStore) Prefix(p ID, f ItemHandler, opts ...RangeOpt) error {
+	return s.Range(p, PrefixEnd(p), f, opts...)
+}
+
+// Delete the items within a range, returning the number deleted.
+func (s *OrderedStore) DeleteRange(start, end ID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := make([]*orderedNode, orderedMaxLevel)
+
+	n := 0
+	for x := s.find(start, update); x != nil && (end == "" || x.id < end); n++ {
+		next := x.next[0]
+		s.remove(x, update)
+		x = next
+	}
+
+	return n, nil
+}
@@ -0,0 +1,149 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "fmt"
+import "math/rand"
+import "sort"
+import "testing"
+
+func TestOrderedStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func collectIDs(t *testing.T, scan func(ItemHandler) error) []ID {
+	t.Helper()
+
+	ids := []ID{}
+	err := scan(func(id ID, obj Storable) error {
+		ids = append(ids, id)
+		return nil
+	})
+	assert.Expect(t, nil, err)
+
+	return ids
+}
+
+func TestOrderedStoreOrder(t *testing.T) {
+
+	ords := NewOrderedStore()
+	ms := NewMapStore()
+
+	for _, i := range rand.Perm(1000) {
+		id := (ID)(fmt.Sprintf("item-%04d", i))
+		ords.StoreItem(id, i)
+		ms.StoreItem(id, i)
+	}
+
+	// Remove every third item.
+	for i := 0; i < 1000; i += 3 {
+		id := (ID)(fmt.Sprintf("item-%04d", i))
+		ords.Delete(id)
+		ms.Delete(id)
+	}
+
+	exp, _ := ms.List()
+	sort.Slice(exp, func(i, j int) bool { return exp[i] < exp[j] })
+
+	ids, err := ords.List()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, exp, ids)
+	assert.Expect(t, len(exp), ords.Len())
+
+	rev := collectIDs(t, func(f ItemHandler) error {
+		return ords.Range("", "", f, OptRangeReverse())
+	})
+
+	for i := range rev {
+		assert.Expect(t, exp[len(exp)-1-i], rev[i])
+	}
+
+	obj, err := ords.Retrieve("item-0001")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 1, obj)
+
+	_, err = ords.Retrieve("item-0003")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestOrderedStorePrefix(t *testing.T) {
+
+	ords := NewOrderedStore()
+	for _, id := range []ID{"users/1", "users/1/orders/1", "users/1/orders/2",
+		"users/10/orders/1", "users/2/orders/1", "usersx"} {
+		ords.StoreItem(id, (string)(id))
+	}
+
+	ids := collectIDs(t, func(f ItemHandler) error {
+		return ords.Prefix("users/1/", f)
+	})
+	assert.Expect(t, []ID{"users/1/orders/1", "users/1/orders/2"}, ids)
+
+	ids = collectIDs(t, func(f ItemHandler) error {
+		return ords.Prefix("users/", f, OptRangeReverse(), OptRangeLimit(2))
+	})
+	assert.Expect(t, []ID{"users/2/orders/1", "users/10/orders/1"}, ids)
+
+	ids = collectIDs(t, func(f ItemHandler) error {
+		return ords.Prefix("nobody/", f)
+	})
+	assert.Expect(t, []ID{}, ids)
+}
+
+func TestOrderedStoreRangeError(t *testing.T) {
+
+	ords := NewOrderedStore()
+	ords.StoreItem("a", 1)
+	ords.StoreItem("b", 2)
+
+	n := 0
+	err := ords.Range("", "", func(id ID, obj Storable) error {
+		n++
+		return ErrNotFound
+	})
+
+	assert.Expect(t, ErrNotFound, err)
+	assert.Expect(t, 1, n)
+}
+
+func TestOrderedStoreDeleteRange(t *testing.T) {
+
+	ords := NewOrderedStore()
+	for i := 0; i < 100; i++ {
+		ords.StoreItem((ID)(fmt.Sprintf("%02d", i)), i)
+	}
+
+	n, err := ords.DeleteRange("10", "20")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 10, n)
+
+	n, _ = ords.DeleteRange("90", "")
+	assert.Expect(t, 10, n)
+
+	ids, _ := ords.List()
+	assert.Expect(t, 80, len(ids))
+	assert.Expect(t, ID("09"), ids[9])
+	assert.Expect(t, ID("20"), ids[10])
+	assert.Expect(t, ID("89"), ids[79])
+
+	rev := collectIDs(t, func(f ItemHandler) error {
+		return ords.Range("", "", f, OptRangeReverse(), OptRangeLimit(1))
+	})
+	assert.Expect(t, []ID{"89"}, rev)
+
+	n, _ = ords.DeleteRange("", "")
+	assert.Expect(t, 80, n)
+	assert.Expect(t, 0, ords.Len())
+
+	ids, _ = ords.List()
+	assert.Expect(t, []ID{}, ids)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Expect(t, ID("b"), PrefixEnd("a"))
+	assert.Expect(t, ID("a0"), PrefixEnd("a/"))
+	assert.Expect(t, ID("b"), PrefixEnd("a\xff"))
+	assert.Expect(t, ID(""), PrefixEnd("\xff\xff"))
+	assert.Expect(t, ID(""), PrefixEnd(""))
+}
@@ -0,0 +1,719 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "bufio"
+import "bytes"
+import "encoding/binary"
+import "encoding/json"
+import "hash/crc32"
+import "io"
+import "os"
+import "path/filepath"
+import "sync"
+import "time"
+
+// Returned by a PersistentMapStore after it has been closed.
+const ErrStoreClosed = StoreError("The store is closed.")
+
+// Returned when opening a PersistentMapStore with a damaged snapshot.
+const ErrCorruptSnapshot = StoreError("The snapshot is corrupt.")
+
+// Returned when opening a PersistentMapStore with a damaged record
+// followed by more of the log, which a crash can not explain.
+const ErrCorruptLog = StoreError("The write-ahead log is corrupt.")
+
+// A complete log record which does not match its checksum.
+const errBadRecord = StoreError("Bad log record.")
+
+// When a PersistentMapStore flushes its log to stable storage.
+type SyncPolicy int
+
+const (
+	// Sync the log before each mutation returns.
+	SyncAlways SyncPolicy = iota
+
+	// Sync the log in the background, see OptSyncInterval.  Up to an
+	// interval of mutations may be lost in a crash.
+	SyncPeriodic
+
+	// Leave flushing the log to the operating system.
+	SyncNever
+)
+
+type PersistentOpt func(*PersistentMapStore)
+
+// Set the policy for syncing the log, SyncAlways by default.
+func OptSync(p SyncPolicy) PersistentOpt {
+	return func(s *PersistentMapStore) {
+		s.policy = p
+	}
+}
+
+// Sync the log every d in the background, implies SyncPeriodic.
+func OptSyncInterval(d time.Duration) PersistentOpt {
+	return func(s *PersistentMapStore) {
+		s.policy = SyncPeriodic
+		s.syncEvery = d
+	}
+}
+
+// Snapshot the store after every n mutations.
+func OptSnapshotEvery(n int) PersistentOpt {
+	return func(s *PersistentMapStore) {
+		s.snapEvery = n
+	}
+}
+
+// Snapshot the store every d in the background, when it has changed.
+func OptSnapshotInterval(d time.Duration) PersistentOpt {
+	return func(s *PersistentMapStore) {
+		s.snapInterval = d
+	}
+}
+
+// Receive the errors of automatic snapshots with f.
+//
+// A failed snapshot loses nothing as the log is kept, so mutations
+// succeed regardless.  The snapshot is retried after another
+// OptSnapshotEvery mutations or at the next OptSnapshotInterval.
+//
+func OptSnapshotErrors(f func(error)) PersistentOpt {
+	return func(s *PersistentMapStore) {
+		s.snapErrs = f
+	}
+}
+
+const (
+	logStore  byte = 1
+	logDelete byte = 2
+)
+
+// Files kept in the directory of a PersistentMapStore.
+const (
+	snapshotFile = "snapshot"
+	logFile      = "wal"
+)
+
+// A MapStore which survives application exit.
+//
+// Items are held in memory as in a MapStore.  Each mutation is first
+// appended to a write-ahead log in dir, the whole map is periodically
+// written to a snapshot through the encoder and the log truncated.
+// Opening the store recovers the snapshot and replays the log,
+// discarding a partially written final entry.  A damaged entry
+// anywhere else fails with ErrCorruptLog rather than dropping the
+// entries after it.
+//
+// Snapshots block mutations while they are written.  Failed automatic
+// snapshots are reported separately, see OptSnapshotErrors.
+//
+// Once syncing the log fails, what reached stable storage is unknown,
+// so every later mutation, Snapshot and Close return the error.  The
+// mutations up to it may or may not be recovered when the store is
+// opened again.
+//
+// PersistentMapStore is safe for concurrent use.  Handlers passed to
+// Apply must not call back into the store.
+//
+type PersistentMapStore struct {
+	dir          string
+	enc          func(Storable) ([]byte, error)
+	dec          func([]byte) (Storable, error)
+	policy       SyncPolicy
+	syncEvery    time.Duration
+	snapEvery    int
+	snapInterval time.Duration
+	snapErrs     func(error)
+
+	items   MapStore
+	wal     *os.File
+	offset  int64
+	changes int
+	failed  int
+	err     error
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+}
+
+func NewPersistentMapStore(dir string,
+	enc func(Storable) ([]byte, error),
+	dec func([]byte) (Storable, error),
+	opts ...PersistentOpt) (*PersistentMapStore, error) {
+
+	s := &PersistentMapStore{
+		dir:       dir,
+		enc:       enc,
+		dec:       dec,
+		policy:    SyncAlways,
+		syncEvery: time.Second,
+		items:     NewMapStore(),
+		done:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	os.Remove(filepath.Join(dir, snapshotFile+".tmp"))
+
+	err = s.recover()
+	if err != nil {
+		return nil, err
+	}
+
+	s.wal, err = os.OpenFile(filepath.Join(dir, logFile),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.policy == SyncPeriodic || s.snapInterval > 0 {
+		s.wg.Add(1)
+		go s.background()
+	}
+
+	return s, nil
+}
+
+// Append a log record for an operation to buf.
+//
+// Records are framed by the length and CRC-32 of their body, which
+// holds the operation, ID, encoded item and JSON metadata.
+//
+func appendRecord(buf []byte, op byte, id ID, data, meta []byte) []byte {
+
+	body := []byte{op}
+	for _, field := range [][]byte{([]byte)(id), data, meta} {
+		body = appendUvarint(body, uint64(len(field)))
+		body = append(body, field...)
+	}
+
+	var head [8]byte
+	binary.LittleEndian.PutUint32(head[:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(head[4:], crc32.ChecksumIEEE(body))
+
+	return append(append(buf, head[:]...), body...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+// Read the next record, returning io.EOF at a clean end,
+// io.ErrUnexpectedEOF for a partial record and errBadRecord for a
+// damaged one.
+func readRecord(r io.Reader) (op byte, id ID, data, meta []byte, n int64, err error) {
+
+	var head [8]byte
+	_, err = io.ReadFull(r, head[:])
+	if err != nil {
+		return
+	}
+
+	// The buffer grows as the body is read, so a damaged length can
+	// not allocate more than the rest of the file.
+	buf := &bytes.Buffer{}
+	size := int64(binary.LittleEndian.Uint32(head[:4]))
+
+	_, err = io.CopyN(buf, r, size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return
+	}
+
+	body := buf.Bytes()
+
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(head[4:]) || len(body) == 0 {
+		err = errBadRecord
+		return
+	}
+
+	op, rest := body[0], body[1:]
+
+	fields := make([][]byte, 3)
+	for i := range fields {
+		l, k := binary.Uvarint(rest)
+		if k <= 0 || uint64(len(rest)-k) < l {
+			err = errBadRecord
+			return
+		}
+
+		fields[i], rest = rest[k:k+int(l)], rest[k+int(l):]
+	}
+
+	return op, (ID)(fields[0]), fields[1], fields[2], int64(len(head) + len(body)), nil
+}
+
+// Apply a record to the map.
+func (s *PersistentMapStore) replay(op byte, id ID, data, meta []byte) error {
+
+	if op == logDelete {
+		delete(s.items, id)
+		return nil
+	}
+
+	obj, err := s.dec(data)
+	if err != nil {
+		return err
+	}
+
+	if len(meta) == 0 {
+		s.items[id] = obj
+		return nil
+	}
+
+	m := Meta{}
+	err = json.Unmarshal(meta, &m)
+	if err != nil {
+		return err
+	}
+
+	s.items[id] = metaItem{obj, m}
+
+	return nil
+}
+
+// Load the snapshot and replay the log, truncating a torn final
+// record.
+func (s *PersistentMapStore) recover() error {
+
+	f, err := os.Open(filepath.Join(s.dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		defer f.Close()
+
+		r := bufio.NewReader(f)
+		for {
+			op, id, data, meta, _, err := readRecord(r)
+			if err == io.EOF {
+				break
+			}
+
+			if err == io.ErrUnexpectedEOF || err == errBadRecord || (err == nil && op != logStore) {
+				return ErrCorruptSnapshot
+			}
+
+			if err == nil {
+				err = s.replay(op, id, data, meta)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	f, err = os.OpenFile(filepath.Join(s.dir, logFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		op, id, data, meta, n, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+
+		// A damaged record is only a torn write when it is the last.
+		if err == errBadRecord {
+			if _, perr := r.Peek(1); perr != io.EOF {
+				return ErrCorruptLog
+			}
+
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			// The last write did not complete.
+			return f.Truncate(s.offset)
+		}
+
+		if err == nil {
+			err = s.replay(op, id, data, meta)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		s.offset += n
+	}
+}
+
+func (s *PersistentMapStore) background() {
+	defer s.wg.Done()
+
+	var syncs, snaps <-chan time.Time
+
+	if s.policy == SyncPeriodic {
+		t := time.NewTicker(s.syncEvery)
+		defer t.Stop()
+		syncs = t.C
+	}
+
+	if s.snapInterval > 0 {
+		t := time.NewTicker(s.snapInterval)
+		defer t.Stop()
+		snaps = t.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+
+		case <-syncs:
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = s.wal.Sync()
+			}
+			s.mu.Unlock()
+
+		case <-snaps:
+			s.mu.Lock()
+			if s.changes > 0 {
+				s.autoSnapshot()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Append a record to the log, undoing a partial write on failure.
+//
+// A failed sync is kept in s.err, failing the store.
+//
+func (s *PersistentMapStore) append(op byte, id ID, data, meta []byte) error {
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if s.err != nil {
+		return s.err
+	}
+
+	rec := appendRecord(nil, op, id, data, meta)
+
+	_, err := s.wal.Write(rec)
+	if err != nil {
+		s.wal.Truncate(s.offset)
+		return err
+	}
+
+	s.offset += int64(len(rec))
+
+	if s.policy == SyncAlways {
+		s.err = s.wal.Sync()
+	}
+
+	return s.err
+}
+
+// Count a mutation, taking a snapshot when one is due.
+//
+// A snapshot is due after OptSnapshotEvery mutations, since the last
+// snapshot or the last failed attempt.
+//
+func (s *PersistentMapStore) mutated() {
+
+	s.changes++
+	if s.snapEvery > 0 && s.changes-s.failed >= s.snapEvery {
+		s.autoSnapshot()
+	}
+}
+
+// Take a snapshot which is not requested by the caller, reporting a
+// failure to the OptSnapshotErrors handler.
+func (s *PersistentMapStore) autoSnapshot() {
+
+	if s.err != nil {
+		return
+	}
+
+	err := s.snapshot()
+	if err == nil {
+		return
+	}
+
+	s.failed = s.changes
+
+	if s.snapErrs != nil {
+		s.snapErrs(err)
+	}
+}
+
+// Log and store an item, with its metadata when meta is given.
+func (s *PersistentMapStore) put(id ID, obj Storable, meta *Meta) error {
+
+	data, err := s.enc(obj)
+	if err != nil {
+		return err
+	}
+
+	var mbs []byte
+	var item Storable = obj
+
+	if meta != nil {
+		_, old, _ := s.items.RetrieveMeta(id)
+		m := touchMeta(*meta, old, time.Now())
+
+		mbs, err = json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		item = metaItem{obj, m}
+	}
+
+	err = s.append(logStore, id, data, mbs)
+	if err != nil {
+		return err
+	}
+
+	s.items[id] = item
+
+	s.mutated()
+
+	return nil
+}
+
+func (s *PersistentMapStore) StoreItem(id ID, obj Storable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(id, obj, nil)
+}
+
+func (s *PersistentMapStore) StoreItemWithMeta(id ID, obj Storable, meta Meta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(id, obj, &meta)
+}
+
+func (s *PersistentMapStore) Retrieve(id ID) (Storable, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.items.Retrieve(id)
+}
+
+func (s *PersistentMapStore) RetrieveMeta(id ID) (Storable, Meta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.items.RetrieveMeta(id)
+}
+
+func (s *PersistentMapStore) Stat(id ID) (Meta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.items.Stat(id)
+}
+
+func (s *PersistentMapStore) List() ([]ID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.items.List()
+}
+
+func (s *PersistentMapStore) Apply(f ItemHandler) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.items.Apply(f)
+}
+
+func (s *PersistentMapStore) Delete(id ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[id]; !ok {
+		return nil
+	}
+
+	err := s.append(logDelete, id, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	delete(s.items, id)
+
+	s.mutated()
+
+	return nil
+}
+
+// Apply f atomically, keeping the metadata of items stored with it.
+func (s *PersistentMapStore) Update(id ID, f UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, meta, err := s.items.RetrieveMeta(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	obj, err := f(old, err == nil)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.items[id].(metaItem); ok {
+		return s.put(id, obj, &meta)
+	}
+
+	return s.put(id, obj, nil)
+}
+
+// Write every item to a new snapshot and truncate the log.
+func (s *PersistentMapStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if s.err != nil {
+		return s.err
+	}
+
+	return s.snapshot()
+}
+
+func (s *PersistentMapStore) snapshot() error {
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	err = s.items.Apply(func(id ID, obj Storable) error {
+
+		data, err := s.enc(obj)
+		if err != nil {
+			return err
+		}
+
+		var meta []byte
+		if mi, ok := s.items[id].(metaItem); ok {
+			meta, err = json.Marshal(mi.meta)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = w.Write(appendRecord(nil, logStore, id, data, meta))
+
+		return err
+	})
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(s.dir, snapshotFile))
+	if err != nil {
+		return err
+	}
+
+	// Make the rename durable before dropping the log.  Not every
+	// platform can sync a directory, so this is best effort.
+	if d, err := os.Open(s.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	err = s.wal.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	s.err = s.wal.Sync()
+	if s.err != nil {
+		return s.err
+	}
+
+	s.offset = 0
+	s.changes = 0
+	s.failed = 0
+
+	return nil
+}
+
+// The number of items in the store.
+func (s *PersistentMapStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.items)
+}
+
+// Stop background work, sync the log and close it.
+//
+// The error of an earlier failed sync is returned, as the log may not
+// hold every mutation.
+//
+func (s *PersistentMapStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	s.wg.Wait()
+
+	err := s.err
+	if err == nil {
+		err = s.wal.Sync()
+	}
+
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
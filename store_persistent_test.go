@@ -0,0 +1,339 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+func TestPersistentStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func encodeBytes(obj Storable) ([]byte, error) {
+	s, ok := obj.(string)
+	if !ok {
+		return nil, NewStoreError("Not a string.")
+	}
+
+	return ([]byte)(s), nil
+}
+
+func decodeBytes(bs []byte) (Storable, error) {
+	return (string)(bs), nil
+}
+
+func openPersistent(t *testing.T, dir string, opts ...PersistentOpt) *PersistentMapStore {
+	t.Helper()
+
+	s, err := NewPersistentMapStore(dir, encodeBytes, decodeBytes, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func persistentDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "stored-persistent-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fi.Size()
+}
+
+func TestPersistentMapStoreRecover(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	s := openPersistent(t, dir)
+	s.StoreItem("1", "one")
+	s.StoreItem("2", "two")
+	s.StoreItem("1", "uno")
+	s.Delete("2")
+	s.StoreItemWithMeta("3", "three", Meta{Tags: []string{"odd"}})
+
+	_, before, _ := s.RetrieveMeta("3")
+
+	assert.Expect(t, nil, s.Close())
+	assert.Expect(t, ErrStoreClosed, s.StoreItem("4", "four"))
+
+	s = openPersistent(t, dir)
+	defer s.Close()
+
+	assert.Expect(t, 2, s.Len())
+
+	obj, err := s.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "uno", obj)
+
+	_, err = s.Retrieve("2")
+	assert.Expect(t, ErrNotFound, err)
+
+	obj, meta, err := s.RetrieveMeta("3")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "three", obj)
+	assert.Expect(t, []string{"odd"}, meta.Tags)
+	assert.Expect(t, true, before.Created.Equal(meta.Created))
+
+	// Updates keep the metadata.
+	err = Update(s, "3", func(old Storable, exists bool) (Storable, error) {
+		return old.(string) + "!", nil
+	})
+	assert.Expect(t, nil, err)
+
+	_, meta, _ = s.RetrieveMeta("3")
+	assert.Expect(t, []string{"odd"}, meta.Tags)
+}
+
+func TestPersistentMapStoreSnapshot(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	wal := filepath.Join(dir, logFile)
+
+	s := openPersistent(t, dir, OptSnapshotEvery(10))
+	for i := 0; i < 25; i++ {
+		s.StoreItem((ID)(fmt.Sprintf("%d", i)), fmt.Sprintf("value-%d", i))
+	}
+
+	// Two snapshots were taken, five mutations remain in the log.
+	sz := fileSize(t, wal)
+	if sz == 0 {
+		t.Error("Expected the log to hold the latest mutations.")
+	}
+
+	assert.Expect(t, nil, s.Snapshot())
+	assert.Expect(t, int64(0), fileSize(t, wal))
+
+	s.Delete("0")
+	s.StoreItem("1", "changed")
+	s.Close()
+
+	s = openPersistent(t, dir)
+	defer s.Close()
+
+	assert.Expect(t, 24, s.Len())
+
+	obj, _ := s.Retrieve("1")
+	assert.Expect(t, "changed", obj)
+
+	obj, _ = s.Retrieve("24")
+	assert.Expect(t, "value-24", obj)
+}
+
+func TestPersistentMapStoreTornLog(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	wal := filepath.Join(dir, logFile)
+
+	s := openPersistent(t, dir)
+	s.StoreItem("1", "one")
+	s.StoreItem("2", "two")
+	s.Close()
+
+	good := fileSize(t, wal)
+
+	// Simulate a crash part way through a write.
+	rec := appendRecord(nil, logStore, "3", []byte("three"), nil)
+
+	f, _ := os.OpenFile(wal, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(rec[:len(rec)-2])
+	f.Close()
+
+	s = openPersistent(t, dir)
+
+	ids, _ := s.List()
+	assert.Expect(t, 2, len(ids))
+	assert.Expect(t, good, fileSize(t, wal))
+
+	// Later writes are not lost behind the damaged record.
+	s.StoreItem("4", "four")
+	s.Close()
+
+	s = openPersistent(t, dir)
+	defer s.Close()
+
+	obj, err := s.Retrieve("4")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "four", obj)
+}
+
+func TestPersistentMapStoreCorruptLog(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	wal := filepath.Join(dir, logFile)
+
+	s := openPersistent(t, dir)
+	s.StoreItem("1", "one")
+	s.StoreItem("2", "two")
+	s.Close()
+
+	// A damaged final record is taken for a torn write.
+	bs, _ := ioutil.ReadFile(wal)
+	bs[len(bs)-1] ^= 0xff
+	ioutil.WriteFile(wal, bs, 0644)
+
+	s = openPersistent(t, dir)
+	ids, _ := s.List()
+	assert.Expect(t, []ID{"1"}, ids)
+
+	s.StoreItem("3", "three")
+	s.Close()
+
+	// One followed by more records is not, as they would be lost.
+	bs, _ = ioutil.ReadFile(wal)
+	bs[10] ^= 0xff
+	ioutil.WriteFile(wal, bs, 0644)
+
+	_, err := NewPersistentMapStore(dir, encodeBytes, decodeBytes)
+	assert.Expect(t, ErrCorruptLog, err)
+}
+
+func TestPersistentMapStoreSyncFailure(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	s := openPersistent(t, dir, OptSync(SyncPeriodic))
+	assert.Expect(t, nil, s.StoreItem("1", "one"))
+
+	// As left by a failed background sync.
+	fail := NewStoreError("Sync failed.")
+	s.mu.Lock()
+	s.err = fail
+	s.mu.Unlock()
+
+	// The store fails until it is opened again.
+	assert.Expect(t, fail, s.StoreItem("2", "two"))
+	assert.Expect(t, fail, s.Delete("1"))
+	assert.Expect(t, fail, s.Snapshot())
+	assert.Expect(t, fail, s.Close())
+
+	obj, err := s.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "one", obj)
+
+	s = openPersistent(t, dir)
+	defer s.Close()
+
+	assert.Expect(t, nil, s.StoreItem("2", "two"))
+}
+
+func TestPersistentMapStoreCorruptSnapshot(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	s := openPersistent(t, dir)
+	s.StoreItem("1", "one")
+	s.Snapshot()
+	s.Close()
+
+	snap := filepath.Join(dir, snapshotFile)
+	bs, _ := ioutil.ReadFile(snap)
+	bs[len(bs)-1] ^= 0xff
+	ioutil.WriteFile(snap, bs, 0644)
+
+	_, err := NewPersistentMapStore(dir, encodeBytes, decodeBytes)
+	assert.Expect(t, ErrCorruptSnapshot, err)
+}
+
+func TestPersistentMapStoreBackground(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	wal := filepath.Join(dir, logFile)
+
+	s := openPersistent(t, dir,
+		OptSyncInterval(time.Millisecond),
+		OptSnapshotInterval(5*time.Millisecond))
+
+	s.StoreItem("1", "one")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fileSize(t, wal) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Expect(t, int64(0), fileSize(t, wal))
+	assert.Expect(t, nil, s.Close())
+
+	s = openPersistent(t, dir, OptSync(SyncNever))
+	defer s.Close()
+
+	obj, _ := s.Retrieve("1")
+	assert.Expect(t, "one", obj)
+}
+
+func TestPersistentMapStoreEncodeError(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	s := openPersistent(t, dir)
+	defer s.Close()
+
+	assert.Expect(t, NewStoreError("Not a string."), s.StoreItem("1", 1))
+
+	_, err := s.Retrieve("1")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestPersistentMapStoreSnapshotError(t *testing.T) {
+
+	dir := persistentDir(t)
+	defer os.RemoveAll(dir)
+
+	errs := []error{}
+	s := openPersistent(t, dir, OptSnapshotEvery(2), OptSnapshotErrors(func(err error) {
+		errs = append(errs, err)
+	}))
+	defer s.Close()
+
+	// Block the snapshot with a directory in the way of its file.
+	tmp := filepath.Join(dir, snapshotFile+".tmp")
+	os.MkdirAll(filepath.Join(tmp, "blocked"), 0755)
+
+	assert.Expect(t, nil, s.StoreItem("1", "one"))
+	assert.Expect(t, nil, s.StoreItem("2", "two"))
+	assert.Expect(t, 1, len(errs))
+
+	// Not retried on every mutation.
+	assert.Expect(t, nil, s.StoreItem("3", "three"))
+	assert.Expect(t, 1, len(errs))
+
+	os.RemoveAll(tmp)
+
+	assert.Expect(t, nil, s.StoreItem("4", "four"))
+	assert.Expect(t, 1, len(errs))
+	assert.Expect(t, int64(0), fileSize(t, filepath.Join(dir, logFile)))
+
+	obj, _ := s.Retrieve("2")
+	assert.Expect(t, "two", obj)
+}
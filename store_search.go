@@ -0,0 +1,265 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "fmt"
+import "reflect"
+import "strings"
+import "sync"
+
+// Extract the searchable text of an item.
+type TextExtractor func(Storable) (string, error)
+
+// The text of strings, byte slices and fmt.Stringers, or of the
+// string fields, elements and values within structs, slices and maps.
+//
+// Other values, such as numbers, have no text.  Values reached again
+// through a shared pointer or map, including cycles, are extracted
+// once and nesting deeper than MaxExtractDepth is ignored.
+//
+func ExtractText(obj Storable) (string, error) {
+
+	texts := []string{}
+	seen := map[uintptr]bool{}
+	extractValue(reflect.ValueOf(obj), &texts, seen, 0)
+
+	return strings.Join(texts, " "), nil
+}
+
+// The deepest nesting of values searched for text by ExtractText.
+const MaxExtractDepth = 32
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+func extractValue(v reflect.Value, texts *[]string, seen map[uintptr]bool, depth int) {
+
+	if !v.IsValid() || depth > MaxExtractDepth {
+		return
+	}
+
+	if v.Type().Implements(stringerType) && v.CanInterface() {
+		if v.Kind() != reflect.Ptr || !v.IsNil() {
+			*texts = append(*texts, v.Interface().(fmt.Stringer).String())
+			return
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		*texts = append(*texts, v.String())
+
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return
+		}
+		seen[v.Pointer()] = true
+		extractValue(v.Elem(), texts, seen, depth+1)
+
+	case reflect.Interface:
+		if !v.IsNil() {
+			extractValue(v.Elem(), texts, seen, depth+1)
+		}
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			*texts = append(*texts, (string)(v.Bytes()))
+			return
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			extractValue(v.Index(i), texts, seen, depth+1)
+		}
+
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return
+		}
+		seen[v.Pointer()] = true
+		for _, k := range v.MapKeys() {
+			extractValue(v.MapIndex(k), texts, seen, depth+1)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				extractValue(v.Field(i), texts, seen, depth+1)
+			}
+		}
+	}
+}
+
+type SearchableOpt func(*SearchableStore)
+
+// Extract the text of items of the same type as example with f.
+//
+// Items of other types use ExtractText.
+//
+func OptTextExtractor(example Storable, f TextExtractor) SearchableOpt {
+	return func(s *SearchableStore) {
+		s.extractors[reflect.TypeOf(example)] = f
+	}
+}
+
+// Keep the search index in ix, for example one created with a
+// different Analyzer.
+func OptSearchIndex(ix *Index) SearchableOpt {
+	return func(s *SearchableStore) {
+		s.index = ix
+	}
+}
+
+// A store wrapper keeping a full-text index of its items.
+//
+// The index is built from the items already in the store when it is
+// created and updated as items are stored, updated and deleted
+// through the wrapper.  Changes made directly to the wrapped store
+// are not indexed until Reindex is called.
+//
+type SearchableStore struct {
+	store      Store
+	index      *Index
+	extractors map[reflect.Type]TextExtractor
+	mu         sync.Mutex
+}
+
+func NewSearchableStore(store Store, opts ...SearchableOpt) (*SearchableStore, error) {
+
+	s := &SearchableStore{
+		store:      store,
+		extractors: map[reflect.Type]TextExtractor{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.index == nil {
+		s.index = NewIndex()
+	}
+
+	return s, s.Reindex()
+}
+
+func (s *SearchableStore) extract(obj Storable) (string, error) {
+
+	if f, ok := s.extractors[reflect.TypeOf(obj)]; ok {
+		return f(obj)
+	}
+
+	return ExtractText(obj)
+}
+
+// Rebuild the index from the items in the store.
+func (s *SearchableStore) Reindex() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.index.clear()
+
+	return s.store.Apply(func(id ID, obj Storable) error {
+
+		text, err := s.extract(obj)
+		if err != nil {
+			return err
+		}
+
+		s.index.Add(id, text)
+
+		return nil
+	})
+}
+
+// Find the items matching query, most relevant first.
+func (s *SearchableStore) Search(query string, limit int) ([]SearchResult, error) {
+	return s.index.Search(query, limit), nil
+}
+
+func (s *SearchableStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *SearchableStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+
+	text, err := s.extract(obj)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = StoreItemContext(ctx, s.store, id, obj)
+	if err != nil {
+		return err
+	}
+
+	s.index.Add(id, text)
+
+	return nil
+}
+
+// Update an item in the wrapped store, indexing the new value.
+func (s *SearchableStore) Update(id ID, f UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var text string
+	err := Update(s.store, id, func(old Storable, exists bool) (Storable, error) {
+
+		obj, err := f(old, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		text, err = s.extract(obj)
+
+		return obj, err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.index.Add(id, text)
+
+	return nil
+}
+
+func (s *SearchableStore) Retrieve(id ID) (Storable, error) {
+	return s.store.Retrieve(id)
+}
+
+func (s *SearchableStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+	return RetrieveContext(ctx, s.store, id)
+}
+
+func (s *SearchableStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *SearchableStore) ListContext(ctx context.Context) ([]ID, error) {
+	return ListContext(ctx, s.store)
+}
+
+func (s *SearchableStore) Apply(f ItemHandler) error {
+	return s.store.Apply(f)
+}
+
+func (s *SearchableStore) Delete(id ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *SearchableStore) DeleteContext(ctx context.Context, id ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := DeleteContext(ctx, s.store, id)
+	if err != nil {
+		return err
+	}
+
+	s.index.Remove(id)
+
+	return nil
+}
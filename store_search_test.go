@@ -0,0 +1,172 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "strings"
+import "testing"
+
+func TestSearchableStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type article struct {
+	Title  string
+	Body   string
+	Tags   []string
+	Views  int
+	secret string
+}
+
+func searchIDs(t *testing.T, s Searcher, q string) []ID {
+	t.Helper()
+
+	results, err := s.Search(q, 0)
+	assert.Expect(t, nil, err)
+
+	ids := []ID{}
+	for _, result := range results {
+		ids = append(ids, result.ID)
+	}
+
+	return ids
+}
+
+func TestExtractText(t *testing.T) {
+
+	text, err := ExtractText(&article{
+		Title:  "Title",
+		Body:   "Body",
+		Tags:   []string{"a", "b"},
+		Views:  7,
+		secret: "hidden",
+	})
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Title Body a b", text)
+
+	text, _ = ExtractText([]byte("bytes"))
+	assert.Expect(t, "bytes", text)
+
+	text, _ = ExtractText(42)
+	assert.Expect(t, "", text)
+
+	text, _ = ExtractText(nil)
+	assert.Expect(t, "", text)
+}
+
+func TestSearchableStore(t *testing.T) {
+
+	ms := NewMapStore()
+	ms.StoreItem("existing", "Indexed on creation.")
+
+	ss, err := NewSearchableStore(NewSyncStore(ms),
+		OptTextExtractor(article{}, func(obj Storable) (string, error) {
+			return strings.Repeat(obj.(article).Title+" ", 3), nil
+		}))
+	assert.Expect(t, nil, err)
+
+	assert.Expect(t, []ID{"existing"}, searchIDs(t, ss, "creation"))
+
+	ss.StoreItem("1", article{Title: "Ordered stores", Body: "Search"})
+	ss.StoreItem("2", "Searching stored documents.")
+
+	assert.Expect(t, []ID{"1", "2"}, searchIDs(t, ss, "store"))
+	assert.Expect(t, []ID{"2"}, searchIDs(t, ss, "search"))
+
+	err = Update(ss, "2", func(old Storable, exists bool) (Storable, error) {
+		return "Updated document.", nil
+	})
+	assert.Expect(t, nil, err)
+
+	assert.Expect(t, []ID{"1"}, searchIDs(t, ss, "store"))
+	assert.Expect(t, []ID{"2"}, searchIDs(t, ss, "update"))
+
+	ss.Delete("1")
+	assert.Expect(t, []ID{}, searchIDs(t, ss, "store"))
+
+	// Changes behind the wrapper are picked up by Reindex.
+	ms.StoreItem("3", "Stored directly.")
+	ms.Delete("2")
+	assert.Expect(t, nil, ss.Reindex())
+
+	assert.Expect(t, []ID{"3"}, searchIDs(t, ss, "store"))
+	assert.Expect(t, []ID{}, searchIDs(t, ss, "update"))
+}
+
+func TestSearchableStoreExtractError(t *testing.T) {
+
+	ss, _ := NewSearchableStore(NewMapStore(),
+		OptTextExtractor(0, func(obj Storable) (string, error) {
+			return "", ErrNotFound
+		}))
+
+	assert.Expect(t, ErrNotFound, ss.StoreItem("1", 1))
+
+	_, err := ss.Retrieve("1")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+type node struct {
+	Name string
+	Next *node
+	Tags map[string]interface{}
+}
+
+func TestExtractTextCycle(t *testing.T) {
+
+	n := &node{Name: "a", Tags: map[string]interface{}{}}
+	n.Next = &node{Name: "b", Next: n}
+	n.Tags["self"] = n.Tags
+
+	text, err := ExtractText(n)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "a b", text)
+
+	// Deep nesting is cut off rather than followed.
+	deep := &node{Name: "deep"}
+	for i := 0; i < 2*MaxExtractDepth; i++ {
+		deep = &node{Next: deep}
+	}
+
+	text, _ = ExtractText(deep)
+	assert.Expect(t, false, strings.Contains(text, "deep"))
+}
+
+func TestSearchDecorators(t *testing.T) {
+
+	ss, _ := NewSearchableStore(NewMapStore())
+	ss.StoreItem("acme/1", "Acme fox.")
+	ss.StoreItem("acme/2", "Acme fox, private.")
+	ss.StoreItem("initech/1", "Initech fox.")
+
+	ns, _ := NewNamespacedStore(ss, "acme")
+	assert.Expect(t, []ID{"1", "2"}, searchIDs(t, ns, "acme fox"))
+	assert.Expect(t, []ID{}, searchIDs(t, ns, "initech"))
+
+	results, err := ns.Search("fox", 1)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 1, len(results))
+
+	private := func(principal string, action Action, id ID, obj Storable) Decision {
+		if obj != nil && strings.Contains(obj.(string), "private") {
+			return Deny
+		}
+
+		return Abstain
+	}
+
+	as := NewAuthorizedStore(NewTracedStore(ns, &HookTracer{}, "mem"), NewRulePolicy(
+		private,
+		AllowAnyone(ActionRead, ActionList),
+	))
+	assert.Expect(t, []ID{"1"}, searchIDs(t, as, "fox"))
+
+	_, err = NewAuthorizedStore(ns, NewRulePolicy()).Search("fox", 0)
+	assert.Expect(t, ErrUnauthenticated, err)
+
+	_, err = NewAuthorizedStore(NewMapStore(), NewRulePolicy(
+		AllowAnyone(ActionList),
+	)).Search("fox", 0)
+	assert.Expect(t, ErrSearchUnsupported, err)
+}
@@ -0,0 +1,110 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "sync"
+
+// A store wrapper serializing access to a store that is not safe for
+// concurrent use, such as a MapStore shared by DataServer handlers.
+//
+// Handlers passed to Apply must not call back into the store.
+//
+type SyncStore struct {
+	store Store
+	mu    sync.RWMutex
+}
+
+func NewSyncStore(store Store) *SyncStore {
+	return &SyncStore{store: store}
+}
+
+func (s *SyncStore) StoreItem(id ID, obj Storable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.store.StoreItem(id, obj)
+}
+
+func (s *SyncStore) Retrieve(id ID) (Storable, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.Retrieve(id)
+}
+
+func (s *SyncStore) List() ([]ID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.List()
+}
+
+func (s *SyncStore) Search(query string, limit int) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return Search(s.store, query, limit)
+}
+
+func (s *SyncStore) Apply(f ItemHandler) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.Apply(f)
+}
+
+func (s *SyncStore) Delete(id ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.store.Delete(id)
+}
+
+// Store an item with its metadata, returning ErrMetaUnsupported when
+// the wrapped store is not a MetaStore.
+func (s *SyncStore) StoreItemWithMeta(id ID, obj Storable, meta Meta) error {
+	return s.StoreItemWithMetaContext(context.Background(), id, obj, meta)
+}
+
+func (s *SyncStore) StoreItemWithMetaContext(ctx context.Context, id ID, obj Storable, meta Meta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms, ok := s.store.(MetaStore)
+	if !ok {
+		return ErrMetaUnsupported
+	}
+
+	return StoreItemWithMetaContext(ctx, ms, id, obj, meta)
+}
+
+func (s *SyncStore) RetrieveMeta(id ID) (Storable, Meta, error) {
+	return s.RetrieveMetaContext(context.Background(), id)
+}
+
+func (s *SyncStore) RetrieveMetaContext(ctx context.Context, id ID) (Storable, Meta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ms, ok := s.store.(MetaStore); ok {
+		return RetrieveMetaContext(ctx, ms, id)
+	}
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+
+	return obj, Meta{}, err
+}
+
+func (s *SyncStore) Stat(id ID) (Meta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ms, ok := s.store.(MetaStore); ok {
+		return ms.Stat(id)
+	}
+
+	_, err := s.store.Retrieve(id)
+
+	return Meta{}, err
+}
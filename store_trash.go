@@ -0,0 +1,274 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "sync"
+import "time"
+
+// An item moved to the trash by a soft delete.
+type Trashed struct {
+	Time  time.Time
+	Value Storable
+}
+
+// Stores that keep deleted items in a trash until they are purged.
+type TrashStore interface {
+	Store
+	Restore(ID) error
+	ListTrash() ([]ID, error)
+	RetrieveTrash(ID) (Trashed, error)
+	Purge(ID) error
+}
+
+// Returned by stores wrapping a store which does not keep a trash.
+const ErrTrashUnsupported = StoreError("The store does not keep a trash.")
+
+// Returned by Restore when an item with the same ID exists.
+const ErrRestoreExists = StoreError("Can't restore over an existing item.")
+
+// TrashStores accepting a context, see ContextStore.
+type TrashContextStore interface {
+	TrashStore
+	RestoreContext(context.Context, ID) error
+	ListTrashContext(context.Context) ([]ID, error)
+	RetrieveTrashContext(context.Context, ID) (Trashed, error)
+	PurgeContext(context.Context, ID) error
+}
+
+// Restore an item using the context aware method when available.
+func RestoreContext(ctx context.Context, s TrashStore, id ID) error {
+	if tcs, ok := s.(TrashContextStore); ok {
+		return tcs.RestoreContext(ctx, id)
+	}
+
+	return s.Restore(id)
+}
+
+// List the trash using the context aware method when available.
+func ListTrashContext(ctx context.Context, s TrashStore) ([]ID, error) {
+	if tcs, ok := s.(TrashContextStore); ok {
+		return tcs.ListTrashContext(ctx)
+	}
+
+	return s.ListTrash()
+}
+
+// Retrieve a trashed item using the context aware method when
+// available.
+func RetrieveTrashContext(ctx context.Context, s TrashStore, id ID) (Trashed, error) {
+	if tcs, ok := s.(TrashContextStore); ok {
+		return tcs.RetrieveTrashContext(ctx, id)
+	}
+
+	return s.RetrieveTrash(id)
+}
+
+// Purge a trashed item using the context aware method when available.
+func PurgeContext(ctx context.Context, s TrashStore, id ID) error {
+	if tcs, ok := s.(TrashContextStore); ok {
+		return tcs.PurgeContext(ctx, id)
+	}
+
+	return s.Purge(id)
+}
+
+type SoftDeleteStoreOpt func(*SoftDeleteStore)
+
+// Purge items which have been in the trash for longer than d.
+//
+// Expired items are purged by PurgeExpired.
+//
+func OptTrashRetention(d time.Duration) SoftDeleteStoreOpt {
+	return func(s *SoftDeleteStore) {
+		s.retention = d
+	}
+}
+
+// A store wrapper which moves deleted items to a trash store.
+//
+// Deleted items are hidden from Retrieve, List and Apply until they
+// are restored.
+//
+type SoftDeleteStore struct {
+	store     Store
+	trash     Store
+	retention time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewSoftDeleteStore(store, trash Store, opts ...SoftDeleteStoreOpt) *SoftDeleteStore {
+
+	s := &SoftDeleteStore{
+		store: store,
+		trash: trash,
+		now:   time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *SoftDeleteStore) StoreItem(id ID, obj Storable) error {
+	return s.store.StoreItem(id, obj)
+}
+
+func (s *SoftDeleteStore) Retrieve(id ID) (Storable, error) {
+	return s.store.Retrieve(id)
+}
+
+func (s *SoftDeleteStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *SoftDeleteStore) Search(query string, limit int) ([]SearchResult, error) {
+	return Search(s.store, query, limit)
+}
+
+func (s *SoftDeleteStore) Apply(f ItemHandler) error {
+	return s.store.Apply(f)
+}
+
+// Move an item to the trash.
+//
+// An earlier deleted item with the same ID is replaced in the trash.
+//
+func (s *SoftDeleteStore) Delete(id ID) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, err := s.store.Retrieve(id)
+	if err == ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = s.trash.StoreItem(id, Trashed{s.now(), obj})
+	if err != nil {
+		return err
+	}
+
+	return s.store.Delete(id)
+}
+
+// Move an item from the trash back into the store.
+//
+// Items are not restored over an existing item with the same ID,
+// ErrRestoreExists is returned instead.
+//
+func (s *SoftDeleteStore) Restore(id ID) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.retrieveTrash(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.Retrieve(id)
+	if err == nil {
+		return ErrRestoreExists
+	}
+
+	if err != ErrNotFound {
+		return err
+	}
+
+	err = s.store.StoreItem(id, t.Value)
+	if err != nil {
+		return err
+	}
+
+	return s.trash.Delete(id)
+}
+
+func (s *SoftDeleteStore) ListTrash() ([]ID, error) {
+	return s.trash.List()
+}
+
+func (s *SoftDeleteStore) RetrieveTrash(id ID) (Trashed, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.retrieveTrash(id)
+}
+
+func (s *SoftDeleteStore) retrieveTrash(id ID) (Trashed, error) {
+
+	obj, err := s.trash.Retrieve(id)
+	if err != nil {
+		return Trashed{}, err
+	}
+
+	t, ok := obj.(Trashed)
+	if !ok {
+		return Trashed{}, NewStoreError("The trashed item " + (string)(id) + " is invalid.")
+	}
+
+	return t, nil
+}
+
+// Permanently delete an item from the trash.
+func (s *SoftDeleteStore) Purge(id ID) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.trash.Retrieve(id)
+	if err != nil {
+		return err
+	}
+
+	return s.trash.Delete(id)
+}
+
+// Permanently delete items that have outlived the trash retention
+// period, returning the number of items purged.
+func (s *SoftDeleteStore) PurgeExpired() (int, error) {
+
+	if s.retention <= 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.trash.List()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := s.now().Add(-s.retention)
+	n := 0
+
+	for _, id := range ids {
+
+		t, err := s.retrieveTrash(id)
+		if err != nil {
+			return n, err
+		}
+
+		if !t.Time.Before(cutoff) {
+			continue
+		}
+
+		err = s.trash.Delete(id)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,100 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "time"
+
+func TestTrashStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestSoftDeleteStore(t *testing.T) {
+
+	var ts TrashStore = NewSoftDeleteStore(NewMapStore(), NewMapStore())
+
+	ts.StoreItem("1", "one")
+	ts.StoreItem("2", "two")
+
+	err := ts.Delete("1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = ts.Retrieve("1")
+	assert.Expect(t, ErrNotFound, err)
+
+	ids, _ := ts.List()
+	assert.Expect(t, []ID{"2"}, ids)
+
+	ids, _ = ts.ListTrash()
+	assert.Expect(t, []ID{"1"}, ids)
+
+	tr, err := ts.RetrieveTrash("1")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, "one", tr.Value)
+
+	err = ts.Restore("1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	obj, err := ts.Retrieve("1")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, "one", obj)
+
+	ids, _ = ts.ListTrash()
+	assert.Expect(t, 0, len(ids))
+
+	err = ts.Restore("1")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestSoftDeleteStoreRestoreConflict(t *testing.T) {
+
+	ts := NewSoftDeleteStore(NewMapStore(), NewMapStore())
+
+	ts.StoreItem("1", "one")
+	ts.Delete("1")
+	ts.StoreItem("1", "uno")
+
+	err := ts.Restore("1")
+	assert.Expect(t, ErrRestoreExists, err)
+
+	err = ts.Purge("1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = ts.RetrieveTrash("1")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestSoftDeleteStorePurgeExpired(t *testing.T) {
+
+	now := time.Now()
+
+	ts := NewSoftDeleteStore(NewMapStore(), NewMapStore(), OptTrashRetention(time.Hour))
+	ts.StoreItem("1", "one")
+	ts.StoreItem("2", "two")
+
+	ts.now = func() time.Time { return now.Add(-2 * time.Hour) }
+	ts.Delete("1")
+	ts.now = func() time.Time { return now }
+	ts.Delete("2")
+
+	n, err := ts.PurgeExpired()
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 1, n)
+
+	ids, _ := ts.ListTrash()
+	assert.Expect(t, []ID{"2"}, ids)
+}
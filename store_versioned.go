@@ -0,0 +1,345 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "sync"
+import "time"
+
+// A single revision of an item.
+//
+// Value is encoded along with the revision so that history stores
+// which encode their items keep it.  Codecs decoding revisions without
+// a prototype for Value, such as JSON, return it as a generic value.
+//
+type Revision struct {
+	Rev     int       `json:"rev"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author,omitempty"`
+	Deleted bool      `json:"deleted,omitempty"` // The item was deleted in this revision
+	Value   Storable  `json:"value,omitempty"`
+}
+
+// Stores that keep the revision history of their items.
+type RevisionStore interface {
+	Store
+	History(ID) ([]Revision, error)
+	RetrieveRevision(ID, int) (Storable, error)
+	Revert(ID, int) error
+}
+
+// Returned by stores wrapping a store which does not keep revisions.
+const ErrRevisionsUnsupported = StoreError("The store does not keep revisions.")
+
+// RevisionStores accepting a context, see ContextStore.
+type RevisionContextStore interface {
+	RevisionStore
+	HistoryContext(context.Context, ID) ([]Revision, error)
+	RetrieveRevisionContext(context.Context, ID, int) (Storable, error)
+	RevertContext(context.Context, ID, int) error
+}
+
+// List the revisions of an item using the context aware method when
+// available.
+func HistoryContext(ctx context.Context, s RevisionStore, id ID) ([]Revision, error) {
+	if rcs, ok := s.(RevisionContextStore); ok {
+		return rcs.HistoryContext(ctx, id)
+	}
+
+	return s.History(id)
+}
+
+// Retrieve a revision of an item using the context aware method when
+// available.
+func RetrieveRevisionContext(ctx context.Context, s RevisionStore, id ID, rev int) (Storable, error) {
+	if rcs, ok := s.(RevisionContextStore); ok {
+		return rcs.RetrieveRevisionContext(ctx, id, rev)
+	}
+
+	return s.RetrieveRevision(id, rev)
+}
+
+// Revert an item using the context aware method when available.
+func RevertContext(ctx context.Context, s RevisionStore, id ID, rev int) error {
+	if rcs, ok := s.(RevisionContextStore); ok {
+		return rcs.RevertContext(ctx, id, rev)
+	}
+
+	return s.Revert(id, rev)
+}
+
+type VersionedStoreOpt func(*VersionedStore)
+
+// Keep at most n revisions of each item.
+func OptMaxRevisions(n int) VersionedStoreOpt {
+	return func(s *VersionedStore) {
+		s.maxRevs = n
+	}
+}
+
+// Discard revisions older than d.
+//
+// The latest revision of an item is always kept.
+//
+func OptMaxRevisionAge(d time.Duration) VersionedStoreOpt {
+	return func(s *VersionedStore) {
+		s.maxAge = d
+	}
+}
+
+// A store wrapper which keeps every revision of its items.
+//
+// The current value of each item is kept in store while the list of
+// revisions for the item is kept in history under the same ID.
+// Deleting an item records a deletion revision, the item can be
+// brought back with Revert.  The context aware methods record the
+// principal of their context as the author of the revision.
+//
+type VersionedStore struct {
+	store   Store
+	history Store
+	maxRevs int
+	maxAge  time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func NewVersionedStore(store, history Store, opts ...VersionedStoreOpt) *VersionedStore {
+
+	s := &VersionedStore{
+		store:   store,
+		history: history,
+		now:     time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *VersionedStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemBy(id, obj, "")
+}
+
+// Store an item, recording the principal of ctx as the author of the
+// new revision, see WithPrincipal.
+func (s *VersionedStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+	return s.storeItem(ctx, id, obj, Principal(ctx))
+}
+
+// Store an item, recording author in the new revision.
+func (s *VersionedStore) StoreItemBy(id ID, obj Storable, author string) error {
+	return s.storeItem(context.Background(), id, obj, author)
+}
+
+func (s *VersionedStore) storeItem(ctx context.Context, id ID, obj Storable, author string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(ctx, id, Revision{Author: author, Value: obj})
+}
+
+func (s *VersionedStore) Retrieve(id ID) (Storable, error) {
+	return s.store.Retrieve(id)
+}
+
+func (s *VersionedStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+	return RetrieveContext(ctx, s.store, id)
+}
+
+func (s *VersionedStore) List() ([]ID, error) {
+	return s.store.List()
+}
+
+func (s *VersionedStore) ListContext(ctx context.Context) ([]ID, error) {
+	return ListContext(ctx, s.store)
+}
+
+func (s *VersionedStore) Search(query string, limit int) ([]SearchResult, error) {
+	return Search(s.store, query, limit)
+}
+
+func (s *VersionedStore) Apply(f ItemHandler) error {
+	return s.store.Apply(f)
+}
+
+func (s *VersionedStore) Delete(id ID) error {
+	return s.DeleteBy(id, "")
+}
+
+// Delete an item, recording the principal of ctx as the author of the
+// deletion revision.
+func (s *VersionedStore) DeleteContext(ctx context.Context, id ID) error {
+	return s.deleteItem(ctx, id, Principal(ctx))
+}
+
+// Delete an item, recording author in the deletion revision.
+func (s *VersionedStore) DeleteBy(id ID, author string) error {
+	return s.deleteItem(context.Background(), id, author)
+}
+
+func (s *VersionedStore) deleteItem(ctx context.Context, id ID, author string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(ctx, id, Revision{Author: author, Deleted: true})
+}
+
+// List the retained revisions of an item, oldest first.
+func (s *VersionedStore) History(id ID) ([]Revision, error) {
+	return s.HistoryContext(context.Background(), id)
+}
+
+func (s *VersionedStore) HistoryContext(ctx context.Context, id ID) ([]Revision, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revs, err := s.revisions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return append([]Revision{}, revs...), nil
+}
+
+func (s *VersionedStore) RetrieveRevision(id ID, rev int) (Storable, error) {
+	return s.RetrieveRevisionContext(context.Background(), id, rev)
+}
+
+func (s *VersionedStore) RetrieveRevisionContext(ctx context.Context, id ID, rev int) (Storable, error) {
+
+	r, err := s.revision(id, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Value, nil
+}
+
+// Restore an item to the value it had at revision rev.
+//
+// The restored value is recorded as a new revision, without an author.
+//
+func (s *VersionedStore) Revert(id ID, rev int) error {
+	return s.RevertContext(context.Background(), id, rev)
+}
+
+// Revert an item, recording the principal of ctx as the author of the
+// new revision.
+func (s *VersionedStore) RevertContext(ctx context.Context, id ID, rev int) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.find(id, rev)
+	if err != nil {
+		return err
+	}
+
+	return s.record(ctx, id, Revision{Author: Principal(ctx), Value: r.Value})
+}
+
+func (s *VersionedStore) revision(id ID, rev int) (Revision, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.find(id, rev)
+}
+
+func (s *VersionedStore) find(id ID, rev int) (Revision, error) {
+
+	revs, err := s.revisions(id)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	for _, r := range revs {
+		if r.Rev == rev {
+			if r.Deleted {
+				return Revision{}, ErrNotFound
+			}
+
+			return r, nil
+		}
+	}
+
+	return Revision{}, ErrNotFound
+}
+
+func (s *VersionedStore) revisions(id ID) ([]Revision, error) {
+
+	obj, err := s.history.Retrieve(id)
+	if err == ErrNotFound {
+		return []Revision{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	revs, ok := obj.([]Revision)
+	if !ok {
+		return nil, NewStoreError("The history of " + (string)(id) + " is not a revision list.")
+	}
+
+	return revs, nil
+}
+
+// Append a revision to the history and update the current value.
+//
+// Must be called with the lock held.
+//
+func (s *VersionedStore) record(ctx context.Context, id ID, r Revision) error {
+
+	revs, err := s.revisions(id)
+	if err != nil {
+		return err
+	}
+
+	r.Rev = 1
+	if len(revs) > 0 {
+		r.Rev = revs[len(revs)-1].Rev + 1
+	}
+
+	r.Time = s.now()
+
+	revs = s.prune(append(append([]Revision{}, revs...), r))
+
+	if r.Deleted {
+		err = DeleteContext(ctx, s.store, id)
+	} else {
+		err = StoreItemContext(ctx, s.store, id, r.Value)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return StoreItemContext(ctx, s.history, id, revs)
+}
+
+func (s *VersionedStore) prune(revs []Revision) []Revision {
+
+	if s.maxRevs > 0 && len(revs) > s.maxRevs {
+		revs = revs[len(revs)-s.maxRevs:]
+	}
+
+	if s.maxAge > 0 {
+		cutoff := s.now().Add(-s.maxAge)
+		for len(revs) > 1 && revs[0].Time.Before(cutoff) {
+			revs = revs[1:]
+		}
+	}
+
+	return revs
+}
@@ -0,0 +1,160 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "io/ioutil"
+import "os"
+import "testing"
+import "time"
+
+func TestVersionedStoreTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestVersionedStoreHistory(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore())
+
+	vs.StoreItemBy("1", "one", "alice")
+	vs.StoreItemBy("1", "uno", "bob")
+	vs.DeleteBy("1", "carol")
+
+	_, err := vs.Retrieve("1")
+	assert.Expect(t, ErrNotFound, err)
+
+	revs, err := vs.History("1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 3, len(revs))
+	assert.Expect(t, "alice", revs[0].Author)
+	assert.Expect(t, 2, revs[1].Rev)
+	assert.Expect(t, true, revs[2].Deleted)
+
+	obj, err := vs.RetrieveRevision("1", 1)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, "one", obj)
+
+	_, err = vs.RetrieveRevision("1", 3)
+	assert.Expect(t, ErrNotFound, err)
+
+	_, err = vs.History("2")
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestVersionedStoreRevert(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore())
+
+	vs.StoreItem("1", "one")
+	vs.StoreItem("1", "two")
+	vs.Delete("1")
+
+	err := vs.Revert("1", 1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	obj, err := vs.Retrieve("1")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Expect(t, "one", obj)
+
+	revs, _ := vs.History("1")
+	assert.Expect(t, 4, revs[3].Rev)
+
+	err = vs.Revert("1", 3)
+	assert.Expect(t, ErrNotFound, err)
+}
+
+func TestVersionedStoreContextAuthor(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore())
+
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	assert.Expect(t, nil, StoreItemContext(alice, vs, "1", "one"))
+	assert.Expect(t, nil, UpdateContext(bob, vs, "1", func(old Storable, exists bool) (Storable, error) {
+		return "two", nil
+	}))
+	assert.Expect(t, nil, DeleteContext(alice, vs, "1"))
+	assert.Expect(t, nil, RevertContext(bob, vs, "1", 1))
+
+	revs, err := vs.History("1")
+	assert.Expect(t, nil, err)
+
+	authors := []string{}
+	for _, r := range revs {
+		authors = append(authors, r.Author)
+	}
+	assert.Expect(t, []string{"alice", "bob", "alice", "bob"}, authors)
+	assert.Expect(t, "one", revs[3].Value)
+}
+
+func TestVersionedStoreRetention(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore(), OptMaxRevisions(2))
+	for _, s := range []string{"a", "b", "c"} {
+		vs.StoreItem("1", s)
+	}
+
+	revs, _ := vs.History("1")
+	assert.Expect(t, 2, len(revs))
+	assert.Expect(t, 2, revs[0].Rev)
+
+	now := time.Now()
+	vs = NewVersionedStore(NewMapStore(), NewMapStore(), OptMaxRevisionAge(time.Hour))
+	vs.now = func() time.Time { return now.Add(-2 * time.Hour) }
+	vs.StoreItem("1", "a")
+	vs.now = func() time.Time { return now }
+	vs.StoreItem("1", "b")
+
+	revs, _ = vs.History("1")
+	assert.Expect(t, 1, len(revs))
+	assert.Expect(t, "b", revs[0].Value)
+}
+
+func TestVersionedStorePersistentHistory(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	c := NewJSONCodec(OptJSONPrototype([]Revision{}))
+
+	open := func() *PersistentMapStore {
+		ps, err := NewPersistentMapStore(dir, c.Encode, c.Decode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ps
+	}
+
+	history := open()
+	vs := NewVersionedStore(NewMapStore(), history)
+	vs.StoreItem("1", "a")
+	vs.StoreItem("1", "b")
+	history.Close()
+
+	// Values survive being encoded with their revisions.
+	vs = NewVersionedStore(NewMapStore(), open())
+
+	obj, err := vs.RetrieveRevision("1", 1)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "a", obj)
+
+	assert.Expect(t, nil, vs.Revert("1", 1))
+	obj, _ = vs.Retrieve("1")
+	assert.Expect(t, "a", obj)
+}
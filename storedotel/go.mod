@@ -0,0 +1,11 @@
+module kilobit.ca/go/stored/storedotel
+
+// OpenTelemetry v1.19 requires Go 1.20, the stored module itself
+// supports Go 1.13.
+go 1.20
+
+require (
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+	kilobit.ca/go/stored v0.1.0
+)
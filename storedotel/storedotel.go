@@ -0,0 +1,116 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+// An OpenTelemetry adapter for the stored tracing hooks.
+//
+// This package is a separate module so that the core packages do not
+// depend on OpenTelemetry.
+//
+//	tracer := storedotel.NewTracer(otel.Tracer("stored"))
+//	hs := stored.NewHttpStore(..., stored.OptUseTracer(tracer))
+//
+package storedotel // import "kilobit.ca/go/stored/storedotel"
+
+import "kilobit.ca/go/stored"
+import "context"
+import "fmt"
+import "go.opentelemetry.io/otel/attribute"
+import "go.opentelemetry.io/otel/codes"
+import "go.opentelemetry.io/otel/trace"
+
+// A stored.Tracer starting OpenTelemetry spans.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+func NewTracer(t trace.Tracer) *Tracer {
+	return &Tracer{t}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...stored.Attr) (context.Context, stored.Span) {
+
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		if sc, ok := stored.RemoteSpanContext(ctx); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, ToOTel(sc))
+		}
+	}
+
+	ctx, s := t.tracer.Start(ctx, name, trace.WithAttributes(Attributes(attrs)...))
+	span := &Span{s}
+
+	return stored.ContextWithSpan(ctx, span), span
+}
+
+// A stored.Span wrapping an OpenTelemetry span.
+type Span struct {
+	trace.Span
+}
+
+func (s *Span) SpanContext() stored.SpanContext {
+	return FromOTel(s.Span.SpanContext())
+}
+
+func (s *Span) SetAttributes(attrs ...stored.Attr) {
+	s.Span.SetAttributes(Attributes(attrs)...)
+}
+
+// End the span, recording err and an error status when err is not
+// nil.
+func (s *Span) End(err error) {
+	if err != nil {
+		s.Span.RecordError(err)
+		s.Span.SetStatus(codes.Error, err.Error())
+	}
+
+	s.Span.End()
+}
+
+func ToOTel(sc stored.SpanContext) trace.SpanContext {
+
+	flags := trace.TraceFlags(0)
+	if sc.Sampled {
+		flags = trace.FlagsSampled
+	}
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    sc.TraceID,
+		SpanID:     sc.SpanID,
+		TraceFlags: flags,
+		Remote:     true,
+	})
+}
+
+func FromOTel(sc trace.SpanContext) stored.SpanContext {
+	return stored.SpanContext{
+		TraceID: sc.TraceID(),
+		SpanID:  sc.SpanID(),
+		Sampled: sc.IsSampled(),
+	}
+}
+
+// Convert attributes, formatting values of unsupported types as
+// strings.
+func Attributes(attrs []stored.Attr) []attribute.KeyValue {
+
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(a.Key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(a.Key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(a.Key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(a.Key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(a.Key, v))
+		case []string:
+			kvs = append(kvs, attribute.StringSlice(a.Key, v))
+		default:
+			kvs = append(kvs, attribute.String(a.Key, fmt.Sprint(v)))
+		}
+	}
+
+	return kvs
+}
@@ -0,0 +1,57 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package storedotel // import "kilobit.ca/go/stored/storedotel"
+
+import "kilobit.ca/go/stored"
+import "context"
+import "net/http"
+import "testing"
+import "go.opentelemetry.io/otel/attribute"
+import "go.opentelemetry.io/otel/trace"
+
+func TestRemoteParent(t *testing.T) {
+
+	sc, err := stored.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := NewTracer(trace.NewNoopTracerProvider().Tracer("test"))
+
+	h := http.Header{}
+	h.Set(stored.TraceparentHeader, sc.Traceparent())
+	ctx := stored.ExtractTraceparent(context.Background(), h)
+
+	// The no-op tracer continues the span context of the parent.
+	ctx, span := tracer.Start(ctx, "test")
+	defer span.End(nil)
+
+	if span.SpanContext() != sc {
+		t.Errorf("Expected span context %v, got %v", sc, span.SpanContext())
+	}
+
+	if stored.SpanFromContext(ctx) != span {
+		t.Error("The span was not attached to the context.")
+	}
+}
+
+func TestAttributes(t *testing.T) {
+
+	kvs := Attributes([]stored.Attr{
+		{Key: "s", Value: "v"},
+		{Key: "i", Value: 1},
+		{Key: "id", Value: stored.ID("1")},
+	})
+
+	exp := []attribute.KeyValue{
+		attribute.String("s", "v"),
+		attribute.Int("i", 1),
+		attribute.String("id", "1"),
+	}
+
+	for i := range exp {
+		if kvs[i] != exp[i] {
+			t.Errorf("Expected %v, got %v", exp[i], kvs[i])
+		}
+	}
+}
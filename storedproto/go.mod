@@ -0,0 +1,10 @@
+module kilobit.ca/go/stored/storedproto
+
+// google.golang.org/protobuf v1.36 requires Go 1.23, the stored
+// module itself supports Go 1.13.
+go 1.23
+
+require (
+	google.golang.org/protobuf v1.36.11
+	kilobit.ca/go/stored v0.1.0
+)
@@ -0,0 +1,261 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+// Protocol Buffers codecs for stored, binary and protojson.
+//
+// This package is a separate module so that the core packages do not
+// depend on the protobuf runtime.
+//
+//	codecs := storedproto.Codecs(&pb.Order{})
+//	ds := www.NewDataServer("/orders", store, stored.UUIDv4Gen(),
+//		www.OptSetCodecs(codecs))
+//	hs := stored.NewCodecHttpStore(url, codecs, storedproto.MediaType)
+//
+package storedproto // import "kilobit.ca/go/stored/storedproto"
+
+import "kilobit.ca/go/stored"
+import "io"
+import "io/ioutil"
+import "mime"
+import "strings"
+import "google.golang.org/protobuf/encoding/protojson"
+import "google.golang.org/protobuf/proto"
+import "google.golang.org/protobuf/reflect/protoreflect"
+import "google.golang.org/protobuf/reflect/protoregistry"
+
+type ProtoError string
+
+func (err ProtoError) Error() string {
+	return (string)(err)
+}
+
+// Returned when encoding a value which is not a proto.Message.
+const ErrNotMessage = ProtoError("The value is not a protocol buffer message.")
+
+// Returned for messages of a type other than that of the codec.
+const ErrWrongMessageType = ProtoError("The message is not of the codec's type.")
+
+// Returned when decoding without a prototype or a message type.
+const ErrNoMessageType = ProtoError("The message type is unknown.")
+
+// The media type of the binary codec.
+const MediaType = "application/x-protobuf"
+
+// The media type of the protojson codec.
+const JSONMediaType = stored.JSONMediaType
+
+// The media type parameter holding the full name of the message type,
+// as in application/x-protobuf; messageType=shop.Order.  Parameter
+// names are not case sensitive.
+const MessageTypeParam = "messageType"
+
+type Opt func(*Codec)
+
+// Find message types named by media types when decoding without a
+// prototype, by default protoregistry.GlobalTypes.
+func OptResolver(r protoregistry.MessageTypeResolver) Opt {
+	return func(c *Codec) {
+		c.resolver = r
+	}
+}
+
+func OptJSONMarshal(m protojson.MarshalOptions) Opt {
+	return func(c *Codec) {
+		c.jsonMarshal = m
+	}
+}
+
+func OptJSONUnmarshal(u protojson.UnmarshalOptions) Opt {
+	return func(c *Codec) {
+		c.jsonUnmarshal = u
+	}
+}
+
+// A stored.MediaTypeCodec for proto.Message values.
+//
+// Messages decode to new messages of the type of the prototype.
+// Encoded media types name the message type, and when decoding a
+// media type naming another type is an error.  Without a prototype
+// messages decode only to the type named by the media type.
+//
+type Codec struct {
+	msg           protoreflect.MessageType
+	json          bool
+	resolver      protoregistry.MessageTypeResolver
+	jsonMarshal   protojson.MarshalOptions
+	jsonUnmarshal protojson.UnmarshalOptions
+}
+
+func newCodec(msg proto.Message, json bool, opts ...Opt) *Codec {
+
+	c := &Codec{json: json, resolver: protoregistry.GlobalTypes}
+
+	if msg != nil {
+		c.msg = msg.ProtoReflect().Type()
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// A codec for the binary wire format, decoding to the type of msg.
+func NewCodec(msg proto.Message, opts ...Opt) *Codec {
+	return newCodec(msg, false, opts...)
+}
+
+// A codec for the protojson format, decoding to the type of msg.
+func NewJSONCodec(msg proto.Message, opts ...Opt) *Codec {
+	return newCodec(msg, true, opts...)
+}
+
+// A registry with the binary and protojson codecs for msg, with the
+// binary format preferred, and the default list codecs.
+func Codecs(msg proto.Message, opts ...Opt) *stored.CodecRegistry {
+
+	r := stored.DefaultCodecs()
+	r.Register(NewJSONCodec(msg, opts...))
+
+	codecs := stored.NewCodecRegistry(NewCodec(msg, opts...))
+	for _, t := range r.MediaTypes() {
+		c, _ := r.Codec(t)
+		codecs.Register(c)
+	}
+
+	for _, t := range r.ListMediaTypes() {
+		c, _ := r.ListCodec(t)
+		codecs.RegisterList(c)
+	}
+
+	return codecs
+}
+
+func (c *Codec) MediaType() string {
+	if c.json {
+		return JSONMediaType
+	}
+
+	return MediaType
+}
+
+func (c *Codec) message(obj stored.Storable) (proto.Message, error) {
+
+	m, ok := obj.(proto.Message)
+	if !ok {
+		return nil, ErrNotMessage
+	}
+
+	if c.msg != nil && m.ProtoReflect().Descriptor().FullName() != c.msg.Descriptor().FullName() {
+		return nil, ErrWrongMessageType
+	}
+
+	return m, nil
+}
+
+func (c *Codec) Encode(obj stored.Storable) ([]byte, error) {
+
+	m, err := c.message(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.json {
+		return c.jsonMarshal.Marshal(m)
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+// Encode a message, returning the media type with the name of its
+// type.
+func (c *Codec) EncodeMediaType(obj stored.Storable) (string, []byte, error) {
+
+	bs, err := c.Encode(obj)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Full names are tokens, needing no quotes.
+	name := obj.(proto.Message).ProtoReflect().Descriptor().FullName()
+
+	return c.MediaType() + "; " + MessageTypeParam + "=" + (string)(name), bs, nil
+}
+
+func (c *Codec) decode(mt protoreflect.MessageType, bs []byte) (stored.Storable, error) {
+
+	if mt == nil {
+		return nil, ErrNoMessageType
+	}
+
+	m := mt.New().Interface()
+
+	var err error
+	if c.json {
+		err = c.jsonUnmarshal.Unmarshal(bs, m)
+	} else {
+		err = proto.Unmarshal(bs, m)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (c *Codec) Decode(bs []byte) (stored.Storable, error) {
+	return c.decode(c.msg, bs)
+}
+
+// Decode a message of the type named by media type t, or of the type
+// of the prototype.
+func (c *Codec) DecodeMediaType(t string, bs []byte) (stored.Storable, error) {
+
+	_, params, _ := mime.ParseMediaType(t)
+
+	name, ok := params[strings.ToLower(MessageTypeParam)]
+	if !ok {
+		return c.Decode(bs)
+	}
+
+	if c.msg != nil {
+		if (string)(c.msg.Descriptor().FullName()) != name {
+			return nil, ErrWrongMessageType
+		}
+
+		return c.Decode(bs)
+	}
+
+	mt, err := c.resolver.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, ErrNoMessageType
+	}
+
+	return c.decode(mt, bs)
+}
+
+func (c *Codec) EncodeTo(w io.Writer, obj stored.Storable) error {
+
+	bs, err := c.Encode(obj)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(bs)
+
+	return err
+}
+
+// Decode a message from the rest of r.
+func (c *Codec) DecodeFrom(r io.Reader) (stored.Storable, error) {
+
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Decode(bs)
+}
+
+var _ stored.MediaTypeCodec = &Codec{}
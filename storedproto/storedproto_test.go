@@ -0,0 +1,171 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package storedproto // import "kilobit.ca/go/stored/storedproto"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/stored/storetest"
+import "kilobit.ca/go/stored/www"
+import "fmt"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "testing"
+import "google.golang.org/protobuf/proto"
+import "google.golang.org/protobuf/types/known/timestamppb"
+import "google.golang.org/protobuf/types/known/wrapperspb"
+
+func TestCodec(t *testing.T) {
+
+	for _, c := range []*Codec{NewCodec(&wrapperspb.StringValue{}), NewJSONCodec(&wrapperspb.StringValue{})} {
+
+		mt, bs, err := c.EncodeMediaType(wrapperspb.String("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := c.MediaType() + "; messageType=google.protobuf.StringValue"
+		if mt != exp {
+			t.Errorf("Expected %q, got %q.", exp, mt)
+		}
+
+		obj, err := c.DecodeMediaType(mt, bs)
+		if err != nil || !proto.Equal(wrapperspb.String("hello"), obj.(proto.Message)) {
+			t.Errorf("Decoded %v, %v.", obj, err)
+		}
+
+		obj, err = c.Decode(bs)
+		if err != nil || !proto.Equal(wrapperspb.String("hello"), obj.(proto.Message)) {
+			t.Errorf("Decoded %v, %v.", obj, err)
+		}
+
+		_, err = c.Encode("hello")
+		if err != ErrNotMessage {
+			t.Errorf("Expected ErrNotMessage, got %v.", err)
+		}
+
+		_, err = c.Encode(timestamppb.Now())
+		if err != ErrWrongMessageType {
+			t.Errorf("Expected ErrWrongMessageType, got %v.", err)
+		}
+
+		_, err = c.DecodeMediaType(c.MediaType()+"; messageType=google.protobuf.Timestamp", bs)
+		if err != ErrWrongMessageType {
+			t.Errorf("Expected ErrWrongMessageType, got %v.", err)
+		}
+	}
+
+	bs, err := NewJSONCodec(&wrapperspb.StringValue{}).Encode(wrapperspb.String("hello"))
+	if err != nil || string(bs) != `"hello"` {
+		t.Errorf("Encoded %q, %v.", bs, err)
+	}
+}
+
+func TestCodecResolver(t *testing.T) {
+
+	c := NewCodec(nil)
+
+	ts := timestamppb.New(timestamppb.Now().AsTime())
+
+	mt, bs, err := c.EncodeMediaType(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := c.DecodeMediaType(mt, bs)
+	if err != nil || !proto.Equal(ts, obj.(proto.Message)) {
+		t.Errorf("Decoded %v, %v.", obj, err)
+	}
+
+	_, err = c.Decode(bs)
+	if err != ErrNoMessageType {
+		t.Errorf("Expected ErrNoMessageType, got %v.", err)
+	}
+
+	_, err = c.DecodeMediaType(MediaType+"; messageType=missing.Type", bs)
+	if err != ErrNoMessageType {
+		t.Errorf("Expected ErrNoMessageType, got %v.", err)
+	}
+}
+
+func TestDataServer(t *testing.T) {
+
+	codecs := Codecs(&wrapperspb.StringValue{})
+
+	ds := www.NewDataServer("/test", stored.NewMapStore(), stored.IncrIDGen(),
+		www.OptSetCodecs(codecs),
+		www.OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest("PUT", "/test/1", strings.NewReader(`"hello"`))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d, %s.", res.Code, res.Body)
+	}
+
+	req = httptest.NewRequest("PUT", "/test/2", strings.NewReader(`"2019-05-01T00:00:00Z"`))
+	req.Header.Set("Content-Type", "application/json; messageType=google.protobuf.Timestamp")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d.", res.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/test/1", nil)
+	req.Header.Set("Accept", MediaType)
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	exp := MediaType + "; messageType=google.protobuf.StringValue"
+	if ct := res.Header().Get("Content-Type"); ct != exp {
+		t.Errorf("Expected %q, got %q.", exp, ct)
+	}
+
+	bs, _ := proto.Marshal(wrapperspb.String("hello"))
+	if res.Body.String() != string(bs) {
+		t.Errorf("Expected %q, got %q.", bs, res.Body)
+	}
+}
+
+func TestConformance(t *testing.T) {
+
+	codecs := Codecs(&wrapperspb.StringValue{})
+
+	for _, mt := range []string{MediaType, JSONMediaType} {
+		mt := mt
+
+		t.Run(mt, func(t *testing.T) {
+			storetest.RunConformance(t, func() (stored.Store, func()) {
+
+				ds := www.NewDataServer(
+					"/",
+					stored.NewSyncStore(stored.NewMapStore()),
+					stored.IncrIDGen(),
+					www.OptSetCodecs(codecs),
+					www.OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+				)
+
+				srv := httptest.NewServer(ds)
+
+				hs := stored.NewCodecHttpStore(srv.URL, codecs, mt,
+					stored.OptUseClient(srv.Client()))
+
+				return hs, srv.Close
+			},
+				storetest.OptValues(func(i int) stored.Storable {
+					return wrapperspb.String(fmt.Sprintf("value-%d", i))
+				}),
+				storetest.OptEqual(func(a, b stored.Storable) bool {
+					am, ok := a.(proto.Message)
+					bm, ok2 := b.(proto.Message)
+					return ok && ok2 && proto.Equal(am, bm)
+				}),
+			)
+		})
+	}
+}
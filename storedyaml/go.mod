@@ -0,0 +1,8 @@
+module kilobit.ca/go/stored/storedyaml
+
+go 1.13
+
+require (
+	gopkg.in/yaml.v3 v3.0.1
+	kilobit.ca/go/stored v0.1.0
+)
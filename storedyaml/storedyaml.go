@@ -0,0 +1,87 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+// A YAML codec for stored, using gopkg.in/yaml.v3.
+//
+// This package is a separate module so that the core packages do not
+// depend on a YAML parser.
+//
+//	codecs := stored.StandardCodecs(Order{})
+//	codecs.Register(storedyaml.NewCodec(Order{}))
+//
+package storedyaml // import "kilobit.ca/go/stored/storedyaml"
+
+import "kilobit.ca/go/stored"
+import "bytes"
+import "io"
+import "reflect"
+import "gopkg.in/yaml.v3"
+
+// The media type of the YAML codec, RFC 9512.
+const MediaType = "application/yaml"
+
+type codec struct {
+	proto reflect.Type
+}
+
+// A stored.Codec for YAML items, decoding to the type of proto.
+//
+// Struct fields are named by the yaml struct tag, or the lower cased
+// field name.  Without a prototype items decode to the generic types
+// of yaml.v3, such as map[string]interface{}.
+//
+func NewCodec(proto stored.Storable) stored.Codec {
+	return codec{reflect.TypeOf(proto)}
+}
+
+func (c codec) MediaType() string {
+	return MediaType
+}
+
+func (c codec) Encode(obj stored.Storable) ([]byte, error) {
+	return yaml.Marshal(obj)
+}
+
+func (c codec) EncodeTo(w io.Writer, obj stored.Storable) error {
+
+	enc := yaml.NewEncoder(w)
+
+	err := enc.Encode(obj)
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+func (c codec) Decode(bs []byte) (stored.Storable, error) {
+	return c.DecodeFrom(bytes.NewReader(bs))
+}
+
+func (c codec) DecodeFrom(r io.Reader) (stored.Storable, error) {
+
+	dec := yaml.NewDecoder(r)
+
+	if c.proto == nil {
+		var obj interface{}
+		err := dec.Decode(&obj)
+		return obj, err
+	}
+
+	if c.proto.Kind() == reflect.Ptr {
+		v := reflect.New(c.proto.Elem())
+		err := dec.Decode(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		return v.Interface(), nil
+	}
+
+	v := reflect.New(c.proto)
+	err := dec.Decode(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Elem().Interface(), nil
+}
@@ -0,0 +1,119 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package storedyaml // import "kilobit.ca/go/stored/storedyaml"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/stored/storetest"
+import "kilobit.ca/go/stored/www"
+import "bytes"
+import "io/ioutil"
+import "log"
+import "net/http/httptest"
+import "reflect"
+import "testing"
+
+type order struct {
+	ID    string  `yaml:"id"`
+	Total float64 `yaml:"total"`
+}
+
+func TestCodec(t *testing.T) {
+
+	c := NewCodec(&order{})
+	if c.MediaType() != MediaType {
+		t.Errorf("Expected %q, got %q.", MediaType, c.MediaType())
+	}
+
+	bs, err := c.Encode(&order{"o-1", 9.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bs) != "id: o-1\ntotal: 9.5\n" {
+		t.Errorf("Unexpected encoding %q.", bs)
+	}
+
+	obj, err := c.Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&order{"o-1", 9.5}, obj) {
+		t.Errorf("Expected the order, got %#v.", obj)
+	}
+
+	buf := &bytes.Buffer{}
+	err = c.EncodeTo(buf, order{"o-2", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err = NewCodec(order{}).DecodeFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(order{"o-2", 1}, obj) {
+		t.Errorf("Expected the order, got %#v.", obj)
+	}
+
+	obj, err = NewCodec(nil).Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]interface{}{"id": "o-1", "total": 9.5}
+	if !reflect.DeepEqual(exp, obj) {
+		t.Errorf("Expected %#v, got %#v.", exp, obj)
+	}
+
+	_, err = c.Decode([]byte("id: [unclosed"))
+	if err == nil {
+		t.Error("Expected a syntax error.")
+	}
+}
+
+func TestTypedCodec(t *testing.T) {
+
+	types := stored.NewTypeRegistry()
+	types.Register("Order", order{})
+
+	c := stored.NewTypedCodec(types, NewCodec)
+
+	bs, err := c.Encode(order{"o-1", 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := c.Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(order{"o-1", 2}, obj) {
+		t.Errorf("Expected the order, got %#v.", obj)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+
+		codecs := stored.DefaultCodecs()
+		codecs.Register(NewCodec(""))
+
+		ds := www.NewDataServer(
+			"/",
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.IncrIDGen(),
+			www.OptSetCodecs(codecs),
+			www.OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+		)
+
+		srv := httptest.NewServer(ds)
+
+		hs := stored.NewCodecHttpStore(srv.URL, codecs, MediaType,
+			stored.OptUseClient(srv.Client()))
+
+		return hs, srv.Close
+	})
+}
@@ -0,0 +1,482 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+// A conformance test suite for Store implementations.
+//
+// Run the suite from a test in the package implementing the store:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.RunConformance(t, func() (stored.Store, func()) {
+//			return NewMyStore(), nil
+//		})
+//	}
+//
+package storetest // import "kilobit.ca/go/stored/storetest"
+
+import "kilobit.ca/go/stored"
+import "context"
+import "errors"
+import "fmt"
+import "reflect"
+import "sort"
+import "sync"
+import "testing"
+
+// Create an empty store for a single test, along with an optional
+// function releasing its resources.
+type Factory func() (stored.Store, func())
+
+type Opt func(*suite)
+
+type suite struct {
+	value      func(int) stored.Storable
+	equal      func(a, b stored.Storable) bool
+	concurrent bool
+	oddIDs     []stored.ID
+	contentID  func(stored.Storable) (stored.ID, error)
+}
+
+// Generate the i-th test value, by default the string "value-i".
+//
+// Stores with codecs limited to particular types can supply values
+// they are able to encode.  Values are compared with
+// reflect.DeepEqual unless OptEqual is given.
+//
+func OptValues(f func(int) stored.Storable) Opt {
+	return func(s *suite) {
+		s.value = f
+	}
+}
+
+// Compare values with f rather than reflect.DeepEqual, for values
+// such as protocol buffer messages which carry internal state.
+func OptEqual(f func(a, b stored.Storable) bool) Opt {
+	return func(s *suite) {
+		s.equal = f
+	}
+}
+
+// Skip the concurrency and parallel apply tests for stores which are
+// not safe for concurrent use.
+func OptSkipConcurrency() Opt {
+	return func(s *suite) {
+		s.concurrent = false
+	}
+}
+
+// Replace the set of unusual IDs each store must support.
+func OptOddIDs(ids ...stored.ID) Opt {
+	return func(s *suite) {
+		s.oddIDs = ids
+	}
+}
+
+// Store items under the IDs given by id, for stores deriving the IDs
+// of items from their content such as stored.ContentStore.
+//
+// Tests storing items under particular IDs are skipped.
+//
+func OptContentAddressed(id func(stored.Storable) (stored.ID, error)) Opt {
+	return func(s *suite) {
+		s.contentID = id
+	}
+}
+
+// IDs which tend to trip up escaping in encoded or remote stores.
+var OddIDs = []stored.ID{
+	"with space",
+	"ünïcødé",
+	"a+b",
+	"100%",
+	"a/b",
+	"?#&=",
+	"..",
+	"-",
+}
+
+// Run the conformance tests as subtests of t.
+func RunConformance(t *testing.T, factory Factory, opts ...Opt) {
+
+	s := &suite{
+		value: func(i int) stored.Storable {
+			return fmt.Sprintf("value-%d", i)
+		},
+		equal: func(a, b stored.Storable) bool {
+			return reflect.DeepEqual(a, b)
+		},
+		concurrent: true,
+		oddIDs:     OddIDs,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	tests := []struct {
+		name string
+		f    func(*testing.T, stored.Store)
+	}{
+		{"StoreRetrieve", s.testStoreRetrieve},
+		{"Overwrite", s.testOverwrite},
+		{"Delete", s.testDelete},
+		{"Missing", s.testMissing},
+		{"List", s.testList},
+		{"Apply", s.testApply},
+		{"ApplyError", s.testApplyError},
+		{"ApplyParallel", s.testApplyParallel},
+		{"Range", s.testRange},
+		{"OddIDs", s.testOddIDs},
+		{"Concurrency", s.testConcurrency},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+
+			if (test.name == "Concurrency" || test.name == "ApplyParallel") && !s.concurrent {
+				t.Skip("The store is not safe for concurrent use.")
+			}
+
+			if (test.name == "Overwrite" || test.name == "Range" || test.name == "OddIDs") && s.contentID != nil {
+				t.Skip("The store chooses the IDs of its items.")
+			}
+
+			store, cleanup := factory()
+			if cleanup != nil {
+				defer cleanup()
+			}
+
+			test.f(t, store)
+		})
+	}
+}
+
+// The ID of an item, id unless the store is content addressed.
+func (s *suite) id(t *testing.T, id stored.ID, obj stored.Storable) stored.ID {
+	t.Helper()
+
+	if s.contentID == nil {
+		return id
+	}
+
+	cid, err := s.contentID(obj)
+	if err != nil {
+		t.Errorf("Failed to derive the ID of %#v, %s", obj, err)
+		return id
+	}
+
+	return cid
+}
+
+// Store an item, returning its ID.
+func (s *suite) mustStore(t *testing.T, store stored.Store, id stored.ID, obj stored.Storable) stored.ID {
+	t.Helper()
+
+	id = s.id(t, id, obj)
+
+	err := store.StoreItem(id, obj)
+	if err != nil {
+		t.Fatalf("StoreItem(%q) failed, %s", id, err)
+	}
+
+	return id
+}
+
+func (s *suite) expectItem(t *testing.T, store stored.Store, id stored.ID, exp stored.Storable) {
+	t.Helper()
+
+	obj, err := store.Retrieve(id)
+	if err != nil {
+		t.Errorf("Retrieve(%q) failed, %s", id, err)
+		return
+	}
+
+	if !s.equal(exp, obj) {
+		t.Errorf("Retrieve(%q) returned %#v, expected %#v", id, obj, exp)
+	}
+}
+
+func (s *suite) equalItems(exp, items map[stored.ID]stored.Storable) bool {
+
+	if len(exp) != len(items) {
+		return false
+	}
+
+	for id, obj := range exp {
+		item, ok := items[id]
+		if !ok || !s.equal(obj, item) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *suite) expectMissing(t *testing.T, store stored.Store, id stored.ID) {
+	t.Helper()
+
+	_, err := store.Retrieve(id)
+	if err != stored.ErrNotFound {
+		t.Errorf("Retrieve(%q) returned %v, expected stored.ErrNotFound", id, err)
+	}
+}
+
+func (s *suite) expectIDs(t *testing.T, store stored.Store, exp ...stored.ID) {
+	t.Helper()
+
+	ids, err := store.List()
+	if err != nil {
+		t.Errorf("List failed, %s", err)
+		return
+	}
+
+	sortIDs(ids)
+	sortIDs(exp)
+
+	if len(ids) != len(exp) || (len(ids) > 0 && !reflect.DeepEqual(exp, ids)) {
+		t.Errorf("List returned %q, expected %q", ids, exp)
+	}
+}
+
+func sortIDs(ids []stored.ID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+func (s *suite) testStoreRetrieve(t *testing.T, store stored.Store) {
+
+	id1 := s.mustStore(t, store, "1", s.value(1))
+	id2 := s.mustStore(t, store, "2", s.value(2))
+
+	s.expectItem(t, store, id1, s.value(1))
+	s.expectItem(t, store, id2, s.value(2))
+}
+
+func (s *suite) testOverwrite(t *testing.T, store stored.Store) {
+
+	s.mustStore(t, store, "1", s.value(1))
+	s.mustStore(t, store, "1", s.value(2))
+
+	s.expectItem(t, store, "1", s.value(2))
+	s.expectIDs(t, store, "1")
+}
+
+func (s *suite) testDelete(t *testing.T, store stored.Store) {
+
+	id1 := s.mustStore(t, store, "1", s.value(1))
+	id2 := s.mustStore(t, store, "2", s.value(2))
+
+	err := store.Delete(id1)
+	if err != nil {
+		t.Errorf("Delete failed, %s", err)
+	}
+
+	s.expectMissing(t, store, id1)
+	s.expectItem(t, store, id2, s.value(2))
+	s.expectIDs(t, store, id2)
+}
+
+func (s *suite) testMissing(t *testing.T, store stored.Store) {
+
+	s.expectMissing(t, store, "missing")
+
+	err := store.Delete("missing")
+	if err != nil && err != stored.ErrNotFound {
+		t.Errorf("Delete of a missing item returned %v, expected nil or stored.ErrNotFound", err)
+	}
+
+	s.expectIDs(t, store)
+}
+
+func (s *suite) testList(t *testing.T, store stored.Store) {
+
+	s.expectIDs(t, store)
+
+	exp := []stored.ID{}
+	for i := 0; i < 10; i++ {
+		id := s.mustStore(t, store, (stored.ID)(fmt.Sprintf("item-%d", i)), s.value(i))
+		exp = append(exp, id)
+	}
+
+	s.expectIDs(t, store, exp...)
+
+	store.Delete(exp[0])
+	s.expectIDs(t, store, exp[1:]...)
+}
+
+func (s *suite) testApply(t *testing.T, store stored.Store) {
+
+	exp := map[stored.ID]stored.Storable{}
+	for i := 0; i < 5; i++ {
+		id := s.mustStore(t, store, (stored.ID)(fmt.Sprintf("item-%d", i)), s.value(i))
+		exp[id] = s.value(i)
+	}
+
+	seen := map[stored.ID]stored.Storable{}
+	err := store.Apply(func(id stored.ID, obj stored.Storable) error {
+		if _, ok := seen[id]; ok {
+			t.Errorf("Apply visited %q more than once", id)
+		}
+
+		seen[id] = obj
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Apply failed, %s", err)
+	}
+
+	if !s.equalItems(exp, seen) {
+		t.Errorf("Apply visited %#v, expected %#v", seen, exp)
+	}
+}
+
+func (s *suite) testApplyError(t *testing.T, store stored.Store) {
+
+	for i := 0; i < 5; i++ {
+		s.mustStore(t, store, (stored.ID)(fmt.Sprintf("item-%d", i)), s.value(i))
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+
+	err := store.Apply(func(stored.ID, stored.Storable) error {
+		calls++
+		return stop
+	})
+
+	if err != stop {
+		t.Errorf("Apply returned %v, expected the handler error", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Apply called the handler %d times after an error, expected 1", calls)
+	}
+}
+
+func (s *suite) testApplyParallel(t *testing.T, store stored.Store) {
+
+	exp := []stored.ID{}
+	for i := 0; i < 10; i++ {
+		id := s.mustStore(t, store, (stored.ID)(fmt.Sprintf("item-%d", i)), s.value(i))
+		exp = append(exp, id)
+	}
+
+	seen := []stored.ID{}
+	err := stored.ApplyParallel(store, func(id stored.ID, obj stored.Storable) error {
+		seen = append(seen, id)
+		return nil
+	}, 4, stored.OptApplyOrdered())
+	if err != nil {
+		t.Errorf("ApplyParallel failed, %s", err)
+	}
+
+	sortIDs(exp)
+	sortIDs(seen)
+	if !reflect.DeepEqual(exp, seen) {
+		t.Errorf("ApplyParallel visited %v, expected %v", seen, exp)
+	}
+}
+
+func (s *suite) testRange(t *testing.T, store stored.Store) {
+
+	for i, id := range []stored.ID{"a/1", "a/2", "a/3", "b/1", "c"} {
+		s.mustStore(t, store, id, s.value(i))
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		start, end stored.ID
+		opts       []stored.RangeOpt
+		exp        []stored.ID
+	}{
+		{"", "", nil, []stored.ID{"a/1", "a/2", "a/3", "b/1", "c"}},
+		{"a/", stored.PrefixEnd("a/"), nil, []stored.ID{"a/1", "a/2", "a/3"}},
+		{"a/2", "c", nil, []stored.ID{"a/2", "a/3", "b/1"}},
+		{"a/2", "", []stored.RangeOpt{stored.OptRangeReverse()}, []stored.ID{"c", "b/1", "a/3", "a/2"}},
+		{"", "b", []stored.RangeOpt{stored.OptRangeReverse(), stored.OptRangeLimit(2)}, []stored.ID{"a/3", "a/2"}},
+		{"d", "", nil, []stored.ID{}},
+	}
+
+	for _, test := range tests {
+		ids, err := stored.ListRangeContext(ctx, store, test.start, test.end, test.opts...)
+		if err != nil {
+			t.Errorf("ListRangeContext(%q, %q) failed, %s", test.start, test.end, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(test.exp, ids) {
+			t.Errorf("ListRangeContext(%q, %q) returned %v, expected %v",
+				test.start, test.end, ids, test.exp)
+		}
+	}
+}
+
+func (s *suite) testOddIDs(t *testing.T, store stored.Store) {
+
+	for i, id := range s.oddIDs {
+		s.mustStore(t, store, id, s.value(i))
+	}
+
+	for i, id := range s.oddIDs {
+		s.expectItem(t, store, id, s.value(i))
+	}
+
+	s.expectIDs(t, store, append([]stored.ID{}, s.oddIDs...)...)
+
+	for _, id := range s.oddIDs {
+		err := store.Delete(id)
+		if err != nil {
+			t.Errorf("Delete(%q) failed, %s", id, err)
+		}
+
+		s.expectMissing(t, store, id)
+	}
+}
+
+func (s *suite) testConcurrency(t *testing.T, store stored.Store) {
+
+	const workers = 8
+	const items = 10
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < items; i++ {
+				// Values are distinct for content addressed stores.
+				v := s.value(w*items + i)
+				id := s.id(t, (stored.ID)(fmt.Sprintf("w%d-%d", w, i)), v)
+
+				err := store.StoreItem(id, v)
+				if err != nil {
+					t.Errorf("StoreItem(%q) failed, %s", id, err)
+					continue
+				}
+
+				obj, err := store.Retrieve(id)
+				if err != nil || !s.equal(v, obj) {
+					t.Errorf("Retrieve(%q) returned %#v, %v", id, obj, err)
+				}
+
+				if i%2 == 0 {
+					err = store.Delete(id)
+					if err != nil {
+						t.Errorf("Delete(%q) failed, %s", id, err)
+					}
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	ids, err := store.List()
+	if err != nil {
+		t.Errorf("List failed, %s", err)
+	}
+
+	if len(ids) != workers*items/2 {
+		t.Errorf("List returned %d items, expected %d", len(ids), workers*items/2)
+	}
+}
@@ -0,0 +1,370 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+import "encoding/hex"
+import "math/rand"
+import "net/http"
+import "strings"
+import "sync"
+import "time"
+
+// A key value pair describing a span.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Identifies a span across process boundaries.
+//
+// See https://www.w3.org/TR/trace-context/
+//
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// The W3C traceparent header value for the span context.
+func (sc SpanContext) Traceparent() string {
+
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" +
+		hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+type TraceError string
+
+func (err TraceError) Error() string {
+	return (string)(err)
+}
+
+const ErrInvalidTraceparent = TraceError("Invalid traceparent header.")
+
+// Parse a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+
+	sc := SpanContext{}
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+
+	tid, err := hex.DecodeString(parts[1])
+	if err != nil || len(tid) != 16 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	sid, err := hex.DecodeString(parts[2])
+	if err != nil || len(sid) != 8 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	copy(sc.TraceID[:], tid)
+	copy(sc.SpanID[:], sid)
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+
+	return sc, nil
+}
+
+// A unit of work being traced.
+type Span interface {
+	SpanContext() SpanContext
+	SetAttributes(...Attr)
+	End(error)
+}
+
+// Starts spans, typically as children of the span found in ctx.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// The active span in a context, or nil.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// Attach the span context of a remote caller to ctx, to be used as
+// the parent of the next span started.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func RemoteSpanContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+const TraceparentHeader = "traceparent"
+
+// Add the traceparent header for a span to outgoing request headers.
+func InjectTraceparent(span Span, h http.Header) {
+	sc := span.SpanContext()
+	if sc.IsValid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Attach the span context found in incoming request headers to ctx.
+func ExtractTraceparent(ctx context.Context, h http.Header) context.Context {
+
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SpanContext() SpanContext { return SpanContext{} }
+func (nopSpan) SetAttributes(...Attr)    {}
+func (nopSpan) End(error)                {}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+// A Tracer that does nothing.
+var NopTracer Tracer = nopTracer{}
+
+// A description of a span passed to HookTracer callbacks.
+type SpanData struct {
+	Name    string
+	Context SpanContext
+	Parent  SpanContext
+	Attrs   []Attr
+	Start   time.Time
+	End     time.Time
+	Err     error
+}
+
+var fallbackRand = struct {
+	*rand.Rand
+	sync.Mutex
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// Fill the bytes of a trace or span ID.
+//
+// IDs need only be unique, not unpredictable, so a failure of the
+// system random source falls back to a time seeded generator rather
+// than leaving a zero, invalid, ID.
+//
+func traceIDBytes(bs []byte) {
+
+	if randomBytes(bs) == nil {
+		return
+	}
+
+	fallbackRand.Lock()
+	defer fallbackRand.Unlock()
+
+	fallbackRand.Read(bs)
+}
+
+// A Tracer calling hooks as spans start and end.
+//
+// Spans are given W3C compatible IDs, continuing the trace of the
+// parent span in the context if there is one.  Either hook may be
+// nil.
+//
+type HookTracer struct {
+	OnStart func(SpanData)
+	OnEnd   func(SpanData)
+}
+
+type hookSpan struct {
+	t    *HookTracer
+	data SpanData
+	mu   sync.Mutex
+}
+
+func (t *HookTracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span) {
+
+	parent := SpanContext{}
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.SpanContext()
+	} else if sc, ok := RemoteSpanContext(ctx); ok {
+		parent = sc
+	}
+
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		traceIDBytes(sc.TraceID[:])
+	}
+
+	traceIDBytes(sc.SpanID[:])
+
+	span := &hookSpan{
+		t: t,
+		data: SpanData{
+			Name:    name,
+			Context: sc,
+			Parent:  parent,
+			Attrs:   append([]Attr{}, attrs...),
+			Start:   time.Now(),
+		},
+	}
+
+	if t.OnStart != nil {
+		t.OnStart(span.data)
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (s *hookSpan) SpanContext() SpanContext {
+	return s.data.Context
+}
+
+func (s *hookSpan) SetAttributes(attrs ...Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+}
+
+func (s *hookSpan) End(err error) {
+	s.mu.Lock()
+	s.data.End = time.Now()
+	s.data.Err = err
+	data := s.data
+	s.mu.Unlock()
+
+	if s.t.OnEnd != nil {
+		s.t.OnEnd(data)
+	}
+}
+
+// A store wrapper starting a span for every operation.
+//
+// Spans are named after the store and operation, for example
+// "cache.Retrieve", and continue the trace found in the context passed
+// to the context aware methods.
+//
+type TracedStore struct {
+	store  Store
+	tracer Tracer
+	name   string
+}
+
+func NewTracedStore(store Store, tracer Tracer, name string) *TracedStore {
+	return &TracedStore{store, tracer, name}
+}
+
+func (s *TracedStore) start(ctx context.Context, op string, id ID) (context.Context, Span) {
+	if id == "" {
+		return s.tracer.Start(ctx, s.name+"."+op)
+	}
+
+	return s.tracer.Start(ctx, s.name+"."+op, Attr{"stored.id", (string)(id)})
+}
+
+func (s *TracedStore) StoreItem(id ID, obj Storable) error {
+	return s.StoreItemContext(context.Background(), id, obj)
+}
+
+func (s *TracedStore) StoreItemContext(ctx context.Context, id ID, obj Storable) error {
+	ctx, span := s.start(ctx, "StoreItem", id)
+	err := StoreItemContext(ctx, s.store, id, obj)
+	span.End(err)
+	return err
+}
+
+func (s *TracedStore) Retrieve(id ID) (Storable, error) {
+	return s.RetrieveContext(context.Background(), id)
+}
+
+func (s *TracedStore) RetrieveContext(ctx context.Context, id ID) (Storable, error) {
+	ctx, span := s.start(ctx, "Retrieve", id)
+	obj, err := RetrieveContext(ctx, s.store, id)
+	span.End(err)
+	return obj, err
+}
+
+func (s *TracedStore) List() ([]ID, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *TracedStore) ListContext(ctx context.Context) ([]ID, error) {
+	ctx, span := s.start(ctx, "List", "")
+	ids, err := ListContext(ctx, s.store)
+	span.SetAttributes(Attr{"stored.count", len(ids)})
+	span.End(err)
+	return ids, err
+}
+
+func (s *TracedStore) Search(query string, limit int) ([]SearchResult, error) {
+	return s.SearchContext(context.Background(), query, limit)
+}
+
+func (s *TracedStore) SearchContext(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	ctx, span := s.start(ctx, "Search", "")
+	results, err := SearchContext(ctx, s.store, query, limit)
+	span.SetAttributes(Attr{"stored.count", len(results)})
+	span.End(err)
+	return results, err
+}
+
+func (s *TracedStore) Update(id ID, f UpdateFunc) error {
+	return s.UpdateContext(context.Background(), id, f)
+}
+
+func (s *TracedStore) UpdateContext(ctx context.Context, id ID, f UpdateFunc) error {
+	ctx, span := s.start(ctx, "Update", id)
+	err := UpdateContext(ctx, s.store, id, f)
+	span.End(err)
+	return err
+}
+
+func (s *TracedStore) Apply(f ItemHandler) error {
+	_, span := s.start(context.Background(), "Apply", "")
+	err := s.store.Apply(f)
+	span.End(err)
+	return err
+}
+
+func (s *TracedStore) Delete(id ID) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *TracedStore) DeleteContext(ctx context.Context, id ID) error {
+	ctx, span := s.start(ctx, "Delete", id)
+	err := DeleteContext(ctx, s.store, id)
+	span.End(err)
+	return err
+}
@@ -0,0 +1,107 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "context"
+import "crypto/rand"
+import "net/http"
+import "strings"
+import "sync"
+
+func TestTraceTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestParseTraceparent(t *testing.T) {
+
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, true, sc.Sampled)
+	assert.Expect(t, tp, sc.Traceparent())
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xyz-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(bad)
+		assert.Expect(t, ErrInvalidTraceparent, err)
+	}
+}
+
+type spanLog struct {
+	spans []SpanData
+	mu    sync.Mutex
+}
+
+func (l *spanLog) tracer() *HookTracer {
+	return &HookTracer{OnEnd: func(d SpanData) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.spans = append(l.spans, d)
+	}}
+}
+
+func TestHookTracer(t *testing.T) {
+
+	l := &spanLog{}
+	tracer := l.tracer()
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child", Attr{"k", "v"})
+	child.End(nil)
+	parent.End(ErrNotFound)
+
+	assert.Expect(t, 2, len(l.spans))
+	assert.Expect(t, "child", l.spans[0].Name)
+	assert.Expect(t, parent.SpanContext(), l.spans[0].Parent)
+	assert.Expect(t, parent.SpanContext().TraceID, l.spans[0].Context.TraceID)
+	assert.Expect(t, []Attr{{"k", "v"}}, l.spans[0].Attrs)
+	assert.Expect(t, ErrNotFound, l.spans[1].Err)
+
+	h := http.Header{}
+	InjectTraceparent(parent, h)
+	ctx = ExtractTraceparent(context.Background(), h)
+	_, remote := tracer.Start(ctx, "remote")
+
+	assert.Expect(t, parent.SpanContext().TraceID, remote.SpanContext().TraceID)
+}
+
+func TestHookTracerRandomFailure(t *testing.T) {
+
+	reader := rand.Reader
+	rand.Reader = strings.NewReader("")
+	defer func() { rand.Reader = reader }()
+
+	l := &spanLog{}
+	_, a := l.tracer().Start(context.Background(), "a")
+	_, b := l.tracer().Start(context.Background(), "b")
+
+	assert.Expect(t, true, a.SpanContext().IsValid())
+	assert.Expect(t, true, b.SpanContext().IsValid())
+	assert.Expect(t, false, a.SpanContext() == b.SpanContext())
+}
+
+func TestTracedStore(t *testing.T) {
+
+	l := &spanLog{}
+	ts := NewTracedStore(NewMapStore(), l.tracer(), "mem")
+
+	ts.StoreItem("1", "one")
+	ts.Retrieve("2")
+
+	assert.Expect(t, 2, len(l.spans))
+	assert.Expect(t, "mem.StoreItem", l.spans[0].Name)
+	assert.Expect(t, []Attr{{"stored.id", "1"}}, l.spans[0].Attrs)
+	assert.Expect(t, ErrNotFound, l.spans[1].Err)
+}
@@ -0,0 +1,348 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "io"
+import "io/ioutil"
+import "mime"
+import "reflect"
+import "sort"
+import "sync"
+
+type TypeError string
+
+func (err TypeError) Error() string {
+	return (string)(err)
+}
+
+// Returned for values or names of types which are not registered.
+const ErrUnknownType = TypeError("The type is not registered.")
+
+// Returned when registering a name or type a second time.
+const ErrTypeConflict = TypeError("The type or name is already registered.")
+
+// Returned when decoding a value which is not a type envelope.
+const ErrNoEnvelope = TypeError("The value is not a type envelope.")
+
+// The media type parameter naming the type of an encoded value, as in
+// application/json; type=Order.
+const TypeParam = "type"
+
+// Names for the Go types held in a store.
+//
+// A store holding several domain types records the name of each
+// value's type when encoding it, so the same type can be rebuilt when
+// decoding.
+//
+// TypeRegistry is safe for concurrent use.
+//
+type TypeRegistry struct {
+	names map[string]reflect.Type
+	types map[reflect.Type]string
+	mu    sync.RWMutex
+}
+
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		names: map[string]reflect.Type{},
+		types: map[reflect.Type]string{},
+	}
+}
+
+// Register the type of example under name.
+//
+// Values decode to the type of the example, a value or a pointer.
+// Encoding recognizes both the type and a pointer to it.
+//
+func (r *TypeRegistry) Register(name string, example Storable) error {
+
+	t := reflect.TypeOf(example)
+	if t == nil || name == "" {
+		return ErrUnknownType
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, named := r.names[name]
+	_, typed := r.types[t]
+	if named || typed {
+		return ErrTypeConflict
+	}
+
+	r.names[name] = t
+	r.types[t] = name
+
+	return nil
+}
+
+// The name of the type of obj.
+func (r *TypeRegistry) TypeName(obj Storable) (string, error) {
+
+	t := reflect.TypeOf(obj)
+	if t == nil {
+		return "", ErrUnknownType
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if name, ok := r.types[t]; ok {
+		return name, nil
+	}
+
+	if t.Kind() == reflect.Ptr {
+		if name, ok := r.types[t.Elem()]; ok {
+			return name, nil
+		}
+	} else if name, ok := r.types[reflect.PtrTo(t)]; ok {
+		return name, nil
+	}
+
+	return "", ErrUnknownType
+}
+
+// A zero value of the named type, or a pointer to a new zero value
+// for pointer types.
+func (r *TypeRegistry) Prototype(name string) (Storable, error) {
+
+	r.mu.RLock()
+	t, ok := r.names[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownType
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface(), nil
+	}
+
+	return reflect.Zero(t).Interface(), nil
+}
+
+// The registered names in order.
+func (r *TypeRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Codecs whose encoding depends on the parameters of the media type.
+//
+// EncodeMediaType returns the full media type of the encoding, for
+// use as a Content-Type, and DecodeMediaType decodes according to
+// one.
+//
+type MediaTypeCodec interface {
+	Codec
+	EncodeMediaType(Storable) (string, []byte, error)
+	DecodeMediaType(t string, bs []byte) (Storable, error)
+}
+
+// Create a codec decoding to the type of proto, or to generic values
+// when proto is nil.
+type CodecFactory func(proto Storable) Codec
+
+// Codecs able to split a type envelope without decoding its value,
+// returning the type name and the encoded value.
+//
+// ErrNoEnvelope is returned when bs is not an envelope.
+//
+type EnvelopeDecoder interface {
+	DecodeEnvelope(bs []byte) (string, []byte, error)
+}
+
+// A codec for the registered types of a TypeRegistry.
+//
+// With media types, as over HTTP, values are encoded as they are by
+// the codec for their type and the type is named by the type
+// parameter of the media type.  Without media types, as in a file,
+// values are wrapped in an envelope, a map holding the type name
+// under "type" and the value under "value".
+//
+// Envelopes need a self-describing format such as JSON, YAML, CBOR or
+// MessagePack.  Codecs implementing EnvelopeDecoder, such as the JSON
+// codecs, decode the value straight to its type.  Otherwise the value
+// is first decoded generically then encoded again and decoded to its
+// type, so the generic values must encode without loss.
+//
+type TypedCodec struct {
+	types   *TypeRegistry
+	factory CodecFactory
+	generic Codec
+	codecs  map[string]Codec
+	mu      sync.Mutex
+}
+
+func NewTypedCodec(types *TypeRegistry, factory CodecFactory) *TypedCodec {
+	return &TypedCodec{
+		types:   types,
+		factory: factory,
+		generic: factory(nil),
+		codecs:  map[string]Codec{},
+	}
+}
+
+// A TypedCodec for JSON.
+func NewTypedJSONCodec(types *TypeRegistry, opts ...JSONOpt) *TypedCodec {
+	return NewTypedCodec(types, func(proto Storable) Codec {
+		return NewJSONCodec(append(append([]JSONOpt{}, opts...), OptJSONPrototype(proto))...)
+	})
+}
+
+// The codec decoding the named type.
+func (c *TypedCodec) codec(name string) (Codec, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tc, ok := c.codecs[name]; ok {
+		return tc, nil
+	}
+
+	proto, err := c.types.Prototype(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := c.factory(proto)
+	c.codecs[name] = tc
+
+	return tc, nil
+}
+
+func (c *TypedCodec) MediaType() string {
+	return c.generic.MediaType()
+}
+
+// Encode obj, returning the media type with the name of its type.
+func (c *TypedCodec) EncodeMediaType(obj Storable) (string, []byte, error) {
+
+	name, err := c.types.TypeName(obj)
+	if err != nil {
+		return "", nil, err
+	}
+
+	bs, err := c.generic.Encode(obj)
+	if err != nil {
+		return "", nil, err
+	}
+
+	t := mime.FormatMediaType(baseMediaType(c.MediaType()), map[string]string{TypeParam: name})
+
+	return t, bs, nil
+}
+
+// Decode bs to the type named by media type t, or from an envelope
+// when t does not name a type.
+func (c *TypedCodec) DecodeMediaType(t string, bs []byte) (Storable, error) {
+
+	_, params, _ := mime.ParseMediaType(t)
+
+	name := params[TypeParam]
+	if name == "" {
+		return c.Decode(bs)
+	}
+
+	tc, err := c.codec(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc.Decode(bs)
+}
+
+// Encode obj in a type envelope.
+func (c *TypedCodec) Encode(obj Storable) ([]byte, error) {
+
+	name, err := c.types.TypeName(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.generic.Encode(map[string]interface{}{
+		"type":  name,
+		"value": obj,
+	})
+}
+
+// Decode a value from a type envelope.
+func (c *TypedCodec) Decode(bs []byte) (Storable, error) {
+
+	if ed, ok := c.generic.(EnvelopeDecoder); ok {
+		name, vbs, err := ed.DecodeEnvelope(bs)
+		if err != nil {
+			return nil, err
+		}
+
+		tc, err := c.codec(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return tc.Decode(vbs)
+	}
+
+	env, err := c.generic.Decode(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	var name, value interface{}
+
+	switch m := env.(type) {
+	case map[string]interface{}:
+		name, value = m["type"], m["value"]
+	case map[interface{}]interface{}:
+		name, value = m["type"], m["value"]
+	default:
+		return nil, ErrNoEnvelope
+	}
+
+	s, ok := name.(string)
+	if !ok {
+		return nil, ErrNoEnvelope
+	}
+
+	tc, err := c.codec(s)
+	if err != nil {
+		return nil, err
+	}
+
+	vbs, err := c.generic.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc.Decode(vbs)
+}
+
+func (c *TypedCodec) EncodeTo(w io.Writer, obj Storable) error {
+
+	bs, err := c.Encode(obj)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(bs)
+
+	return err
+}
+
+func (c *TypedCodec) DecodeFrom(r io.Reader) (Storable, error) {
+
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Decode(bs)
+}
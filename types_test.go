@@ -0,0 +1,146 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "bytes"
+import "testing"
+
+func TestTypesTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+type refund struct {
+	Order  string  `json:"order"`
+	Amount float64 `json:"amount"`
+}
+
+func testTypes(t *testing.T) *TypeRegistry {
+
+	types := NewTypeRegistry()
+	assert.Expect(t, nil, types.Register("Order", order{}))
+	assert.Expect(t, nil, types.Register("Refund", &refund{}))
+
+	return types
+}
+
+func TestTypeRegistry(t *testing.T) {
+
+	types := testTypes(t)
+
+	assert.Expect(t, ErrTypeConflict, types.Register("Order", "other"))
+	assert.Expect(t, ErrTypeConflict, types.Register("Other", order{}))
+	assert.Expect(t, ErrUnknownType, types.Register("", "x"))
+	assert.Expect(t, ErrUnknownType, types.Register("Nil", nil))
+
+	assert.Expect(t, []string{"Order", "Refund"}, types.Names())
+
+	tests := []struct {
+		obj  Storable
+		name string
+		err  error
+	}{
+		{order{}, "Order", nil},
+		{&order{}, "Order", nil},
+		{&refund{}, "Refund", nil},
+		{refund{}, "Refund", nil},
+		{"string", "", ErrUnknownType},
+		{nil, "", ErrUnknownType},
+	}
+
+	for _, test := range tests {
+		name, err := types.TypeName(test.obj)
+		assert.Expect(t, test.err, err)
+		assert.Expect(t, test.name, name)
+	}
+
+	proto, err := types.Prototype("Order")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{}, proto)
+
+	proto, err = types.Prototype("Refund")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &refund{}, proto)
+
+	_, err = types.Prototype("Missing")
+	assert.Expect(t, ErrUnknownType, err)
+}
+
+func TestTypedCodecEnvelope(t *testing.T) {
+
+	c := NewTypedJSONCodec(testTypes(t))
+	assert.Expect(t, JSONMediaType, c.MediaType())
+
+	bs, err := c.Encode(order{"o-1", 9.5})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, `{"type":"Order","value":{"id":"o-1","total":9.5}}`, (string)(bs))
+
+	objs := []Storable{order{"o-1", 9.5}, &refund{"o-1", 2}}
+	for _, obj := range objs {
+		buf := &bytes.Buffer{}
+		assert.Expect(t, nil, c.EncodeTo(buf, obj))
+
+		dobj, err := c.DecodeFrom(buf)
+		assert.Expect(t, nil, err)
+		assert.Expect(t, obj, dobj)
+	}
+
+	_, err = c.Encode("string")
+	assert.Expect(t, ErrUnknownType, err)
+
+	_, err = c.Decode([]byte(`{"type":"Missing","value":{}}`))
+	assert.Expect(t, ErrUnknownType, err)
+
+	_, err = c.Decode([]byte(`["Order"]`))
+	assert.Expect(t, ErrNoEnvelope, err)
+
+	_, err = c.Decode([]byte(`{"value":{}}`))
+	assert.Expect(t, ErrNoEnvelope, err)
+}
+
+type ledger struct {
+	Cents int64 `json:"cents"`
+}
+
+func TestTypedCodecPrecision(t *testing.T) {
+
+	types := NewTypeRegistry()
+	types.Register("Ledger", ledger{})
+
+	c := NewTypedJSONCodec(types)
+
+	// Not representable as a float64.
+	obj, err := c.Decode([]byte(`{"type":"Ledger","value":{"cents":9007199254740993}}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, ledger{9007199254740993}, obj)
+}
+
+func TestTypedCodecMediaType(t *testing.T) {
+
+	var c MediaTypeCodec = NewTypedJSONCodec(testTypes(t))
+
+	mt, bs, err := c.EncodeMediaType(&refund{"o-1", 2})
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "application/json; type=Refund", mt)
+	assert.Expect(t, `{"order":"o-1","amount":2}`, (string)(bs))
+
+	obj, err := c.DecodeMediaType(mt, bs)
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &refund{"o-1", 2}, obj)
+
+	obj, err = c.DecodeMediaType("application/json;type=Order", []byte(`{"id":"o-2"}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-2", 0}, obj)
+
+	// Without a type parameter the body is an envelope.
+	obj, err = c.DecodeMediaType("application/json",
+		[]byte(`{"type":"Order","value":{"id":"o-3"}}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-3", 0}, obj)
+
+	_, err = c.DecodeMediaType("application/json; type=Missing", []byte(`{}`))
+	assert.Expect(t, ErrUnknownType, err)
+
+	_, _, err = c.EncodeMediaType(1)
+	assert.Expect(t, ErrUnknownType, err)
+}
@@ -0,0 +1,239 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "context"
+
+// Returned by compare and swap when the item has changed since it was
+// read.
+const ErrConflict = StoreError("The item was modified concurrently.")
+
+// Returned by Update for stores unable to update items atomically.
+const ErrUpdateUnsupported = StoreError("The store does not support atomic updates.")
+
+// The number of times Update retries a compare and swap after a
+// conflict before giving up with ErrConflict.
+var MaxUpdateRetries = 100
+
+// Compute the new value of an item from its current value.
+//
+// The exists flag is false when there is no current item.  Returning
+// an error aborts the update.  The function may be called more than
+// once when the store retries after a conflict.
+//
+type UpdateFunc func(old Storable, exists bool) (Storable, error)
+
+// Stores able to apply an UpdateFunc atomically.
+type Updater interface {
+	Update(ID, UpdateFunc) error
+}
+
+// Updaters accepting a context, see ContextStore.
+type ContextUpdater interface {
+	UpdateContext(context.Context, ID, UpdateFunc) error
+}
+
+// Update an item using the context aware method when available.
+func UpdateContext(ctx context.Context, s Store, id ID, f UpdateFunc) error {
+	if cu, ok := s.(ContextUpdater); ok {
+		return cu.UpdateContext(ctx, id, f)
+	}
+
+	return Update(s, id, f)
+}
+
+// Stores able to write an item only if it has not changed.
+//
+// Versions are opaque to callers, with zero reserved for missing
+// items.
+//
+type CASStore interface {
+	Store
+	RetrieveVersion(ID) (Storable, int, error)
+	CompareAndSwap(id ID, version int, obj Storable) error
+}
+
+// Atomically replace an item with the value computed by f.
+//
+// Stores implementing Updater are used directly, CASStores are
+// updated in a compare and swap loop, as VersionedStore updates
+// itself.  Other stores, including a bare MapStore, return
+// ErrUpdateUnsupported.
+//
+func Update(s Store, id ID, f UpdateFunc) error {
+
+	if u, ok := s.(Updater); ok {
+		return u.Update(id, f)
+	}
+
+	cs, ok := s.(CASStore)
+	if !ok {
+		return ErrUpdateUnsupported
+	}
+
+	return updateCAS(func() (Storable, int, error) {
+		return cs.RetrieveVersion(id)
+	}, func(version int, obj Storable) error {
+		return cs.CompareAndSwap(id, version, obj)
+	}, f)
+}
+
+// Apply f in a compare and swap loop, retrying conflicts up to
+// MaxUpdateRetries times.
+func updateCAS(retrieve func() (Storable, int, error), swap func(int, Storable) error, f UpdateFunc) error {
+
+	for i := 0; i <= MaxUpdateRetries; i++ {
+
+		old, version, err := retrieve()
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		obj, err := f(old, version != 0)
+		if err != nil {
+			return err
+		}
+
+		err = swap(version, obj)
+		if err != ErrConflict {
+			return err
+		}
+	}
+
+	return ErrConflict
+}
+
+// Apply f to an item held in memory, keeping its metadata.
+//
+// MapStores are not safe for concurrent use, so they do not implement
+// Updater and are updated through a SyncStore, which holds its lock
+// around the call.
+//
+func (s MapStore) update(id ID, f UpdateFunc) error {
+
+	old, meta, err := s.RetrieveMeta(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	obj, err := f(old, err == nil)
+	if err != nil {
+		return err
+	}
+
+	// Keep the metadata of items stored with it.
+	if _, ok := s[id].(metaItem); ok {
+		return s.StoreItemWithMeta(id, obj, meta)
+	}
+
+	s[id] = obj
+
+	return nil
+}
+
+// Apply f with the store locked against every other operation.
+//
+// This is the atomic update of a MapStore, which on its own returns
+// ErrUpdateUnsupported from Update.
+//
+func (s *SyncStore) Update(id ID, f UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.store.(Updater); ok {
+		return u.Update(id, f)
+	}
+
+	if ms, ok := s.store.(MapStore); ok {
+		return ms.update(id, f)
+	}
+
+	old, err := s.store.Retrieve(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	obj, err := f(old, err == nil)
+	if err != nil {
+		return err
+	}
+
+	return s.store.StoreItem(id, obj)
+}
+
+// Apply f and record the result as a new revision.
+func (s *VersionedStore) Update(id ID, f UpdateFunc) error {
+	return s.UpdateContext(context.Background(), id, f)
+}
+
+// Update an item in a compare and swap loop, recording the principal
+// of ctx as the author of the new revision.
+//
+// The history is not locked while f runs, so f may be called again
+// when the item changes in the meantime.
+//
+func (s *VersionedStore) UpdateContext(ctx context.Context, id ID, f UpdateFunc) error {
+
+	author := Principal(ctx)
+
+	return updateCAS(func() (Storable, int, error) {
+		return s.retrieveVersion(ctx, id)
+	}, func(version int, obj Storable) error {
+		return s.compareAndSwap(ctx, id, version, Revision{Author: author, Value: obj})
+	}, f)
+}
+
+// Retrieve an item along with its latest revision number.
+func (s *VersionedStore) RetrieveVersion(id ID) (Storable, int, error) {
+	return s.retrieveVersion(context.Background(), id)
+}
+
+func (s *VersionedStore) retrieveVersion(ctx context.Context, id ID) (Storable, int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revs, err := s.revisions(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(revs) == 0 || revs[len(revs)-1].Deleted {
+		return nil, 0, ErrNotFound
+	}
+
+	obj, err := RetrieveContext(ctx, s.store, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return obj, revs[len(revs)-1].Rev, nil
+}
+
+// Store an item as a new revision if its latest revision is still
+// version.
+func (s *VersionedStore) CompareAndSwap(id ID, version int, obj Storable) error {
+	return s.compareAndSwap(context.Background(), id, version, Revision{Value: obj})
+}
+
+func (s *VersionedStore) compareAndSwap(ctx context.Context, id ID, version int, r Revision) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revs, err := s.revisions(id)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	if len(revs) > 0 && !revs[len(revs)-1].Deleted {
+		current = revs[len(revs)-1].Rev
+	}
+
+	if current != version {
+		return ErrConflict
+	}
+
+	return s.record(ctx, id, r)
+}
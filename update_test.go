@@ -0,0 +1,167 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package stored // import "kilobit.ca/go/stored"
+
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "errors"
+import "fmt"
+import "sync"
+import "testing"
+
+func TestUpdateTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func increment(old Storable, exists bool) (Storable, error) {
+	if !exists {
+		return 1, nil
+	}
+
+	return old.(int) + 1, nil
+}
+
+func concurrentIncrements(t *testing.T, s Store, n int) {
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := Update(s, "counter", increment)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestUpdateMapStore(t *testing.T) {
+
+	ms := NewMapStore()
+
+	// MapStores are only updated atomically through a SyncStore.
+	assert.Expect(t, ErrUpdateUnsupported, Update(ms, "1", increment))
+
+	ss := NewSyncStore(ms)
+
+	assert.Expect(t, nil, Update(ss, "1", increment))
+	assert.Expect(t, nil, Update(ss, "1", increment))
+	assert.Expect(t, 2, ms["1"])
+
+	fail := errors.New("Failed")
+	err := Update(ss, "1", func(old Storable, exists bool) (Storable, error) {
+		return nil, fail
+	})
+
+	assert.Expect(t, fail, err)
+	assert.Expect(t, 2, ms["1"])
+}
+
+func TestUpdateSyncStore(t *testing.T) {
+
+	ss := NewSyncStore(NewMapStore())
+	concurrentIncrements(t, ss, 50)
+
+	obj, _ := ss.Retrieve("counter")
+	assert.Expect(t, 50, obj)
+}
+
+func TestUpdateVersionedStore(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore())
+	concurrentIncrements(t, vs, 50)
+
+	obj, _ := vs.Retrieve("counter")
+	assert.Expect(t, 50, obj)
+
+	revs, _ := vs.History("counter")
+	assert.Expect(t, 50, len(revs))
+}
+
+func TestUpdateVersionedStoreRetries(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore())
+	vs.StoreItem("1", 1)
+
+	// The history is not locked while f runs, a write from within it
+	// is a conflict and f is called again.
+	calls := 0
+	err := Update(vs, "1", func(old Storable, exists bool) (Storable, error) {
+		calls++
+		if calls == 1 {
+			vs.StoreItem("1", 10)
+		}
+
+		return old.(int) + 1, nil
+	})
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, 2, calls)
+
+	obj, _ := vs.Retrieve("1")
+	assert.Expect(t, 11, obj)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+
+	vs := NewVersionedStore(NewMapStore(), NewMapStore())
+
+	assert.Expect(t, nil, vs.CompareAndSwap("1", 0, "one"))
+	assert.Expect(t, ErrConflict, vs.CompareAndSwap("1", 0, "uno"))
+
+	obj, rev, err := vs.RetrieveVersion("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "one", obj)
+	assert.Expect(t, 1, rev)
+
+	assert.Expect(t, nil, vs.CompareAndSwap("1", 1, "uno"))
+
+	vs.Delete("1")
+	_, rev, err = vs.RetrieveVersion("1")
+	assert.Expect(t, ErrNotFound, err)
+	assert.Expect(t, 0, rev)
+
+	assert.Expect(t, nil, vs.CompareAndSwap("1", 0, "one again"))
+}
+
+func TestUpdateUnsupported(t *testing.T) {
+	s := NewTracedStore(struct{ Store }{NewMapStore()}, NopTracer, "test")
+	assert.Expect(t, ErrUpdateUnsupported, Update(s, "1", increment))
+}
+
+func TestUpdateDecorators(t *testing.T) {
+
+	sprint := func(obj Storable) ([]byte, error) {
+		return ([]byte)(fmt.Sprint(obj)), nil
+	}
+
+	audited, _ := NewAuditedStore(NewMapStore(), StoreAuditSink(NewMapStore()), sprint)
+	namespaced, _ := NewNamespacedStore(NewSyncStore(NewMapStore()), "acme", OptQuota(Quota{MaxItems: 1}))
+
+	stores := map[string]Store{
+		"traced":     NewTracedStore(NewSyncStore(NewMapStore()), NopTracer, "test"),
+		"schema":     NewSchemaStore(NewSyncStore(NewMapStore()), NewSchemaRegistry(1)),
+		"versioned":  NewVersionedStore(NewMapStore(), NewMapStore()),
+		"audited":    audited,
+		"namespaced": namespaced,
+		"authorized": NewAuthorizedStore(NewSyncStore(NewMapStore()), NewRulePolicy(AllowAnyone())),
+	}
+
+	for name, s := range stores {
+		s := s
+		t.Run(name, func(t *testing.T) {
+			concurrentIncrements(t, s, 50)
+
+			obj, err := s.Retrieve("counter")
+			assert.Expect(t, nil, err)
+			assert.Expect(t, 50, obj)
+		})
+	}
+
+	err := UpdateContext(context.Background(), namespaced, "other", increment)
+	assert.Expect(t, ErrQuotaExceeded, err)
+}
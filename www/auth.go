@@ -0,0 +1,34 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "net/http"
+
+// Identifies the caller of a request, see OptSetAuthenticator.
+//
+// An empty principal is an anonymous caller.  Returning an error
+// rejects the request, stored.ErrUnauthenticated as 401 Unauthorized.
+//
+type Authenticator func(*http.Request) (string, error)
+
+// Authenticate callers with HTTP Basic credentials checked by check.
+//
+// Requests without credentials are anonymous, those with credentials
+// failing the check are rejected.
+//
+func BasicAuthenticator(check func(user, password string) bool) Authenticator {
+	return func(req *http.Request) (string, error) {
+
+		user, password, ok := req.BasicAuth()
+		if !ok {
+			return "", nil
+		}
+
+		if !check(user, password) {
+			return "", stored.ErrUnauthenticated
+		}
+
+		return user, nil
+	}
+}
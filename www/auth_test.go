@@ -0,0 +1,63 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "net/http/httptest"
+import "net/http"
+import "io/ioutil"
+import "log"
+
+func TestAuthTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+
+	store := stored.NewMapStore()
+	store.StoreItem("1", "Hello World!")
+
+	policy := stored.NewRulePolicy(stored.AllowAuthenticated(stored.ActionRead))
+
+	ds := NewDataServer(
+		"/test",
+		stored.NewAuthorizedStore(store, policy),
+		nil,
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetAuthenticator(BasicAuthenticator(func(user, password string) bool {
+			return user == "alice" && password == "secret"
+		}), `Basic realm="test"`),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	get := func(id, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test/"+id, nil)
+		req.Header.Add("Accept", "text/plain")
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res
+	}
+
+	res := get("1", "", "")
+	assert.Expect(t, http.StatusUnauthorized, res.Code)
+	assert.Expect(t, `Basic realm="test"`, res.Header().Get("WWW-Authenticate"))
+
+	res = get("1", "alice", "wrong")
+	assert.Expect(t, http.StatusUnauthorized, res.Code)
+	assert.Expect(t, `Basic realm="test"`, res.Header().Get("WWW-Authenticate"))
+
+	res = get("1", "alice", "secret")
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "Hello World!", res.Body.String())
+	assert.Expect(t, "", res.Header().Get("WWW-Authenticate"))
+
+	// Missing items are only revealed to callers allowed to read them.
+	assert.Expect(t, http.StatusUnauthorized, get("2", "", "").Code)
+	assert.Expect(t, http.StatusNotFound, get("2", "alice", "secret").Code)
+}
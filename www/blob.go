@@ -0,0 +1,551 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "strconv"
+import "strings"
+
+// The path segment under which blobs are served.
+const BlobPath = "_blobs"
+
+// The path segment under which chunked uploads are accepted.
+const UploadPath = "_uploads"
+
+// Headers of the chunked upload protocol.
+const (
+	UploadOffsetHeader = "Upload-Offset"
+	UploadLengthHeader = "Upload-Length"
+	UploadTypeHeader   = "Upload-Content-Type"
+)
+
+// Serve the blobs of a stored.BlobStore.
+//
+// GET {base}/_blobs/ lists the blobs as JSON.  GET and HEAD
+// {base}/_blobs/{id} stream a blob, honouring single Range requests,
+// PUT streams the request body into the blob and DELETE removes it.
+//
+// With OptSetTenants each tenant sees only its own blobs.  Requests
+// are authorized by the policy of OptSetBlobPolicy.
+//
+func (ds DataServer) ServeBlob(tail string, res http.ResponseWriter, req *http.Request) {
+
+	id, _ := ShiftPath(tail)
+
+	switch {
+	case id == "" && req.Method == "GET":
+		err := ds.authorizeBlob(req, stored.ActionList, "", nil)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error listing the blobs, "+err.Error(),
+				res, req)
+			return
+		}
+
+		ids, err := ds.blobs.ListBlobs()
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error listing the blobs, "+err.Error(),
+				res, req)
+			return
+		}
+
+		ds.writeJSON(ids, res, req)
+
+	case id == "":
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid URL, "+req.URL.EscapedPath(),
+			res, req)
+
+	case req.Method == "GET" || req.Method == "HEAD":
+		ds.getBlob((stored.ID)(id), res, req)
+
+	case req.Method == "PUT":
+		m := stored.ReadMetaHeader(req.Header)
+		meta := stored.BlobMeta{
+			ContentType: m.ContentType,
+			Tags:        m.Tags,
+			Labels:      m.Labels,
+		}
+
+		err := ds.authorizeBlobWrite(req, (stored.ID)(id), meta)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error storing the blob, "+err.Error(),
+				res, req)
+			return
+		}
+
+		err = ds.blobs.PutStream((stored.ID)(id), req.Body, meta)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error storing the blob, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	case req.Method == "DELETE":
+		err := ds.authorizeStoredBlob(req, stored.ActionDelete, (stored.ID)(id))
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error deleting the blob, "+err.Error(),
+				res, req)
+			return
+		}
+
+		err = ds.blobs.DeleteBlob((stored.ID)(id))
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error deleting the blob, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		ds.ServeError(http.StatusNotImplemented,
+			"Invalid Method, "+req.Method+".",
+			res, req)
+	}
+}
+
+// Check the blob policy, if any, for an action by the principal of
+// req.
+func (ds DataServer) authorizeBlob(req *http.Request, action stored.Action, id stored.ID, obj stored.Storable) error {
+
+	if ds.blobPolicy == nil {
+		return nil
+	}
+
+	return ds.blobPolicy.Authorize(stored.Principal(req.Context()), action, id, obj)
+}
+
+// Check an action on a stored blob against its metadata.
+//
+// Missing blobs are checked without metadata, so that callers denied
+// access can not tell whether a blob exists.
+//
+func (ds DataServer) authorizeStoredBlob(req *http.Request, action stored.Action, id stored.ID) error {
+
+	if ds.blobPolicy == nil {
+		return nil
+	}
+
+	meta, err := ds.blobs.StatBlob(id)
+	switch err {
+	case nil:
+		return ds.authorizeBlob(req, action, id, meta)
+	case stored.ErrNotFound:
+		return ds.authorizeBlob(req, action, id, nil)
+	}
+
+	return err
+}
+
+// Check a write of a blob against any existing blob and the new
+// metadata, as stored.AuthorizedStore does for items.
+func (ds DataServer) authorizeBlobWrite(req *http.Request, id stored.ID, meta stored.BlobMeta) error {
+
+	if ds.blobPolicy == nil {
+		return nil
+	}
+
+	old, err := ds.blobs.StatBlob(id)
+	if err != nil && err != stored.ErrNotFound {
+		return err
+	}
+
+	if err == nil {
+		err = ds.authorizeBlob(req, stored.ActionWrite, id, old)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ds.authorizeBlob(req, stored.ActionWrite, id, meta)
+}
+
+func (ds DataServer) getBlob(id stored.ID, res http.ResponseWriter, req *http.Request) {
+
+	err := ds.authorizeStoredBlob(req, stored.ActionRead, id)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error retrieving the blob, "+err.Error(),
+			res, req)
+		return
+	}
+
+	rng := req.Header.Get("Range")
+
+	// Stores reading ranges themselves avoid opening the whole blob.
+	if _, ok := ds.blobs.(stored.BlobRanger); ok && rng != "" {
+		meta, err := ds.blobs.StatBlob(id)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error retrieving the blob, "+err.Error(),
+				res, req)
+			return
+		}
+
+		offset, length, ok := ds.blobRange(rng, meta, res, req)
+		if !ok {
+			return
+		}
+
+		part, meta, err := stored.GetRange(ds.blobs, id, offset, length)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error retrieving the blob, "+err.Error(),
+				res, req)
+			return
+		}
+
+		defer part.Close()
+
+		ds.writeBlob(http.StatusPartialContent, meta, part, length, res, req)
+		return
+	}
+
+	rc, meta, err := ds.blobs.GetStream(id)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error retrieving the blob, "+err.Error(),
+			res, req)
+		return
+	}
+
+	defer rc.Close()
+
+	// Seekable blobs get the full Range and conditional request
+	// handling of the standard library.
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		writeBlobMeta(res.Header(), meta)
+		http.ServeContent(res, req, "", meta.ModTime, rs)
+		return
+	}
+
+	if rng == "" {
+		ds.writeBlob(http.StatusOK, meta, rc, meta.Size, res, req)
+		return
+	}
+
+	offset, length, ok := ds.blobRange(rng, meta, res, req)
+	if !ok {
+		return
+	}
+
+	_, err = io.CopyN(ioutil.Discard, rc, offset)
+	if err != nil {
+		ds.ServeError(http.StatusInternalServerError,
+			"Error retrieving the blob, "+err.Error(),
+			res, req)
+		return
+	}
+
+	ds.writeBlob(http.StatusPartialContent, meta, rc, length, res, req)
+}
+
+// Set the headers describing a blob, other than its size.
+func writeBlobMeta(h http.Header, meta stored.BlobMeta) {
+
+	t := meta.ContentType
+	if t == "" {
+		t = "application/octet-stream"
+	}
+
+	h.Set("Content-Type", t)
+
+	stored.WriteMetaHeader(h, stored.Meta{
+		Created: meta.Created,
+		Tags:    meta.Tags,
+		Labels:  meta.Labels,
+	})
+}
+
+// Parse the requested range, serving an error when it can not be
+// satisfied.
+func (ds DataServer) blobRange(rng string, meta stored.BlobMeta, res http.ResponseWriter, req *http.Request) (int64, int64, bool) {
+
+	offset, length, ok := ParseRange(rng, meta.Size)
+	if !ok {
+		res.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", meta.Size))
+		ds.ServeError(http.StatusRequestedRangeNotSatisfiable,
+			"Invalid range, "+rng,
+			res, req)
+		return 0, 0, false
+	}
+
+	res.Header().Set("Content-Range",
+		fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, meta.Size))
+
+	return offset, length, true
+}
+
+// Write size bytes of a blob from body.
+func (ds DataServer) writeBlob(code int, meta stored.BlobMeta, body io.Reader, size int64, res http.ResponseWriter, req *http.Request) {
+
+	writeBlobMeta(res.Header(), meta)
+	res.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	res.Header().Set("Accept-Ranges", "bytes")
+	if !meta.ModTime.IsZero() {
+		res.Header().Set("Last-Modified", meta.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	res.WriteHeader(code)
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	_, err := io.CopyN(res, body, size)
+	if err != nil {
+		ds.Println(req.Method + " " + req.URL.EscapedPath() + " " +
+			"Write Error" + " - " + err.Error())
+	}
+}
+
+// Parse a Range header holding a single byte range of a blob of the
+// given size, returning the offset and length of the range.
+//
+// Multiple ranges are not supported.
+//
+func ParseRange(s string, size int64) (offset, length int64, ok bool) {
+
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, 0, false
+	}
+
+	spec := strings.TrimSpace(s[len("bytes="):])
+
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false
+	}
+
+	start, end := spec[:i], spec[i+1:]
+
+	if start == "" {
+		// A suffix range, the last n bytes.
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+
+		if n > size {
+			n = size
+		}
+
+		return size - n, n, true
+	}
+
+	offset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, 0, false
+	}
+
+	last := size - 1
+	if end != "" {
+		last, err = strconv.ParseInt(end, 10, 64)
+		if err != nil || last < offset {
+			return 0, 0, false
+		}
+
+		if last >= size {
+			last = size - 1
+		}
+	}
+
+	return offset, last - offset + 1, true
+}
+
+// Serve resumable chunked uploads into the blob store.
+//
+// POST {base}/_uploads?id={id} starts an upload of the blob id, with
+// the optional Upload-Length and Upload-Content-Type headers giving
+// its size and type.  The Location of the upload is returned.
+//
+// HEAD on the upload returns the Upload-Offset reached so far, PATCH
+// appends the body as a chunk starting at the Upload-Offset of the
+// request, PUT completes the upload and DELETE discards it.
+//
+// Uploads are kept to the tenant and checked against the blob policy
+// as writes of their blob, see ServeBlob.
+//
+func (ds DataServer) ServeUpload(tail string, res http.ResponseWriter, req *http.Request) {
+
+	token, _ := ShiftPath(tail)
+
+	if token == "" {
+		if req.Method != "POST" {
+			ds.ServeError(http.StatusNotImplemented,
+				"Invalid Method, "+req.Method+".",
+				res, req)
+			return
+		}
+
+		ds.beginUpload(res, req)
+		return
+	}
+
+	id, err := ds.upload(token, req)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error reading the upload, "+err.Error(),
+			res, req)
+		return
+	}
+
+	switch req.Method {
+	case "HEAD":
+		offset, err := ds.uploads.Offset(token)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error reading the upload, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.Header().Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+		res.WriteHeader(http.StatusOK)
+
+	case "PATCH":
+		offset, err := strconv.ParseInt(req.Header.Get(UploadOffsetHeader), 10, 64)
+		if err != nil {
+			ds.ServeError(http.StatusBadRequest,
+				"Invalid "+UploadOffsetHeader+" header.",
+				res, req)
+			return
+		}
+
+		offset, err = ds.uploads.Write(token, offset, req.Body)
+		res.Header().Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error writing the upload, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	case "PUT":
+		_, err := ds.uploads.Complete(token)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error completing the upload, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.Header().Set("Location", ds.base+"/"+BlobPath+"/"+stored.EscapeID(id))
+		res.WriteHeader(http.StatusCreated)
+
+	case "DELETE":
+		err := ds.uploads.Abort(token)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error discarding the upload, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		ds.ServeError(http.StatusNotImplemented,
+			"Invalid Method, "+req.Method+".",
+			res, req)
+	}
+}
+
+func (ds DataServer) beginUpload(res http.ResponseWriter, req *http.Request) {
+
+	meta := stored.BlobMeta{ContentType: req.Header.Get(UploadTypeHeader)}
+
+	if l := req.Header.Get(UploadLengthHeader); l != "" {
+		size, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || size < 0 {
+			ds.ServeError(http.StatusBadRequest,
+				"Invalid "+UploadLengthHeader+" header.",
+				res, req)
+			return
+		}
+
+		meta.Size = size
+	}
+
+	id := (stored.ID)(req.URL.Query().Get("id"))
+
+	err := ds.authorizeUpload(req, id, meta)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error starting the upload, "+err.Error(),
+			res, req)
+		return
+	}
+
+	if id != "" {
+		id = ds.blobID(id)
+	}
+
+	token, err := ds.uploads.Begin(id, meta)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error starting the upload, "+err.Error(),
+			res, req)
+		return
+	}
+
+	res.Header().Set("Location", ds.base+"/"+UploadPath+"/"+url.PathEscape(token))
+	res.Header().Set(UploadOffsetHeader, "0")
+	res.WriteHeader(http.StatusCreated)
+}
+
+// The ID of a blob of the tenant in the blob store of the uploads.
+func (ds DataServer) blobID(id stored.ID) stored.ID {
+
+	if ds.tenant == "" {
+		return id
+	}
+
+	return (stored.ID)(ds.tenant+stored.NamespaceSep) + id
+}
+
+// Check a write of the blob of an upload, as for PUT requests.
+func (ds DataServer) authorizeUpload(req *http.Request, id stored.ID, meta stored.BlobMeta) error {
+
+	if ds.blobs == nil {
+		return ds.authorizeBlob(req, stored.ActionWrite, id, meta)
+	}
+
+	return ds.authorizeBlobWrite(req, id, meta)
+}
+
+// The ID of the blob of an upload, within the tenant.
+//
+// Uploads of other tenants are not found.
+//
+func (ds DataServer) upload(token string, req *http.Request) (stored.ID, error) {
+
+	id, meta, err := ds.uploads.Info(token)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := (string)(ds.blobID(""))
+	if !strings.HasPrefix((string)(id), prefix) {
+		return "", stored.ErrNotFound
+	}
+
+	id = id[len(prefix):]
+
+	return id, ds.authorizeUpload(req, id, meta)
+}
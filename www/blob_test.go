@@ -0,0 +1,387 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "net/http/httptest"
+import "strings"
+import "net/http"
+import "io"
+import "io/ioutil"
+import "log"
+import "os"
+
+func TestBlobTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func newBlobServer(bs stored.BlobStore, opts ...WWWOpt) *httptest.Server {
+
+	opts = append([]WWWOpt{
+		OptSetBlobStore(bs),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	}, opts...)
+
+	return httptest.NewServer(NewDataServer("/data", stored.NewMapStore(), nil, opts...))
+}
+
+func readAll(t *testing.T, res *http.Response) string {
+	defer res.Body.Close()
+
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return (string)(bs)
+}
+
+func TestParseRange(t *testing.T) {
+
+	tests := []struct {
+		s      string
+		offset int64
+		length int64
+		ok     bool
+	}{
+		{"bytes=0-4", 0, 5, true},
+		{"bytes=6-", 6, 6, true},
+		{"bytes=-3", 9, 3, true},
+		{"bytes=-30", 0, 12, true},
+		{"bytes=10-100", 10, 2, true},
+		{"bytes=12-", 0, 0, false},
+		{"bytes=4-2", 0, 0, false},
+		{"bytes=0-1,4-5", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		offset, length, ok := ParseRange(test.s, 12)
+		assert.Expect(t, test.ok, ok)
+		assert.Expect(t, test.offset, offset)
+		assert.Expect(t, test.length, length)
+	}
+}
+
+func TestHttpBlobStore(t *testing.T) {
+
+	srv := newBlobServer(stored.NewMemBlobStore())
+	defer srv.Close()
+
+	hbs := stored.NewHttpBlobStore(srv.URL+"/data/_blobs",
+		stored.OptBlobClient(srv.Client()))
+
+	err := hbs.PutStream("a/b", strings.NewReader("Hello World!"),
+		stored.BlobMeta{ContentType: "text/plain", Tags: []string{"greeting"}})
+	assert.Expect(t, nil, err)
+
+	rc, meta, err := hbs.GetStream("a/b")
+	assert.Expect(t, nil, err)
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+
+	assert.Expect(t, "Hello World!", (string)(data))
+	assert.Expect(t, "text/plain", meta.ContentType)
+	assert.Expect(t, int64(12), meta.Size)
+	assert.Expect(t, []string{"greeting"}, meta.Tags)
+
+	if meta.Created.IsZero() {
+		t.Error("Expected the creation time to be set.")
+	}
+
+	rc, meta, err = hbs.GetRange("a/b", 6, 5)
+	assert.Expect(t, nil, err)
+	data, _ = ioutil.ReadAll(rc)
+	rc.Close()
+
+	assert.Expect(t, "World", (string)(data))
+	assert.Expect(t, int64(12), meta.Size)
+
+	_, _, err = hbs.GetRange("a/b", 20, -1)
+	assert.Expect(t, stored.ErrInvalidRange, err)
+
+	meta, err = hbs.StatBlob("a/b")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, int64(12), meta.Size)
+
+	ids, err := hbs.ListBlobs()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []stored.ID{"a/b"}, ids)
+
+	assert.Expect(t, nil, hbs.DeleteBlob("a/b"))
+
+	_, _, err = hbs.GetStream("a/b")
+	assert.Expect(t, stored.ErrNotFound, err)
+}
+
+// A blob store hiding the ability to seek or read ranges.
+type streamOnly struct {
+	stored.BlobStore
+}
+
+func (s streamOnly) GetStream(id stored.ID) (io.ReadCloser, stored.BlobMeta, error) {
+	rc, meta, err := s.BlobStore.GetStream(id)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{rc, rc}, meta, nil
+}
+
+func TestBlobRanges(t *testing.T) {
+
+	bs := stored.NewMemBlobStore()
+	bs.PutStream("1", strings.NewReader("Hello World!"), stored.BlobMeta{})
+
+	origin := newBlobServer(bs)
+	defer origin.Close()
+
+	// A server proxying the origin, serving ranges with GetRange.
+	proxy := newBlobServer(stored.NewHttpBlobStore(origin.URL+"/data/_blobs",
+		stored.OptBlobClient(origin.Client())))
+	defer proxy.Close()
+
+	// A server reading through whole streams.
+	stream := newBlobServer(streamOnly{bs})
+	defer stream.Close()
+
+	for _, srv := range []*httptest.Server{origin, proxy, stream} {
+
+		req, _ := http.NewRequest("GET", srv.URL+"/data/_blobs/1", nil)
+		req.Header.Set("Range", "bytes=-6")
+
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Expect(t, http.StatusPartialContent, res.StatusCode)
+		assert.Expect(t, "bytes 6-11/12", res.Header.Get("Content-Range"))
+		assert.Expect(t, "World!", readAll(t, res))
+
+		req.Header.Set("Range", "bytes=20-")
+		res, _ = srv.Client().Do(req)
+		readAll(t, res)
+
+		assert.Expect(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+
+		res, _ = srv.Client().Head(srv.URL + "/data/_blobs/1")
+		readAll(t, res)
+
+		assert.Expect(t, http.StatusOK, res.StatusCode)
+		assert.Expect(t, "12", res.Header.Get("Content-Length"))
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	bs := stored.NewMemBlobStore()
+	uploads, _ := stored.NewUploads(bs, dir)
+
+	srv := newBlobServer(bs, OptSetUploads(uploads))
+	defer srv.Close()
+
+	c := srv.Client()
+
+	req, _ := http.NewRequest("POST", srv.URL+"/data/_uploads?id=big", nil)
+	req.Header.Set(UploadLengthHeader, "10")
+	req.Header.Set(UploadTypeHeader, "text/plain")
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, res)
+
+	assert.Expect(t, http.StatusCreated, res.StatusCode)
+	loc := res.Header.Get("Location")
+
+	chunk := func(offset, body string) *http.Response {
+		req, _ := http.NewRequest("PATCH", srv.URL+loc, strings.NewReader(body))
+		req.Header.Set(UploadOffsetHeader, offset)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAll(t, res)
+		return res
+	}
+
+	res = chunk("0", "01234")
+	assert.Expect(t, http.StatusNoContent, res.StatusCode)
+	assert.Expect(t, "5", res.Header.Get(UploadOffsetHeader))
+
+	res = chunk("2", "23456")
+	assert.Expect(t, http.StatusConflict, res.StatusCode)
+	assert.Expect(t, "5", res.Header.Get(UploadOffsetHeader))
+
+	res, _ = c.Head(srv.URL + loc)
+	assert.Expect(t, "5", res.Header.Get(UploadOffsetHeader))
+
+	res = chunk("5", "56789")
+	assert.Expect(t, http.StatusNoContent, res.StatusCode)
+
+	req, _ = http.NewRequest("PUT", srv.URL+loc, nil)
+	res, _ = c.Do(req)
+	readAll(t, res)
+
+	assert.Expect(t, http.StatusCreated, res.StatusCode)
+	assert.Expect(t, "/data/_blobs/big", res.Header.Get("Location"))
+
+	res, _ = c.Get(srv.URL + "/data/_blobs/big")
+	assert.Expect(t, "0123456789", readAll(t, res))
+	assert.Expect(t, "text/plain", res.Header.Get("Content-Type"))
+}
+
+func TestWWW2MaxBodySize(t *testing.T) {
+
+	ds := NewDataServer(
+		"/test",
+		stored.NewMapStore(),
+		stored.IncrIDGen(),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetMaxBodySize(5),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	put := func(body string) int {
+		req := httptest.NewRequest("PUT", "/test/1", strings.NewReader(body))
+		req.Header.Add("Content-Type", "text/plain")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusNoContent, put("Hello"))
+	assert.Expect(t, http.StatusRequestEntityTooLarge, put("Hello World!"))
+
+	ds.Options(OptSetMaxBodySize(0))
+	assert.Expect(t, http.StatusNoContent, put("Hello World!"))
+
+	ds = NewDataServer("/test", stored.NewMapStore(), nil,
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	assert.Expect(t, http.StatusRequestEntityTooLarge, put(strings.Repeat("x", DefaultMaxBodySize+1)))
+}
+
+func TestTenantBlobs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	bs := stored.NewMemBlobStore()
+	uploads, _ := stored.NewUploads(bs, dir)
+
+	ds := NewDataServer(
+		"/test",
+		nil,
+		nil,
+		OptSetBlobStore(bs),
+		OptSetUploads(uploads),
+		OptSetTenants(stored.NewTenants(stored.NewMapStore()), HeaderTenant("X-Tenant")),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	do := func(tenant, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Tenant", tenant)
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res
+	}
+
+	assert.Expect(t, http.StatusNoContent, do("acme", "PUT", "/test/_blobs/a", "Acme").Code)
+	assert.Expect(t, http.StatusNoContent, do("globex", "PUT", "/test/_blobs/a", "Globex").Code)
+
+	ids, _ := bs.ListBlobs()
+	assert.Expect(t, []stored.ID{"acme/a", "globex/a"}, ids)
+
+	res := do("acme", "GET", "/test/_blobs/a", "")
+	assert.Expect(t, "Acme", res.Body.String())
+
+	res = do("acme", "GET", "/test/_blobs/", "")
+	assert.Expect(t, "[\"a\"]", strings.TrimSpace(res.Body.String()))
+
+	assert.Expect(t, http.StatusNoContent, do("globex", "DELETE", "/test/_blobs/a", "").Code)
+	assert.Expect(t, http.StatusOK, do("acme", "GET", "/test/_blobs/a", "").Code)
+
+	res = do("acme", "POST", "/test/_uploads?id=up", "")
+	assert.Expect(t, http.StatusCreated, res.Code)
+	loc := res.Header().Get("Location")
+
+	assert.Expect(t, http.StatusNotFound, do("globex", "HEAD", loc, "").Code)
+	assert.Expect(t, http.StatusNotFound, do("globex", "PUT", loc, "").Code)
+
+	req := httptest.NewRequest("PATCH", loc, strings.NewReader("Up"))
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set(UploadOffsetHeader, "0")
+	ds.ServeHTTP(httptest.NewRecorder(), req)
+
+	res = do("acme", "PUT", loc, "")
+	assert.Expect(t, http.StatusCreated, res.Code)
+	assert.Expect(t, "/test/_blobs/up", res.Header().Get("Location"))
+
+	ids, _ = bs.ListBlobs()
+	assert.Expect(t, []stored.ID{"acme/a", "acme/up"}, ids)
+}
+
+func TestBlobPolicy(t *testing.T) {
+
+	bs := stored.NewMemBlobStore()
+	bs.PutStream("mine", strings.NewReader("Mine"), stored.BlobMeta{Labels: map[string]string{"owner": "alice"}})
+	bs.PutStream("theirs", strings.NewReader("Theirs"), stored.BlobMeta{Labels: map[string]string{"owner": "bob"}})
+
+	owner := func(id stored.ID, obj stored.Storable) string {
+		return obj.(stored.BlobMeta).Labels["owner"]
+	}
+
+	policy := stored.NewRulePolicy(
+		stored.AllowOwner(owner, stored.ActionRead, stored.ActionDelete),
+		stored.AllowAuthenticated(stored.ActionList),
+	)
+
+	ms := stored.NewAuthorizedStore(stored.NewMapStore(), policy)
+	ds := NewDataServer("/test", ms, nil,
+		OptSetBlobStore(bs),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	do := func(principal, method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if principal != "" {
+			req = req.WithContext(stored.WithPrincipal(req.Context(), principal))
+		}
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusUnauthorized, do("", "GET", "/test/_blobs/"))
+	assert.Expect(t, http.StatusOK, do("alice", "GET", "/test/_blobs/"))
+	assert.Expect(t, http.StatusOK, do("alice", "GET", "/test/_blobs/mine"))
+	assert.Expect(t, http.StatusForbidden, do("alice", "GET", "/test/_blobs/theirs"))
+	assert.Expect(t, http.StatusForbidden, do("alice", "GET", "/test/_blobs/missing"))
+	assert.Expect(t, http.StatusForbidden, do("alice", "DELETE", "/test/_blobs/theirs"))
+	assert.Expect(t, http.StatusForbidden, do("alice", "PUT", "/test/_blobs/mine"))
+
+	ids, _ := bs.ListBlobs()
+	assert.Expect(t, 2, len(ids))
+}
@@ -0,0 +1,115 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/stored/storetest"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "io/ioutil"
+import "log"
+
+func TestConformanceHttpStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+
+		ds := NewDataServer(
+			"/",
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.IncrIDGen(),
+			OptSetEncoder("text/plain", PlainStringEncoder),
+			OptSetDecoder("text/plain", PlainStringDecoder),
+			OptSetListEncoder("text/plain", PlainStringListEncoder("\n")),
+			OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+		)
+
+		srv := httptest.NewServer(ds)
+
+		hdrs := &http.Header{}
+		hdrs.Add("Accept", "text/plain")
+		hdrs.Add("Content-Type", "text/plain")
+
+		hs := stored.NewHttpStore(
+			stored.SimpleStoreReq("PUT", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+			stored.SimpleStoreReq("GET", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+			stored.SimpleStoreReq("GET", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+			stored.SimpleStoreReq("DELETE", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+			stored.StringMarshaler, stored.StringUnmarshaler,
+			stored.StringIDUnmarshaler("\n"),
+			stored.OptUseClient(srv.Client()),
+		)
+
+		return hs, srv.Close
+	})
+}
+
+func TestConformanceCodecHttpStore(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+
+		codecs := stored.NewCodecRegistry(stored.StringCodec)
+		codecs.RegisterList(stored.StringListCodec("\n"))
+
+		ds := NewDataServer(
+			"/",
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.IncrIDGen(),
+			OptSetCodecs(codecs),
+			OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+		)
+
+		srv := httptest.NewServer(ds)
+
+		hs := stored.NewCodecHttpStore(srv.URL, codecs, "text/plain",
+			stored.OptUseClient(srv.Client()))
+
+		return hs, srv.Close
+	})
+}
+
+func TestConformanceDefaultJSON(t *testing.T) {
+	storetest.RunConformance(t, func() (stored.Store, func()) {
+
+		ds := NewDataServer(
+			"/",
+			stored.NewSyncStore(stored.NewMapStore()),
+			stored.IncrIDGen(),
+			OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+		)
+
+		srv := httptest.NewServer(ds)
+
+		hs := stored.NewCodecHttpStore(srv.URL, nil, "",
+			stored.OptUseClient(srv.Client()))
+
+		return hs, srv.Close
+	})
+}
+
+func TestConformanceStandardCodecs(t *testing.T) {
+
+	codecs := stored.StandardCodecs("")
+
+	for _, mt := range codecs.MediaTypes() {
+		mt := mt
+
+		t.Run(mt, func(t *testing.T) {
+			storetest.RunConformance(t, func() (stored.Store, func()) {
+
+				ds := NewDataServer(
+					"/",
+					stored.NewSyncStore(stored.NewMapStore()),
+					stored.IncrIDGen(),
+					OptSetCodecs(codecs),
+					OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+				)
+
+				srv := httptest.NewServer(ds)
+
+				hs := stored.NewCodecHttpStore(srv.URL, codecs, mt,
+					stored.OptUseClient(srv.Client()))
+
+				return hs, srv.Close
+			})
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/tested/assert"
+import "context"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "io/ioutil"
+import "log"
+
+func TestMetaTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestWWW2Meta(t *testing.T) {
+
+	ds := NewDataServer(
+		"/",
+		stored.NewSyncStore(stored.NewMapStore()),
+		stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	srv := httptest.NewServer(ds)
+	defer srv.Close()
+
+	hdrs := &http.Header{}
+	hdrs.Add("Accept", "text/plain")
+	hdrs.Add("Content-Type", "text/plain")
+
+	hs := stored.NewHttpStore(
+		stored.SimpleStoreReq("PUT", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.SimpleStoreReq("GET", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.SimpleStoreReq("GET", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.SimpleStoreReq("DELETE", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.StringMarshaler, stored.StringUnmarshaler,
+		stored.StringIDUnmarshaler("\n"),
+		stored.OptUseClient(srv.Client()),
+	)
+
+	var ms stored.MetaStore = hs
+
+	err := ms.StoreItemWithMeta("1", "Hello World!", stored.Meta{
+		Tags:   []string{"greeting"},
+		Labels: map[string]string{"lang": "en"},
+	})
+	assert.Expect(t, nil, err)
+
+	obj, meta, err := ms.RetrieveMeta("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello World!", obj)
+	assert.Expect(t, "text/plain", meta.ContentType)
+	assert.Expect(t, int64(12), meta.Size)
+	assert.Expect(t, []string{"greeting"}, meta.Tags)
+	assert.Expect(t, map[string]string{"lang": "en"}, meta.Labels)
+
+	if meta.Created.IsZero() || meta.Updated.IsZero() {
+		t.Error("Expected the timestamps to be set.")
+	}
+
+	stat, err := ms.Stat("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, meta, stat)
+
+	_, err = ms.Stat("2")
+	assert.Expect(t, stored.ErrNotFound, err)
+}
+
+// Records the principals of the context aware meta calls.
+type principalMetaStore struct {
+	stored.MapStore
+	principals []string
+}
+
+func (s *principalMetaStore) StoreItemWithMetaContext(ctx context.Context, id stored.ID, obj stored.Storable, meta stored.Meta) error {
+	s.principals = append(s.principals, stored.Principal(ctx))
+	return s.StoreItemWithMeta(id, obj, meta)
+}
+
+func (s *principalMetaStore) RetrieveMetaContext(ctx context.Context, id stored.ID) (stored.Storable, stored.Meta, error) {
+	s.principals = append(s.principals, stored.Principal(ctx))
+	return s.RetrieveMeta(id)
+}
+
+func TestWWW2MetaContext(t *testing.T) {
+
+	ps := &principalMetaStore{MapStore: stored.NewMapStore()}
+
+	ds := NewDataServer(
+		"/test",
+		ps,
+		nil,
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	serve := func(method string) int {
+		req := httptest.NewRequest(method, "/test/1", strings.NewReader("Hello"))
+		req.Header.Add("Content-Type", "text/plain")
+		req.Header.Add("Accept", "text/plain")
+		req = req.WithContext(stored.WithPrincipal(req.Context(), "alice"))
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusNoContent, serve("PUT"))
+	assert.Expect(t, http.StatusOK, serve("GET"))
+	assert.Expect(t, []string{"alice", "alice"}, ps.principals)
+}
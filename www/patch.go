@@ -0,0 +1,126 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "bytes"
+import "encoding/json"
+import "reflect"
+
+// The media type of JSON merge patches.
+const MergePatchType = "application/merge-patch+json"
+
+// Apply a JSON merge patch, as described by RFC 7386.
+//
+// The current item is converted to its generic JSON form before the
+// patch is applied.  Patched structs, and pointers to structs, are
+// decoded back into their type, while other items are made of maps,
+// slices, strings, float64s, bools and nils.  Missing items are
+// patched as null, creating them.
+//
+// Register with OptSetPatcher(MergePatchType, JSONMergePatch).
+//
+func JSONMergePatch(old stored.Storable, exists bool, patch []byte) (stored.Storable, error) {
+
+	var p interface{}
+	err := json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	t, typed := structType(old)
+
+	var target interface{}
+	if exists {
+		bs, err := json.Marshal(old)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep the numbers of typed items exact.
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		if typed {
+			dec.UseNumber()
+		}
+
+		err = dec.Decode(&target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	patched := mergePatch(target, p)
+	if !exists || !typed {
+		return patched, nil
+	}
+
+	return retype(patched, t)
+}
+
+// The type of a struct, or pointer to a struct, item.
+func structType(obj stored.Storable) (reflect.Type, bool) {
+
+	t := reflect.TypeOf(obj)
+	if t == nil {
+		return nil, false
+	}
+
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+
+	return t, st.Kind() == reflect.Struct
+}
+
+// Decode the generic JSON form of a patched item into the struct type
+// t, or the pointer type t.
+func retype(patched interface{}, t reflect.Type) (stored.Storable, error) {
+
+	bs, err := json.Marshal(patched)
+	if err != nil {
+		return nil, err
+	}
+
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+
+	v := reflect.New(st)
+
+	err = json.Unmarshal(bs, v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return v.Interface(), nil
+	}
+
+	return v.Elem().Interface(), nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	tm, ok := target.(map[string]interface{})
+	if !ok {
+		tm = map[string]interface{}{}
+	}
+
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+			continue
+		}
+
+		tm[k] = mergePatch(tm[k], v)
+	}
+
+	return tm
+}
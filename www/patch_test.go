@@ -0,0 +1,151 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "net/http/httptest"
+import "strings"
+import "net/http"
+import "io/ioutil"
+import "log"
+import "sync"
+
+func TestPatchTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func TestJSONMergePatch(t *testing.T) {
+
+	old := map[string]interface{}{
+		"title": "Goodbye!",
+		"author": map[string]interface{}{
+			"givenName":  "John",
+			"familyName": "Doe",
+		},
+		"tags": []interface{}{"example", "sample"},
+	}
+
+	obj, err := JSONMergePatch(old, true, []byte(`{
+		"title": "Hello!",
+		"author": {"familyName": null},
+		"tags": ["example"],
+		"phoneNumber": "+01-123-456-7890"
+	}`))
+
+	assert.Expect(t, nil, err)
+	assert.Expect(t, map[string]interface{}{
+		"title":       "Hello!",
+		"author":      map[string]interface{}{"givenName": "John"},
+		"tags":        []interface{}{"example"},
+		"phoneNumber": "+01-123-456-7890",
+	}, obj)
+
+	obj, err = JSONMergePatch(nil, false, []byte(`{"a": 1, "b": null}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, map[string]interface{}{"a": 1.0}, obj)
+
+	_, err = JSONMergePatch(nil, false, []byte(`{`))
+	if err == nil {
+		t.Error("Expected an error for an invalid patch.")
+	}
+}
+
+func appendPatch(old stored.Storable, exists bool, patch []byte) (stored.Storable, error) {
+	if !exists {
+		return (string)(patch), nil
+	}
+
+	return old.(string) + (string)(patch), nil
+}
+
+type patchedOrder struct {
+	ID    string   `json:"id"`
+	Total int64    `json:"total"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func TestJSONMergePatchTyped(t *testing.T) {
+
+	old := patchedOrder{"1", 9007199254740993, []string{"new"}}
+
+	obj, err := JSONMergePatch(old, true, []byte(`{"tags": null}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, patchedOrder{"1", 9007199254740993, nil}, obj)
+
+	obj, err = JSONMergePatch(&old, true, []byte(`{"total": 12}`))
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &patchedOrder{"1", 12, []string{"new"}}, obj)
+
+	_, err = JSONMergePatch(old, true, []byte(`{"total": "twelve"}`))
+	if err == nil {
+		t.Error("Expected an error for a patch not matching the type.")
+	}
+}
+
+func TestWWW2Patch(t *testing.T) {
+
+	ms := stored.NewSyncStore(stored.NewMapStore())
+
+	ds := NewDataServer(
+		"/test",
+		ms,
+		stored.IncrIDGen(),
+		OptSetPatcher("text/plain", appendPatch),
+		OptSetPatcher(MergePatchType, JSONMergePatch),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	patch := func(id, t, body string) int {
+		req := httptest.NewRequest("PATCH", "/test/"+id, strings.NewReader(body))
+		req.Header.Add("Content-Type", t)
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Expect(t, http.StatusNoContent, patch("1", "text/plain", "x"))
+		}()
+	}
+	wg.Wait()
+
+	obj, _ := ms.Retrieve("1")
+	assert.Expect(t, strings.Repeat("x", 20), obj)
+
+	assert.Expect(t, http.StatusNoContent, patch("2", MergePatchType, `{"a": 1}`))
+	assert.Expect(t, http.StatusBadRequest, patch("2", MergePatchType, `{"a":`))
+	assert.Expect(t, http.StatusUnsupportedMediaType, patch("2", "text/csv", "a"))
+
+	obj, _ = ms.Retrieve("2")
+	assert.Expect(t, map[string]interface{}{"a": 1.0}, obj)
+}
+
+func TestWWW2PatchUnsupported(t *testing.T) {
+
+	// A bare MapStore can not be updated atomically.
+	for _, s := range []stored.Store{
+		stored.NewTracedStore(struct{ stored.Store }{stored.NewMapStore()}, stored.NopTracer, "test"),
+		stored.NewMapStore(),
+	} {
+		ds := NewDataServer(
+			"/test",
+			s,
+			stored.IncrIDGen(),
+			OptSetPatcher("text/plain", appendPatch),
+			OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+		)
+
+		req := httptest.NewRequest("PATCH", "/test/1", strings.NewReader("x"))
+		req.Header.Add("Content-Type", "text/plain")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+
+		assert.Expect(t, http.StatusNotImplemented, res.Code)
+	}
+}
@@ -0,0 +1,63 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "net/http"
+import "net/url"
+
+// Determines the tenant of a request, see OptSetTenants.
+//
+// Resolvers may consume a leading segment of the request path, which
+// is then treated as part of the base path of the tenant.
+//
+type TenantResolver func(*http.Request) (string, error)
+
+// Take the tenant from the first path segment, as in
+// {base}/{tenant}/{id}.
+func PathTenant() TenantResolver {
+	return func(req *http.Request) (string, error) {
+
+		head, tail := ShiftPath(req.URL.EscapedPath())
+		if head == "" {
+			return "", stored.ErrNoTenant
+		}
+
+		path, err := url.PathUnescape(tail)
+		if err != nil {
+			return "", err
+		}
+
+		req.URL.Path = path
+		req.URL.RawPath = tail
+
+		return head, nil
+	}
+}
+
+// Take the tenant from a request header.
+func HeaderTenant(name string) TenantResolver {
+	return func(req *http.Request) (string, error) {
+
+		tenant := req.Header.Get(name)
+		if tenant == "" {
+			return "", stored.ErrNoTenant
+		}
+
+		return tenant, nil
+	}
+}
+
+// Use the principal attached to the request context as the tenant,
+// see stored.WithPrincipal.
+func PrincipalTenant() TenantResolver {
+	return func(req *http.Request) (string, error) {
+
+		tenant := stored.Principal(req.Context())
+		if tenant == "" {
+			return "", stored.ErrUnauthenticated
+		}
+
+		return tenant, nil
+	}
+}
@@ -0,0 +1,189 @@
+/* Copyright 2019 Kilobit Labs Inc. */
+
+package www // import "kilobit.ca/go/stored/www"
+
+import "kilobit.ca/go/stored"
+import "kilobit.ca/go/tested/assert"
+import "testing"
+import "net/http/httptest"
+import "strings"
+import "net/http"
+import "io/ioutil"
+import "log"
+import "bytes"
+
+func TestTenantTest(t *testing.T) {
+	assert.Expect(t, true, true)
+}
+
+func newTenantServer(ms stored.Store, resolve TenantResolver) *DataServer {
+
+	tenants := stored.NewTenants(ms,
+		stored.OptTenantQuota(func(tenant string) stored.Quota {
+			return stored.Quota{MaxItems: 2}
+		}),
+		stored.OptTenantCheck(stored.AllowTenants("acme", "alice")),
+	)
+
+	return NewDataServer(
+		"/test",
+		nil,
+		stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetListEncoder("text/plain", PlainStringListEncoder("\n")),
+		OptSetTenants(tenants, resolve),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+}
+
+func TestPathTenant(t *testing.T) {
+
+	ms := stored.NewMapStore()
+	ds := newTenantServer(ms, PathTenant())
+
+	req := httptest.NewRequest("POST", "/test/acme", strings.NewReader("Hello"))
+	req.Header.Add("Content-Type", "text/plain")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusCreated, res.Code)
+	assert.Expect(t, "/test/acme/0", res.Header().Get("Location"))
+	assert.Expect(t, "Hello", ms["acme/0"])
+
+	req = httptest.NewRequest("GET", "/test/acme/0", nil)
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "Hello", res.Body.String())
+
+	req = httptest.NewRequest("GET", "/test/initech/0", nil)
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusNotFound, res.Code)
+
+	req = httptest.NewRequest("GET", "/test", nil)
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusBadRequest, res.Code)
+}
+
+func TestHeaderTenantQuota(t *testing.T) {
+
+	ms := stored.NewMapStore()
+	ds := newTenantServer(ms, HeaderTenant("X-Tenant"))
+
+	put := func(id string) int {
+		req := httptest.NewRequest("PUT", "/test/"+id, strings.NewReader("Hello"))
+		req.Header.Add("Content-Type", "text/plain")
+		req.Header.Add("X-Tenant", "acme")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusNoContent, put("a"))
+	assert.Expect(t, http.StatusNoContent, put("b"))
+	assert.Expect(t, http.StatusInsufficientStorage, put("c"))
+
+	req := httptest.NewRequest("GET", "/test/", nil)
+	req.Header.Add("Accept", "text/plain")
+	req.Header.Add("X-Tenant", "acme")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	ids := strings.Split(res.Body.String(), "\n")
+	assert.Expect(t, 2, len(ids))
+}
+
+func TestPrincipalTenant(t *testing.T) {
+
+	ms := stored.NewMapStore()
+	ms.StoreItem("alice/1", "Alice's")
+
+	ds := newTenantServer(ms, PrincipalTenant())
+
+	req := httptest.NewRequest("GET", "/test/1", nil)
+	req.Header.Add("Accept", "text/plain")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusUnauthorized, res.Code)
+
+	req = httptest.NewRequest("GET", "/test/1", nil)
+	req = req.WithContext(stored.WithPrincipal(req.Context(), "alice"))
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "Alice's", res.Body.String())
+}
+
+func TestTenantDecorators(t *testing.T) {
+
+	ms := stored.NewMapStore()
+	tenants := stored.NewTenants(ms,
+		stored.OptTenantCheck(stored.AllowTenants("acme")),
+		stored.OptTenantWrap(func(tenant string, s stored.Store) stored.Store {
+			return stored.NewAuthorizedStore(s, stored.NewRulePolicy(stored.AllowAuthenticated()))
+		}),
+	)
+
+	ds := NewDataServer(
+		"/test",
+		nil,
+		nil,
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetTenants(tenants, PathTenant()),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	put := func(path string, principal string) int {
+		req := httptest.NewRequest("PUT", path, strings.NewReader("Hello"))
+		req.Header.Add("Content-Type", "text/plain")
+		if principal != "" {
+			req = req.WithContext(stored.WithPrincipal(req.Context(), principal))
+		}
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusUnauthorized, put("/test/acme/1", ""))
+	assert.Expect(t, http.StatusNoContent, put("/test/acme/1", "alice"))
+	assert.Expect(t, http.StatusNotFound, put("/test/mallory/1", "alice"))
+	assert.Expect(t, 1, len(ms))
+
+	// A store alongside tenants is ignored rather than served.
+	buf := &bytes.Buffer{}
+	ds = NewDataServer("/test", ms, nil,
+		OptSetTenants(tenants, PathTenant()),
+		OptSetLogger(log.New(buf, "", 0)))
+
+	assert.Expect(t, nil, ds.store)
+	assert.Expect(t, true, strings.Contains(buf.String(), "ignored"))
+}
+
+func TestTenantSearch(t *testing.T) {
+
+	ss, _ := stored.NewSearchableStore(stored.NewMapStore())
+	ss.StoreItem("acme/1", "Acme fox.")
+	ss.StoreItem("alice/1", "Alice fox.")
+
+	ds := newTenantServer(ss, HeaderTenant("X-Tenant"))
+
+	req := httptest.NewRequest("GET", "/test/?q=fox", nil)
+	req.Header.Add("Accept", "text/plain")
+	req.Header.Add("X-Tenant", "acme")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "1", res.Body.String())
+}
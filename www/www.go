@@ -17,6 +17,10 @@ import "mime"
 import "io"
 import "path"
 import "net/url"
+import "encoding/json"
+import "time"
+import "io/ioutil"
+import "sort"
 
 type WWWOpt func(*DataServer)
 
@@ -24,30 +28,79 @@ type Encoder func(stored.Storable) ([]byte, error)
 
 type Decoder func([]byte) (stored.Storable, error)
 
+// Computes the new value of an item from its current value and the
+// body of a PATCH request.  The exists flag is false when there is no
+// current item.
+type Patcher func(old stored.Storable, exists bool, patch []byte) (stored.Storable, error)
+
 type DataServer struct {
-	base     string
-	encoders map[string]Encoder
-	decoders map[string]Decoder
-	store    stored.Store
-	idgen    func(stored.Storable) string
+	base         string
+	encoders     map[string]Encoder
+	decoders     map[string]Decoder
+	listEncoders map[string]Encoder
+	listCodecs   map[string]stored.Codec
+	patchers     map[string]Patcher
+	codecs       *stored.CodecRegistry
+	store        stored.Store
+	blobs        stored.BlobStore
+	uploads      *stored.Uploads
+	blobPolicy   stored.Policy
+	maxBody      int64
+	idgen        stored.IDGenerator
+	tracer       stored.Tracer
+	tenants      *stored.Tenants
+	resolve      TenantResolver
+	tenant       string
+	authn        Authenticator
+	challenge    string
+	digests      []stored.Checksum
 	*log.Logger
 }
 
+// Create a new DataServer.
+//
+// New items are given IDs by idgen or, when idgen is nil, by the
+// store itself if it implements stored.Creator, given the request
+// context when it implements stored.ContextCreator.
+//
+// Items and lists of IDs are encoded as application/json by default,
+// with items decoded into the generic encoding/json types.  Set a
+// typed JSON codec with OptSetCodecs to decode domain types.
+//
 func NewDataServer(base string, store stored.Store,
-	idgen func(stored.Storable) string,
+	idgen stored.IDGenerator,
 	opts ...WWWOpt) *DataServer {
 
+	jc := stored.NewJSONCodec()
+
 	ds := &DataServer{
-		base,
-		map[string]Encoder{},
-		map[string]Decoder{},
-		store,
-		idgen,
-		log.New(os.Stderr, "www2: ", log.Ldate),
+		base:         base,
+		encoders:     map[string]Encoder{jc.MediaType(): jc.Encode},
+		decoders:     map[string]Decoder{jc.MediaType(): jc.Decode},
+		listEncoders: map[string]Encoder{jc.MediaType(): stored.JSONListCodec.Encode},
+		listCodecs:   map[string]stored.Codec{jc.MediaType(): stored.JSONListCodec},
+		patchers:     map[string]Patcher{},
+		store:        store,
+		maxBody:      DefaultMaxBodySize,
+		idgen:        idgen,
+		tracer:       stored.NopTracer,
+		Logger:       log.New(os.Stderr, "www2: ", log.Ldate),
 	}
 
 	ds.Options(opts...)
 
+	// Items are only served from the tenant stores.
+	if ds.tenants != nil && store != nil {
+		ds.Println("The store is ignored when serving tenants, see OptSetTenants.")
+		ds.store = nil
+	}
+
+	// Blobs are subject to the policy of the store unless given
+	// their own.
+	if as, ok := ds.store.(*stored.AuthorizedStore); ok && ds.blobPolicy == nil {
+		ds.blobPolicy = as.Policy()
+	}
+
 	return ds
 }
 
@@ -70,7 +123,7 @@ func ShiftPath(p string) (head, tail string) {
 
 	i++
 
-	head, err := url.QueryUnescape(path[1:i])
+	head, err := url.PathUnescape(path[1:i])
 	if err != nil {
 		head = path[1:i]
 	}
@@ -101,16 +154,142 @@ func Acceptable(accept string, types map[string]Encoder) (string, Encoder) {
 	return "", nil
 }
 
+// The media type of a request body without its parameters, or
+// application/octet-stream when it is not given.
+func ContentType(req *http.Request) string {
+
+	t := req.Header.Get("Content-Type")
+	if t == "" {
+		return "application/octet-stream"
+	}
+
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return t
+	}
+
+	return mt
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
 func (ds DataServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 
+	ctx := stored.ExtractTraceparent(req.Context(), req.Header)
+	ctx, span := ds.tracer.Start(ctx, "DataServer "+req.Method,
+		stored.Attr{Key: "http.method", Value: req.Method},
+		stored.Attr{Key: "http.target", Value: req.URL.RequestURI()},
+	)
+
+	rec := &statusRecorder{ResponseWriter: res}
+	ds.serve(rec, req.WithContext(ctx))
+
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+
+	span.SetAttributes(stored.Attr{Key: "http.status_code", Value: rec.code})
+
+	var err error
+	if rec.code >= http.StatusInternalServerError {
+		err = errors.New(http.StatusText(rec.code))
+	}
+
+	span.End(err)
+}
+
+func (ds DataServer) serve(res http.ResponseWriter, req *http.Request) {
+
 	req.URL.Path = strings.TrimPrefix(req.URL.Path, ds.base)
+	req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, ds.base)
+
+	if ds.authn != nil {
+		principal, err := ds.authn(req)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusUnauthorized),
+				"Could not authenticate the request, "+err.Error(),
+				res, req)
+			return
+		}
+
+		if principal != "" {
+			req = req.WithContext(stored.WithPrincipal(req.Context(), principal))
+		}
+	}
+
+	if ds.tenants != nil {
+		before := req.URL.EscapedPath()
+
+		tenant, err := ds.resolve(req)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusBadRequest),
+				"Could not determine the tenant, "+err.Error(),
+				res, req)
+			return
+		}
+
+		ns, err := ds.tenants.Decorated(tenant)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusBadRequest),
+				"Invalid tenant, "+err.Error(),
+				res, req)
+			return
+		}
+
+		// Serve from the tenant store, under any consumed path.
+		ds.store = ns
+		ds.base += strings.TrimSuffix(before, req.URL.EscapedPath())
+		ds.tenant = tenant
+
+		if ds.blobs != nil {
+			ds.blobs, err = stored.NewNamespacedBlobStore(ds.blobs, tenant)
+			if err != nil {
+				ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+					"Error scoping the blobs to the tenant, "+err.Error(),
+					res, req)
+				return
+			}
+		}
+	}
+
+	head, tail := ShiftPath(req.URL.EscapedPath())
+	if ts, ok := ds.store.(stored.TrashStore); ok && head == TrashPath {
+		ds.ServeTrash(ts, tail, res, req)
+		return
+	}
+
+	if ds.blobs != nil && head == BlobPath {
+		ds.ServeBlob(tail, res, req)
+		return
+	}
+
+	if ds.uploads != nil && head == UploadPath {
+		ds.ServeUpload(tail, res, req)
+		return
+	}
 
 	switch req.Method {
 	case "POST":
+		if head != "" && tail != "" && tail != "/" {
+			ds.RevertRevision(head, tail, res, req)
+			return
+		}
+
 		ds.CreateData(res, req)
 		return
 
-	case "GET":
+	case "GET", "HEAD":
 		ds.RetrData(res, req)
 		return
 
@@ -122,6 +301,10 @@ func (ds DataServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		ds.DeleteData(res, req)
 		return
 
+	case "PATCH":
+		ds.PatchData(res, req)
+		return
+
 	default:
 		ds.ServeError(
 			http.StatusNotImplemented,
@@ -130,22 +313,160 @@ func (ds DataServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// The path segment under which the trash of a stored.TrashStore is
+// served.
+const TrashPath = "_trash"
+
+type trashEntry struct {
+	ID   stored.ID `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// Serve the trash of a stored.TrashStore.
+//
+// GET {base}/_trash lists the trashed items as JSON, GET
+// {base}/_trash/{id} retrieves a trashed item, POST
+// {base}/_trash/{id} restores it and DELETE {base}/_trash/{id}
+// purges it.
+//
+func (ds DataServer) ServeTrash(ts stored.TrashStore, tail string, res http.ResponseWriter, req *http.Request) {
+
+	id, _ := ShiftPath(tail)
+
+	switch {
+	case id == "" && req.Method == "GET":
+		ids, err := stored.ListTrashContext(req.Context(), ts)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error listing the trash, "+err.Error(),
+				res, req)
+			return
+		}
+
+		entries := []trashEntry{}
+		for _, id := range ids {
+			t, err := stored.RetrieveTrashContext(req.Context(), ts, id)
+			if err != nil {
+				continue
+			}
+
+			entries = append(entries, trashEntry{id, t.Time})
+		}
+
+		ds.writeJSON(entries, res, req)
+
+	case id == "":
+		ds.ServeError(http.StatusMethodNotAllowed,
+			"Invalid Method, "+req.Method+".",
+			res, req)
+
+	case req.Method == "GET":
+		t, enc := ds.acceptable(req, false)
+		if t == "" {
+			ds.ServeError(http.StatusNotAcceptable,
+				"No acceptable response format is supported.",
+				res, req)
+			return
+		}
+
+		tr, err := stored.RetrieveTrashContext(req.Context(), ts, (stored.ID)(id))
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusNotFound),
+				"The object was not found, "+err.Error(),
+				res, req)
+			return
+		}
+
+		t, bs, err := ds.encode(req, t, enc, tr.Value)
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Failed to encode the store object, "+err.Error(),
+				res, req)
+			return
+		}
+
+		ds.write(t, bs, res, req)
+
+	case req.Method == "POST" || req.Method == "DELETE":
+		var err error
+		if req.Method == "POST" {
+			err = stored.RestoreContext(req.Context(), ts, (stored.ID)(id))
+		} else {
+			err = stored.PurgeContext(req.Context(), ts, (stored.ID)(id))
+		}
+
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+				"Error updating the trash, "+err.Error(),
+				res, req)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		ds.ServeError(http.StatusMethodNotAllowed,
+			"Invalid Method, "+req.Method+".",
+			res, req)
+	}
+}
+
+// The HTTP status code for an error returned by a store, or def when
+// the error has no particular status.
+func StatusOf(err error, def int) int {
+
+	switch err {
+	case stored.ErrNotFound:
+		return http.StatusNotFound
+	case stored.ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case stored.ErrForbidden:
+		return http.StatusForbidden
+	case stored.ErrUnknownTenant:
+		return http.StatusNotFound
+	case stored.ErrQuotaExceeded:
+		return http.StatusInsufficientStorage
+	case stored.ErrConflict, stored.ErrRestoreExists:
+		return http.StatusConflict
+	case stored.ErrUpdateUnsupported, stored.ErrSearchUnsupported,
+		stored.ErrRevisionsUnsupported, stored.ErrTrashUnsupported:
+		return http.StatusNotImplemented
+	case stored.ErrInvalidRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	case stored.ErrInvalidBlobID, stored.ErrContentMismatch:
+		return http.StatusBadRequest
+	case stored.ErrUploadOffset, stored.ErrUploadBusy, stored.ErrUploadIncomplete:
+		return http.StatusConflict
+	case stored.ErrCorrupt, stored.ErrNotChecksummed, stored.ErrUnknownChecksum:
+		// Includes stored.ErrContentCorrupt.
+		return http.StatusInternalServerError
+	case ErrBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrTypeNotAcceptable:
+		return http.StatusNotAcceptable
+	}
+
+	return def
+}
+
 func (ds DataServer) ServeError(code int, msg string, res http.ResponseWriter, req *http.Request) {
 	estr := req.Method + " " + req.URL.EscapedPath() + " " +
 		http.StatusText(code) + " - " + msg
 	ds.Println(estr)
+
+	if code == http.StatusUnauthorized && ds.challenge != "" {
+		res.Header().Set("WWW-Authenticate", ds.challenge)
+	}
+
 	res.WriteHeader(code)
 	res.Write([](byte)(estr))
 }
 
 func (ds DataServer) CreateData(res http.ResponseWriter, req *http.Request) {
 
-	t := req.Header.Get("Content-Type")
-	if t == "" {
-		t = "application/octet-stream"
-	}
+	t := ContentType(req)
 
-	dec, ok := ds.decoders[t]
+	dec, ok := ds.decoder(t)
 	if !ok {
 		// Handle unknown content type.
 		ds.ServeError(http.StatusUnsupportedMediaType,
@@ -154,17 +475,16 @@ func (ds DataServer) CreateData(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	bs := make([]byte, req.ContentLength)
-	_, err := req.Body.Read(bs)
-	if err != nil && err != io.EOF {
+	bs, err := ds.readBody(req)
+	if err != nil {
 		// handle read error.
-		ds.ServeError(http.StatusBadRequest,
+		ds.ServeError(StatusOf(err, http.StatusBadRequest),
 			"Could not read the request, "+err.Error(),
 			res, req)
 		return
 	}
 
-	obj, err := dec(bs)
+	obj, err := ds.decode(req, t, dec, bs)
 	if err != nil {
 		// handle decoding error
 		ds.ServeError(http.StatusBadRequest,
@@ -173,183 +493,916 @@ func (ds DataServer) CreateData(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	id := ds.idgen(obj)
-
-	err = ds.store.StoreItem((stored.ID)(id), obj)
+	id, err := ds.create(req, obj, len(bs))
 	if err != nil {
 		// handle storage error
-		ds.ServeError(http.StatusInternalServerError,
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
 			"Error storing the object, "+err.Error(),
 			res, req)
 		return
 	}
 
-	res.Header().Add("Location", ds.base+"/"+id)
+	res.Header().Add("Location", ds.base+"/"+(string)(id))
 	res.WriteHeader(http.StatusCreated)
 }
 
-func (ds DataServer) RetrData(res http.ResponseWriter, req *http.Request) {
+// Returned when a request body exceeds the maximum size.
+var ErrBodyTooLarge = errors.New("The request body is too large.")
 
-	id, _ := ShiftPath(req.URL.EscapedPath())
-	if id == "" {
-		// handle id error
-		ds.ServeError(http.StatusBadRequest,
-			"Invalid URL, "+req.URL.EscapedPath(),
-			res, req)
-		return
-	}
+// Read a request body, up to the maximum size.
+func (ds DataServer) readBody(req *http.Request) ([]byte, error) {
 
-	t, enc := Acceptable(req.Header.Get("Accept"), ds.encoders)
-	if t == "" {
-		// handle acceptable type error
-		ds.ServeError(http.StatusNotAcceptable,
-			"No acceptable response format is supported.",
-			res, req)
-		return
+	if ds.maxBody <= 0 {
+		return ioutil.ReadAll(req.Body)
 	}
 
-	obj, err := ds.store.Retrieve((stored.ID)(id))
+	bs, err := ioutil.ReadAll(io.LimitReader(req.Body, ds.maxBody+1))
 	if err != nil {
-		// handle storage error
-		ds.ServeError(http.StatusNotFound,
-			"The object was not found, "+err.Error(),
-			res, req)
-		return
+		return nil, err
 	}
 
-	bs, err := enc(obj)
-	if err != nil {
-		// handle encoding error
-		ds.ServeError(http.StatusInternalServerError,
-			"Failed to encode the store object, "+err.Error(),
-			res, req)
-		return
+	if int64(len(bs)) > ds.maxBody {
+		return nil, ErrBodyTooLarge
 	}
 
-	res.Header().Add("Content-Length", strconv.Itoa(len(bs)))
-	res.WriteHeader(http.StatusOK)
+	return bs, nil
+}
 
-	_, err = res.Write(bs)
-	if err != nil {
-		ds.Println(req.Method + " " + req.URL.EscapedPath() +
-			"Write Error" + " - " + err.Error())
+// Choose the encoder of the media type best matching the Accept header
+// of req, for items or for lists of IDs.
+//
+// The codecs of OptSetCodecs are preferred, followed by JSON and the
+// other encoders in order of media type.
+//
+func (ds DataServer) acceptable(req *http.Request, list bool) (string, Encoder) {
+
+	encoders := ds.encoders
+	if list {
+		encoders = ds.listEncoders
 	}
-}
 
-func (ds DataServer) UpdateData(res http.ResponseWriter, req *http.Request) {
+	types := []string{}
+	if ds.codecs != nil && list {
+		types = ds.codecs.ListMediaTypes()
+	} else if ds.codecs != nil {
+		types = ds.codecs.MediaTypes()
+	}
 
-	id, _ := ShiftPath(req.URL.EscapedPath())
-	if id == "" {
-		// handle id error
-		ds.ServeError(http.StatusBadRequest,
-			"Invalid URL, "+req.URL.EscapedPath(),
-			res, req)
-		return
+	others := []string{}
+	for t := range encoders {
+		if t != stored.JSONMediaType {
+			others = append(others, t)
+		}
 	}
 
-	t := req.Header.Get("Content-Type")
-	if t == "" {
-		t = "application/octet-stream"
+	sort.Strings(others)
+
+	if _, ok := encoders[stored.JSONMediaType]; ok {
+		types = append(types, stored.JSONMediaType)
 	}
 
-	dec, ok := ds.decoders[t]
+	types = append(types, others...)
+
+	t, ok := stored.NegotiateMediaType(req.Header.Get("Accept"), types)
 	if !ok {
-		// Handle unknown content type.
-		ds.ServeError(http.StatusUnsupportedMediaType,
-			"Media type, "+t+" is not supported.",
-			res, req)
-		return
+		return "", nil
 	}
 
-	bs := make([]byte, req.ContentLength)
-	_, err := req.Body.Read(bs)
-	if err != nil && err != io.EOF {
-		// handle read error.
-		ds.ServeError(http.StatusBadRequest,
-			"Could not read the request, "+err.Error(),
-			res, req)
-		return
+	if c, ok := ds.codec(t, list); ok {
+		return t, c.Encode
 	}
 
-	obj, err := dec(bs)
-	if err != nil {
-		// handle decoding error
-		ds.ServeError(http.StatusBadRequest,
-			"Error decoding the request body, "+err.Error(),
-			res, req)
-		return
+	return t, encoders[t]
+}
+
+// The codec of OptSetCodecs for media type t.
+func (ds DataServer) codec(t string, list bool) (stored.Codec, bool) {
+
+	if ds.codecs == nil {
+		return nil, false
 	}
 
-	err = ds.store.StoreItem((stored.ID)(id), obj)
-	if err != nil {
-		// handle storage error
-		ds.ServeError(http.StatusInternalServerError,
-			"Error storing the object, "+err.Error(),
-			res, req)
-		return
+	if list {
+		return ds.codecs.ListCodec(t)
 	}
 
-	res.WriteHeader(http.StatusNoContent)
+	return ds.codecs.Codec(t)
 }
 
-func (ds DataServer) DeleteData(res http.ResponseWriter, req *http.Request) {
+// The decoder for request bodies of media type t.
+func (ds DataServer) decoder(t string) (Decoder, bool) {
 
-	id, _ := ShiftPath(req.URL.EscapedPath())
-	if id == "" {
-		// handle id error
-		ds.ServeError(http.StatusBadRequest,
-			"Invalid URL, "+req.URL.EscapedPath(),
-			res, req)
-		return
+	if c, ok := ds.codec(t, false); ok {
+		return c.Decode, true
 	}
 
-	err := ds.store.Delete((stored.ID)(id))
-	if err != nil {
-		ds.ServeError(http.StatusInternalServerError,
-			"Error storing the object, "+err.Error(),
-			res, req)
-		return
-	}
+	dec, ok := ds.decoders[t]
 
-	res.WriteHeader(http.StatusNoContent)
+	return dec, ok
 }
 
-func PlainStringEncoder(s stored.Storable) ([]byte, error) {
-	str, ok := s.(string)
+// The codec of OptSetCodecs for media type t when it names the types
+// of items with a media type parameter.
+func (ds DataServer) typedCodec(t string) (stored.MediaTypeCodec, bool) {
+
+	c, ok := ds.codec(t, false)
 	if !ok {
-		// Handle type error
-		return nil, errors.New("Object encodable as a string type.")
+		return nil, false
 	}
 
-	return ([]byte)(str), nil
+	mc, ok := c.(stored.MediaTypeCodec)
+
+	return mc, ok
 }
 
-func OptSetEncoder(t string, enc Encoder) WWWOpt {
-	return func(ds *DataServer) {
-		ds.encoders[t] = enc
+// Decode a request body of media type t, codecs using the media type
+// parameters are given the whole Content-Type.
+func (ds DataServer) decode(req *http.Request, t string, dec Decoder, bs []byte) (stored.Storable, error) {
+
+	if mc, ok := ds.typedCodec(t); ok {
+		return mc.DecodeMediaType(req.Header.Get("Content-Type"), bs)
 	}
+
+	return dec(bs)
 }
 
-func OptSetDecoder(t string, dec Decoder) WWWOpt {
-	return func(ds *DataServer) {
-		ds.decoders[t] = dec
+// Returned when an item is not of a type listed in the Accept header.
+var ErrTypeNotAcceptable = errors.New("The type of the object is not acceptable.")
+
+// Encode an item as media type t, returning the full media type of
+// the encoding.
+//
+// Items encoded with a media type naming their type must be of a type
+// accepted by the request, as in Accept: application/json; type=Order.
+//
+func (ds DataServer) encode(req *http.Request, t string, enc Encoder, obj stored.Storable) (string, []byte, error) {
+
+	mc, ok := ds.typedCodec(t)
+	if !ok {
+		bs, err := enc(obj)
+		return t, bs, err
 	}
-}
 
-func OptSetLogger(l *log.Logger) WWWOpt {
-	return func(ds *DataServer) {
-		ds.Logger = l
+	mt, bs, err := mc.EncodeMediaType(obj)
+	if err != nil {
+		return "", nil, err
 	}
+
+	_, params, _ := mime.ParseMediaType(mt)
+	if !acceptsType(req.Header.Get("Accept"), t, params[stored.TypeParam]) {
+		return "", nil, ErrTypeNotAcceptable
+	}
+
+	return mt, bs, nil
 }
 
-func PlainStringDecoder(bs []byte) (stored.Storable, error) {
-	return (string)(bs), nil
+// Whether an Accept header accepts media type t for the named type.
+func acceptsType(accept, t, name string) bool {
+
+	group := t[:strings.Index(t, "/")+1] + "*"
+
+	for _, entry := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(entry)
+		if err != nil || (mt != t && mt != group && mt != "*/*") {
+			continue
+		}
+
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+
+		if p, ok := params[stored.TypeParam]; !ok || p == name {
+			return true
+		}
+	}
+
+	return false
 }
 
-func IncrIDGen() func(stored.Storable) string {
-	i := -1
-	return func(obj stored.Storable) string {
-		i++
-		return strconv.Itoa(i)
+// Store an item, along with metadata from the request headers when the
+// store keeps metadata.
+func (ds DataServer) storeItem(req *http.Request, id stored.ID, obj stored.Storable, size int) error {
+
+	if ms, ok := ds.store.(stored.MetaStore); ok {
+		meta := stored.ReadMetaHeader(req.Header)
+		meta.Size = int64(size)
+
+		err := stored.StoreItemWithMetaContext(req.Context(), ms, id, obj, meta)
+		if err != stored.ErrMetaUnsupported {
+			return err
+		}
 	}
+
+	return stored.StoreItemContext(req.Context(), ds.store, id, obj)
+}
+
+func (ds DataServer) create(req *http.Request, obj stored.Storable, size int) (stored.ID, error) {
+
+	if ds.idgen == nil {
+		c, ok := ds.store.(stored.Creator)
+		if !ok {
+			return "", errors.New("No ID generator is configured.")
+		}
+
+		return stored.CreateContext(req.Context(), c, obj)
+	}
+
+	id, err := ds.idgen.NextID(obj)
+	if err != nil {
+		return "", err
+	}
+
+	return id, ds.storeItem(req, id, obj, size)
+}
+
+func (ds DataServer) RetrData(res http.ResponseWriter, req *http.Request) {
+
+	id, tail := ShiftPath(req.URL.EscapedPath())
+	if id == "" {
+		ds.ListData(res, req)
+		return
+	}
+
+	if tail != "" && tail != "/" {
+		ds.RetrRevision(id, tail, res, req)
+		return
+	}
+
+	t, enc := ds.acceptable(req, false)
+	if t == "" {
+		// handle acceptable type error
+		ds.ServeError(http.StatusNotAcceptable,
+			"No acceptable response format is supported.",
+			res, req)
+		return
+	}
+
+	obj, meta, err := ds.retrieve(req, (stored.ID)(id))
+	if err != nil {
+		// handle storage error
+		ds.ServeError(StatusOf(err, http.StatusNotFound),
+			"Error retrieving the object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	stored.WriteMetaHeader(res.Header(), meta)
+
+	t, bs, err := ds.encode(req, t, enc, obj)
+	if err != nil {
+		// handle encoding error
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Failed to encode the store object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	ds.write(t, bs, res, req)
+}
+
+// Retrieve an item, along with its metadata when the store keeps
+// metadata.
+func (ds DataServer) retrieve(req *http.Request, id stored.ID) (stored.Storable, stored.Meta, error) {
+
+	if ms, ok := ds.store.(stored.MetaStore); ok {
+		return stored.RetrieveMetaContext(req.Context(), ms, id)
+	}
+
+	obj, err := stored.RetrieveContext(req.Context(), ds.store, id)
+
+	return obj, stored.Meta{}, err
+}
+
+// Serve the IDs of every item in the store, encoded by a list
+// encoder.
+//
+// The prefix, start and end query parameters select the IDs within a
+// range, in order, or in reverse order with reverse=true.  Limit caps
+// the number of IDs returned.  The q parameter searches the store
+// instead, see SearchData.
+//
+func (ds DataServer) ListData(res http.ResponseWriter, req *http.Request) {
+
+	if _, ok := req.URL.Query()["q"]; ok {
+		ds.SearchData(res, req)
+		return
+	}
+
+	t, enc := ds.acceptable(req, true)
+	if t == "" {
+		// handle acceptable type error
+		ds.ServeError(http.StatusNotAcceptable,
+			"No acceptable list format is supported.",
+			res, req)
+		return
+	}
+
+	ranged, start, end, opts, err := listRange(req.URL.Query())
+	if err != nil {
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid range, "+err.Error(),
+			res, req)
+		return
+	}
+
+	var ids []stored.ID
+	if ranged {
+		ids, err = stored.ListRangeContext(req.Context(), ds.store, start, end, opts...)
+	} else {
+		ids, err = stored.ListContext(req.Context(), ds.store)
+	}
+
+	if err != nil {
+		// handle storage error
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error listing the objects, "+err.Error(),
+			res, req)
+		return
+	}
+
+	ds.writeList(t, enc, ids, res, req)
+}
+
+// Serve the IDs of the items matching the q query parameter, most
+// relevant first.  Limit caps the number of IDs returned.  Stores
+// unable to search respond with 501 Not Implemented.
+func (ds DataServer) SearchData(res http.ResponseWriter, req *http.Request) {
+
+	t, enc := ds.acceptable(req, true)
+	if t == "" {
+		// handle acceptable type error
+		ds.ServeError(http.StatusNotAcceptable,
+			"No acceptable list format is supported.",
+			res, req)
+		return
+	}
+
+	q := req.URL.Query()
+
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil {
+			ds.ServeError(http.StatusBadRequest,
+				"Invalid limit, "+err.Error(),
+				res, req)
+			return
+		}
+	}
+
+	results, err := stored.SearchContext(req.Context(), ds.store, q.Get("q"), limit)
+	if err != nil {
+		// handle storage error
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error searching the objects, "+err.Error(),
+			res, req)
+		return
+	}
+
+	ids := make([]stored.ID, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+
+	ds.writeList(t, enc, ids, res, req)
+}
+
+// Read the range selected by the query parameters of a list request.
+func listRange(q url.Values) (bool, stored.ID, stored.ID, []stored.RangeOpt, error) {
+
+	start := (stored.ID)(q.Get("start"))
+	end := (stored.ID)(q.Get("end"))
+
+	ranged := false
+	for _, k := range []string{"prefix", "start", "end", "reverse", "limit"} {
+		if _, ok := q[k]; ok {
+			ranged = true
+		}
+	}
+
+	if p := (stored.ID)(q.Get("prefix")); p != "" {
+		if start < p {
+			start = p
+		}
+
+		pend := stored.PrefixEnd(p)
+		if end == "" || (pend != "" && end > pend) {
+			end = pend
+		}
+	}
+
+	opts := []stored.RangeOpt{}
+
+	if v := q.Get("reverse"); v != "" {
+		reverse, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, "", "", nil, err
+		}
+
+		if reverse {
+			opts = append(opts, stored.OptRangeReverse())
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return false, "", "", nil, err
+		}
+
+		opts = append(opts, stored.OptRangeLimit(limit))
+	}
+
+	return ranged, start, end, opts, nil
+}
+
+// Serve the revisions of an item from a stored.RevisionStore.
+//
+// GET {base}/{id}/revisions lists the revisions as JSON while GET
+// {base}/{id}/revisions/{rev} retrieves the item at that revision.
+// See RevertRevision for reverting to a revision.
+//
+func (ds DataServer) RetrRevision(id, tail string, res http.ResponseWriter, req *http.Request) {
+
+	rs, ok := ds.store.(stored.RevisionStore)
+	head, tail := ShiftPath(tail)
+	if !ok || head != "revisions" {
+		ds.ServeError(http.StatusNotFound,
+			"Invalid URL, "+req.URL.EscapedPath(),
+			res, req)
+		return
+	}
+
+	rev, tail := ShiftPath(tail)
+	if rev == "" {
+		revs, err := stored.HistoryContext(req.Context(), rs, (stored.ID)(id))
+		if err != nil {
+			ds.ServeError(StatusOf(err, http.StatusNotFound),
+				"The object was not found, "+err.Error(),
+				res, req)
+			return
+		}
+
+		// The listing describes the revisions, their values are
+		// retrieved one at a time.
+		for i := range revs {
+			revs[i].Value = nil
+		}
+
+		ds.writeJSON(revs, res, req)
+		return
+	}
+
+	n, err := strconv.Atoi(rev)
+	if err != nil || (tail != "" && tail != "/") {
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid revision, "+rev,
+			res, req)
+		return
+	}
+
+	t, enc := ds.acceptable(req, false)
+	if t == "" {
+		ds.ServeError(http.StatusNotAcceptable,
+			"No acceptable response format is supported.",
+			res, req)
+		return
+	}
+
+	obj, err := stored.RetrieveRevisionContext(req.Context(), rs, (stored.ID)(id), n)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusNotFound),
+			"The revision was not found, "+err.Error(),
+			res, req)
+		return
+	}
+
+	t, bs, err := ds.encode(req, t, enc, obj)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Failed to encode the store object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	ds.write(t, bs, res, req)
+}
+
+// Revert an item of a stored.RevisionStore.
+//
+// POST {base}/{id}/revisions/{rev} restores the item to its value at
+// that revision, recorded as a new revision.
+//
+func (ds DataServer) RevertRevision(id, tail string, res http.ResponseWriter, req *http.Request) {
+
+	rs, ok := ds.store.(stored.RevisionStore)
+	head, tail := ShiftPath(tail)
+	if !ok || head != "revisions" {
+		ds.ServeError(http.StatusNotFound,
+			"Invalid URL, "+req.URL.EscapedPath(),
+			res, req)
+		return
+	}
+
+	rev, tail := ShiftPath(tail)
+	n, err := strconv.Atoi(rev)
+	if err != nil || (tail != "" && tail != "/") {
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid revision, "+rev,
+			res, req)
+		return
+	}
+
+	err = stored.RevertContext(req.Context(), rs, (stored.ID)(id), n)
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error reverting the object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (ds DataServer) writeJSON(v interface{}, res http.ResponseWriter, req *http.Request) {
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		ds.ServeError(http.StatusInternalServerError,
+			"Failed to encode the response, "+err.Error(),
+			res, req)
+		return
+	}
+
+	ds.write("application/json", bs, res, req)
+}
+
+// Write a list of IDs as media type t.
+//
+// Lists are streamed when their codec can write them incrementally,
+// unless a digest of the whole body must be sent first.
+//
+func (ds DataServer) writeList(t string, enc Encoder, ids []stored.ID, res http.ResponseWriter, req *http.Request) {
+
+	c, ok := ds.codec(t, true)
+	if !ok {
+		c, ok = ds.listCodecs[t]
+	}
+
+	if !ok || len(ds.digests) > 0 {
+		bs, err := enc(ids)
+		if err != nil {
+			// handle encoding error
+			ds.ServeError(http.StatusInternalServerError,
+				"Failed to encode the list, "+err.Error(),
+				res, req)
+			return
+		}
+
+		ds.write(t, bs, res, req)
+		return
+	}
+
+	res.Header().Set("Content-Type", t)
+	res.WriteHeader(http.StatusOK)
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	err := c.EncodeTo(res, ids)
+	if err != nil {
+		ds.Println(req.Method + " " + req.URL.EscapedPath() +
+			"Write Error" + " - " + err.Error())
+	}
+}
+
+func (ds DataServer) write(t string, bs []byte, res http.ResponseWriter, req *http.Request) {
+
+	res.Header().Set("Content-Type", t)
+	res.Header().Add("Content-Length", strconv.Itoa(len(bs)))
+
+	if len(ds.digests) > 0 {
+		stored.SetContentDigest(res.Header(), bs, ds.digests...)
+	}
+
+	res.WriteHeader(http.StatusOK)
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	_, err := res.Write(bs)
+	if err != nil {
+		ds.Println(req.Method + " " + req.URL.EscapedPath() +
+			"Write Error" + " - " + err.Error())
+	}
+}
+
+func (ds DataServer) UpdateData(res http.ResponseWriter, req *http.Request) {
+
+	id, _ := ShiftPath(req.URL.EscapedPath())
+	if id == "" {
+		// handle id error
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid URL, "+req.URL.EscapedPath(),
+			res, req)
+		return
+	}
+
+	t := ContentType(req)
+
+	dec, ok := ds.decoder(t)
+	if !ok {
+		// Handle unknown content type.
+		ds.ServeError(http.StatusUnsupportedMediaType,
+			"Media type, "+t+" is not supported.",
+			res, req)
+		return
+	}
+
+	bs, err := ds.readBody(req)
+	if err != nil {
+		// handle read error.
+		ds.ServeError(StatusOf(err, http.StatusBadRequest),
+			"Could not read the request, "+err.Error(),
+			res, req)
+		return
+	}
+
+	obj, err := ds.decode(req, t, dec, bs)
+	if err != nil {
+		// handle decoding error
+		ds.ServeError(http.StatusBadRequest,
+			"Error decoding the request body, "+err.Error(),
+			res, req)
+		return
+	}
+
+	err = ds.storeItem(req, (stored.ID)(id), obj, len(bs))
+	if err != nil {
+		// handle storage error
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error storing the object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// Apply the request body to an item with the patcher registered for
+// its Content-Type, as a single atomic update, see stored.Update.
+func (ds DataServer) PatchData(res http.ResponseWriter, req *http.Request) {
+
+	id, _ := ShiftPath(req.URL.EscapedPath())
+	if id == "" {
+		// handle id error
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid URL, "+req.URL.EscapedPath(),
+			res, req)
+		return
+	}
+
+	t := ContentType(req)
+
+	patch, ok := ds.patchers[t]
+	if !ok {
+		// Handle unknown content type.
+		ds.ServeError(http.StatusUnsupportedMediaType,
+			"Patch type, "+t+" is not supported.",
+			res, req)
+		return
+	}
+
+	bs, err := ds.readBody(req)
+	if err != nil {
+		// handle read error.
+		ds.ServeError(StatusOf(err, http.StatusBadRequest),
+			"Could not read the request, "+err.Error(),
+			res, req)
+		return
+	}
+
+	var perr error
+	err = stored.UpdateContext(req.Context(), ds.store, (stored.ID)(id),
+		func(old stored.Storable, exists bool) (stored.Storable, error) {
+			obj, err := patch(old, exists, bs)
+			perr = err
+			return obj, err
+		})
+
+	if perr != nil {
+		// handle patch error
+		ds.ServeError(http.StatusBadRequest,
+			"Error applying the patch, "+perr.Error(),
+			res, req)
+		return
+	}
+
+	if err != nil {
+		// handle storage error
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error updating the object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (ds DataServer) DeleteData(res http.ResponseWriter, req *http.Request) {
+
+	id, _ := ShiftPath(req.URL.EscapedPath())
+	if id == "" {
+		// handle id error
+		ds.ServeError(http.StatusBadRequest,
+			"Invalid URL, "+req.URL.EscapedPath(),
+			res, req)
+		return
+	}
+
+	err := stored.DeleteContext(req.Context(), ds.store, (stored.ID)(id))
+	if err != nil {
+		ds.ServeError(StatusOf(err, http.StatusInternalServerError),
+			"Error storing the object, "+err.Error(),
+			res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func PlainStringEncoder(s stored.Storable) ([]byte, error) {
+	str, ok := s.(string)
+	if !ok {
+		// Handle type error
+		return nil, errors.New("Object encodable as a string type.")
+	}
+
+	return ([]byte)(str), nil
+}
+
+func OptSetEncoder(t string, enc Encoder) WWWOpt {
+	return func(ds *DataServer) {
+		ds.encoders[t] = enc
+	}
+}
+
+// Encode and decode items, and encode lists of IDs, with the codecs
+// in r.
+//
+// The registry is consulted for each request, so codecs registered
+// later are used.  Its codecs take precedence over the encoders and
+// decoders of the other options for the same media type.
+//
+func OptSetCodecs(r *stored.CodecRegistry) WWWOpt {
+	return func(ds *DataServer) {
+		ds.codecs = r
+	}
+}
+
+// Set the encoder for lists of IDs, the encoder is passed a
+// []stored.ID.
+func OptSetListEncoder(t string, enc Encoder) WWWOpt {
+	return func(ds *DataServer) {
+		ds.listEncoders[t] = enc
+		delete(ds.listCodecs, t)
+	}
+}
+
+func OptSetDecoder(t string, dec Decoder) WWWOpt {
+	return func(ds *DataServer) {
+		ds.decoders[t] = dec
+	}
+}
+
+// Send the digests of encoded items and lists in a Content-Digest
+// header, SHA-256 when no checksums are given.  An MD5 digest is sent
+// in a Content-MD5 header.
+//
+// HttpStore verifies the digest of the responses it decodes.
+//
+func OptContentDigest(sums ...stored.Checksum) WWWOpt {
+	return func(ds *DataServer) {
+		if len(sums) == 0 {
+			sums = []stored.Checksum{stored.SHA256}
+		}
+
+		ds.digests = sums
+	}
+}
+
+// The default limit on the size of request bodies decoded into items.
+const DefaultMaxBodySize = 10 << 20
+
+// Limit the size of request bodies decoded into items to n bytes
+// instead of DefaultMaxBodySize, zero or less removes the limit.
+// Blobs are not limited.
+func OptSetMaxBodySize(n int64) WWWOpt {
+	return func(ds *DataServer) {
+		ds.maxBody = n
+	}
+}
+
+// Serve blobs under BlobPath, see ServeBlob.
+func OptSetBlobStore(bs stored.BlobStore) WWWOpt {
+	return func(ds *DataServer) {
+		ds.blobs = bs
+	}
+}
+
+// Accept chunked uploads under UploadPath, see ServeUpload.
+func OptSetUploads(u *stored.Uploads) WWWOpt {
+	return func(ds *DataServer) {
+		ds.uploads = u
+	}
+}
+
+// Authorize blob and upload requests with p, using the principal of
+// the request context.
+//
+// When the store is a stored.AuthorizedStore its policy is used by
+// default.  Blob IDs are checked with the stored.BlobMeta of the blob,
+// or nil when it does not exist.
+//
+func OptSetBlobPolicy(p stored.Policy) WWWOpt {
+	return func(ds *DataServer) {
+		ds.blobPolicy = p
+	}
+}
+
+// Accept PATCH requests with the given Content-Type.
+func OptSetPatcher(t string, p Patcher) WWWOpt {
+	return func(ds *DataServer) {
+		ds.patchers[t] = p
+	}
+}
+
+// Start a span for every request, continuing the trace found in the
+// W3C traceparent header.
+func OptSetTracer(t stored.Tracer) WWWOpt {
+	return func(ds *DataServer) {
+		ds.tracer = t
+	}
+}
+
+// Serve each tenant from its own store, as given by
+// stored.Tenants.Decorated for the tenant determined by resolve.
+//
+// The store passed to NewDataServer should be nil, any other store is
+// ignored with a logged warning.  Tenant stores are decorated with
+// stored.OptTenantWrap.
+//
+func OptSetTenants(tenants *stored.Tenants, resolve TenantResolver) WWWOpt {
+	return func(ds *DataServer) {
+		ds.tenants = tenants
+		ds.resolve = resolve
+	}
+}
+
+// Identify callers with auth, attaching their principal to the
+// request context for the store, see stored.WithPrincipal.
+//
+// Responses of 401 Unauthorized carry challenge, such as
+// `Basic realm="stored"`, in a WWW-Authenticate header.
+//
+func OptSetAuthenticator(auth Authenticator, challenge string) WWWOpt {
+	return func(ds *DataServer) {
+		ds.authn = auth
+		ds.challenge = challenge
+	}
+}
+
+func OptSetLogger(l *log.Logger) WWWOpt {
+	return func(ds *DataServer) {
+		ds.Logger = l
+	}
+}
+
+// Encode a list of IDs as strings joined by sep.
+func PlainStringListEncoder(sep string) Encoder {
+	return func(s stored.Storable) ([]byte, error) {
+		ids, ok := s.([]stored.ID)
+		if !ok {
+			return nil, errors.New("Object is not a list of IDs.")
+		}
+
+		strs := make([]string, len(ids))
+		for i := range ids {
+			strs[i] = (string)(ids[i])
+		}
+
+		return ([]byte)(strings.Join(strs, sep)), nil
+	}
+}
+
+func PlainStringDecoder(bs []byte) (stored.Storable, error) {
+	return (string)(bs), nil
+}
+
+// Deprecated: Use stored.IncrIDGen.
+func IncrIDGen() stored.IDGenerator {
+	return stored.IncrIDGen()
 }
@@ -12,6 +12,7 @@ import "strings"
 import "net/http"
 import "io/ioutil"
 import "log"
+import "sync"
 
 func TestWWW2Test(t *testing.T) {
 	assert.Expect(t, true, true)
@@ -26,6 +27,25 @@ var ds *DataServer = NewDataServer(
 	OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
 )
 
+func TestShiftPath(t *testing.T) {
+
+	tests := []struct{ path, head, tail string }{
+		{"/1", "1", ""},
+		{"/1/revisions/2", "1", "/revisions/2"},
+		{"/with%20space", "with space", ""},
+		{"/a%2Fb/c", "a/b", "/c"},
+		// Path segments are not query strings, + is not a space.
+		{"/a+b", "a+b", ""},
+		{"/", "", ""},
+	}
+
+	for _, test := range tests {
+		head, tail := ShiftPath(test.path)
+		assert.Expect(t, test.head, head)
+		assert.Expect(t, test.tail, tail)
+	}
+}
+
 func TestWWW2New(t *testing.T) {
 
 	req := httptest.NewRequest(
@@ -202,7 +222,7 @@ func TestWWW2HttpStoreClient(t *testing.T) {
 		t.Error(err)
 	}
 
-	obj, err := hs.Retrieve("1", "")
+	obj, err := hs.Retrieve("1")
 	if err != nil {
 		t.Error(err)
 	}
@@ -214,3 +234,782 @@ func TestWWW2HttpStoreClient(t *testing.T) {
 	
 	assert.Expect(t, "Hello World!", s)
 }
+
+func TestWWW2CreatorStore(t *testing.T) {
+
+	ms := stored.NewMapStore()
+
+	ds := NewDataServer(
+		"/test",
+		stored.NewIDGenStore(ms, stored.CounterIDGen(stored.NewMapStore(), "_id")),
+		nil,
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest(
+		"POST",
+		"/test/",
+		strings.NewReader("Hello World!"),
+	)
+	req.Header.Add("Content-Type", "text/plain")
+
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusCreated, res.Code)
+	assert.Expect(t, "/test/0", res.Header().Get("Location"))
+	assert.Expect(t, "Hello World!", ms["0"])
+}
+
+func TestWWW2CreatorContext(t *testing.T) {
+
+	audit := stored.NewMapStore()
+	as, _ := stored.NewAuditedStore(stored.NewMapStore(),
+		stored.StoreAuditSink(audit), PlainStringEncoder)
+
+	ds := NewDataServer(
+		"/test",
+		stored.NewIDGenStore(as, stored.IncrIDGen()),
+		nil,
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest("POST", "/test/", strings.NewReader("Hello World!"))
+	req.Header.Add("Content-Type", "text/plain")
+	req = req.WithContext(stored.WithPrincipal(req.Context(), "alice"))
+
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+	assert.Expect(t, http.StatusCreated, res.Code)
+
+	records, err := stored.ReadAuditStore(audit)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 1, len(records))
+	assert.Expect(t, "alice", records[0].Principal)
+}
+
+func TestWWW2ContentStore(t *testing.T) {
+
+	ms := stored.NewMapStore()
+	ds := NewDataServer(
+		"/test",
+		stored.NewContentStore(stored.NewSyncStore(ms), PlainStringEncoder),
+		nil,
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest(
+		"POST",
+		"/test/",
+		strings.NewReader("Hello World!"),
+	)
+	req.Header.Add("Content-Type", "text/plain")
+
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusCreated, res.Code)
+	assert.Expect(t,
+		"/test/7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069",
+		res.Header().Get("Location"))
+
+	// IDs not matching the content are refused.
+	req = httptest.NewRequest("PUT", "/test/1", strings.NewReader("Hello World!"))
+	req.Header.Add("Content-Type", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusBadRequest, res.Code)
+
+	// Tampered items are a server error rather than missing.
+	ms["7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069"] = "Goodbye!"
+
+	req = httptest.NewRequest("GET",
+		"/test/7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069", nil)
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusInternalServerError, res.Code)
+}
+
+func TestWWW2Revisions(t *testing.T) {
+
+	vs := stored.NewVersionedStore(stored.NewMapStore(), stored.NewMapStore())
+	vs.StoreItemBy("1", "one", "alice")
+	vs.StoreItemBy("1", "two", "bob")
+
+	ds := NewDataServer(
+		"/test",
+		vs,
+		stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest("GET", "/test/1/revisions", nil)
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "application/json", res.Header().Get("Content-Type"))
+	assert.Expect(t, true, strings.Contains(res.Body.String(), `"author":"bob"`))
+	assert.Expect(t, false, strings.Contains(res.Body.String(), `"value"`))
+
+	req = httptest.NewRequest("GET", "/test/1/revisions/1", nil)
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "one", res.Body.String())
+
+	req = httptest.NewRequest("GET", "/test/1/revisions/5", nil)
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusNotFound, res.Code)
+
+	// Reverts and writes are authored by the request principal.
+	req = httptest.NewRequest("POST", "/test/1/revisions/1", nil)
+	req = req.WithContext(stored.WithPrincipal(req.Context(), "carol"))
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusNoContent, res.Code)
+
+	req = httptest.NewRequest("PUT", "/test/1", strings.NewReader("three"))
+	req.Header.Add("Content-Type", "text/plain")
+	req = req.WithContext(stored.WithPrincipal(req.Context(), "dave"))
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusNoContent, res.Code)
+
+	revs, _ := vs.History("1")
+	assert.Expect(t, 4, len(revs))
+	assert.Expect(t, "carol", revs[2].Author)
+	assert.Expect(t, "one", revs[2].Value)
+	assert.Expect(t, "dave", revs[3].Author)
+
+	req = httptest.NewRequest("POST", "/test/1/revisions/9", nil)
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusNotFound, res.Code)
+
+	req = httptest.NewRequest("POST", "/test/1/revisions/x", nil)
+	res = httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusBadRequest, res.Code)
+}
+
+func TestWWW2Trash(t *testing.T) {
+
+	ts := stored.NewSoftDeleteStore(stored.NewMapStore(), stored.NewMapStore())
+	ts.StoreItem("1", "one")
+
+	ds := NewDataServer(
+		"/test",
+		ts,
+		stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Add("Accept", "text/plain")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res
+	}
+
+	assert.Expect(t, http.StatusNoContent, serve("DELETE", "/test/1").Code)
+	assert.Expect(t, http.StatusNotFound, serve("GET", "/test/1").Code)
+
+	res := serve("GET", "/test/_trash")
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, true, strings.Contains(res.Body.String(), `"id":"1"`))
+
+	res = serve("GET", "/test/_trash/1")
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "one", res.Body.String())
+
+	assert.Expect(t, http.StatusNoContent, serve("POST", "/test/_trash/1").Code)
+	assert.Expect(t, http.StatusOK, serve("GET", "/test/1").Code)
+	assert.Expect(t, http.StatusNotFound, serve("POST", "/test/_trash/1").Code)
+
+	serve("DELETE", "/test/1")
+	ts.StoreItem("1", "uno")
+	assert.Expect(t, http.StatusConflict, serve("POST", "/test/_trash/1").Code)
+
+	assert.Expect(t, http.StatusNoContent, serve("DELETE", "/test/_trash/1").Code)
+	assert.Expect(t, http.StatusNotFound, serve("GET", "/test/_trash/1").Code)
+
+	// Other failures are not conflicts.
+	ts = stored.NewSoftDeleteStore(stored.NewMapStore(), failingDelete{stored.NewMapStore()})
+	ts.StoreItem("1", "one")
+	ts.Delete("1")
+	ds.store = ts
+
+	assert.Expect(t, http.StatusInternalServerError, serve("DELETE", "/test/_trash/1").Code)
+}
+
+type failingDelete struct {
+	stored.Store
+}
+
+func (s failingDelete) Delete(id stored.ID) error {
+	return stored.NewStoreError("The disk is on fire.")
+}
+
+func TestWWW2AuditPrincipal(t *testing.T) {
+
+	audit := stored.NewMapStore()
+	as, _ := stored.NewAuditedStore(stored.NewMapStore(),
+		stored.StoreAuditSink(audit), PlainStringEncoder)
+
+	ds := NewDataServer(
+		"/test",
+		as,
+		stored.IncrIDGen(),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest("PUT", "/test/1", strings.NewReader("Hello World!"))
+	req.Header.Add("Content-Type", "text/plain")
+	req = req.WithContext(stored.WithPrincipal(req.Context(), "alice"))
+
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+	assert.Expect(t, http.StatusNoContent, res.Code)
+
+	records, err := stored.ReadAuditStore(audit)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Expect(t, 1, len(records))
+	assert.Expect(t, "alice", records[0].Principal)
+}
+
+func TestWWW2Tracing(t *testing.T) {
+
+	spans := []stored.SpanData{}
+	mu := sync.Mutex{}
+	tracer := &stored.HookTracer{OnEnd: func(d stored.SpanData) {
+		mu.Lock()
+		defer mu.Unlock()
+		spans = append(spans, d)
+	}}
+
+	ds := NewDataServer(
+		"/",
+		stored.NewTracedStore(stored.NewMapStore(), tracer, "mem"),
+		stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetTracer(tracer),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	srv := httptest.NewServer(ds)
+	defer srv.Close()
+
+	hdrs := &http.Header{}
+	hdrs.Add("Accept", "text/plain")
+	hdrs.Add("Content-Type", "text/plain")
+
+	hs := stored.NewHttpStore(
+		stored.SimpleStoreReq("PUT", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.SimpleStoreReq("GET", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.SimpleStoreReq("GET", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.SimpleStoreReq("DELETE", srv.URL+"/", stored.AppendIDURLFunc, hdrs),
+		stored.StringMarshaler, stored.StringUnmarshaler,
+		stored.StringIDUnmarshaler(","),
+		stored.OptUseClient(srv.Client()),
+		stored.OptUseTracer(tracer),
+	)
+
+	err := hs.StoreItem("1", "Hello World!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Wait for the server span to end.
+	srv.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	byName := map[string]stored.SpanData{}
+	for _, s := range spans {
+		byName[s.Name] = s
+		assert.Expect(t, spans[0].Context.TraceID, s.Context.TraceID)
+	}
+
+	assert.Expect(t, 3, len(byName))
+	assert.Expect(t, byName["HttpStore.StoreItem"].Context, byName["DataServer PUT"].Parent)
+	assert.Expect(t, byName["DataServer PUT"].Context, byName["mem.StoreItem"].Parent)
+}
+
+func TestWWW2Authorization(t *testing.T) {
+
+	policy := stored.NewRulePolicy(
+		stored.AllowAnyone(stored.ActionRead),
+		stored.AllowRole(func(p string) []string {
+			if p == "editor" {
+				return []string{"editor"}
+			}
+			return nil
+		}, "editor", stored.ActionWrite),
+	)
+
+	ds := NewDataServer(
+		"/test",
+		stored.NewAuthorizedStore(stored.NewMapStore(), policy),
+		stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetDecoder("text/plain", PlainStringDecoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	// A stand-in for an authentication middleware.
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		user := req.Header.Get("X-User")
+		if user != "" {
+			req = req.WithContext(stored.WithPrincipal(req.Context(), user))
+		}
+
+		ds.ServeHTTP(res, req)
+	})
+
+	put := func(user string) int {
+		req := httptest.NewRequest("PUT", "/test/1", strings.NewReader("Hello World!"))
+		req.Header.Add("Content-Type", "text/plain")
+		req.Header.Add("X-User", user)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusUnauthorized, put(""))
+	assert.Expect(t, http.StatusForbidden, put("reader"))
+	assert.Expect(t, http.StatusNoContent, put("editor"))
+
+	req := httptest.NewRequest("DELETE", "/test/1", nil)
+	req.Header.Add("X-User", "editor")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	assert.Expect(t, http.StatusForbidden, res.Code)
+
+	req = httptest.NewRequest("GET", "/test/1", nil)
+	req.Header.Add("Accept", "text/plain")
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	assert.Expect(t, http.StatusOK, res.Code)
+}
+
+func TestWWW2ListRange(t *testing.T) {
+
+	for name, store := range map[string]stored.Store{
+		"ordered": stored.NewOrderedStore(),
+		"map":     stored.NewSyncStore(stored.NewMapStore()),
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ds := NewDataServer(
+				"/test",
+				store,
+				stored.IncrIDGen(),
+				OptSetListEncoder("text/plain", PlainStringListEncoder(",")),
+				OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+			)
+
+			for _, id := range []stored.ID{"users/1", "users/1/orders/1",
+				"users/1/orders/2", "users/2", "usersx"} {
+				store.StoreItem(id, (string)(id))
+			}
+
+			list := func(query string) (int, string) {
+				req := httptest.NewRequest("GET", "/test/?"+query, nil)
+				req.Header.Add("Accept", "text/plain")
+				res := httptest.NewRecorder()
+				ds.ServeHTTP(res, req)
+				return res.Code, res.Body.String()
+			}
+
+			tests := []struct {
+				query string
+				exp   string
+			}{
+				{"prefix=users/1/", "users/1/orders/1,users/1/orders/2"},
+				{"prefix=users/&reverse=true&limit=2", "users/2,users/1/orders/2"},
+				{"start=users/2", "users/2,usersx"},
+				{"start=users/1/&end=users/2", "users/1/orders/1,users/1/orders/2"},
+				{"prefix=users/&end=users/1/orders/2", "users/1,users/1/orders/1"},
+			}
+
+			for _, test := range tests {
+				code, body := list(test.query)
+				assert.Expect(t, http.StatusOK, code)
+				assert.Expect(t, test.exp, body)
+			}
+
+			code, _ := list("limit=many")
+			assert.Expect(t, http.StatusBadRequest, code)
+		})
+	}
+}
+
+func TestWWW2ListStream(t *testing.T) {
+
+	store := stored.NewOrderedStore()
+	store.StoreItem("1", "a")
+	store.StoreItem("2", "b")
+
+	list := func(opts ...WWWOpt) *httptest.ResponseRecorder {
+		opts = append(opts, OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)))
+		ds := NewDataServer("/test", store, nil, opts...)
+
+		req := httptest.NewRequest("GET", "/test/", nil)
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res
+	}
+
+	// Streamed, without a length.
+	res := list()
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, `["1","2"]`, res.Body.String())
+	assert.Expect(t, "", res.Header().Get("Content-Length"))
+
+	// Buffered to compute the digest.
+	res = list(OptContentDigest())
+	assert.Expect(t, `["1","2"]`, res.Body.String())
+	assert.Expect(t, "9", res.Header().Get("Content-Length"))
+
+	// Replaced encoders are not bypassed.
+	res = list(OptSetListEncoder("application/json", func(obj stored.Storable) ([]byte, error) {
+		return []byte("[]"), nil
+	}))
+	assert.Expect(t, "[]", res.Body.String())
+}
+
+func TestWWW2Search(t *testing.T) {
+
+	ss, _ := stored.NewSearchableStore(stored.NewSyncStore(stored.NewMapStore()))
+
+	ds := NewDataServer(
+		"/test",
+		ss,
+		stored.IncrIDGen(),
+		OptSetListEncoder("text/plain", PlainStringListEncoder(",")),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	ss.StoreItem("1", "The quick brown fox.")
+	ss.StoreItem("2", "A fox, a fox, a fox!")
+	ss.StoreItem("3", "The lazy dog.")
+
+	search := func(query string) (int, string) {
+		req := httptest.NewRequest("GET", "/test/?"+query, nil)
+		req.Header.Add("Accept", "text/plain")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code, res.Body.String()
+	}
+
+	code, body := search("q=foxes")
+	assert.Expect(t, http.StatusOK, code)
+	assert.Expect(t, "2,1", body)
+
+	code, body = search("q=fox&limit=1")
+	assert.Expect(t, http.StatusOK, code)
+	assert.Expect(t, "2", body)
+
+	code, body = search("q=cats")
+	assert.Expect(t, http.StatusOK, code)
+	assert.Expect(t, "", body)
+
+	code, _ = search("q=fox&limit=x")
+	assert.Expect(t, http.StatusBadRequest, code)
+
+	ds = NewDataServer("/test", stored.NewMapStore(), stored.IncrIDGen(),
+		OptSetListEncoder("text/plain", PlainStringListEncoder(",")),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	code, _ = search("q=fox")
+	assert.Expect(t, http.StatusNotImplemented, code)
+}
+
+type order struct {
+	ID    string  `json:"id"`
+	Total float64 `json:"total"`
+}
+
+func TestWWW2TypedJSON(t *testing.T) {
+
+	codecs := stored.NewCodecRegistry(stored.NewJSONCodec(
+		stored.OptJSONPrototype(order{}),
+		stored.OptJSONDisallowUnknownFields(),
+	))
+
+	store := stored.NewSyncStore(stored.NewMapStore())
+
+	ds := NewDataServer("/test", store, stored.IncrIDGen(),
+		OptSetCodecs(codecs),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	put := func(body string) int {
+		req := httptest.NewRequest("PUT", "/test/1", strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/json; charset=utf-8")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusNoContent, put(`{"id":"o-1","total":9.5}`))
+	assert.Expect(t, http.StatusBadRequest, put(`{"id":"o-1","extra":1}`))
+
+	obj, _ := store.Retrieve("1")
+	assert.Expect(t, order{"o-1", 9.5}, obj)
+
+	req := httptest.NewRequest("GET", "/test/", nil)
+	req.Header.Add("Accept", "application/json")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, `["1"]`, res.Body.String())
+}
+
+func TestWWW2Negotiation(t *testing.T) {
+
+	codecs := stored.NewCodecRegistry()
+
+	store := stored.NewMapStore()
+	store.StoreItem("1", "Hello")
+
+	ds := NewDataServer("/test", store, nil,
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetCodecs(codecs),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test/1", nil)
+		req.Header.Add("Accept", accept)
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res
+	}
+
+	tests := map[string]string{
+		"":                            "application/json",
+		"*/*":                         "application/json",
+		"text/*":                      "text/plain",
+		"text/plain;q=0.5, */*":       "application/json",
+		"application/json;q=0, */*":   "text/plain",
+		"text/plain;q=0, */*;q=0.1":   "application/json",
+		"application/cbor, text/html": "",
+	}
+
+	for accept, exp := range tests {
+		res := get(accept)
+		assert.Expect(t, exp, res.Header().Get("Content-Type"))
+	}
+
+	// Codecs registered after the option are used.
+	codecs.Register(stored.NewCBORCodec(nil))
+
+	res := get("application/cbor, text/html")
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "application/cbor", res.Header().Get("Content-Type"))
+	assert.Expect(t, "eHello", res.Body.String())
+}
+
+type refund struct {
+	Order  string  `json:"order"`
+	Amount float64 `json:"amount"`
+}
+
+func TestWWW2TypeRegistry(t *testing.T) {
+
+	types := stored.NewTypeRegistry()
+	types.Register("Order", order{})
+	types.Register("Refund", &refund{})
+
+	codecs := stored.NewCodecRegistry(stored.NewTypedJSONCodec(types))
+	codecs.RegisterList(stored.JSONListCodec)
+
+	store := stored.NewSyncStore(stored.NewMapStore())
+
+	ds := NewDataServer("/test", store, stored.IncrIDGen(),
+		OptSetCodecs(codecs),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	srv := httptest.NewServer(ds)
+	defer srv.Close()
+
+	hs := stored.NewCodecHttpStore(srv.URL+"/test", codecs, "",
+		stored.OptUseClient(srv.Client()))
+
+	assert.Expect(t, nil, hs.StoreItem("1", order{"o-1", 9.5}))
+	assert.Expect(t, nil, hs.StoreItem("2", &refund{"o-1", 2}))
+
+	obj, _ := store.Retrieve("2")
+	assert.Expect(t, &refund{"o-1", 2}, obj)
+
+	obj, err := hs.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, order{"o-1", 9.5}, obj)
+
+	obj, err = hs.Retrieve("2")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, &refund{"o-1", 2}, obj)
+
+	get := func(id, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test/"+id, nil)
+		req.Header.Add("Accept", accept)
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res
+	}
+
+	res := get("1", "application/json; type=Order")
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "application/json; type=Order", res.Header().Get("Content-Type"))
+	assert.Expect(t, `{"id":"o-1","total":9.5}`, res.Body.String())
+
+	res = get("2", "application/json; type=Order")
+	assert.Expect(t, http.StatusNotAcceptable, res.Code)
+
+	res = get("2", "application/json; type=Order, */*;q=0.1")
+	assert.Expect(t, http.StatusOK, res.Code)
+
+	// Bodies without a type parameter are type envelopes.
+	req := httptest.NewRequest("PUT", "/test/3",
+		strings.NewReader(`{"type":"Refund","value":{"order":"o-2","amount":1}}`))
+	req.Header.Add("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ds.ServeHTTP(rec, req)
+	assert.Expect(t, http.StatusNoContent, rec.Code)
+
+	obj, _ = store.Retrieve("3")
+	assert.Expect(t, &refund{"o-2", 1}, obj)
+
+	req = httptest.NewRequest("PUT", "/test/4", strings.NewReader(`{}`))
+	req.Header.Add("Content-Type", "application/json; type=Missing")
+	rec = httptest.NewRecorder()
+	ds.ServeHTTP(rec, req)
+	assert.Expect(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestWWW2ContentDigest(t *testing.T) {
+
+	store := stored.NewSyncStore(stored.NewMapStore())
+	store.StoreItem("1", "Hello World!")
+
+	ds := NewDataServer("/test", store, stored.IncrIDGen(),
+		OptContentDigest(),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	req := httptest.NewRequest("GET", "/test/1", nil)
+	req.Header.Add("Accept", "application/json")
+	res := httptest.NewRecorder()
+	ds.ServeHTTP(res, req)
+
+	assert.Expect(t, http.StatusOK, res.Code)
+	assert.Expect(t, "sha-256=:hpM7CxR6xMAQJmuZAEFY+heTfbiaA917sspe9/Q8Mlo=:",
+		res.Header().Get(stored.ContentDigestHeader))
+
+	// Flip a bit in each response body, leaving the digest.
+	corrupt := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		ds.ServeHTTP(rec, r)
+
+		bs := rec.Body.Bytes()
+		if corrupt && len(bs) > 0 {
+			bs[len(bs)/2] ^= 0x01
+		}
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+
+		w.WriteHeader(rec.Code)
+		w.Write(bs)
+	}))
+	defer srv.Close()
+
+	hs := stored.NewCodecHttpStore(srv.URL+"/test", nil, "",
+		stored.OptUseClient(srv.Client()))
+
+	obj, err := hs.Retrieve("1")
+	assert.Expect(t, nil, err)
+	assert.Expect(t, "Hello World!", obj)
+
+	ids, err := hs.List()
+	assert.Expect(t, nil, err)
+	assert.Expect(t, []stored.ID{"1"}, ids)
+
+	corrupt = true
+
+	_, err = hs.Retrieve("1")
+	assert.Expect(t, stored.ErrCorrupt, err)
+
+	_, err = hs.List()
+	assert.Expect(t, stored.ErrCorrupt, err)
+}
+
+func TestWWW2IntegrityStore(t *testing.T) {
+
+	ms := stored.NewMapStore()
+	is := stored.NewIntegrityStore(stored.NewSyncStore(ms),
+		PlainStringEncoder, PlainStringDecoder)
+	is.StoreItem("1", "Hello World!")
+	ms.StoreItem("2", "Not checksummed")
+
+	ds := NewDataServer("/test", is, stored.IncrIDGen(),
+		OptSetEncoder("text/plain", PlainStringEncoder),
+		OptSetLogger(log.New(ioutil.Discard, "", log.Ldate)),
+	)
+
+	get := func(id string) int {
+		req := httptest.NewRequest("GET", "/test/"+id, nil)
+		req.Header.Add("Accept", "text/plain")
+		res := httptest.NewRecorder()
+		ds.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Expect(t, http.StatusOK, get("1"))
+
+	// Tamper with the stored item, leaving its checksum.
+	c := ms["1"].(stored.Checksummed)
+	c.Data = ([]byte)("Hello World?")
+	ms["1"] = c
+
+	assert.Expect(t, http.StatusInternalServerError, get("1"))
+	assert.Expect(t, http.StatusInternalServerError, get("2"))
+	assert.Expect(t, http.StatusNotFound, get("3"))
+}